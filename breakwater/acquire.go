@@ -0,0 +1,74 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Describes work admitted with Acquire that is not an RPC
+*/
+type CallInfo struct {
+	Method string        // name the work is accounted under, a MethodSLO for it applies
+	Class  PriorityClass // priority of the work, BestEffort lets batch work be shed first
+	Client string        // local client the work is accounted to, each gets its own credits
+	Cost   int64         // credits the work costs, 0 for 1
+}
+
+/*
+Ends work admitted with Acquire. Calling it again does nothing.
+*/
+type ReleaseFunc func()
+
+type localCallKey struct{}
+
+/*
+Admits work that does not arrive over RPC, such as background jobs or
+message queue consumers, against the same credit pool and overload signal
+as RPC traffic. The work is accounted to a local client registered like a
+remote one, whose demand is the work it has in flight, so it gets its
+share of cTotal and is shed by the same AQM. Returns the error Admit would
+if the work is shed; otherwise release has to be called once the work is
+done.
+*/
+func (b *Breakwater) Acquire(ctx context.Context, info CallInfo) (release ReleaseFunc, err error) {
+	id := uuid.NewSHA1(b.id, []byte("local/"+info.Client))
+	counter, _ := b.localCalls.LoadOrStore(id, new(int64))
+	inFlight := counter.(*int64)
+	demand := atomic.AddInt64(inFlight, 1)
+
+	md := metadata.Pairs("demand", strconv.FormatInt(demand, 10), "id", id.String(), "priority-class", info.Class.String())
+	ctx = metadata.NewIncomingContext(context.WithValue(ctx, localCallKey{}, true), md)
+	if info.Method != "" {
+		ctx = ContextWithMethod(ctx, info.Method)
+	}
+	if info.Cost > 1 {
+		ctx = WithCost(ctx, info.Cost)
+	}
+	a, err := b.Admit(ctx)
+	if err != nil {
+		atomic.AddInt64(inFlight, -1)
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(inFlight, -1)
+			a.Done()
+		})
+	}, nil
+}
+
+/*
+Returns true for work admitted with Acquire, whose client id the server
+made up itself and can trust
+*/
+func isLocalCall(ctx context.Context) bool {
+	local, _ := ctx.Value(localCallKey{}).(bool)
+	return local
+}
@@ -0,0 +1,64 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAcquireRegistersLocalClient(t *testing.T) {
+	params := BWParametersDefault
+	// local work needs no identity from the transport
+	params.ClientIdentity = IdentityTLS
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	first, err := bw.Acquire(context.Background(), CallInfo{Client: "batch"})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	second, err := bw.Acquire(context.Background(), CallInfo{Client: "batch"})
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if n := bw.clients.len(); n != 1 {
+		t.Errorf("Expected the work to be accounted to one local client, got %d clients", n)
+	}
+	id := uuid.NewSHA1(bw.id, []byte("local/batch"))
+	c, ok := bw.clients.load(id)
+	if !ok {
+		t.Fatalf("Expected the local client to be registered")
+	}
+	if demand := atomic.LoadInt64(&c.demand); demand != 2 {
+		t.Errorf("Expected a demand of the 2 jobs in flight, got %d", demand)
+	}
+	if s := bw.Snapshot(); s.CIssued < 1 {
+		t.Errorf("Expected local work to be issued credits from the pool, got %+v", s)
+	}
+
+	first()
+	first()
+	second()
+	counter, _ := bw.localCalls.Load(id)
+	if inFlight := atomic.LoadInt64(counter.(*int64)); inFlight != 0 {
+		t.Errorf("Expected releasing each job once to leave none in flight, got %d", inFlight)
+	}
+}
+
+func TestAcquireShedsBatchWorkFirst(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	// beyond the best effort threshold, within the high one
+	bw.storeQueueingDelay(bw.aqmDelay() * 1.5)
+
+	if _, err := bw.Acquire(context.Background(), CallInfo{Client: "batch", Class: BestEffort}); !isServerShed(err) {
+		t.Errorf("Expected batch work to be shed, got %v", err)
+	}
+	release, err := bw.Acquire(context.Background(), CallInfo{Client: "interactive", Class: High})
+	if err != nil {
+		t.Fatalf("Expected high priority work to be admitted, got %v", err)
+	}
+	release()
+}
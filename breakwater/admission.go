@@ -0,0 +1,242 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Transport agnostic core of the server interceptor, shared by the gRPC
+interceptor and the HTTP middleware.
+An Admission is the outcome of Admit for a single request: the header to
+send with the response, and the state Done needs once it was handled.
+*/
+type Admission struct {
+	Header    metadata.MD // credits, revocation and version headers for the response
+	RequestID string      // trace id of the request's traceparent header, "" if it has none
+
+	b            *Breakwater
+	md           metadata.MD
+	clientId     uuid.UUID
+	start        time.Time
+	arrival      time.Time // on the wire if known, start otherwise
+	handlerStart time.Time
+	admitted     bool        // credits were issued, false when passing through
+	degraded     bool        // answered by DegradeFunc instead of the handler
+	worker       bool        // holds a slot of the worker pool
+	dedup        *dedupEntry // claimed by this request, or the earlier one it duplicates
+	duplicate    bool        // answered with the response of an earlier request with its idempotency key
+	downstream   *downstreamTime
+	meta         CallMeta // metadata of the request, handed to the handler with Context
+}
+
+/*
+Runs admission control for a request whose breakwater metadata is in the
+incoming metadata of ctx, and issues its credits. Transports other than
+gRPC attach their headers with metadata.NewIncomingContext.
+The returned Admission is never nil, and its Header has to be sent with the
+response even if the request is rejected.
+*/
+func (b *Breakwater) Admit(ctx context.Context) (*Admission, error) {
+	a := &Admission{b: b, start: time.Now()}
+	if b.dropsHeader() {
+		defer func() { a.Header = nil }()
+	}
+	b.checkRTTUpdateStall()
+	b.recordRPCDelay(ctx)
+	if b.PassThrough() {
+		return a, nil
+	}
+
+	b.reportLoad(ctx)
+	a.Header = b.loadHeader()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	a.md = md
+	a.RequestID = requestIDOf(md)
+	rm, mdErr := b.requestMetadataOf(ctx, md)
+	declaredId := rm.clientId
+	if mdErr == nil {
+		rm.clientId, mdErr = b.identifyClient(ctx, rm.clientId)
+		rm.clientId = b.subdivideClient(rm.clientId, md)
+	}
+	class := rm.class
+	bypass := b.bypassed(ctx, md)
+	var key dedupKey
+	if mdErr == nil {
+		key = dedupKey{client: rm.clientId, reqid: b.idempotencyKey(ctx, md)}
+	}
+	if key.reqid != "" {
+		a.dedup = b.dedup.lookup(key)
+		a.duplicate = a.dedup != nil
+	}
+
+	if b.loadShedding && !admittedByTap(ctx) && !bypass && !a.duplicate {
+		thresholds := b.thresholdsFor(methodOf(ctx))
+		queueingDelay, shed := b.aqmDecision(class, thresholds)
+		// b.logger("[Req handled]: Server-side queuing delay is %f microseconds", queueingDelay)
+
+		if !shed {
+			if b.verbose {
+				b.logAdmit("[Load Shedding] not applied, server-side queuing delay %f us is within AQM threshold", queueingDelay)
+			}
+		} else if b.degrades(ctx) {
+			b.logger("[Brownout] degrading request %s, server-side queuing delay %f us is beyond AQM threshold", a.RequestID, queueingDelay)
+			a.degraded = true
+		} else {
+			b.logShed("[Load Shedding] applied to request %s, server-side queuing delay %f us is beyond AQM threshold", a.RequestID, queueingDelay)
+			if mdErr == nil {
+				a.Header = metadata.Join(a.Header, b.revocationHeader(rm.clientId))
+			}
+			if b.serverQueue == nil {
+				// shed requests never reach Done, so they have to keep the
+				// delay fresh or shedding would never stop
+				go b.rttUpdate()
+				return a, b.reject(ctx, ServerAQMError(b.id.String(), queueingDelay, b.serverRetryDelay(queueingDelay, thresholds)))
+			}
+			if err := b.enqueueRequest(ctx, class, thresholds); err != nil {
+				return a, b.reject(ctx, err)
+			}
+		}
+	}
+
+	if mdErr == errUnidentifiedClient {
+		b.logger("[Received Req]:	Error: client without an identity")
+		return a, mdErr
+	}
+	if mdErr != nil {
+		b.logger("[Received Req]:	Error: malformed metadata: %v", mdErr)
+		return a, errMissingMetadata
+	}
+	clientId, demand := rm.clientId, rm.demand
+	a.clientId = clientId
+	a.meta = CallMeta{ClientID: clientId, Demand: demand, Class: class, RequestID: a.RequestID}
+
+	if b.verbose {
+		b.logger("[Received Req]:	ClientId: %s, RequestId: %s, Demand %d", clientId, a.RequestID, demand)
+	}
+
+	// Register client if unregistered
+	b.RegisterClient(clientId, demand)
+	if b.tenantQuotas {
+		b.setClientTenant(clientId, b.tenantOf(ctx))
+	}
+	if len(md["weight"]) > 0 {
+		if weight, err := strconv.ParseFloat(md["weight"][0], 64); err == nil && weight > 0 {
+			b.SetClientWeight(clientId, weight)
+		}
+	}
+	if b.measureRTT && len(md["rtt"]) > 0 {
+		if rtt, err := strconv.ParseInt(md["rtt"][0], 10, 64); err == nil && rtt > 0 {
+			b.setClientRTT(clientId, rtt)
+		}
+	}
+
+	if key.reqid != "" && !a.duplicate && !a.degraded {
+		a.dedup, a.duplicate = b.dedup.claim(key)
+	}
+	if b.workers != nil && !bypass && !a.degraded && !a.duplicate {
+		if err := b.workers.acquire(ctx); err != nil {
+			if a.dedup != nil {
+				b.dedup.finish(a.dedup, nil, err)
+			}
+			return a, err
+		}
+		a.worker = true
+	}
+
+	cost := incomingCost(ctx, md)
+	if a.degraded || a.duplicate {
+		cost = 0
+	}
+	issuedCredits := b.issueCredits(clientId, demand, class, cost)
+	if b.verbose {
+		b.logger("[Received Req]:	issued credits is %d", issuedCredits)
+	}
+
+	// Piggyback updated credits issued
+	header := metadata.MD{"credits": []string{formatCount(issuedCredits)}}
+	b.setVersion(header)
+	a.Header = joinHeaders(a.Header, b.revocationHeader(clientId), header, b.leaseHeader(), b.tokenHeader(md, declaredId))
+	// the load including the credits just issued
+	for key, values := range b.loadHeader() {
+		a.Header[key] = values
+	}
+	a.admitted = true
+	a.downstream = &downstreamTime{}
+	a.handlerStart = time.Now()
+	a.arrival = arrivalOf(ctx, a.start)
+	return a, nil
+}
+
+/*
+Returns a header with a grant made while the request has been in flight so
+far, nil if there is none. Transports that write the response header before
+the handler returns send it then, and Done only returns a grant made after.
+*/
+func (a *Admission) PendingHeader() metadata.MD {
+	b := a.b
+	// older clients only read the first credits value
+	if !a.admitted || b.dropsHeader() || b.negotiatedVersion(peerVersion(a.md)) < protocolV2 {
+		return nil
+	}
+	return b.pendingGrantHeader(a.clientId)
+}
+
+/*
+Keys of the trailer Done may return, for transports that have to declare
+trailers before the response header is written
+*/
+func (a *Admission) TrailerKeys() []string {
+	b := a.b
+	if !a.admitted || b.dropsHeader() {
+		return nil
+	}
+	var keys []string
+	if b.measureRTT && len(a.md["rtt-ts"]) > 0 {
+		keys = append(keys, "rtt-echo", "rtt-server")
+	}
+	if b.timingTrailers {
+		keys = append(keys, queueingDelayKey, handlerTimeKey)
+	}
+	return keys
+}
+
+/*
+Finishes a request once it was handled. Returns a header with a grant made
+while the request was in flight, and a trailer echoing the client's RTT
+timestamp and the request's timing; either may be nil. Transports that already sent the response
+header drop them, which only loses the optimizations they carry.
+*/
+func (a *Admission) Done() (header metadata.MD, trailer metadata.MD) {
+	if !a.admitted {
+		return nil, nil
+	}
+	b := a.b
+	if a.worker {
+		b.workers.release()
+	}
+	header = a.PendingHeader()
+	if b.gradient != nil && !a.degraded && !a.duplicate {
+		b.gradient.sample(float64(a.handlerTime().Microseconds()))
+	}
+	if b.measureRTT {
+		trailer = rttEcho(a.md, a.start)
+	}
+	if b.timingTrailers {
+		trailer = metadata.Join(trailer, a.timingTrailer())
+	}
+	if b.dropsHeader() {
+		header, trailer = nil, nil
+	}
+
+	// Does update once every rtt in separate goroutine
+	if b.rttUpdateDue() {
+		go b.rttUpdate()
+	}
+	return header, trailer
+}
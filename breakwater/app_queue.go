@@ -0,0 +1,75 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/*
+AppQueue is an application-level queue (e.g. a worker pool behind the handler)
+whose backlog is reported to breakwater, so that the overload signal reflects
+the end-to-end server backlog and not only the part visible to gRPC.
+The delay of a queue is the larger of
+1. the reported wait time of the oldest queued item
+2. depth / capacity * aqmDelay, so a full queue counts as an AQM breach
+*/
+type AppQueue struct {
+	name     string
+	capacity int64 // capacity of the queue, 0 if unbounded
+	depth    int64 // current depth, accessed atomically
+	waitUs   int64 // wait time of the oldest queued item in microseconds, accessed atomically
+}
+
+/*
+Registers an application queue, reports made through the returned handle
+are used by every following RTT update and shedding decision
+*/
+func (b *Breakwater) RegisterAppQueue(name string, capacity int64) *AppQueue {
+	q := &AppQueue{name: name, capacity: capacity}
+	b.appQueues.Store(name, q)
+	b.logger("[App Queue]:	Registered queue %s with capacity %d", name, capacity)
+	return q
+}
+
+/*
+Removes an application queue so it no longer contributes to the delay
+*/
+func (b *Breakwater) UnregisterAppQueue(q *AppQueue) {
+	b.appQueues.Delete(q.name)
+}
+
+/*
+Reports the current depth of the queue and how long its oldest item has waited
+*/
+func (q *AppQueue) Report(depth int64, oldestWait time.Duration) {
+	atomic.StoreInt64(&q.depth, depth)
+	atomic.StoreInt64(&q.waitUs, oldestWait.Microseconds())
+}
+
+/*
+Returns the depth last reported for the queue
+*/
+func (q *AppQueue) Depth() int64 {
+	return atomic.LoadInt64(&q.depth)
+}
+
+func (q *AppQueue) delay(aqmDelay float64) float64 {
+	delay := float64(atomic.LoadInt64(&q.waitUs))
+	if q.capacity > 0 {
+		delay = math.Max(delay, float64(q.Depth())/float64(q.capacity)*aqmDelay)
+	}
+	return delay
+}
+
+/*
+Largest delay across all registered application queues in microseconds
+*/
+func (b *Breakwater) appQueueDelay() float64 {
+	var delay float64
+	b.appQueues.Range(func(key, value interface{}) bool {
+		delay = math.Max(delay, value.(*AppQueue).delay(b.aqmDelay()))
+		return true
+	})
+	return delay
+}
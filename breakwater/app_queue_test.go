@@ -0,0 +1,40 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppQueueWaitRaisesDelay(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 20)
+	q := bw.RegisterAppQueue("workers", 0)
+	q.Report(10, 500*time.Microsecond)
+
+	delay := bw.getDelay()
+	if delay != 500.0 {
+		t.Errorf("Expected delay to be 500.0, got %f", delay)
+	}
+
+	bw.UnregisterAppQueue(q)
+	delay = bw.getDelay()
+	if delay != 20.0 {
+		t.Errorf("Expected delay to be 20.0 after unregistering, got %f", delay)
+	}
+}
+
+func TestAppQueueFullCountsAsAQMBreach(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	q := bw.RegisterAppQueue("workers", 100)
+
+	q.Report(50, 0)
+	if delay := bw.getDelay(); delay != bw.aqmDelay()/2 {
+		t.Errorf("Expected half full queue to report %f, got %f", bw.aqmDelay()/2, delay)
+	}
+
+	q.Report(100, 0)
+	if delay := bw.getDelay(); delay != bw.aqmDelay() {
+		t.Errorf("Expected full queue to report %f, got %f", bw.aqmDelay(), delay)
+	}
+}
@@ -1,21 +1,26 @@
 package breakwater
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"runtime/metrics"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"google.golang.org/grpc/health"
 )
 
-// make RTT configurable from input
-var RTT_MICROSECOND int64                   // RTT in microseconds
+// RTT in microseconds for instances whose parameters leave RTT_MICROSECOND
+// unset. Each instance keeps its own copy, so changing it later has no effect.
+//
+// Deprecated: set BWParameters.RTT_MICROSECOND, or call SetRTT on a running Breakwater.
+var RTT_MICROSECOND int64
+
 const DELAY_THRESHOLD_PERCENT float64 = 0.4 // target is 0.4 of SLA as per Breakwater
 const MAX_Q_LENGTH = 50                     // max length of queue
-var debug bool = false
-var useClientTimeExpiration bool = true
-var loadShedding bool = true
-var useClientQueueLength bool = false
 var creditsOnFail bool = false
 
 /*
@@ -26,137 +31,448 @@ DATA STRUCTURES:
 4. cIssued
 */
 type Connection struct {
-	issued          int64 // issued credits
-	issuedWriteLock chan int64
-	demand          int64 // number of requests pending
-	demandWriteLock chan int64
-	id              uuid.UUID
-	lastUpdated     chan time.Time // last time new credits were issued
+	id uuid.UUID
+	// Shared counters, updated in place atomically so readers never need
+	// the mutex. Writes to issued also hold the mutex, so read-modify-write
+	// updates are not lost.
+	issued        int64      // issued credits
+	demand        int64      // number of requests pending, as last reported and decayed while idle
+	demandUpdated int64      // unix nanos when the client last reported its demand
+	lastSeen      int64      // unix nanos of the client's last request, evicted when idle for too long
+	mu            sync.Mutex // guards the fields below
+	lastUpdated   time.Time  // last time new credits were issued
+	revokeTo      int64      // pending credit revocation to send to the client, 0 if none
+	weight        float64    // weight for weighted fair sharing
+	share         int64      // fair share of cTotal allocated at the last RTT update
+	tenant        string     // tenant whose quota the client's credits count against
+	rtt           int64      // smoothed RTT reported by the client in microseconds, 0 if none
+	pendingGrant  int64      // credits granted at an RTT update but not yet sent to the client, 0 if none
+	evicted       bool       // removed from the registry, so its demand no longer counts
 }
 
 type Breakwater struct {
-	clientMap sync.Map // Map of client connections
-	// requestMap      sync.Map  // Map of requests for time tracking
-	lastUpdateTime    time.Time // last time since an RTT update
-	numClients        chan int64
-	rttLock           chan int64 // Lock for cTotal, cIssued, lastUpdateTime update
-	cTotal            int64      // global pool of credits
-	cIssued           chan int64 // total credits currently issued
-	aFactor           float64    // aggressive factor for increasing credits
-	bFactor           float64    // multiplicative factor for decreasing credits
-	SLO               int64      // SLA in microseconds
-	thresholdDelay    float64    // threshold delay (for server-side token reduction) in microseconds
-	aqmDelay          float64    // aqm threshold (for server-side AQM) in microseconds
-	clientExpiration  int64      // client expiration time in microseconds
-	prevHist          *metrics.Float64Histogram
-	currHist          *metrics.Float64Histogram
-	id                uuid.UUID
-	pendingOutgoing   chan int64 // pending outgoing requests
-	noCreditBlocker   chan int64 // block requests when no credits
-	outgoingCredits   chan int64 // outgoing credits
-	queueingDelayChan chan DelayOperation
+	clients                  *clientRegistry            // client connections by id
+	lastUpdateTime           time.Time                  // last time since an RTT update
+	lastUpdateNanos          int64                      // lastUpdateTime in unix nanos for readers without rttLock, accessed atomically
+	rttLock                  chan int64                 // Lock for cTotal, cIssued, lastUpdateTime update
+	cTotal                   int64                      // global pool of credits, stored atomically under rttLock, see getCTotal
+	minCredits               int64                      // floor on cTotal, see boundCredits
+	maxCredits               int64                      // ceiling on cTotal, 0 for none
+	recovery                 string                     // RecoveryAdditive or RecoverySlowStart, see recoverCredits
+	grantMode                string                     // GrantModeLazy or GrantModeRTT, see recomputeGrants
+	grantPolicy              GrantPolicy                // computes recomputed client grants
+	stateStore               StateStore                 // where SaveState saves the controller state, nil if none
+	maxStateAge              int64                      // oldest saved state restored in microseconds, 0 for any
+	totalDemand              int64                      // demand of all clients, accessed atomically
+	weightedDemand           uint64                     // float64 bits of the weighted demand of all clients, accessed atomically
+	credits                  float64                    // exact pool cTotal is rounded from, see exactCredits
+	lastGoodTotal            float64                    // last exact pool within the SLO, only accessed under the rtt lock
+	warmup                   *warmup                    // startup ramp of cTotal, nil once it is over
+	initialCredits           int64                      // cTotal idle decay moves towards
+	idleHalfLife             int64                      // idle decay half-life in microseconds, 0 disables
+	lastIdleDecay            time.Time                  // last idle decay step, only accessed under the rtt lock
+	history                  *updateHistory             // last RTT updates, nil if HistoryLength is 0
+	cIssued                  chan int64                 // total credits currently issued
+	aFactor                  float64                    // aggressive factor for increasing credits
+	bFactor                  float64                    // multiplicative factor for decreasing credits
+	SLO                      int64                      // SLA in microseconds, accessed atomically, see thresholdDelay and aqmDelay
+	methodSLOs               map[string]methodSLOLimits // thresholds by full method name, see thresholdsFor
+	clientExpiration         int64                      // client expiration time in microseconds, accessed atomically
+	rtt                      int64                      // RTT in microseconds, accessed atomically
+	useClientTimeExpiration  int32                      // 1 if waiting requests expire after clientExpiration, accessed atomically
+	useClientQueueLength     int32                      // 1 if requests are rejected once the client queue is full, accessed atomically
+	prevHist                 *metrics.Float64Histogram
+	currHist                 *metrics.Float64Histogram
+	id                       uuid.UUID
+	idString                 string         // id formatted once for request metadata
+	queueingDelay            uint64         // float64 bits of the smoothed queueing delay as of the last RTT update, accessed atomically
+	delaySource              func() float64 // returns the queueing delay since the last sample in microseconds
+	delayPercentile          float64        // percentile of the scheduler latency histogram used as the delay, see histogramDelay
+	delaySmoothing           float64        // EWMA weight of new delay samples, see smoothDelay
+	delayMinSamples          int64          // delay samples before cTotal starts to change
+	delaySamples             int64          // delay samples seen by smoothDelay
+	rawDelay                 uint64         // float64 bits of the last delay sample, accessed atomically
+	smoothedDelay            uint64         // float64 bits of the smoothed delay, accessed atomically
+	queueingDelaySampled     bool           // queueingDelay holds a sample, guarded by rttLock
+	calibration              *calibration   // baseline delay samples while the SLO is calibrated, nil otherwise
+	stallTimeoutRTTs         int64          // RTTs without a grant before the client probes for credits
+	maxStallBackoff          int64          // upper bound on the probe backoff in microseconds
+	observer                 *Observer
+	safetyValve              bool     // switch to pass-through mode on anomalies
+	passThrough              int32    // 1 if the safety valve has tripped, accessed atomically
+	rejections               sync.Map // rejections made by this Breakwater by RejectReason, as *int64
+	traceShed                bool     // force sampling the traces of shed requests
+	traceCreditWait          int64    // force sampling the traces of requests waiting longer for a credit in microseconds
+	maxAccountingDrift       int64    // max difference between cIssued and its recount
+	recountRTTs              int64    // RTT updates between recounts of cIssued from every connection
+	rttUpdates               int64    // RTT updates run so far, guarded by rttLock
+	recounting               int32    // 1 while recountIssued runs, accessed atomically
+	recountDivergence        int64    // divergence the last recount saw, for the next to confirm, owned by recountIssued
+	rttSum                   int64    // sum of the RTTs clients reported in microseconds, accessed atomically
+	rttClients               int64    // clients that reported an RTT, accessed atomically
+	maxRTTUpdateStall        int64    // max time an RTT update may hold the lock in microseconds
+	invariantCheckInterval   int64    // microseconds between recounts of cIssued, 0 disables
+	repairAccounting         bool     // resync cIssued when a recount disagrees
+	rttUpdateStarted         int64    // unix nanos when the running RTT update started, 0 if none
+	appQueues                sync.Map // application queues reporting their backlog, by name
+	targets                  sync.Map // client side state per target, by cc.Target()
+	localCalls               sync.Map // in-flight Acquire work by local client id, as *int64
+	priorityFunc             func(ctx context.Context) int
+	dropFromFront            bool         // client side AQM, drop the oldest waiter when the queue is full
+	serverQueue              *serverQueue // nil if requests are shed as soon as AQM triggers
+	maxRetries               int64        // retries of requests shed by the server, 0 disables
+	retryBaseBackoff         int64        // backoff before the first retry in microseconds
+	retryMaxBackoff          int64        // upper bound on the retry backoff in microseconds
+	clientIdleTimeout        int64        // microseconds without a request before a client is evicted, 0 disables
+	retryCredits             string       // credits a retry attempt takes, RetryCreditsFull by default
+	retryCreditDiscount      float64      // fraction of a credit a retry takes with RetryCreditsDiscounted
+	loadHeaders              bool         // send the server's load in response headers
+	timingTrailers           bool         // send each request's timing in response trailers
+	recoverPanics            bool         // recover handler panics as codes.Internal errors
+	leaseRTTs                int64        // RTT update intervals a grant stays valid for, 0 never expires
+	revocationFactor         float64      // revoke credits when the delay exceeds this multiple of aqmDelay, 0 disables
+	maxGrantIncrease         int64        // credits a client's grant may grow by per RTT update, 0 for no limit
+	controlStreams           sync.Map     // connected control streams, by client id
+	openControlStreams       int64        // control stream handlers running, accessed atomically
+	minOvercommit            int64        // minimum credits overcommitted per client
+	maxOvercommit            int64        // maximum credits overcommitted per client, 0 for no cap
+	disableOvercommit        bool         // issue only the demand, never overcommit
+	weightedFairSharing      bool         // issue credits by weighted max-min fair share instead of demand
+	clientWeight             float64      // weight this client asks servers for, 0 to not send one
+	tenantQuotas             bool         // cap the credits issued to each tenant's clients to an equal share of cTotal
+	tenantFunc               func(ctx context.Context) string
+	tenants                  sync.Map                                 // credit pools by tenant
+	numTenants               int64                                    // tenants with registered clients, accessed atomically
+	clientTenant             string                                   // tenant this client sends to servers, "" for none
+	highAQMFactor            uint64                                   // float64 bits, HIGH requests are shed at this multiple of aqmDelay, accessed atomically
+	criticalAQMFactor        uint64                                   // float64 bits, CRITICAL requests are shed at this multiple of aqmDelay, accessed atomically
+	highPriorityReserve      uint64                                   // float64 bits of the fraction of cTotal BEST_EFFORT requests cannot be issued, accessed atomically
+	codelTarget              int64                                    // CoDel target delay in microseconds, 0 for thresholdDelay
+	codelInterval            time.Duration                            // CoDel interval, 0 if CoDel is disabled
+	shedRamp                 float64                                  // multiple of the AQM threshold where every request is shed, 0 unless AQMProbabilistic
+	serverCoDel              []*codel                                 // CoDel drop state per priority class, nil for the fixed AQM threshold
+	gradient                 *gradientLimit                           // RPC latency controller for cTotal, nil for AIMD on the queueing delay
+	orcaReporting            bool                                     // report load in ORCA per-call metrics
+	healthServer             *health.Server                           // set to NOT_SERVING while overloaded, nil disables
+	healthService            string                                   // service whose status is set, "" for the whole server
+	overloadWindows          int64                                    // RTTs over (or back under) the AQM threshold before the status changes
+	overloadedWindows        int64                                    // consecutive RTTs over the AQM threshold
+	healthyWindows           int64                                    // consecutive RTTs under the AQM threshold
+	draining                 int32                                    // 1 while sustained overload drains the instance, accessed atomically
+	rpcDelayMax              int64                                    // largest per-RPC delay since the last sample in microseconds, accessed atomically
+	gcPressure               *GCSignal                                // GC signal counted alongside delaySource, nil unless GCPressure
+	throttling               *throttleDetector                        // CFS throttling counted as overload, nil unless ThrottleWindows
+	workers                  *workerPool                              // handler slots of admitted requests, nil unless MaxConcurrentHandlers
+	dedup                    *dedupWindow                             // responses of requests with an idempotency key, nil unless DedupWindow
+	tokens                   *tokenTable                              // client ids by connection token, nil unless ConnectionTokens
+	measureRTT               bool                                     // measure RTTs instead of assuming RTT_MICROSECOND
+	measuredRTT              int64                                    // mean RTT reported by clients in microseconds, 0 if none, accessed atomically
+	demandDecay              float64                                  // fraction of an idle client's demand kept per RTT
+	rttGrants                bool                                     // piggyback RTT update grants on in-flight responses
+	zeroCredits              bool                                     // grants may be zero, see minGrant
+	binaryMetadata           bool                                     // send the breakwater-bin header instead of textual metadata
+	protocolVersion          int64                                    // credit protocol version spoken, see protocolVersionOf
+	activeClients            int64                                    // clients with demand, accessed atomically
+	demanding                sync.Map                                 // *Connection of each client with demand, by id
+	clock                    func() time.Time                         // time source of the controller, time.Now if nil
+	manualUpdates            bool                                     // credits are only updated by UpdateCredits
+	faultsMu                 sync.Mutex                               // guards faults
+	faults                   Faults                                   // faults injected by InjectFaults
+	clientIdentity           string                                   // IdentityMetadata, IdentityTLS or IdentityFunc
+	identityFunc             func(ctx context.Context) (string, bool) // client identity with IdentityFunc
+	metadataIdentityFallback bool                                     // accept declared ids of clients without an identity
+	subdivideClients         bool                                     // account client keys as separate clients
+	trustClientBypass        bool                                     // honor the bypass clients send in their metadata
+	clientKeyFunc            func(ctx context.Context) string         // key of an outgoing request's credits
+	degradeFunc              DegradeFunc                              // answers requests AQM would shed, nil sheds them
+	responseCaches           map[string]*responseCache                // latest responses of CachedMethods, by full method name
+	interceptedTwiceOnce     sync.Once                                // reports the interceptor installed twice only once
+	verbose                                                           // writes debug lines, BWParameters.Verbose
+	loadShedding             bool                                     // sheds requests, BWParameters.LoadShedding
+	stop                     chan struct{}                            // closed by Close to stop the background goroutines
+	closeOnce                sync.Once                                // closes stop only once
 }
 
-// // TODO: Add fields for gRPC contexts
-// type request struct {
-// 	reqID                  uuid.UUID
-// 	timeDeductionsMicrosec int64
-// }
-
 func InitBreakwater(param BWParameters) (bw *Breakwater) {
+	param = param.WithDefaults()
+	if err := param.Validate(); err != nil {
+		alert("[Breakwater Init]:	Invalid parameters: %v", err)
+	}
 	bFactor, aFactor, SLO, InitialCredits := param.BFactor, param.AFactor, param.SLO, param.InitialCredits
 	thresholdDelay := float64(SLO) * DELAY_THRESHOLD_PERCENT
 	aqmDelay := thresholdDelay * 2.0
+	id := uuid.New()
 	bw = &Breakwater{
-		clientMap:        sync.Map{},
-		lastUpdateTime:   time.Now().Add(-1 * time.Second),
-		numClients:       make(chan int64, 1),
-		rttLock:          make(chan int64, 1),
-		cTotal:           InitialCredits,
-		cIssued:          make(chan int64, 1),
-		bFactor:          bFactor,
-		aFactor:          aFactor,
-		SLO:              SLO,
-		thresholdDelay:   thresholdDelay,
-		aqmDelay:         aqmDelay,
-		clientExpiration: param.ClientExpiration,
-		prevHist:         nil,
-		currHist:         nil,
-		id:               uuid.New(),
-		// Outgoing buffer drops requests if > 50 requests in queue
-		pendingOutgoing:   make(chan int64, MAX_Q_LENGTH),
-		noCreditBlocker:   make(chan int64, 1),
-		outgoingCredits:   make(chan int64, 1),
-		queueingDelayChan: make(chan DelayOperation),
-	}
-	RTT_MICROSECOND = param.RTT_MICROSECOND
-	debug = param.Verbose
-	useClientTimeExpiration = param.UseClientTimeExpiration
-	loadShedding = param.LoadShedding
-	useClientQueueLength = param.UseClientQueueLength
-	// unblock blocker
-	bw.noCreditBlocker <- 1
-	// give 1 credit to start
-	bw.outgoingCredits <- 1
+		clients:                  newClientRegistry(),
+		rttLock:                  make(chan int64, 1),
+		cTotal:                   InitialCredits,
+		minCredits:               param.MinCredits,
+		maxCredits:               param.MaxCredits,
+		recovery:                 param.Recovery,
+		grantMode:                param.GrantMode,
+		grantPolicy:              param.GrantPolicy,
+		stateStore:               param.StateStore,
+		maxStateAge:              param.MaxStateAge,
+		initialCredits:           InitialCredits,
+		idleHalfLife:             param.IdleHalfLife,
+		cIssued:                  make(chan int64, 1),
+		bFactor:                  bFactor,
+		aFactor:                  aFactor,
+		SLO:                      SLO,
+		methodSLOs:               methodThresholds(param.MethodSLOs, param.IdempotentAQMFactor),
+		clientExpiration:         param.ClientExpiration,
+		rtt:                      param.RTT_MICROSECOND,
+		useClientTimeExpiration:  boolFlag(param.UseClientTimeExpiration),
+		useClientQueueLength:     boolFlag(param.UseClientQueueLength),
+		prevHist:                 nil,
+		currHist:                 nil,
+		id:                       id,
+		idString:                 id.String(),
+		stallTimeoutRTTs:         param.StallTimeoutRTTs,
+		maxStallBackoff:          param.MaxStallBackoff,
+		observer:                 param.Observer,
+		traceShed:                param.TraceShed,
+		traceCreditWait:          param.TraceCreditWait,
+		safetyValve:              param.SafetyValve,
+		maxAccountingDrift:       param.MaxAccountingDrift,
+		recountRTTs:              param.RecountRTTs,
+		maxRTTUpdateStall:        param.MaxRTTUpdateStall,
+		invariantCheckInterval:   param.InvariantCheckInterval,
+		repairAccounting:         param.RepairAccounting,
+		priorityFunc:             param.PriorityFunc,
+		dropFromFront:            param.DropFromFront,
+		maxRetries:               param.MaxRetries,
+		retryBaseBackoff:         param.RetryBaseBackoff,
+		retryMaxBackoff:          param.RetryMaxBackoff,
+		clientIdleTimeout:        param.ClientIdleTimeout,
+		leaseRTTs:                param.CreditLeaseRTTs,
+		loadHeaders:              param.LoadHeaders,
+		timingTrailers:           param.TimingTrailers,
+		recoverPanics:            param.RecoverPanics,
+		retryCredits:             param.RetryCredits,
+		retryCreditDiscount:      param.RetryCreditCost,
+		revocationFactor:         param.RevocationFactor,
+		maxGrantIncrease:         param.MaxGrantIncrease,
+		minOvercommit:            param.MinOvercommit,
+		maxOvercommit:            param.MaxOvercommit,
+		disableOvercommit:        param.DisableOvercommit,
+		weightedFairSharing:      param.WeightedFairSharing,
+		clientWeight:             param.ClientWeight,
+		tenantQuotas:             param.TenantQuotas,
+		tenantFunc:               param.TenantFunc,
+		clientTenant:             param.Tenant,
+		highAQMFactor:            math.Float64bits(param.HighAQMFactor),
+		criticalAQMFactor:        math.Float64bits(param.CriticalAQMFactor),
+		highPriorityReserve:      math.Float64bits(param.HighPriorityReserve),
+		orcaReporting:            param.ORCAReporting,
+		measureRTT:               param.MeasureRTT,
+		demandDecay:              param.DemandDecay,
+		rttGrants:                param.RTTGrants,
+		zeroCredits:              param.ZeroCredits,
+		binaryMetadata:           param.BinaryMetadata,
+		protocolVersion:          protocolVersionOf(param),
+		healthServer:             param.HealthServer,
+		healthService:            param.HealthService,
+		overloadWindows:          param.OverloadWindows,
+		codelTarget:              param.CoDelTarget,
+		delayPercentile:          param.DelayPercentile,
+		delaySmoothing:           param.DelaySmoothing,
+		delayMinSamples:          param.DelayMinSamples,
+		clock:                    param.Clock,
+		manualUpdates:            param.ManualUpdates,
+		clientIdentity:           param.ClientIdentity,
+		identityFunc:             param.IdentityFunc,
+		metadataIdentityFallback: param.MetadataIdentityFallback,
+		subdivideClients:         param.SubdivideClients,
+		trustClientBypass:        param.TrustClientBypass,
+		clientKeyFunc:            param.ClientKeyFunc,
+		degradeFunc:              param.DegradeFunc,
+		responseCaches:           newResponseCaches(param.CachedMethods),
+		verbose:                  verbose(param.Verbose),
+		loadShedding:             param.LoadShedding,
+		stop:                     make(chan struct{}),
+	}
+	bw.setLastUpdate(bw.now().Add(-1 * time.Second))
+	if bw.grantPolicy == nil {
+		bw.grantPolicy = DemandGrantPolicy{}
+	}
+	if bw.stateStore == nil && param.StateFile != "" {
+		bw.stateStore = FileStateStore{Path: param.StateFile}
+	}
+	if param.HistoryLength > 0 {
+		bw.history = newUpdateHistory(param.HistoryLength)
+	}
+	if param.WarmupPeriod > 0 && param.WarmupCredits > 0 && param.WarmupCredits < InitialCredits {
+		bw.warmup = &warmup{
+			start:    bw.now(),
+			period:   time.Duration(param.WarmupPeriod) * time.Microsecond,
+			from:     param.WarmupCredits,
+			to:       InitialCredits,
+			schedule: param.WarmupSchedule,
+		}
+		atomic.StoreInt64(&bw.cTotal, param.WarmupCredits)
+	}
+	if param.CalibrationPeriod > 0 {
+		bw.calibration = &calibration{
+			until:  bw.now().Add(time.Duration(param.CalibrationPeriod) * time.Microsecond),
+			factor: param.CalibrationFactor,
+		}
+	}
+	bw.delaySource = bw.schedulerDelay
+	var cpuSignal *CPUSignal
+	if param.DelaySignal == DelaySignalCPU {
+		var ok bool
+		if cpuSignal, ok = NewCPUSignal(thresholdDelay, param.CPUTarget); !ok {
+			reason := "process CPU time unavailable on this platform, using scheduling latency instead"
+			alert("[Breakwater Init]:	%s", reason)
+			bw.observer.degraded(reason)
+		}
+	}
+	if param.DelaySignal == DelaySignalRPC {
+		bw.delaySource = bw.rpcDelay
+	} else if param.DelaySignal == DelaySignalGC {
+		bw.delaySource = NewGCSignal(aqmDelay).Delay
+	} else if cpuSignal != nil {
+		bw.delaySource = cpuSignal.Delay
+	} else if !schedulerLatencySupported() {
+		reason := fmt.Sprintf("runtime metric %s unsupported, probing scheduling latency instead", schedulerLatencyMetric)
+		alert("[Breakwater Init]:	%s", reason)
+		bw.observer.degraded(reason)
+		bw.delaySource = newSchedulerProbe().delay
+	}
+	if param.GCPressure && param.DelaySignal != DelaySignalGC {
+		bw.gcPressure = NewGCSignal(aqmDelay)
+	}
+	if param.ThrottleWindows > 0 {
+		bw.throttling = newThrottleDetector(param.ThrottleWindows)
+	}
+	if param.MaxConcurrentHandlers > 0 {
+		bw.workers = newWorkerPool(param.MaxConcurrentHandlers)
+	}
+	if param.DedupWindow > 0 {
+		bw.dedup = newDedupWindow(time.Duration(param.DedupWindow) * time.Microsecond)
+	}
+	if param.ConnectionTokens {
+		bw.tokens = newTokenTable()
+	}
+	if param.Algorithm == AlgorithmGradient {
+		bw.gradient = newGradientLimit(param.GradientTolerance, bw.verbose)
+	}
+	if param.AQM == AQMCoDel {
+		bw.codelInterval = time.Duration(param.CoDelInterval) * time.Microsecond
+		bw.serverCoDel = make([]*codel, Critical+1)
+		for i := range bw.serverCoDel {
+			bw.serverCoDel[i] = newCoDel(bw.codelInterval)
+		}
+	}
+	if param.AQM == AQMProbabilistic {
+		bw.shedRamp = param.ShedRamp
+	}
 	// unblock rttLock
 	bw.rttLock <- 1
 	// zero credits and delay
-	bw.numClients <- 0
 	bw.cIssued <- 0
+	if bw.stateStore != nil {
+		bw.restoreState()
+	}
+
+	if bw.loadShedding && param.ServerQueueLength > 0 {
+		bw.serverQueue = newServerQueue(param.ServerQueueLength, time.Duration(param.ServerQueueTimeout)*time.Microsecond)
+		go bw.drainServerQueue()
+	}
 
 	if param.ServerSide {
 		// log
-		logger("[Server Init]:	Initialized server with params: bFactor: %f, aFactor: %f, SLO: %d, InitialCredits: %d\n", bFactor, aFactor, SLO, InitialCredits)
+		bw.logger("[Server Init]:	Initialized server with params: bFactor: %f, aFactor: %f, SLO: %d, InitialCredits: %d\n", bFactor, aFactor, SLO, InitialCredits)
 		// Start the goroutine that updates credits periodically
 		// Does update once every rtt in separate goroutine
 		go bw.rttUpdate()
+	}
 
-		// Start the goroutine that manages credits
-		if loadShedding {
-			// Start the goroutine that manages queueingDelay
-			go bw.manageQueueingDelay()
-		}
+	if bw.stallTimeoutRTTs > 0 {
+		go bw.monitorCreditStall()
+	}
+
+	if bw.idleHalfLife > 0 {
+		go bw.decayIdlePool()
 	}
 
-	bw.startTimeoutRoutine(25 * time.Second)
+	if bw.invariantCheckInterval == 0 && debugBuild {
+		bw.invariantCheckInterval = debugInvariantCheckInterval
+	}
+	if bw.invariantCheckInterval > 0 {
+		go bw.checkCreditInvariants()
+	}
+
+	if param.ExpvarName != "" {
+		bw.PublishExpvar(param.ExpvarName)
+	}
 	return
 }
 
-func (b *Breakwater) manageQueueingDelay() {
-	var queueingDelay float64 // This variable is owned by this goroutine
+/*
+Stops the goroutines InitBreakwater started in the background. Safe to
+call more than once, the Breakwater should not be used afterwards.
+*/
+func (b *Breakwater) Close() {
+	b.closeOnce.Do(func() { close(b.stop) })
+}
+
+/*
+Client side stall recovery.
+If requests to a target are waiting but no credits have been granted for
+stallTimeoutRTTs RTTs (e.g. every in-flight request failed before the server
+could piggyback a grant), issue a single probe credit so one request can go
+out and fetch a fresh grant. Probes back off exponentially up to
+maxStallBackoff while the stall persists, and the backoff resets as soon as
+a real grant arrives.
+*/
+func (b *Breakwater) monitorCreditStall() {
+	rtt := b.getRTT()
+	stallTimeout := time.Duration(b.stallTimeoutRTTs*rtt) * time.Microsecond
+	maxBackoff := time.Duration(max(b.maxStallBackoff, b.stallTimeoutRTTs*rtt)) * time.Microsecond
 
-	for op := range b.queueingDelayChan {
-		if op.Response != nil {
-			// A read is being requested
-			op.Response <- queueingDelay
-		} else {
-			// A write is being requested
-			queueingDelay = op.Value
+	ticker := time.NewTicker(stallTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.stop:
+			return
 		}
+		b.targets.Range(func(key, value interface{}) bool {
+			value.(*clientTarget).probeIfStalled(stallTimeout, maxBackoff)
+			return true
+		})
 	}
 }
 
-type DelayOperation struct {
-	Value    float64      // For setting a value
-	Response chan float64 // For getting a value
-}
+func (t *clientTarget) probeIfStalled(stallTimeout time.Duration, maxBackoff time.Duration) {
+	lastGrant := <-t.lastCreditGrant
+	t.lastCreditGrant <- lastGrant
+	if time.Since(lastGrant) < stallTimeout || t.getDemand() == 0 {
+		t.stallBackoff = stallTimeout
+		return
+	}
+	if time.Now().Before(t.nextProbe) {
+		return
+	}
+
+	creditBalance := <-t.outgoingCredits
+	if creditBalance > 0 {
+		// Credits are available, waiters are just slow to pick them up
+		t.outgoingCredits <- creditBalance
+		return
+	}
+	t.outgoingCredits <- t.dispatch(1)
 
-func (b *Breakwater) startTimeoutRoutine(duration time.Duration) {
-	// Start a timer for the specified duration
-	timer := time.NewTimer(duration)
-
-	// Start a separate Goroutine to unblock requests after the timer expires
-	go func() {
-		<-timer.C
-		logger("[Timeout]:	Unblocking all requests. Updated spend credits to %d\n", 99999999)
-		// Update credits and unblock other requests
-		<-b.outgoingCredits
-		b.outgoingCredits <- 99999999
-		b.unblockNoCreditBlock()
-		// close channerls after all requests are unblocked and sent
-
-		// close(b.noCreditBlocker)
-		// close(b.outgoingCredits)
-		// close(b.pendingOutgoing)
-	}()
+	if t.stallBackoff == 0 {
+		t.stallBackoff = stallTimeout
+	}
+	t.nextProbe = time.Now().Add(t.stallBackoff)
+	t.logger("[Stall Recovery]:	No credits granted by %s for %v, issued probe credit, next probe in %v", t.target, time.Since(lastGrant), t.stallBackoff)
+	t.stallBackoff *= 2
+	if t.stallBackoff > maxBackoff {
+		t.stallBackoff = maxBackoff
+	}
 }
@@ -0,0 +1,24 @@
+package breakwater
+
+import (
+	"context"
+)
+
+/*
+Brownout
+With a DegradeFunc set, a unary request that AQM would shed is answered by
+DegradeFunc instead of its handler, so the application can return a cached
+or partial response rather than an error. Degraded requests are admitted
+at no cost: the credit the client spent on them is issued again, and they
+take no handler slot.
+Other transports have no request to degrade, so their requests are shed.
+*/
+type DegradeFunc func(ctx context.Context, req interface{}) (interface{}, error)
+
+/*
+Returns true if a request AQM would shed is degraded instead
+*/
+func (b *Breakwater) degrades(ctx context.Context) bool {
+	// only the gRPC interceptor has a request to hand DegradeFunc
+	return b.degradeFunc != nil && intercepted(ctx)
+}
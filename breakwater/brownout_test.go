@@ -0,0 +1,44 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDegradeInsteadOfShedding(t *testing.T) {
+	params := BWParametersDefault
+	params.DegradeFunc = func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "cached " + req.(string), nil
+	}
+	bw := InitBreakwater(params)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+
+	id := uuid.New()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", id.String()))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		t.Errorf("Expected the handler not to run while shedding")
+		return nil, nil
+	}
+	resp, err := bw.UnaryInterceptor(ctx, "page", &grpc.UnaryServerInfo{FullMethod: "/test.Service/Get"}, handler)
+	if err != nil || resp != "cached page" {
+		t.Fatalf("Expected the degraded response, got %v and %v", resp, err)
+	}
+	c, ok := bw.clients.load(id)
+	if !ok {
+		t.Fatalf("Expected the degraded request to register its client")
+	}
+	// the credit spent on the degraded request is issued again
+	if issued := atomic.LoadInt64(&c.issued); issued < 1 {
+		t.Errorf("Expected the client to keep its credit, got %d", issued)
+	}
+
+	// transports without a request to degrade still shed
+	if _, err := bw.Admit(ctx); !isServerShed(err) {
+		t.Errorf("Expected Admit to shed without the interceptor, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package breakwater
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Breakwater metadata of an admitted request, parsed and validated once by
+the interceptor. Handlers and the middleware after breakwater read it with
+FromContext instead of parsing the headers again.
+*/
+type CallMeta struct {
+	ClientID  uuid.UUID     // client the credits were issued to, after identification and subdivision
+	Demand    int64         // demand the client declared
+	Class     PriorityClass // priority class of the request
+	RequestID string        // trace id of the request's traceparent header, "" if it has none
+}
+
+type parsedMetadataKey struct{}
+
+type parsedMetadata struct {
+	rm  requestMetadata
+	err error
+}
+
+/*
+Returns the breakwater metadata of a request admitted by breakwater, from
+the context the handler was given
+*/
+func FromContext(ctx context.Context) (CallMeta, bool) {
+	if a, ok := ctx.Value(admissionKey{}).(*Admission); ok {
+		return a.meta, true
+	}
+	return CallMeta{}, false
+}
+
+/*
+Keeps the metadata parsed by the tap handle for the interceptor
+*/
+func withParsedMetadata(ctx context.Context, rm requestMetadata, err error) context.Context {
+	return context.WithValue(ctx, parsedMetadataKey{}, parsedMetadata{rm: rm, err: err})
+}
+
+/*
+Reads the breakwater metadata of an incoming request, reusing what the tap
+handle already parsed
+*/
+func (b *Breakwater) requestMetadataOf(ctx context.Context, md metadata.MD) (requestMetadata, error) {
+	if parsed, ok := ctx.Value(parsedMetadataKey{}).(parsedMetadata); ok {
+		return parsed.rm, parsed.err
+	}
+	return b.parseRequestMetadata(md)
+}
@@ -0,0 +1,51 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestHandlerReadsCallMeta(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	id := uuid.New()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "7", "id", id.String(), "priority-class", Critical.String()))
+
+	var meta CallMeta
+	var ok bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		meta, ok = FromContext(ctx)
+		return nil, nil
+	}
+	bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	if !ok {
+		t.Fatalf("Expected the handler to find the call metadata")
+	}
+	if meta.ClientID != id || meta.Demand != 7 || meta.Class != Critical {
+		t.Errorf("Unexpected call metadata %+v", meta)
+	}
+	if _, ok := FromContext(ctx); ok {
+		t.Errorf("Expected no call metadata outside of breakwater")
+	}
+}
+
+func TestAdmitReusesTapMetadata(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	declared, parsed := uuid.New(), uuid.New()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", declared.String()))
+	ctx = withParsedMetadata(ctx, requestMetadata{clientId: parsed, demand: 3}, nil)
+
+	admission, err := bw.Admit(ctx)
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+	if meta, _ := FromContext(admission.Context(ctx)); meta.ClientID != parsed || meta.Demand != 3 {
+		t.Errorf("Expected the metadata parsed by the tap handle, got %+v", meta)
+	}
+	admission.Done()
+}
@@ -0,0 +1,82 @@
+package breakwater
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Where the CPU controller of the process's cgroup is mounted, v2 and v1
+var (
+	cgroupV2Dir = "/sys/fs/cgroup"
+	cgroupV1Dir = "/sys/fs/cgroup/cpu"
+)
+
+/*
+CPUs the cgroup's quota allows, false if there is no quota
+*/
+func cgroupCPULimit() (float64, bool) {
+	// v2: "$MAX $PERIOD", with max for no quota
+	if data, err := os.ReadFile(cgroupV2Dir + "/cpu.max"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 {
+			return cpuQuota(fields[0], fields[1])
+		}
+		return 0, false
+	}
+	// v1: the quota, -1 for none, and the period in separate files
+	quota, err1 := os.ReadFile(cgroupV1Dir + "/cpu.cfs_quota_us")
+	period, err2 := os.ReadFile(cgroupV1Dir + "/cpu.cfs_period_us")
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func cpuQuota(quota string, period string) (float64, bool) {
+	q, err1 := strconv.ParseFloat(quota, 64)
+	p, err2 := strconv.ParseFloat(period, 64)
+	if err1 != nil || err2 != nil || q <= 0 || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+/*
+Time the cgroup has been throttled for exceeding its quota so far
+*/
+func cgroupThrottledTime() (time.Duration, bool) {
+	if us, ok := cpuStat(cgroupV2Dir+"/cpu.stat", "throttled_usec"); ok {
+		return time.Duration(us) * time.Microsecond, true
+	}
+	if ns, ok := cpuStat(cgroupV1Dir+"/cpu.stat", "throttled_time"); ok {
+		return time.Duration(ns), true
+	}
+	return 0, false
+}
+
+/*
+Number of periods in which the cgroup has been throttled so far
+*/
+func cgroupThrottledPeriods() (int64, bool) {
+	if n, ok := cpuStat(cgroupV2Dir+"/cpu.stat", "nr_throttled"); ok {
+		return n, true
+	}
+	return cpuStat(cgroupV1Dir+"/cpu.stat", "nr_throttled")
+}
+
+func cpuStat(path string, key string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			return value, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,31 @@
+package breakwater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupV2Limit(t *testing.T) {
+	dir := t.TempDir()
+	defer func(prev string) { cgroupV2Dir = prev }(cgroupV2Dir)
+	cgroupV2Dir = dir
+
+	os.WriteFile(filepath.Join(dir, "cpu.max"), []byte("250000 100000\n"), 0o644)
+	if limit, ok := cgroupCPULimit(); !ok || limit != 2.5 {
+		t.Errorf("Expected a limit of 2.5 CPUs, got %g, %v", limit, ok)
+	}
+	os.WriteFile(filepath.Join(dir, "cpu.max"), []byte("max 100000\n"), 0o644)
+	if _, ok := cgroupCPULimit(); ok {
+		t.Errorf("Expected no limit without a quota")
+	}
+
+	os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 900\nnr_throttled 3\nthrottled_usec 1200\n"), 0o644)
+	if throttled, ok := cgroupThrottledTime(); !ok || throttled != 1200*time.Microsecond {
+		t.Errorf("Expected 1.2ms of throttling, got %v, %v", throttled, ok)
+	}
+	if periods, ok := cgroupThrottledPeriods(); !ok || periods != 3 {
+		t.Errorf("Expected 3 throttled periods, got %d, %v", periods, ok)
+	}
+}
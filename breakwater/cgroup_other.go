@@ -0,0 +1,19 @@
+//go:build !linux
+
+package breakwater
+
+import (
+	"time"
+)
+
+func cgroupCPULimit() (float64, bool) {
+	return 0, false
+}
+
+func cgroupThrottledTime() (time.Duration, bool) {
+	return 0, false
+}
+
+func cgroupThrottledPeriods() (int64, bool) {
+	return 0, false
+}
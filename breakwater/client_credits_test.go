@@ -0,0 +1,215 @@
+package breakwater
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Puts the target into the stalled state: no credits and the last grant long ago
+func stallTarget(t *clientTarget) {
+	<-t.outgoingCredits
+	t.outgoingCredits <- 0
+	<-t.lastCreditGrant
+	t.lastCreditGrant <- time.Now().Add(-1 * time.Second)
+}
+
+func TestStallRecoveryIssuesProbeCredit(t *testing.T) {
+	params := BWParametersDefault
+	params.StallTimeoutRTTs = 2
+	bw := InitBreakwater(params)
+	target := bw.getTarget("server-a")
+	stallTarget(target)
+	target.queueRequest()
+	acquired := make(chan bool)
+	go func() { acquired <- target.acquireCredit(context.Background(), 0, nil) == nil }()
+
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Errorf("Expected the waiting request to get the probe credit")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected waiting requests to be unblocked by a probe")
+	}
+}
+
+func TestStallRecoveryIdleWithoutDemand(t *testing.T) {
+	params := BWParametersDefault
+	params.StallTimeoutRTTs = 2
+	bw := InitBreakwater(params)
+	target := bw.getTarget("server-a")
+	stallTarget(target)
+
+	time.Sleep(50 * time.Millisecond)
+
+	credits := <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 0 {
+		t.Errorf("Expected no probe without pending requests, got %d credits", credits)
+	}
+}
+
+// Waits for the goroutine count to fall back to before, failing after a second
+func expectGoroutinesExit(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Errorf("Expected background goroutines to exit, %d running, %d before", runtime.NumGoroutine(), before)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCloseStopsStallMonitor(t *testing.T) {
+	before := runtime.NumGoroutine()
+	bw := InitBreakwater(BWParametersDefault)
+	if runtime.NumGoroutine() <= before {
+		t.Fatalf("Expected the stall monitor to be running")
+	}
+	bw.Close()
+	bw.Close()
+	expectGoroutinesExit(t, before)
+}
+
+func TestCreditsTrackedPerTarget(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	a := bw.getTarget("server-a")
+	b := bw.getTarget("server-b")
+	if bw.getTarget("server-a") != a {
+		t.Errorf("Expected the same state for repeated lookups of a target")
+	}
+
+	<-a.outgoingCredits
+	a.outgoingCredits <- 40
+
+	credits := <-b.outgoingCredits
+	b.outgoingCredits <- credits
+	if credits != 1 {
+		t.Errorf("Expected credits granted to server-a not to reach server-b, got %d", credits)
+	}
+}
+
+func TestWaitQueueOrder(t *testing.T) {
+	var q waitQueue
+	first := q.push(0)
+	urgent := q.push(5)
+	second := q.push(0)
+	alsoUrgent := q.push(5)
+
+	expected := []*waiter{urgent, alsoUrgent, first, second}
+	for i, w := range expected {
+		if got := q.pop(); got != w {
+			t.Errorf("Expected waiter %d to be priority %d, got priority %d", i, w.priority, got.priority)
+		}
+	}
+	if q.pop() != nil {
+		t.Errorf("Expected queue to be empty")
+	}
+}
+
+func TestCreditsDispatchedByPriorityThenFIFO(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server-a")
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+
+	order := make(chan string, 3)
+	wait := func(name string, priority int) {
+		target.acquireCredit(context.Background(), priority, nil)
+		order <- name
+	}
+	waitQueued := func(n int) {
+		for {
+			credits := <-target.outgoingCredits
+			queued := target.waiters.len()
+			target.outgoingCredits <- credits
+			if queued == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	go wait("batch-1", 0)
+	waitQueued(1)
+	go wait("batch-2", 0)
+	waitQueued(2)
+	go wait("interactive", 1)
+	waitQueued(3)
+
+	for _, expected := range []string{"interactive", "batch-1", "batch-2"} {
+		target.addCredits(1)
+		if got := <-order; got != expected {
+			t.Errorf("Expected %s to be served next, got %s", expected, got)
+		}
+	}
+}
+
+func TestAcquireCreditExpires(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server-a")
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+
+	if err := target.acquireCredit(context.Background(), 0, time.After(10*time.Millisecond)); err != errCreditExpired {
+		t.Errorf("Expected the request to expire without credits, got %v", err)
+	}
+	credits := <-target.outgoingCredits
+	queued := target.waiters.len()
+	target.outgoingCredits <- credits
+	if queued != 0 {
+		t.Errorf("Expected expired waiter to leave the queue, %d still queued", queued)
+	}
+}
+
+func TestWaitQueueDropOldest(t *testing.T) {
+	var q waitQueue
+	urgent := q.push(5)
+	oldest := q.push(0)
+	q.push(0)
+
+	if got := q.dropOldest(); got != oldest {
+		t.Errorf("Expected the oldest lowest priority waiter to be dropped, got priority %d", got.priority)
+	}
+	if q.len() != 2 || q.pop() != urgent {
+		t.Errorf("Expected the remaining waiters to keep their order")
+	}
+}
+
+func TestDropFromFront(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server-a")
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+
+	if target.dropFromFront() {
+		t.Errorf("Expected nothing to drop from an empty queue")
+	}
+
+	result := make(chan error)
+	go func() { result <- target.acquireCredit(context.Background(), 0, nil) }()
+	for {
+		credits := <-target.outgoingCredits
+		queued := target.waiters.len()
+		target.outgoingCredits <- credits
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !target.dropFromFront() {
+		t.Errorf("Expected the waiting request to be dropped")
+	}
+	select {
+	case err := <-result:
+		if err != errDroppedFromFront {
+			t.Errorf("Expected errDroppedFromFront, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the dropped request to stop waiting")
+	}
+}
@@ -0,0 +1,115 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Demand totals
+The demand of all clients, plain and weighted, and the number of clients
+with demand are adjusted whenever a client's demand changes: when it
+reports demand, is granted credits, goes idle, has its weight changed or
+is evicted. Neither the grant policy nor an RTT update has to sum them
+over every client, and the idle decay only visits the clients that still
+have demand, which are kept in demanding.
+*/
+
+/*
+Records the demand a client reported, on a request or its control stream.
+Called holding c.mu.
+*/
+func (b *Breakwater) recordDemand(c *Connection, demand int64, now time.Time) {
+	b.setDemand(c, demand)
+	atomic.StoreInt64(&c.demandUpdated, now.UnixNano())
+}
+
+/*
+Sets the demand of a client and moves the totals by the difference.
+Called holding c.mu, which also guards the weight the weighted total uses.
+*/
+func (b *Breakwater) setDemand(c *Connection, demand int64) {
+	if c.evicted {
+		return
+	}
+	prev := max(atomic.SwapInt64(&c.demand, demand), 0)
+	demand = max(demand, 0)
+	if demand == prev {
+		return
+	}
+	atomic.AddInt64(&b.totalDemand, demand-prev)
+	addFloat64(&b.weightedDemand, c.weight*float64(demand-prev))
+	if prev == 0 {
+		atomic.AddInt64(&b.activeClients, 1)
+		b.demanding.Store(c.id, c)
+	} else if demand == 0 {
+		atomic.AddInt64(&b.activeClients, -1)
+		b.demanding.CompareAndDelete(c.id, c)
+	}
+}
+
+/*
+Sets the weight of a client, moving the weighted demand with it. Called
+holding c.mu.
+*/
+func (b *Breakwater) setWeight(c *Connection, weight float64) {
+	if !c.evicted {
+		addFloat64(&b.weightedDemand, (weight-c.weight)*float64(max(atomic.LoadInt64(&c.demand), 0)))
+	}
+	c.weight = weight
+}
+
+/*
+Takes an evicted client's demand out of the totals for good. Called
+holding c.mu.
+*/
+func (b *Breakwater) forgetDemand(c *Connection) {
+	b.setDemand(c, 0)
+	c.evicted = true
+}
+
+/*
+Decays the demand of clients that reported none since windowStart, so a
+client that went quiet stops counting toward the next allocation. Only
+clients with demand are visited. Called from rttUpdate while holding
+rttLock.
+*/
+func (b *Breakwater) decayIdleDemand(windowStart time.Time) {
+	b.demanding.Range(func(_, value interface{}) bool {
+		c := value.(*Connection)
+		// control streams only report demand when it changes
+		if _, streaming := b.controlStreams.Load(c.id); streaming {
+			return true
+		}
+		c.mu.Lock()
+		if atomic.LoadInt64(&c.demandUpdated) < windowStart.UnixNano() {
+			b.setDemand(c, int64(float64(atomic.LoadInt64(&c.demand))*b.demandDecay))
+		}
+		c.mu.Unlock()
+		return true
+	})
+}
+
+/*
+Clients that unissued credits are overcommitted to, those with demand, or
+every registered client while none has any. At least one, so a client
+evicted concurrently cannot leave zero.
+*/
+func (b *Breakwater) overcommitClients() int64 {
+	if active := atomic.LoadInt64(&b.activeClients); active > 0 {
+		return active
+	}
+	numClients := b.clients.len()
+	return max(numClients, 1)
+}
+
+// Adds delta to the float64 stored as bits at addr
+func addFloat64(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
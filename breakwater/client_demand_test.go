@@ -0,0 +1,80 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDemandRefreshedPerRequest(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	id := uuid.New()
+	bw.RegisterClient(id, 5)
+	bw.updateCreditsToIssue(id, 20)
+
+	c, _ := bw.clients.load(id)
+	if demand := atomic.LoadInt64(&c.demand); demand != 20 {
+		t.Errorf("Expected the demand of the latest request, got %d", demand)
+	}
+}
+
+func TestIdleDemandDecays(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	idle, busy := uuid.New(), uuid.New()
+	bw.RegisterClient(idle, 1)
+	bw.RegisterClient(busy, 40)
+	windowStart := time.Now()
+	bw.updateCreditsToIssue(busy, 40)
+
+	bw.decayIdleDemand(windowStart)
+	c, _ := bw.clients.load(idle)
+	if demand := atomic.LoadInt64(&c.demand); demand != 0 {
+		t.Errorf("Expected the idle client's demand to decay, got %d", demand)
+	}
+	c, _ = bw.clients.load(busy)
+	if demand := atomic.LoadInt64(&c.demand); demand != 40 {
+		t.Errorf("Expected the busy client's demand to be kept, got %d", demand)
+	}
+
+	// unissued credits are only overcommitted to the busy client
+	bw.cTotal = 100
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued
+	if cOvercommit := bw.calculateCreditsToOvercommit(); cOvercommit != 100-cIssued {
+		t.Errorf("Expected all %d unissued credits to be overcommitted to the busy client, got %d", 100-cIssued, cOvercommit)
+	}
+}
+
+func TestDemandTotalsFollowClients(t *testing.T) {
+	params := BWParametersDefault
+	params.ClientIdleTimeout = 1000
+	bw := InitBreakwater(params)
+	idle, busy := uuid.New(), uuid.New()
+	bw.RegisterClient(idle, 10)
+	bw.RegisterClient(busy, 30)
+	bw.SetClientWeight(busy, 2)
+	if total, active := atomic.LoadInt64(&bw.totalDemand), atomic.LoadInt64(&bw.activeClients); total != 40 || active != 2 {
+		t.Errorf("Expected a demand of 40 from 2 clients, got %d from %d", total, active)
+	}
+	if weighted := math.Float64frombits(atomic.LoadUint64(&bw.weightedDemand)); weighted != 70 {
+		t.Errorf("Expected a weighted demand of 70, got %f", weighted)
+	}
+
+	bw.updateCreditsToIssue(busy, 0)
+	if total, active := atomic.LoadInt64(&bw.totalDemand), atomic.LoadInt64(&bw.activeClients); total != 10 || active != 1 {
+		t.Errorf("Expected a demand of 10 from 1 client once the busy one has none, got %d from %d", total, active)
+	}
+
+	c, _ := bw.clients.load(idle)
+	atomic.StoreInt64(&c.lastSeen, 0)
+	bw.evictIdleClients()
+	if total, active := atomic.LoadInt64(&bw.totalDemand), atomic.LoadInt64(&bw.activeClients); total != 0 || active != 0 {
+		t.Errorf("Expected an evicted client's demand to stop counting, got %d from %d", total, active)
+	}
+	if _, ok := bw.demanding.Load(idle); ok {
+		t.Errorf("Expected the evicted client to leave the clients with demand")
+	}
+}
@@ -0,0 +1,48 @@
+package breakwater
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+Client eviction
+Clients are registered on their first request and otherwise stay in the
+registry forever, so clients that went away keep counting toward the
+additive factor, the overcommit split and the revocation clamp. With
+clientIdleTimeout set, clients that sent no request for that long are
+evicted at the next RTT update, along with the credits they were issued.
+Clients with a control stream are alive however long they stay quiet. An
+evicted client that comes back is registered again as a new client.
+*/
+func (b *Breakwater) evictIdleClients() {
+	if b.clientIdleTimeout <= 0 {
+		return
+	}
+	idleSince := b.now().Add(-time.Duration(b.clientIdleTimeout) * time.Microsecond).UnixNano()
+	var evicted, released int64 = 0, 0
+	b.clients.rangeClients(func(c *Connection) bool {
+		if _, streaming := b.controlStreams.Load(c.id); streaming {
+			return true
+		}
+		c.mu.Lock()
+		if atomic.LoadInt64(&c.lastSeen) < idleSince && b.clients.remove(c.id, c) {
+			evicted++
+			released += atomic.LoadInt64(&c.issued)
+			b.trackClientRTT(c.rtt, 0)
+			b.forgetDemand(c)
+			if b.tokens != nil {
+				b.tokens.forget(c.id)
+			}
+			b.logger("[Client Eviction]:	Evicted client %s idle since %v", c.id, time.Unix(0, atomic.LoadInt64(&c.lastSeen)))
+		}
+		c.mu.Unlock()
+		return true
+	})
+	if evicted == 0 {
+		return
+	}
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued - released
+	b.logger("[Client Eviction]:	Evicted %d clients, released %d credits", evicted, released)
+}
@@ -0,0 +1,59 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEvictIdleClients(t *testing.T) {
+	now := time.Unix(0, 0)
+	params := BWParametersDefault
+	params.ClientIdleTimeout = 100000
+	params.Clock = func() time.Time { return now }
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	idle := registerWithCredits(bw, 200)
+	active := registerWithCredits(bw, 100)
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(50 * time.Millisecond)
+		bw.RegisterClient(active, 1)
+		bw.UpdateCredits()
+	}
+	if _, ok := bw.clients.load(idle); ok {
+		t.Errorf("Expected the idle client to be evicted")
+	}
+	if _, ok := bw.clients.load(active); !ok {
+		t.Errorf("Expected the client sending requests to be kept")
+	}
+	s := bw.Snapshot()
+	if s.NumClients != 1 || s.CIssued != 100 {
+		t.Errorf("Expected one client holding 100 credits, got %d clients and cIssued %d", s.NumClients, s.CIssued)
+	}
+
+	// a returning client is registered and counted once again
+	bw.RegisterClient(idle, 1)
+	bw.RegisterClient(idle, 1)
+	if numClients := bw.clients.len(); numClients != 2 {
+		t.Errorf("Expected the returning client to be counted again, got %d clients", numClients)
+	}
+}
+
+func TestRemoveKeepsReregisteredClient(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	id := uuid.New()
+	bw.RegisterClient(id, 1)
+	stale, _ := bw.clients.load(id)
+	bw.clients.remove(id, stale)
+	bw.RegisterClient(id, 1)
+
+	if bw.clients.remove(id, stale) {
+		t.Errorf("Expected removing a stale connection to leave the new one")
+	}
+	if numClients := bw.clients.len(); numClients != 1 {
+		t.Errorf("Expected one client, got %d", numClients)
+	}
+}
@@ -0,0 +1,79 @@
+package breakwater
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+/*
+How the server identifies clients, selected with BWParameters.ClientIdentity
+*/
+const (
+	IdentityMetadata = "metadata" // the id the client declares in its metadata
+	IdentityTLS      = "tls"      // the peer's TLS certificate
+	IdentityFunc     = "func"     // BWParameters.IdentityFunc, e.g. set by a trusted auth interceptor
+)
+
+// namespace of the client ids derived from identities
+var identityNamespace = uuid.MustParse("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+
+var errUnidentifiedClient = status.Errorf(codes.Unauthenticated, "client identity required")
+
+/*
+Identity of the peer's TLS certificate: its first URI SAN, such as a SPIFFE
+id, or else its subject. "" without a verified client certificate.
+*/
+func tlsIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return certificateIdentity(info.State.VerifiedChains[0][0])
+}
+
+func certificateIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.String()
+}
+
+/*
+Returns the id the request's client is accounted under. Unless clients are
+identified by their metadata, it is derived from the identity of the
+connection, and the declared id is only used for clients without one if
+MetadataIdentityFallback is set.
+*/
+func (b *Breakwater) identifyClient(ctx context.Context, declared uuid.UUID) (uuid.UUID, error) {
+	if isLocalCall(ctx) {
+		return declared, nil
+	}
+	var identity string
+	switch b.clientIdentity {
+	case IdentityTLS:
+		identity = tlsIdentity(ctx)
+	case IdentityFunc:
+		if b.identityFunc != nil {
+			identity, _ = b.identityFunc(ctx)
+		}
+	default:
+		return declared, nil
+	}
+	if identity != "" {
+		return uuid.NewSHA1(identityNamespace, []byte(identity)), nil
+	}
+	if b.metadataIdentityFallback {
+		return declared, nil
+	}
+	return uuid.Nil, errUnidentifiedClient
+}
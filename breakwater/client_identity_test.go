@@ -0,0 +1,87 @@
+package breakwater
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type identityKey struct{}
+
+func admitAs(bw *Breakwater, ctx context.Context, declared uuid.UUID) error {
+	md := metadata.Pairs("demand", "1", "id", declared.String())
+	_, err := bw.Admit(metadata.NewIncomingContext(ctx, md))
+	return err
+}
+
+func TestIdentityFromFunc(t *testing.T) {
+	params := BWParametersDefault
+	params.ClientIdentity = IdentityFunc
+	params.IdentityFunc = func(ctx context.Context) (string, bool) {
+		identity, ok := ctx.Value(identityKey{}).(string)
+		return identity, ok
+	}
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	// a client cannot split itself into several by declaring other ids
+	ctx := context.WithValue(context.Background(), identityKey{}, "service-a")
+	for i := 0; i < 3; i++ {
+		if err := admitAs(bw, ctx, uuid.New()); err != nil {
+			t.Fatalf("Expected an identified client to be admitted, got %v", err)
+		}
+	}
+	if numClients := bw.Snapshot().NumClients; numClients != 1 {
+		t.Errorf("Expected requests of one identity to count as one client, got %d", numClients)
+	}
+
+	if err := admitAs(bw, context.Background(), uuid.New()); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a client without an identity to be rejected, got %v", err)
+	}
+	bw.metadataIdentityFallback = true
+	declared := uuid.New()
+	if err := admitAs(bw, context.Background(), declared); err != nil {
+		t.Fatalf("Expected the declared id to be accepted as a fallback, got %v", err)
+	}
+	if _, ok := bw.clients.load(declared); !ok {
+		t.Errorf("Expected the client to be registered under its declared id")
+	}
+}
+
+func TestIdentityFromTLS(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/service-a")
+	withCert := func(cert *x509.Certificate) context.Context {
+		state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+	if identity := tlsIdentity(withCert(&x509.Certificate{URIs: []*url.URL{spiffe}})); identity != "spiffe://example.org/service-a" {
+		t.Errorf("Expected the URI SAN as the identity, got %q", identity)
+	}
+	if identity := tlsIdentity(withCert(&x509.Certificate{Subject: pkix.Name{CommonName: "service-b"}})); identity != "CN=service-b" {
+		t.Errorf("Expected the subject as the identity, got %q", identity)
+	}
+	if identity := tlsIdentity(context.Background()); identity != "" {
+		t.Errorf("Expected no identity without a peer, got %q", identity)
+	}
+
+	params := BWParametersDefault
+	params.ClientIdentity = IdentityTLS
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	if err := admitAs(bw, withCert(&x509.Certificate{URIs: []*url.URL{spiffe}}), uuid.New()); err != nil {
+		t.Fatalf("Expected a client with a certificate to be admitted, got %v", err)
+	}
+	if _, ok := bw.clients.load(uuid.NewSHA1(identityNamespace, []byte(spiffe.String()))); !ok {
+		t.Errorf("Expected the client to be registered under the id derived from its certificate")
+	}
+}
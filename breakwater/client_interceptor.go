@@ -2,30 +2,90 @@ package breakwater
 
 import (
 	"context"
+	"errors"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+/*
+Client side state for a single target (keyed by cc.Target()),
+so credits granted by one server are only spent on requests to that server
+*/
+type clientTarget struct {
+	target          string
+	pendingOutgoing chan int64     // pending outgoing requests
+	outgoingCredits chan int64     // outgoing credits, also guards waiters
+	waiters         waitQueue      // requests blocked until a credit is available
+	lastCreditGrant chan time.Time // last time the target granted credits
+	codel           *codel         // expires waiters by CoDel, nil for the fixed client expiration
+	srtt            int64          // smoothed RTT to the target in microseconds, 0 until measured, accessed atomically
+	serverVersion   int64          // protocol version of the target, 0 until it answers, accessed atomically
+	lease           int64          // lease of the target's grants in microseconds, 0 if they never expire, accessed atomically
+	leaseExpiry     int64          // unix nanos when unspent credits expire, 0 for never, accessed atomically
+	retryDebt       float64        // fraction of a credit discounted retries owe, guarded by outgoingCredits
+	saturation      uint64         // float64 bits of the last credit saturation the target reported, accessed atomically
+	delayRatio      uint64         // float64 bits of the last delay ratio the target reported, accessed atomically
+	loadReported    int32          // 1 once the target reported its load, accessed atomically
+	token           int64          // connection token the target assigned, 0 until it has, accessed atomically
+	verbose                        // writes debug lines, the Breakwater's BWParameters.Verbose
+	// owned by the stall monitor goroutine
+	nextProbe    time.Time
+	stallBackoff time.Duration
+}
+
+func newClientTarget(target string) *clientTarget {
+	t := &clientTarget{
+		target: target,
+		// Outgoing buffer drops requests if > 50 requests in queue
+		pendingOutgoing: make(chan int64, MAX_Q_LENGTH),
+		outgoingCredits: make(chan int64, 1),
+		lastCreditGrant: make(chan time.Time, 1),
+	}
+	// give 1 credit to start
+	t.outgoingCredits <- 1
+	t.lastCreditGrant <- time.Now()
+	return t
+}
+
+/*
+Returns the state for a target, creating it on first use
+*/
+func (b *Breakwater) getTarget(target string) *clientTarget {
+	if t, ok := b.targets.Load(target); ok {
+		return t.(*clientTarget)
+	}
+	newTarget := newClientTarget(target)
+	newTarget.verbose = b.verbose
+	if b.codelInterval > 0 {
+		newTarget.codel = newCoDel(b.codelInterval)
+	}
+	t, loaded := b.targets.LoadOrStore(target, newTarget)
+	if !loaded {
+		b.logger("[Client Init]:	Tracking credits for new target %s", target)
+	}
+	return t.(*clientTarget)
+}
+
 /*
 Helper to get current demand (not exact due to race conditions, but gives a
 fairly precise idea of number of outgoing requests in queue)
 */
-func (b *Breakwater) getDemand() (demand int) {
-	return len(b.pendingOutgoing)
+func (t *clientTarget) getDemand() (demand int) {
+	return len(t.pendingOutgoing)
 }
 
 /*
 Adds request to the outgoing queue, returns false
 and drops request if there are > 50 elements in channel
 */
-func (b *Breakwater) queueRequest() bool {
+func (t *clientTarget) queueRequest() bool {
 	select {
-	case b.pendingOutgoing <- 1:
+	case t.pendingOutgoing <- 1:
 		return true
 	default:
 		return false
@@ -36,150 +96,342 @@ func (b *Breakwater) queueRequest() bool {
 Dequeues request to the outgoing queue,
 returns false if queue channel is empty
 */
-func (b *Breakwater) dequeueRequest() bool {
+func (t *clientTarget) dequeueRequest() bool {
 	select {
-	case <-b.pendingOutgoing:
+	case <-t.pendingOutgoing:
 		return true
 	default:
 		return false
 	}
 }
 
+var (
+	errCreditExpired    = errors.New("request expired in queue")
+	errDroppedFromFront = errors.New("request dropped from the front of the queue")
+)
+
 /*
-Unblocks blockingCreditQueue
+Takes a credit if one is available and no request is waiting for one
 */
-func (b *Breakwater) unblockNoCreditBlock() {
+func (t *clientTarget) tryAcquireCredit() bool {
+	creditBalance := <-t.outgoingCredits
+	if creditBalance > 0 && t.waiters.len() == 0 {
+		t.outgoingCredits <- creditBalance - 1
+		return true
+	}
+	t.outgoingCredits <- creditBalance
+	return false
+}
+
+/*
+Takes a credit for a request of the given priority, waiting in the queue
+if none is available. A nil deadline waits indefinitely.
+Returns errCreditExpired if the deadline fires, ctx.Err() if ctx is done,
+or errDroppedFromFront if client side AQM dropped the request to make room
+for a newer one.
+*/
+func (t *clientTarget) acquireCredit(ctx context.Context, priority int, deadline <-chan time.Time) error {
+	creditBalance := <-t.outgoingCredits
+	if creditBalance > 0 && t.waiters.len() == 0 {
+		t.outgoingCredits <- creditBalance - 1
+		if t.verbose {
+			t.logger("[Waiting in queue]:	Unblocked with credit balance %d\n", creditBalance-1)
+		}
+		return nil
+	}
+	w := t.waiters.push(priority)
+	t.outgoingCredits <- creditBalance
+
+	var err error
 	select {
-	case b.noCreditBlocker <- 1:
-		return
-	default:
-		return
+	case served := <-w.ready:
+		if !served {
+			return errDroppedFromFront
+		}
+		return nil
+	case <-deadline:
+		err = errCreditExpired
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	creditBalance = <-t.outgoingCredits
+	if !t.waiters.remove(w) {
+		// The waiter was served or dropped while we were giving up
+		if <-w.ready {
+			// pass the credit on
+			creditBalance = t.dispatch(creditBalance + 1)
+		} else {
+			err = errDroppedFromFront
+		}
+	}
+	t.outgoingCredits <- creditBalance
+	return err
+}
+
+/*
+Client side AQM: drops the oldest waiting request so a new arrival can
+take its place in a full queue. Returns false if nothing is waiting.
+*/
+func (t *clientTarget) dropFromFront() bool {
+	creditBalance := <-t.outgoingCredits
+	w := t.waiters.dropOldest()
+	t.outgoingCredits <- creditBalance
+	if w == nil {
+		return false
+	}
+	w.ready <- false
+	return true
+}
+
+/*
+Sets the credit balance and hands credits to waiters in queue order
+*/
+func (t *clientTarget) setCredits(credits int64) {
+	<-t.outgoingCredits
+	t.outgoingCredits <- t.dispatch(credits)
+}
+
+/*
+Adds credits to the balance and hands them to waiters in queue order
+*/
+func (t *clientTarget) addCredits(credits int64) {
+	creditBalance := <-t.outgoingCredits
+	t.outgoingCredits <- t.dispatch(creditBalance + credits)
+}
+
+/*
+Hands credits to waiters in queue order, returns the remaining balance.
+Callers must hold outgoingCredits.
+*/
+func (t *clientTarget) dispatch(creditBalance int64) int64 {
+	for creditBalance > 0 {
+		w := t.waiters.pop()
+		if w == nil {
+			break
+		}
+		creditBalance--
+		w.ready <- true
 	}
+	return creditBalance
+}
+
+/*
+Records that the target granted credits to this client
+*/
+func (t *clientTarget) recordCreditGrant() {
+	<-t.lastCreditGrant
+	t.lastCreditGrant <- time.Now()
 }
 
 func (b *Breakwater) UnaryInterceptorClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if b.PassThrough() {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		var header, trailer metadata.MD // variable to store header and trailer
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+		return header, trailer, err
+	}
+	if cache, ok := b.responseCaches[method]; ok {
+		return b.invokeCached(cache, method, req, reply, func() error { return b.Invoke(ctx, cc.Target(), send) })
+	}
+	return b.Invoke(ctx, cc.Target(), send)
+}
+
+/*
+Sends a request with send, the transport agnostic core of the client
+interceptor shared with the HTTP middleware. send gets a context whose
+outgoing metadata carries the breakwater headers, and returns the response
+header and trailer. Requests to the same target share its credits.
+*/
+func (b *Breakwater) Invoke(ctx context.Context, target string, send func(ctx context.Context) (header metadata.MD, trailer metadata.MD, err error)) error {
+	if d := downstreamTimeOf(ctx); d != nil {
+		// the caller is a handler waiting on this call
+		start := time.Now()
+		defer func() { d.add(time.Since(start)) }()
+	}
+	ctx, target = b.keyedTarget(ctx, target)
+	if b.PassThrough() {
+		_, _, err := send(ctx)
+		return err
+	}
+	var err error
+	if b.maxRetries > 0 {
+		err = b.invokeWithRetries(ctx, target, send)
+	} else {
+		err = b.invokeWithCredits(ctx, target, send)
+	}
+	return b.reject(ctx, err)
+}
 
-	// retrieve price table for downstream clients queueing delay
-	// var isDownstream bool = false
-	// var reqid uuid.UUID
+/*
+Attaches the breakwater metadata for target to an outgoing call without
+waiting for a credit, for calls that credits do not gate such as streams,
+so the server still admits them
+*/
+func (b *Breakwater) WithMetadata(ctx context.Context, target string) context.Context {
+	ctx, target = b.keyedTarget(ctx, target)
+	t := b.getTarget(target)
+	class, hasClass := priorityClassFromContext(ctx)
+	return b.withRequestMetadata(ctx, t, int64(t.getDemand()), class, hasClass)
+}
+
+/*
+Waits in the target's queue for a credit, then sends the request
+and applies the credits piggybacked on the response
+*/
+func (b *Breakwater) invokeWithCredits(ctx context.Context, target string, send func(ctx context.Context) (metadata.MD, metadata.MD, error)) error {
 	timeStart := time.Now()
-	// var reqTimeData request
-	// md, ok := metadata.FromIncomingContext(ctx)
-	// if ok && len(md["reqid"]) > 0 {
-	// 	logger("[Before queue]:	is downstream request\n")
-	// 	// reqid, _ := uuid.Parse(md["reqid"][0])
-	// 	// r, ok := b.requestMap.Load(reqid)
-	// 	// isDownstream = true
-	// 	// if ok {
-	// 	// 	// should always be okay (the reqId should already be stored)
-	// 	// 	reqTimeData = r.(request)
-	// 	// } else {
-	// 	// 	b.requestMap.Store(reqid, request{reqid, 0})
-	// 	// }
-	// } else {
-	// 	// This is first upstream client / end user
-	// 	reqid = uuid.New()
-	// }
+
+	t := b.getTarget(target)
+	t.expireLease()
+	if bypassedByContext(ctx) {
+		b.logger("[Client Bypass]:	Sending request without a credit\n")
+		return b.sendRequest(ctx, t, false, send)
+	}
+	if retryAttemptOf(ctx) > 0 && !t.chargeRetry(b.retryCreditCost()) {
+		// a free retry is neither queued nor counted as demand
+		b.logger("[Client Retry]:	Sending retry attempt %d without a credit\n", retryAttemptOf(ctx))
+		return b.sendRequest(ctx, t, false, send)
+	}
 
 	// Check if queue is too long
-	var added bool = b.queueRequest()
-	if useClientQueueLength && !added {
-		return status.Errorf(codes.ResourceExhausted, "Client queue too long, request dropped at client %s", b.id.String())
-	}
-
-	// A note on non-deterministic channel waiting:
-	// While there is no determined order of goroutines waiting,
-	// Current implementations use FIFO queues:
-	// https://stackoverflow.com/questions/25860633/order-of-goroutine-unblocking-on-single-channel
-
-	for {
-		// Unblock if credits are available
-		logger("[Waiting in queue]:	Checking if unblock available\n")
-		// blocks until credit available
-		<-b.noCreditBlocker
-
-		// check that our time spent in queue has not exceeded the aqm threshold
-		// if so, we should drop the request
-		// time in microseconds
-		if useClientTimeExpiration {
-			timeTaken := time.Since(timeStart).Microseconds()
-			if timeTaken > b.clientExpiration {
-				// drop request
-				logger("[Client Req Expired]:	Dropping request due to client side req expiration. Delay (us) was: %d\n", timeTaken)
-				b.unblockNoCreditBlock()
-				b.dequeueRequest()
-				return status.Errorf(codes.ResourceExhausted,
-					"Client id %s request expired in queue.", b.id.String())
+	var added bool = t.queueRequest()
+	if !added && b.dropFromFront && t.dropFromFront() {
+		// the dropped request hands its place in the queue to this one
+		b.logShed("[Client AQM]:	Queue full, dropped oldest waiting request\n")
+		added = true
+	}
+	if atomic.LoadInt32(&b.useClientQueueLength) == 1 && !added {
+		return ClientQueueFullError(b.id.String(), t.retryDelay(b.getRTT()))
+	}
+
+	class, _ := priorityClassFromContext(ctx)
+	priority := int(class)
+	if b.priorityFunc != nil {
+		priority = b.priorityFunc(ctx)
+	}
+
+	// a credit at hand needs no expiration timer
+	var err error
+	if !t.tryAcquireCredit() {
+		// check that our time spent in queue has not exceeded the client expiration
+		// if so, we should drop the request. With CoDel this is decided once the
+		// request has a credit instead.
+		var deadline <-chan time.Time
+		if b.expiresWaiters() && t.codel == nil {
+			clientExpiration := atomic.LoadInt64(&b.clientExpiration)
+			if b.measureRTT {
+				// a credit cannot arrive sooner than one RTT
+				clientExpiration = max(clientExpiration, t.rttEstimate(b.getRTT()))
 			}
+			expiration := time.Duration(clientExpiration)*time.Microsecond - time.Since(timeStart)
+			timer := time.NewTimer(expiration)
+			defer timer.Stop()
+			deadline = timer.C
 		}
 
-		logger("[Waiting in queue]:	Unblock available, checking if credits are sufficient\n")
-		// Check actual number of credits (channel for binary semaphore)
-		creditBalance := <-b.outgoingCredits
-		if creditBalance > 0 {
-			// Decrement credit balance
-			creditBalance--
-			// Send updated credit balance
-			b.outgoingCredits <- creditBalance
-
-			// If there are still credits, unblock other requests
-			if creditBalance > 0 {
-				b.unblockNoCreditBlock()
-			}
-			logger("[Waiting in queue]:	Unblocked with credit balance %d\n", creditBalance)
-			break
-		} else {
-			// Else, return to binary semaphore and keep looping
-			// Set a minimum credit balance of 0
-			b.outgoingCredits <- 0
-			// TODO: Consider adding a timeout here
+		b.logger("[Waiting in queue]:	Waiting for credit with priority %d\n", priority)
+		err = t.acquireCredit(ctx, priority, deadline)
+	}
+	if err != nil {
+		if err == errDroppedFromFront {
+			// our place in the queue was taken over by the newer request
+			b.logShed("[Client AQM]:	Dropping request from front of queue after %d us\n", time.Since(timeStart).Microseconds())
+			return ClientDroppedFromFrontError(b.id.String(), t.retryDelay(b.getRTT()))
+		}
+		t.dequeueRequest()
+		if err != errCreditExpired {
+			return status.FromContextError(err).Err()
+		}
+		// drop request
+		timeTaken := time.Since(timeStart).Microseconds()
+		b.logShed("[Client Req Expired]:	Dropping request due to client side req expiration. Delay (us) was: %d\n", timeTaken)
+		return ClientExpirationError(b.id.String(), t.retryDelay(b.getRTT()))
+	}
+	if t.codel != nil && b.expiresWaiters() {
+		timeTaken := time.Since(timeStart).Microseconds()
+		if t.codel.shouldDrop(float64(timeTaken), b.codelTargetDelay(), time.Now()) {
+			// the credit goes to the next waiter
+			t.dequeueRequest()
+			t.addCredits(1)
+			b.logShed("[Client AQM]:	CoDel dropping request after %d us in queue\n", timeTaken)
+			return ClientExpirationError(b.id.String(), t.retryDelay(b.getRTT()))
 		}
-		logger("[Before Req]:	The method name for price table is %s\n")
-		// noCreditBlocker will unblock again when another request returns with
-		// more credits
 	}
+	t.spendExtraCredits(costOf(ctx) - 1)
+	b.sampleCreditWait(ctx, time.Since(timeStart))
+
+	return b.sendRequest(ctx, t, true, send)
+}
 
+/*
+Sends a request that has its credit, or needs none, and applies the
+credits piggybacked on the response. A queued request leaves the target's
+queue once its demand was read.
+*/
+func (b *Breakwater) sendRequest(ctx context.Context, t *clientTarget, queued bool, send func(ctx context.Context) (metadata.MD, metadata.MD, error)) error {
+	class, hasClass := priorityClassFromContext(ctx)
 	// Get demand
-	demand := b.getDemand()
-	logger("[Waiting in queue]:	demand is %d\n", demand)
-	ctx = metadata.AppendToOutgoingContext(ctx, "demand", strconv.Itoa(demand), "id", b.id.String())
+	demand := t.getDemand()
+	if b.verbose {
+		b.logger("[Waiting in queue]:	demand is %d\n", demand)
+	}
+	ctx = b.withRequestMetadata(ctx, t, int64(demand), class, hasClass)
+	ctx = withBypassAndCost(ctx)
+	if b.clientTenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "tenant", b.clientTenant)
+	}
+	if b.clientWeight > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "weight", strconv.FormatFloat(b.clientWeight, 'g', -1, 64))
+	}
 
-	// After breaking out of request loop, remove request from queue and send request
-	// This should never be blocked
-	logger("[Waiting in queue]:	Dequeueing and handling request\n")
-	b.dequeueRequest()
+	if queued {
+		// After breaking out of request loop, remove request from queue and send request
+		// This should never be blocked
+		b.logger("[Waiting in queue]:	Dequeueing and handling request\n")
+		t.dequeueRequest()
+	}
 
-	var header metadata.MD // variable to store header and trailer
-	err := invoker(ctx, method, req, reply, cc, grpc.Header(&header))
+	if b.measureRTT {
+		ctx = t.stampRTT(ctx)
+	}
+	header, trailer, err := send(ctx)
+	if b.measureRTT {
+		t.recordRTT(trailer)
+	}
+	t.recordServerVersion(header)
+	t.recordToken(header)
+	t.recordLoad(header)
+	// applied last, so a revocation also bounds credits granted below
+	defer t.revokeCredits(header)
 	if err != nil {
 		// The request failed. if flag creditsOnFail is set, then we should add back one credit to the credit balance
 		if creditsOnFail {
-			select {
-			case credit := <-b.outgoingCredits:
-				b.outgoingCredits <- credit + 1
-			default:
-				// Log an error or handle the situation when there are no credits to retrieve
-				status.Errorf(codes.ResourceExhausted, "Client id %s has no credits to add back.", b.id.String())
-			}
-			b.unblockNoCreditBlock()
+			t.addCredits(1)
 		}
 		return err
 	}
 
 	if len(header["credits"]) > 0 {
-		cXNew, _ := strconv.ParseInt(header["credits"][0], 10, 64)
-		logger("[Received Resp]:	Updated credits cXnew to spend is %d\n", cXNew)
+		// a grant made at an RTT update while the request was in flight comes last
+		cXNew, _ := strconv.ParseInt(header["credits"][len(header["credits"])-1], 10, 64)
+		if b.verbose {
+			b.logger("[Received Resp]:	Updated credits cXnew to spend is %d\n", cXNew)
+		}
 
 		// Update credits and unblock other requests
-		<-b.outgoingCredits
-		b.outgoingCredits <- max(cXNew, 1)
-		b.unblockNoCreditBlock()
+		// a zero grant blocks new requests until a positive one arrives
+		t.applyGrant(b, max(cXNew, b.minGrant()))
+		t.recordCreditGrant()
+		t.renewLease(header)
 	} else {
-		logger("[Received Resp]:	No attached credits in response\n")
+		b.logger("[Received Resp]:	No attached credits in response\n")
 		// If no response, then just put to 1
-		outgoingCredits := <-b.outgoingCredits
-		b.outgoingCredits <- max(outgoingCredits, 1)
-		b.unblockNoCreditBlock()
+		outgoingCredits := <-t.outgoingCredits
+		t.outgoingCredits <- t.dispatch(max(outgoingCredits, 1))
+		t.recordCreditGrant()
 	}
 	return err
 }
@@ -0,0 +1,39 @@
+package breakwater
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+const clientKeyMetadata = "client-key"
+
+/*
+Splits a client's credits by the key ClientKeyFunc gives the request, e.g.
+the tenant a proxy forwards it for. Each key of a target gets its own
+credits, and the key is sent along so a server with SubdivideClients set
+accounts it as a client of its own.
+*/
+func (b *Breakwater) keyedTarget(ctx context.Context, target string) (context.Context, string) {
+	if b.clientKeyFunc == nil {
+		return ctx, target
+	}
+	key := b.clientKeyFunc(ctx)
+	if key == "" {
+		return ctx, target
+	}
+	return metadata.AppendToOutgoingContext(ctx, clientKeyMetadata, key), target + "#" + key
+}
+
+/*
+Id a request is accounted under when clients are subdivided by the keys
+they send: one derived from the client's id and the key, or the client's
+own id for requests without a key
+*/
+func (b *Breakwater) subdivideClient(clientId uuid.UUID, md metadata.MD) uuid.UUID {
+	if !b.subdivideClients || len(md[clientKeyMetadata]) == 0 || md[clientKeyMetadata][0] == "" {
+		return clientId
+	}
+	return uuid.NewSHA1(clientId, []byte(md[clientKeyMetadata][0]))
+}
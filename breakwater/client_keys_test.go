@@ -0,0 +1,54 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type tenantKey struct{}
+
+// Sends requests for two tenants through one proxy client
+func sendForTenants(t *testing.T, subdivide bool) (server *Breakwater, proxy *Breakwater) {
+	serverParams := BWParametersDefault
+	serverParams.SubdivideClients = subdivide
+	server = InitBreakwater(serverParams)
+	setDelay(server, 0)
+	clientParams := BWParametersDefault
+	clientParams.ClientKeyFunc = func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}
+	proxy = InitBreakwater(clientParams)
+
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		a, err := server.Admit(metadata.NewIncomingContext(ctx, md))
+		return a.Header, nil, err
+	}
+	for _, tenant := range []string{"a", "b", "a"} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		if err := proxy.Invoke(ctx, "server", send); err != nil {
+			t.Fatalf("Request for tenant %s failed: %v", tenant, err)
+		}
+	}
+	return server, proxy
+}
+
+func TestClientKeysSubdivideCredits(t *testing.T) {
+	server, proxy := sendForTenants(t, true)
+	if numClients := server.Snapshot().NumClients; numClients != 2 {
+		t.Errorf("Expected each tenant of the proxy to be a client, got %d clients", numClients)
+	}
+	for _, target := range []string{"server#a", "server#b"} {
+		if _, ok := proxy.targets.Load(target); !ok {
+			t.Errorf("Expected the proxy to keep separate credits for %s", target)
+		}
+	}
+
+	server, _ = sendForTenants(t, false)
+	if numClients := server.Snapshot().NumClients; numClients != 1 {
+		t.Errorf("Expected the proxy to be one client unless the server subdivides, got %d", numClients)
+	}
+}
@@ -0,0 +1,114 @@
+package breakwater
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+const registryShards = 64 // shards in the client registry, a power of two
+
+/*
+Registry of the connections of a server's clients.
+Clients are spread over shards by their id, so registering clients and
+looking them up on the request path only contend within a shard. The
+registry hands out *Connection pointers, and each connection guards its
+own fields with its mutex, so updates happen in place without copies.
+*/
+type clientRegistry struct {
+	shards [registryShards]registryShard
+	count  int64 // registered clients, accessed atomically
+}
+
+type registryShard struct {
+	mu      sync.RWMutex
+	clients map[uuid.UUID]*Connection
+}
+
+func newClientRegistry() *clientRegistry {
+	r := &clientRegistry{}
+	for i := range r.shards {
+		r.shards[i].clients = make(map[uuid.UUID]*Connection)
+	}
+	return r
+}
+
+/*
+Client ids are random UUIDs, so the last byte spreads them evenly
+*/
+func (r *clientRegistry) shard(id uuid.UUID) *registryShard {
+	return &r.shards[id[len(id)-1]%registryShards]
+}
+
+/*
+Returns the connection of a client, false if it is not registered
+*/
+func (r *clientRegistry) load(id uuid.UUID) (*Connection, bool) {
+	s := r.shard(id)
+	s.mu.RLock()
+	c, ok := s.clients[id]
+	s.mu.RUnlock()
+	return c, ok
+}
+
+/*
+Stores the connection unless the client is already registered.
+Returns the registered connection and true if it was already there.
+*/
+func (r *clientRegistry) loadOrStore(id uuid.UUID, c *Connection) (*Connection, bool) {
+	s := r.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.clients[id]; ok {
+		return existing, true
+	}
+	s.clients[id] = c
+	atomic.AddInt64(&r.count, 1)
+	return c, false
+}
+
+/*
+Removes the connection of a client, unless the client was registered again
+with a new connection. Returns false if c was not registered.
+*/
+func (r *clientRegistry) remove(id uuid.UUID, c *Connection) bool {
+	s := r.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[id] != c {
+		return false
+	}
+	delete(s.clients, id)
+	atomic.AddInt64(&r.count, -1)
+	return true
+}
+
+/*
+Number of registered clients
+*/
+func (r *clientRegistry) len() int64 {
+	return atomic.LoadInt64(&r.count)
+}
+
+/*
+Calls f on every connection until it returns false.
+Each shard is copied under its lock, so f may lock connections or call
+back into the registry.
+*/
+func (r *clientRegistry) rangeClients(f func(c *Connection) bool) {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.RLock()
+		clients := make([]*Connection, 0, len(s.clients))
+		for _, c := range s.clients {
+			clients = append(clients, c)
+		}
+		s.mu.RUnlock()
+		for _, c := range clients {
+			if !f(c) {
+				return
+			}
+		}
+	}
+}
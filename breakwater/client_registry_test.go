@@ -0,0 +1,153 @@
+package breakwater
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const benchmarkClients = 4096
+
+func TestRegisterClientCountsOnce(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	id := uuid.New()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bw.RegisterClient(id, 1)
+		}()
+	}
+	wg.Wait()
+
+	if numClients := bw.clients.len(); numClients != 1 {
+		t.Errorf("Expected a client registered concurrently to be counted once, got %d", numClients)
+	}
+}
+
+// Credit updates used to Load, copy and Store a Connection value, so a
+// concurrent Store of another field could write back a stale issued count.
+// Run with -race to also check the counters are accessed safely.
+func TestConcurrentUpdatesNotLost(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	id := uuid.New()
+	bw.RegisterClient(id, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bw.updateCreditsToIssue(id, 10)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bw.SetClientWeight(id, float64(j%3+1))
+				bw.setClientRTT(id, 100)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, _ := bw.clients.load(id)
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued
+	if issued := atomic.LoadInt64(&c.issued); issued != cIssued {
+		t.Errorf("Expected the connection's credits to match cIssued %d, got %d", cIssued, issued)
+	}
+	if demand := atomic.LoadInt64(&c.demand); demand != 10 {
+		t.Errorf("Expected the demand of 10 to be recorded, got %d", demand)
+	}
+}
+
+func TestRangeClients(t *testing.T) {
+	r := newClientRegistry()
+	for i := 0; i < 100; i++ {
+		id := uuid.New()
+		r.loadOrStore(id, &Connection{id: id})
+	}
+	seen := 0
+	r.rangeClients(func(c *Connection) bool {
+		seen++
+		return true
+	})
+	if seen != 100 {
+		t.Errorf("Expected to visit 100 clients, got %d", seen)
+	}
+}
+
+func benchmarkIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, benchmarkClients)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	return ids
+}
+
+// The previous registry: Connection values in a sync.Map, updated by
+// Load, copy, Store under a per-connection channel lock
+type valueConnection struct {
+	issued    int64
+	demand    int64
+	writeLock chan int64
+	lastSeen  chan time.Time
+}
+
+func BenchmarkRegistrySyncMapValues(b *testing.B) {
+	var clients sync.Map
+	ids := benchmarkIDs()
+	for _, id := range ids {
+		c := valueConnection{writeLock: make(chan int64, 1), lastSeen: make(chan time.Time, 1)}
+		c.writeLock <- 1
+		c.lastSeen <- time.Now()
+		clients.Store(id, c)
+	}
+	var next int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := ids[atomic.AddInt64(&next, 1)%benchmarkClients]
+			value, _ := clients.Load(id)
+			c := value.(valueConnection)
+			<-c.writeLock
+			value, _ = clients.Load(id)
+			c = value.(valueConnection)
+			<-c.lastSeen
+			c.issued++
+			c.demand = c.issued
+			clients.Store(id, c)
+			c.writeLock <- 1
+			c.lastSeen <- time.Now()
+		}
+	})
+}
+
+func BenchmarkRegistryShardedPointers(b *testing.B) {
+	r := newClientRegistry()
+	ids := benchmarkIDs()
+	for _, id := range ids {
+		r.loadOrStore(id, &Connection{id: id})
+	}
+	var next int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := ids[atomic.AddInt64(&next, 1)%benchmarkClients]
+			c, _ := r.load(id)
+			c.mu.Lock()
+			c.issued++
+			c.demand = c.issued
+			c.lastUpdated = time.Now()
+			c.mu.Unlock()
+		}
+	})
+}
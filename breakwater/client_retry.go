@@ -0,0 +1,94 @@
+package breakwater
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Returns true if err is a breakwater rejection issued by the server,
+as opposed to one raised by this client or any other failure
+*/
+func isServerShed(err error) bool {
+	rejection, ok := RejectionFromError(err)
+	if !ok {
+		return false
+	}
+	switch rejection.GetReason() {
+	case bwpb.BreakwaterRejection_REASON_SERVER_AQM,
+		bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_FULL,
+		bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_EXPIRATION,
+		bwpb.BreakwaterRejection_REASON_SERVER_ADMISSION_LEVEL:
+		return true
+	}
+	return false
+}
+
+/*
+Backoff before the given retry (counting from 0): exponential in the number
+of attempts, at least the delay the server suggested, capped at
+retryMaxBackoff, with the upper half jittered so shed clients don't
+return in lockstep
+*/
+func (b *Breakwater) retryBackoff(attempt int64, err error) time.Duration {
+	maxBackoff := time.Duration(b.retryMaxBackoff) * time.Microsecond
+	backoff := time.Duration(b.retryBaseBackoff) * time.Microsecond
+	for i := int64(0); i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if suggested, ok := RetryDelayFromError(err); ok && suggested > backoff {
+		backoff = suggested
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if backoff <= 1 {
+		return backoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+/*
+Client side retries.
+A request shed by the server is put back into the target's queue after a
+jittered exponential backoff, up to maxRetries times. The last error is
+returned if the retries run out, or if the backoff would run past the
+context deadline.
+*/
+func (b *Breakwater) invokeWithRetries(ctx context.Context, target string, send func(ctx context.Context) (metadata.MD, metadata.MD, error)) error {
+	for attempt := int64(0); ; attempt++ {
+		attemptCtx := ctx
+		if attempt > 0 {
+			attemptCtx = WithRetryAttempt(ctx, int(attempt))
+		}
+		err := b.invokeWithCredits(attemptCtx, target, send)
+		if err == nil || attempt >= b.maxRetries || !isServerShed(err) {
+			return err
+		}
+
+		backoff := b.retryBackoff(attempt, err)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			b.logger("[Client Retry]:	Backoff %v would pass the deadline, giving up\n", backoff)
+			return err
+		}
+		// The shed request never used its credit and the server granted no
+		// new ones, so hand it back rather than wait for a stall probe. Only
+		// first attempts and retries charged in full are sure to have taken one.
+		if !creditsOnFail && (attempt == 0 || b.retryCreditCost() >= 1) {
+			b.getTarget(target).addCredits(1)
+		}
+		b.logger("[Client Retry]:	Request shed by server, retry %d in %v\n", attempt+1, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
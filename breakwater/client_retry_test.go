@@ -0,0 +1,111 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Invoker that sheds the first failures requests like an overloaded server
+func sheddingInvoker(failures int, calls *int) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= failures {
+			return ServerAQMError("server-a", 300, time.Millisecond)
+		}
+		return nil
+	}
+}
+
+func newRetryingBreakwater(t *testing.T, maxRetries int64) (*Breakwater, *grpc.ClientConn) {
+	params := BWParametersDefault
+	params.MaxRetries = maxRetries
+	params.RetryBaseBackoff = 1000
+	params.RetryMaxBackoff = 10000
+	bw := InitBreakwater(params)
+	cc, err := grpc.Dial("passthrough:///server-a", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client conn: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return bw, cc
+}
+
+func TestRetryAfterServerShed(t *testing.T) {
+	bw, cc := newRetryingBreakwater(t, 3)
+	calls := 0
+	err := bw.UnaryInterceptorClient(context.Background(), "/test", nil, nil, cc, sheddingInvoker(2, &calls))
+	if err != nil {
+		t.Errorf("Expected the request to succeed after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	bw, cc := newRetryingBreakwater(t, 2)
+	calls := 0
+	err := bw.UnaryInterceptorClient(context.Background(), "/test", nil, nil, cc, sheddingInvoker(10, &calls))
+	if !isServerShed(err) {
+		t.Errorf("Expected the last shed to be returned, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestNoRetryByDefault(t *testing.T) {
+	bw, cc := newRetryingBreakwater(t, 0)
+	calls := 0
+	err := bw.UnaryInterceptorClient(context.Background(), "/test", nil, nil, cc, sheddingInvoker(1, &calls))
+	if !isServerShed(err) || calls != 1 {
+		t.Errorf("Expected a single attempt returning the shed, got %d attempts and %v", calls, err)
+	}
+}
+
+func TestNoRetryOnOtherErrors(t *testing.T) {
+	bw, cc := newRetryingBreakwater(t, 3)
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Errorf(codes.ResourceExhausted, "quota exceeded")
+	}
+	bw.UnaryInterceptorClient(context.Background(), "/test", nil, nil, cc, invoker)
+	if calls != 1 {
+		t.Errorf("Expected errors without a rejection detail not to be retried, got %d attempts", calls)
+	}
+}
+
+func TestRetryBoundedByDeadline(t *testing.T) {
+	bw, cc := newRetryingBreakwater(t, 3)
+	bw.retryBaseBackoff = 100000
+	bw.retryMaxBackoff = 100000
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := bw.UnaryInterceptorClient(ctx, "/test", nil, nil, cc, sheddingInvoker(10, &calls))
+	if !isServerShed(err) || calls != 1 {
+		t.Errorf("Expected to give up when the backoff passes the deadline, got %d attempts and %v", calls, err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	bw, _ := newRetryingBreakwater(t, 3)
+	shed := ServerAQMError("server-a", 0, 0)
+	for attempt, expected := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 8 * time.Millisecond, 10 * time.Millisecond} {
+		backoff := bw.retryBackoff(int64(attempt), shed)
+		if backoff < expected/2 || backoff > expected {
+			t.Errorf("Expected retry %d to back off between %v and %v, got %v", attempt, expected/2, expected, backoff)
+		}
+	}
+	suggested := ServerAQMError("server-a", 0, 6*time.Millisecond)
+	if backoff := bw.retryBackoff(0, suggested); backoff < 3*time.Millisecond {
+		t.Errorf("Expected the suggested delay to raise the backoff, got %v", backoff)
+	}
+}
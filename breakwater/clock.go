@@ -0,0 +1,15 @@
+package breakwater
+
+import "time"
+
+/*
+Current time of the server's credit controller, from BWParameters.Clock if
+set. RTT updates, credit issuing, demand decay, warm-up and calibration all
+read the time through it, so a simulation can drive them on a fake clock.
+*/
+func (b *Breakwater) now() time.Time {
+	if b.clock != nil {
+		return b.clock()
+	}
+	return time.Now()
+}
@@ -0,0 +1,28 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualUpdatesOnFakeClock(t *testing.T) {
+	now := time.Unix(0, 0)
+	params := BWParametersDefault
+	params.Clock = func() time.Time { return now }
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	now = now.Add(time.Second)
+	bw.rttUpdate()
+	if bw.cTotal != params.InitialCredits {
+		t.Fatalf("Expected no update without UpdateCredits, got cTotal %d", bw.cTotal)
+	}
+	bw.UpdateCredits()
+	if bw.cTotal != params.InitialCredits+1 {
+		t.Errorf("Expected UpdateCredits to add a credit, got cTotal %d", bw.cTotal)
+	}
+	if !bw.lastUpdateTime.Equal(now) {
+		t.Errorf("Expected the update to be stamped with the fake clock, got %v", bw.lastUpdateTime)
+	}
+}
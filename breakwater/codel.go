@@ -0,0 +1,127 @@
+package breakwater
+
+import (
+	"math"
+	"time"
+)
+
+/*
+AQM strategies, selected with BWParameters.AQM
+*/
+const (
+	AQMThreshold     = "threshold"
+	AQMCoDel         = "codel"
+	AQMProbabilistic = "probabilistic" // sheds a share of requests growing with the delay over the threshold
+)
+
+/*
+CoDel (Nichols and Jacobson, 2012) as an alternative to the fixed AQM
+threshold.
+Instead of shedding every request while the delay is over a threshold,
+CoDel only starts dropping once the delay has stayed above its target for a
+whole interval, then drops again after interval/sqrt(count) until the delay
+falls back under the target. Short bursts are absorbed, while a standing
+queue is drained at an increasing rate.
+*/
+type codel struct {
+	interval       time.Duration
+	lock           chan int64 // lock for the drop state
+	firstAboveTime time.Time  // when the delay will have been above target for an interval, zero if below
+	dropNext       time.Time  // next drop while dropping
+	count          int64      // drops since entering the dropping state
+	dropping       bool
+}
+
+func newCoDel(interval time.Duration) *codel {
+	c := &codel{
+		interval: interval,
+		lock:     make(chan int64, 1),
+	}
+	c.lock <- 1
+	return c
+}
+
+/*
+Time of the next drop after t, closer together the longer we keep dropping
+*/
+func (c *codel) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(c.interval) / math.Sqrt(float64(c.count))))
+}
+
+/*
+Returns true if the delay has been above target for at least an interval
+*/
+func (c *codel) okToDrop(delay float64, target float64, now time.Time) bool {
+	if delay < target {
+		c.firstAboveTime = time.Time{}
+		return false
+	}
+	if c.firstAboveTime.IsZero() {
+		c.firstAboveTime = now.Add(c.interval)
+		return false
+	}
+	return !now.Before(c.firstAboveTime)
+}
+
+/*
+Decides whether a request that saw the given delay (in microseconds)
+should be dropped, updating the drop schedule
+*/
+func (c *codel) shouldDrop(delay float64, target float64, now time.Time) bool {
+	<-c.lock
+	defer func() { c.lock <- 1 }()
+
+	ok := c.okToDrop(delay, target, now)
+	if c.dropping {
+		if !ok {
+			c.dropping = false
+			return false
+		}
+		if now.Before(c.dropNext) {
+			return false
+		}
+		c.count++
+		c.dropNext = c.controlLaw(c.dropNext)
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	c.dropping = true
+	// resume near the previous drop rate if we only just left the dropping state
+	if c.count > 2 && now.Sub(c.dropNext) < 8*c.interval {
+		c.count -= 2
+	} else {
+		c.count = 1
+	}
+	c.dropNext = c.controlLaw(now)
+	return true
+}
+
+/*
+Delay CoDel tries to keep requests under, in microseconds
+*/
+func (b *Breakwater) codelTargetDelay() float64 {
+	if b.codelTarget > 0 {
+		return float64(b.codelTarget)
+	}
+	return b.thresholdDelay()
+}
+
+/*
+Server side AQM decision for a request of the given class and method
+thresholds. With CoDel each class has its own drop state, and its target is
+scaled like the fixed threshold so lower classes (and methods with tighter
+SLOs) are still shed first.
+*/
+func (b *Breakwater) shouldShed(queueingDelay float64, class PriorityClass, t delayThresholds) bool {
+	if b.shedRamp > 0 {
+		return b.shedsProbabilistically(queueingDelay, b.aqmThreshold(class, t.aqm))
+	}
+	if b.serverCoDel == nil {
+		return queueingDelay >= b.aqmThreshold(class, t.aqm)
+	}
+	target := b.codelTargetDelay() * b.aqmThreshold(class, t.aqm) / b.aqmDelay()
+	return b.serverCoDel[class].shouldDrop(queueingDelay, target, b.now())
+}
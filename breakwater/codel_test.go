@@ -0,0 +1,65 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoDelAbsorbsBursts(t *testing.T) {
+	c := newCoDel(10 * time.Millisecond)
+	now := time.Now()
+	for i := 0; i < 9; i++ {
+		if c.shouldDrop(500, 100, now.Add(time.Duration(i)*time.Millisecond)) {
+			t.Fatalf("Expected a burst shorter than the interval not to be dropped")
+		}
+	}
+	if c.shouldDrop(50, 100, now.Add(9*time.Millisecond)) {
+		t.Errorf("Expected no drop under target")
+	}
+	if c.shouldDrop(500, 100, now.Add(15*time.Millisecond)) {
+		t.Errorf("Expected the interval to restart after the delay fell under target")
+	}
+}
+
+func TestCoDelDropSchedule(t *testing.T) {
+	interval := 10 * time.Millisecond
+	c := newCoDel(interval)
+	now := time.Now()
+	c.shouldDrop(500, 100, now)
+
+	now = now.Add(interval)
+	if !c.shouldDrop(500, 100, now) {
+		t.Fatalf("Expected a drop once the delay stayed above target for an interval")
+	}
+	if c.shouldDrop(500, 100, now.Add(time.Millisecond)) {
+		t.Errorf("Expected no drop before the next scheduled drop")
+	}
+	// drops get closer together, interval/sqrt(count)
+	next := now.Add(interval)
+	if !c.shouldDrop(500, 100, next) {
+		t.Errorf("Expected the second drop an interval after the first")
+	}
+	if gap := c.dropNext.Sub(next); gap != time.Duration(float64(interval)/1.4142135623730951) {
+		t.Errorf("Expected the third drop interval/sqrt(2) later, got %v", gap)
+	}
+	if c.shouldDrop(50, 100, c.dropNext) || c.dropping {
+		t.Errorf("Expected dropping to stop once the delay is under target")
+	}
+}
+
+func TestCoDelServerShedding(t *testing.T) {
+	params := BWParametersDefault
+	params.AQM = AQMCoDel
+	bw := InitBreakwater(params)
+	bw.serverCoDel[BestEffort].interval = time.Hour
+
+	// a spike that the fixed threshold would shed
+	if bw.shouldShed(bw.aqmDelay()*1.5, BestEffort, bw.thresholdsFor("")) {
+		t.Errorf("Expected CoDel to absorb a short spike")
+	}
+
+	fixed := InitBreakwater(BWParametersDefault)
+	if !fixed.shouldShed(fixed.aqmDelay()*1.5, BestEffort, fixed.thresholdsFor("")) {
+		t.Errorf("Expected the fixed threshold to shed beyond the AQM delay")
+	}
+}
@@ -0,0 +1,114 @@
+package breakwater
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	tokenHeader         = "tok"   // connection token a client sends in place of its id
+	assignedTokenHeader = "token" // connection token a server assigns, on responses to requests with an id
+)
+
+/*
+Connection tokens
+Formatting and parsing the client's UUID on every request costs
+allocations on the hot path. With ConnectionTokens, the server answers a
+request carrying a UUID with a random int64 token standing for it, and the
+client sends the token instead from then on, so only its first requests
+carry the UUID. Tokens are random rather than sequential so a token handed
+out before a restart does not name another client after it. A token the
+server does not know, because it restarted or forgot an evicted client,
+stands for an id derived from the token itself, so the client is still
+accounted as a single client.
+*/
+type tokenTable struct {
+	mu     sync.RWMutex
+	ids    map[int64]uuid.UUID
+	tokens map[uuid.UUID]int64
+}
+
+func newTokenTable() *tokenTable {
+	return &tokenTable{ids: make(map[int64]uuid.UUID), tokens: make(map[uuid.UUID]int64)}
+}
+
+/*
+Returns the token of id, assigning one on first use
+*/
+func (t *tokenTable) assign(id uuid.UUID) int64 {
+	t.mu.RLock()
+	token, ok := t.tokens[id]
+	t.mu.RUnlock()
+	if ok {
+		return token
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token, ok := t.tokens[id]; ok {
+		return token
+	}
+	for {
+		token = rand.Int63()
+		if _, taken := t.ids[token]; token != 0 && !taken {
+			break
+		}
+	}
+	t.ids[token] = id
+	t.tokens[id] = token
+	return token
+}
+
+func (t *tokenTable) forget(id uuid.UUID) {
+	t.mu.Lock()
+	if token, ok := t.tokens[id]; ok {
+		delete(t.ids, token)
+		delete(t.tokens, id)
+	}
+	t.mu.Unlock()
+}
+
+/*
+Client id a token stands for
+*/
+func (b *Breakwater) clientOfToken(token int64) uuid.UUID {
+	if b.tokens != nil {
+		b.tokens.mu.RLock()
+		id, ok := b.tokens.ids[token]
+		b.tokens.mu.RUnlock()
+		if ok {
+			return id
+		}
+	}
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[8:], uint64(token))
+	return id
+}
+
+/*
+Server side: the header assigning a token to the client that declared id,
+nil unless the request carried the id itself
+*/
+func (b *Breakwater) tokenHeader(md metadata.MD, id uuid.UUID) metadata.MD {
+	if b.tokens == nil || len(md["id"]) == 0 {
+		return nil
+	}
+	return metadata.Pairs(assignedTokenHeader, strconv.FormatInt(b.tokens.assign(id), 10))
+}
+
+/*
+Client side: keeps the token the target assigned
+*/
+func (t *clientTarget) recordToken(header metadata.MD) {
+	if len(header[assignedTokenHeader]) == 0 {
+		return
+	}
+	if token, err := strconv.ParseInt(header[assignedTokenHeader][0], 10, 64); err == nil && token != 0 {
+		atomic.StoreInt64(&t.token, token)
+	}
+}
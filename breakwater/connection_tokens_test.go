@@ -0,0 +1,64 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestConnectionTokenReplacesId(t *testing.T) {
+	params := BWParametersDefault
+	params.ConnectionTokens = true
+	server := InitBreakwater(params)
+	setDelay(server, 0)
+	client := InitBreakwater(BWParametersDefault)
+	target := newClientTarget("server-a")
+
+	send := func() (metadata.MD, CallMeta) {
+		ctx := client.withRequestMetadata(context.Background(), target, 2, BestEffort, false)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		incoming := metadata.NewIncomingContext(context.Background(), md)
+		admission, err := server.Admit(incoming)
+		if err != nil {
+			t.Fatalf("Expected the request to be admitted, got %v", err)
+		}
+		meta, _ := FromContext(admission.Context(incoming))
+		admission.Done()
+		target.recordToken(admission.Header)
+		return md, meta
+	}
+
+	md, meta := send()
+	if len(md["id"]) == 0 || meta.ClientID != client.id {
+		t.Fatalf("Expected the first request to carry the client's id, got %v", md)
+	}
+	md, meta = send()
+	if len(md["id"]) != 0 || len(md[tokenHeader]) == 0 {
+		t.Errorf("Expected later requests to carry only the token, got %v", md)
+	}
+	if meta.ClientID != client.id {
+		t.Errorf("Expected the token to stand for client %s, got %s", client.id, meta.ClientID)
+	}
+	if n := server.clients.len(); n != 1 {
+		t.Errorf("Expected a single client, got %d", n)
+	}
+}
+
+func TestUnknownConnectionToken(t *testing.T) {
+	params := BWParametersDefault
+	params.ConnectionTokens = true
+	bw := InitBreakwater(params)
+	md := metadata.Pairs("demand", "1", tokenHeader, "12345")
+	first, err := bw.parseRequestMetadata(md)
+	if err != nil {
+		t.Fatalf("Expected an unknown token to be accepted, got %v", err)
+	}
+	second, _ := bw.parseRequestMetadata(md)
+	if first.clientId != second.clientId {
+		t.Errorf("Expected an unknown token to stand for the same client every time")
+	}
+	if _, err := bw.parseRequestMetadata(metadata.Pairs("demand", "1", tokenHeader, "x")); err == nil {
+		t.Errorf("Expected a malformed token to be rejected")
+	}
+}
@@ -0,0 +1,230 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	controlMinBackoff = 100 * time.Millisecond
+	controlMaxBackoff = 30 * time.Second
+)
+
+/*
+Out-of-band control stream.
+Credits are normally piggybacked on responses, so a client that has spent
+all of its credits hears nothing until a stall probe goes out. With a
+control stream open, the client reports its demand whenever it changes and
+the server pushes fresh grants after every RTT update and revocations as
+they happen, as in the Breakwater paper.
+*/
+type controlServer struct {
+	bwpb.UnimplementedBreakwaterControlServer
+	b *Breakwater
+}
+
+/*
+Server side state of a connected control stream
+*/
+type controlStream struct {
+	updates chan *bwpb.CreditUpdate // drained by the stream's send loop
+}
+
+/*
+Registers the BreakwaterControl service on a server using this breakwater
+*/
+func (b *Breakwater) RegisterControlServer(s grpc.ServiceRegistrar) {
+	bwpb.RegisterBreakwaterControlServer(s, &controlServer{b: b})
+}
+
+func (s *controlServer) Connect(stream bwpb.BreakwaterControl_ConnectServer) error {
+	b := s.b
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	clientId, err := uuid.Parse(first.GetClientId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "malformed client id %q", first.GetClientId())
+	}
+	if clientId, err = b.identifyClient(stream.Context(), clientId); err != nil {
+		return err
+	}
+
+	cs := &controlStream{updates: make(chan *bwpb.CreditUpdate, 16)}
+	b.controlStreams.Store(clientId, cs)
+	atomic.AddInt64(&b.openControlStreams, 1)
+	defer func() {
+		atomic.AddInt64(&b.openControlStreams, -1)
+		// a reconnect may already have replaced this stream
+		if current, ok := b.controlStreams.Load(clientId); ok && current == cs {
+			b.controlStreams.Delete(clientId)
+		}
+	}()
+	s.b.logger("[Control Stream]:	Client %s connected", clientId)
+
+	recvErr := make(chan error, 1)
+	go func() {
+		b.handleDemandUpdate(clientId, cs, first.GetDemand())
+		for {
+			update, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			b.handleDemandUpdate(clientId, cs, update.GetDemand())
+		}
+	}()
+
+	for {
+		select {
+		case update := <-cs.updates:
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			s.b.logger("[Control Stream]:	Client %s disconnected: %v", clientId, err)
+			return nil
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+/*
+Issues credits for a demand reported over the control stream. The grant
+is recomputed as for demand piggybacked on a request, but no request was
+sent, so no credit is spent.
+*/
+func (b *Breakwater) handleDemandUpdate(clientId uuid.UUID, cs *controlStream, demand int64) {
+	b.RegisterClient(clientId, demand)
+	issuedCredits := b.grantForDemand(clientId, demand)
+	b.logger("[Control Stream]:	Client %s demand %d, issued credits is %d", clientId, demand, issuedCredits)
+	b.pushControlUpdate(clientId, &bwpb.CreditUpdate{Credits: issuedCredits})
+}
+
+func (b *Breakwater) grantForDemand(clientId uuid.UUID, demand int64) int64 {
+	c, ok := b.clients.load(clientId)
+	if !ok {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return b.grantLocked(c, demand, BestEffort, 0, true)
+}
+
+/*
+Queues an update for the client's control stream.
+Updates are dropped rather than blocking when the stream falls behind.
+Returns false if the client has no control stream.
+*/
+func (b *Breakwater) pushControlUpdate(clientId uuid.UUID, update *bwpb.CreditUpdate) bool {
+	value, ok := b.controlStreams.Load(clientId)
+	if !ok {
+		return false
+	}
+	select {
+	case value.(*controlStream).updates <- update:
+	default:
+		b.logger("[Control Stream]:	Client %s is behind, dropping update", clientId)
+	}
+	return true
+}
+
+/*
+Keeps a control stream to the server behind cc open until ctx is done,
+reconnecting with exponential backoff when the stream breaks
+*/
+func (b *Breakwater) RunControlStream(ctx context.Context, cc *grpc.ClientConn) error {
+	t := b.getTarget(cc.Target())
+	control := bwpb.NewBreakwaterControlClient(cc)
+	backoff := controlMinBackoff
+	for {
+		received, err := b.controlSession(ctx, t, control)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		b.logger("[Control Stream]:	Stream to %s broken: %v, reconnecting in %v", t.target, err, backoff)
+		if received {
+			backoff = controlMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > controlMaxBackoff {
+			backoff = controlMaxBackoff
+		}
+	}
+}
+
+/*
+Runs a single control stream, returns whether any update was received
+*/
+func (b *Breakwater) controlSession(ctx context.Context, t *clientTarget, control bwpb.BreakwaterControlClient) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := control.Connect(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var received int32 = 0
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			update, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			atomic.StoreInt32(&received, 1)
+			t.applyControlUpdate(b, update)
+		}
+	}()
+
+	// Report demand once per RTT whenever it changes
+	ticker := time.NewTicker(time.Duration(b.getRTT()) * time.Microsecond)
+	defer ticker.Stop()
+	lastDemand := int64(-1)
+	for {
+		demand := int64(t.getDemand())
+		if demand != lastDemand {
+			if err := stream.Send(&bwpb.DemandUpdate{ClientId: b.id.String(), Demand: demand}); err != nil {
+				return atomic.LoadInt32(&received) == 1, err
+			}
+			lastDemand = demand
+		}
+		select {
+		case <-ticker.C:
+		case err := <-recvErr:
+			return atomic.LoadInt32(&received) == 1, err
+		}
+	}
+}
+
+/*
+Applies a grant or revocation pushed by the target. Revocations carry
+only a clamp, so with zero credits an update carrying neither is a zero
+grant and stops the client sending.
+*/
+func (t *clientTarget) applyControlUpdate(b *Breakwater, update *bwpb.CreditUpdate) {
+	if update.GetCredits() > 0 || (b.zeroCredits && update.GetRevokeTo() == 0) {
+		t.logger("[Control Stream]:	Target %s granted %d credits\n", t.target, update.GetCredits())
+		t.setCredits(update.GetCredits())
+		t.recordCreditGrant()
+		t.renewLease(nil)
+	}
+	if update.GetRevokeTo() > 0 {
+		t.clampCredits(update.GetRevokeTo())
+	}
+}
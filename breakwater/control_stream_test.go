@@ -0,0 +1,192 @@
+package breakwater
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func dialBufconn(t *testing.T, lis *bufconn.Listener) *grpc.ClientConn {
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+// Starts a server breakwater with the control service and a client
+// breakwater running a control stream to it
+func startControlStream(t *testing.T) (server *Breakwater, client *Breakwater, target *clientTarget) {
+	server = InitBreakwater(BWParametersDefault)
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	server.RegisterControlServer(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc := dialBufconn(t, lis)
+
+	client = InitBreakwater(BWParametersDefault)
+	target = client.getTarget(cc.Target())
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go client.RunControlStream(ctx, cc)
+	return
+}
+
+// Waits until the target's credit balance satisfies cond
+func waitForCredits(t *testing.T, target *clientTarget, cond func(int64) bool) int64 {
+	deadline := time.Now().Add(time.Second)
+	for {
+		credits := <-target.outgoingCredits
+		target.outgoingCredits <- credits
+		if cond(credits) {
+			return credits
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for credits, balance is %d", credits)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestControlStreamGrantsCredits(t *testing.T) {
+	server, client, target := startControlStream(t)
+	target.queueRequest()
+	target.queueRequest()
+
+	waitForCredits(t, target, func(c int64) bool { return c > 0 })
+	if _, ok := server.controlStreams.Load(client.id); !ok {
+		t.Errorf("Expected the server to track the client's control stream")
+	}
+	if _, ok := server.clients.load(client.id); !ok {
+		t.Errorf("Expected the client to be registered through the control stream")
+	}
+}
+
+func TestControlStreamDemandSpendsNoCredit(t *testing.T) {
+	params := BWParametersDefault
+	params.MaxOvercommit = 5
+	bw := InitBreakwater(params)
+	id := uuid.New()
+	for i := 0; i < 3; i++ {
+		bw.handleDemandUpdate(id, nil, 10)
+	}
+
+	// the demand plus the overcommit, however often it is reported
+	c, _ := bw.clients.load(id)
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued
+	if issued := atomic.LoadInt64(&c.issued); issued != 15 || cIssued != 15 {
+		t.Errorf("Expected a grant of 15, got %d with cIssued %d", issued, cIssued)
+	}
+}
+
+func TestControlStreamPushesRevocation(t *testing.T) {
+	server, client, target := startControlStream(t)
+	target.queueRequest()
+	waitForCredits(t, target, func(c int64) bool { return c > 0 })
+	target.setCredits(50)
+
+	c, _ := server.clients.load(client.id)
+	c.issued = 1000
+	server.cTotal = 2
+	server.revokeCredits()
+
+	waitForCredits(t, target, func(c int64) bool { return c <= 2 })
+}
+
+func TestControlStreamRejectsMalformedId(t *testing.T) {
+	server := InitBreakwater(BWParametersDefault)
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	server.RegisterControlServer(s)
+	go s.Serve(lis)
+	defer s.Stop()
+	cc := dialBufconn(t, lis)
+
+	stream, err := bwpb.NewBreakwaterControlClient(cc).Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	stream.Send(&bwpb.DemandUpdate{ClientId: "not-a-uuid", Demand: 1})
+	if _, err := stream.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+}
+
+// Fails the first Connect to exercise reconnection
+type flakyControlServer struct {
+	controlServer
+	failed int32
+}
+
+func (f *flakyControlServer) Connect(stream bwpb.BreakwaterControl_ConnectServer) error {
+	if atomic.CompareAndSwapInt32(&f.failed, 0, 1) {
+		return status.Errorf(codes.Unavailable, "try again")
+	}
+	return f.controlServer.Connect(stream)
+}
+
+func TestControlStreamReconnects(t *testing.T) {
+	server := InitBreakwater(BWParametersDefault)
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	bwpb.RegisterBreakwaterControlServer(s, &flakyControlServer{controlServer: controlServer{b: server}})
+	go s.Serve(lis)
+	defer s.Stop()
+	cc := dialBufconn(t, lis)
+
+	client := InitBreakwater(BWParametersDefault)
+	target := client.getTarget(cc.Target())
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+	target.queueRequest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.RunControlStream(ctx, cc)
+	waitForCredits(t, target, func(c int64) bool { return c > 0 })
+}
+
+func TestControlStreamAppliesZeroGrant(t *testing.T) {
+	for _, zeroCredits := range []bool{false, true} {
+		params := BWParametersDefault
+		params.ZeroCredits = zeroCredits
+		bw := InitBreakwater(params)
+		target := bw.getTarget("server-a")
+
+		target.setCredits(5)
+		target.applyControlUpdate(bw, &bwpb.CreditUpdate{})
+		expected := int64(5)
+		if zeroCredits {
+			expected = 0
+		}
+		if credits := waitForCredits(t, target, func(int64) bool { return true }); credits != expected {
+			t.Errorf("Expected a balance of %d after an empty update with ZeroCredits %v, got %d", expected, zeroCredits, credits)
+		}
+
+		// a revocation is not a zero grant
+		target.setCredits(5)
+		target.applyControlUpdate(bw, &bwpb.CreditUpdate{RevokeTo: 2})
+		if credits := waitForCredits(t, target, func(int64) bool { return true }); credits != 2 {
+			t.Errorf("Expected a revocation to clamp the balance to 2 with ZeroCredits %v, got %d", zeroCredits, credits)
+		}
+	}
+}
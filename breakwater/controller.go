@@ -0,0 +1,47 @@
+package breakwater
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+/*
+Overload control strategies, selected with BWParameters.Algorithm
+*/
+const (
+	AlgorithmBreakwater = "breakwater"
+	AlgorithmDAGOR      = "dagor"
+	AlgorithmGradient   = "gradient" // Breakwater credits, with cTotal driven by RPC latency instead of AIMD
+)
+
+/*
+An overload controller provides the interceptors for both sides of a
+connection, so strategies can be swapped without changing the server or
+client setup
+*/
+type Controller interface {
+	UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error)
+	UnaryInterceptorClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error
+}
+
+/*
+Creates the controller selected by param.Algorithm, Breakwater by default
+*/
+func NewController(param BWParameters) Controller {
+	switch param.Algorithm {
+	case AlgorithmDAGOR:
+		return InitDAGOR(param)
+	case "", AlgorithmBreakwater, AlgorithmGradient:
+		return InitBreakwater(param)
+	default:
+		bw := InitBreakwater(param)
+		bw.logger("WARNING: unknown algorithm %q, using breakwater", param.Algorithm)
+		return bw
+	}
+}
+
+var (
+	_ Controller = (*Breakwater)(nil)
+	_ Controller = (*DAGOR)(nil)
+)
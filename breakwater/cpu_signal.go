@@ -0,0 +1,87 @@
+package breakwater
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const DefaultCPUTarget = 0.8 // CPU utilization at which DelaySignalCPU reaches the delay threshold
+
+/*
+CPUSignal is an overload signal for services limited by CPU rather than by
+the Go scheduler. IO-bound pods throttled by their CPU quota can keep a
+short run queue while their requests wait for the quota to refill, so the
+scheduler latency stays low as they overload.
+The signal is the share of the CPU capacity the process used since the
+previous sample, scaled so the target utilization is the delay threshold
+that starts the multiplicative decrease. On Linux the capacity is the quota
+of the cgroup, and any time the cgroup was throttled counts as full
+utilization; elsewhere it is the number of CPUs.
+Select it with DelaySignalCPU.
+*/
+type CPUSignal struct {
+	threshold float64 // delay threshold in microseconds
+	target    float64 // utilization reaching the threshold
+	capacity  float64 // CPUs the process may use
+
+	mu            sync.Mutex
+	prevAt        time.Time     // time of the previous sample, zero before the first
+	prevUsage     time.Duration // CPU time used by the process at the previous sample
+	prevThrottled time.Duration // time the cgroup was throttled at the previous sample
+}
+
+/*
+Creates a CPU signal that reaches threshold, in microseconds, when the
+process uses target of its CPU capacity. Returns false if this platform
+does not report the CPU time of the process.
+*/
+func NewCPUSignal(threshold float64, target float64) (*CPUSignal, bool) {
+	if _, ok := processCPUTime(); !ok {
+		return nil, false
+	}
+	capacity, ok := cgroupCPULimit()
+	if !ok {
+		capacity = float64(runtime.NumCPU())
+	}
+	return &CPUSignal{threshold: threshold, target: target, capacity: capacity}, true
+}
+
+/*
+Delay source, the CPU utilization since the previous sample as a delay in
+microseconds
+*/
+func (s *CPUSignal) Delay() float64 {
+	return s.Utilization() / s.target * s.threshold
+}
+
+/*
+Share of the CPU capacity used since the previous sample, 1 if the cgroup
+was throttled in between
+*/
+func (s *CPUSignal) Utilization() float64 {
+	now := time.Now()
+	usage, _ := processCPUTime()
+	throttled, _ := cgroupThrottledTime()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prevAt, prevUsage, prevThrottled := s.prevAt, s.prevUsage, s.prevThrottled
+	s.prevAt, s.prevUsage, s.prevThrottled = now, usage, throttled
+	if prevAt.IsZero() {
+		return 0
+	}
+	return cpuUtilization(now.Sub(prevAt), usage-prevUsage, throttled-prevThrottled, s.capacity)
+}
+
+func cpuUtilization(elapsed time.Duration, used time.Duration, throttled time.Duration, capacity float64) float64 {
+	if elapsed <= 0 || capacity <= 0 {
+		return 0
+	}
+	utilization := used.Seconds() / (elapsed.Seconds() * capacity)
+	if throttled > 0 {
+		utilization = math.Max(utilization, 1)
+	}
+	return utilization
+}
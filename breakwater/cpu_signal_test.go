@@ -0,0 +1,46 @@
+package breakwater
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCPUUtilization(t *testing.T) {
+	// 1.5 CPU seconds over a second of a 2 CPU quota
+	if u := cpuUtilization(time.Second, 1500*time.Millisecond, 0, 2); math.Abs(u-0.75) > 1e-9 {
+		t.Errorf("Expected a utilization of 0.75, got %g", u)
+	}
+	// throttled for exceeding the quota while mostly waiting on IO
+	if u := cpuUtilization(time.Second, 100*time.Millisecond, 5*time.Millisecond, 2); u != 1 {
+		t.Errorf("Expected a throttled cgroup to count as fully utilized, got %g", u)
+	}
+}
+
+func TestCPUSignalDrivesDecrease(t *testing.T) {
+	var degraded []string
+	params := BWParametersDefault
+	params.DelaySignal = DelaySignalCPU
+	params.CPUTarget = 0.5
+	params.Observer = &Observer{OnDegraded: func(reason string) { degraded = append(degraded, reason) }}
+	bw := InitBreakwater(params)
+
+	signal, ok := NewCPUSignal(bw.thresholdDelay(), 0.5)
+	if !ok {
+		if len(degraded) != 1 {
+			t.Errorf("Expected the missing CPU time to be reported, got %v", degraded)
+		}
+		t.Skip("process CPU time unavailable on this platform")
+	}
+	if len(degraded) != 0 {
+		t.Errorf("Expected the CPU signal to be used, got %v", degraded)
+	}
+	signal.capacity = 1
+	signal.Delay()
+	// keep one CPU busy
+	for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+	}
+	if delay := signal.Delay(); delay <= bw.thresholdDelay() {
+		t.Errorf("Expected a busy CPU beyond the target to exceed the delay threshold %g, got %g", bw.thresholdDelay(), delay)
+	}
+}
@@ -0,0 +1,45 @@
+package breakwater
+
+/*
+CFS throttling
+A container that exhausts its CPU quota is stopped until the next period,
+so its requests queue up while the Go scheduler sees nothing: the runnable
+goroutines are not waiting for a P, the whole process is descheduled.
+With ThrottleWindows set, every RTT update checks whether nr_throttled in
+the cgroup's cpu.stat grew since the previous one. Once it has grown in
+throttleWindows updates in a row, the delay counts as at least an AQM
+breach until an update passes without throttling.
+*/
+type throttleDetector struct {
+	windows   int64 // throttled updates in a row before it counts as overload
+	prev      int64 // nr_throttled at the previous update, -1 before the first
+	throttled int64 // throttled updates in a row
+	read      func() (int64, bool)
+}
+
+func newThrottleDetector(windows int64) *throttleDetector {
+	return &throttleDetector{windows: windows, prev: -1, read: cgroupThrottledPeriods}
+}
+
+/*
+Reads nr_throttled, called once per RTT update holding rttLock
+*/
+func (d *throttleDetector) sample() {
+	periods, ok := d.read()
+	if !ok {
+		return
+	}
+	if d.prev >= 0 && periods > d.prev {
+		d.throttled++
+	} else {
+		d.throttled = 0
+	}
+	d.prev = periods
+}
+
+/*
+Returns true once the cgroup has been throttled for windows updates in a row
+*/
+func (d *throttleDetector) sustained() bool {
+	return d.throttled >= d.windows
+}
@@ -0,0 +1,37 @@
+package breakwater
+
+import (
+	"testing"
+)
+
+func TestSustainedThrottlingIsOverload(t *testing.T) {
+	params := BWParametersDefault
+	params.ThrottleWindows = 2
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	var periods int64
+	bw.throttling.read = func() (int64, bool) { return periods, true }
+
+	bw.UpdateCredits()
+	periods += 3
+	bw.UpdateCredits()
+	if bw.getDelay() != 0 {
+		t.Fatalf("Expected a single throttled update not to count, got delay %g", bw.getDelay())
+	}
+
+	periods += 2
+	prevCTotal := bw.cTotal
+	bw.UpdateCredits()
+	if delay := bw.getDelay(); delay < bw.aqmDelay() {
+		t.Errorf("Expected sustained throttling to count as an AQM breach, got delay %g", delay)
+	}
+	if bw.cTotal >= prevCTotal {
+		t.Errorf("Expected sustained throttling to decrease cTotal from %d, got %d", prevCTotal, bw.cTotal)
+	}
+
+	bw.UpdateCredits()
+	if bw.getDelay() != 0 {
+		t.Errorf("Expected an update without throttling to clear the overload, got delay %g", bw.getDelay())
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package breakwater
+
+import (
+	"time"
+)
+
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,15 @@
+package breakwater
+
+import "math"
+
+/*
+Keeps cTotal between minCredits and maxCredits, so a short latency spike
+cannot collapse the pool to a single credit, and a long stretch within the
+SLO cannot grow it far beyond what the server could ever handle
+*/
+func (b *Breakwater) boundCredits(cTotal float64) float64 {
+	if b.maxCredits > 0 && cTotal > float64(b.maxCredits) {
+		cTotal = float64(b.maxCredits)
+	}
+	return math.Max(math.Max(cTotal, float64(b.minCredits)), 1)
+}
@@ -0,0 +1,49 @@
+package breakwater
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCreditBounds(t *testing.T) {
+	params := BWParametersDefault
+	params.MinCredits = 50
+	params.MaxCredits = 2000
+	bw := InitBreakwater(params)
+
+	// a spike far beyond the threshold would otherwise halve cTotal every RTT
+	setDelay(bw, 100*bw.thresholdDelay())
+	for i := 0; i < 100; i++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+	}
+	if bw.cTotal != 50 {
+		t.Errorf("Expected cTotal to stop at MinCredits, got %d", bw.cTotal)
+	}
+
+	setDelay(bw, 0)
+	setNumClients(bw, 1000000)
+	for i := 0; i < 100; i++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+	}
+	if bw.cTotal != 2000 {
+		t.Errorf("Expected cTotal to stop at MaxCredits, got %d", bw.cTotal)
+	}
+}
+
+// Random delays around the threshold, checking every update stays in bounds
+func TestCreditBoundsStress(t *testing.T) {
+	params := BWParametersDefault
+	params.MinCredits = 10
+	params.MaxCredits = 500
+	bw := InitBreakwater(params)
+	setNumClients(bw, 10000)
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		setDelay(bw, rng.ExpFloat64()*bw.thresholdDelay())
+		bw.cTotal = bw.getUpdatedTotalCredits()
+		if bw.cTotal < 10 || bw.cTotal > 500 {
+			t.Fatalf("Update %d took cTotal out of bounds: %d", i, bw.cTotal)
+		}
+	}
+}
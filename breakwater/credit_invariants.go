@@ -0,0 +1,66 @@
+package breakwater
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Interval of the invariant checker in builds with the bwdebug tag when
+// InvariantCheckInterval is not set
+const debugInvariantCheckInterval int64 = 1000000
+
+/*
+Credit accounting invariants
+cIssued is a running counter of the credits issued to every connection, kept
+next to the per-connection counts rather than recounted on every request.
+The checker recounts the per-connection credits periodically and reports
+a counter that disagrees with the recount to Observer.OnInvariantViolation.
+A request being issued credits updates its connection before the counter,
+so a divergence only counts once two checks in a row see the same one.
+With RepairAccounting set, the counter is then resynced to the recount.
+The checker runs until Close.
+*/
+func (b *Breakwater) checkCreditInvariants() {
+	ticker := time.NewTicker(time.Duration(b.invariantCheckInterval) * time.Microsecond)
+	defer ticker.Stop()
+	var suspected int64
+	for {
+		select {
+		case <-ticker.C:
+			suspected = b.reconcileCredits(suspected)
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+/*
+Compares cIssued against the per-connection sum, returns the divergence
+for the next check to confirm, 0 if they agree
+*/
+func (b *Breakwater) reconcileCredits(suspected int64) int64 {
+	counted := <-b.cIssued
+	var recounted int64
+	b.clients.rangeClients(func(c *Connection) bool {
+		recounted += atomic.LoadInt64(&c.issued)
+		return true
+	})
+	divergence := counted - recounted
+	if divergence == 0 || divergence != suspected {
+		b.cIssued <- counted
+		return divergence
+	}
+	if b.repairAccounting {
+		b.cIssued <- recounted
+	} else {
+		b.cIssued <- counted
+	}
+	b.invariantViolated(fmt.Sprintf("cIssued is %d but connections hold %d credits", counted, recounted))
+	return 0
+}
+
+func (b *Breakwater) invariantViolated(reason string) {
+	b.logger("[Credit Invariants]:	%s", reason)
+	b.observer.invariantViolation(reason)
+}
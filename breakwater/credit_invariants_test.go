@@ -0,0 +1,80 @@
+package breakwater
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestInvariantCheckerConfirmsDivergence(t *testing.T) {
+	var reasons []string
+	params := BWParametersDefault
+	params.Observer = &Observer{
+		OnInvariantViolation: func(reason string) { reasons = append(reasons, reason) },
+	}
+	bw := InitBreakwater(params)
+	registerWithCredits(bw, 30)
+	registerWithCredits(bw, 20)
+
+	if suspected := bw.reconcileCredits(0); suspected != 0 || len(reasons) != 0 {
+		t.Fatalf("Expected matching accounting to pass, got divergence %d and %v", suspected, reasons)
+	}
+
+	// lose track of 5 credits
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued - 5
+	suspected := bw.reconcileCredits(0)
+	if suspected != -5 || len(reasons) != 0 {
+		t.Fatalf("Expected a first sighting to be held back, got divergence %d and %v", suspected, reasons)
+	}
+	bw.reconcileCredits(suspected)
+	if len(reasons) != 1 {
+		t.Fatalf("Expected a confirmed divergence to be reported once, got %v", reasons)
+	}
+	if s := bw.Snapshot(); s.CIssued != 45 {
+		t.Errorf("Expected cIssued to be left alone without RepairAccounting, got %d", s.CIssued)
+	}
+}
+
+func TestInvariantCheckerRepairs(t *testing.T) {
+	params := BWParametersDefault
+	params.RepairAccounting = true
+	bw := InitBreakwater(params)
+	registerWithCredits(bw, 30)
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued + 12
+
+	bw.reconcileCredits(bw.reconcileCredits(0))
+	if s := bw.Snapshot(); s.CIssued != 30 {
+		t.Errorf("Expected cIssued to be resynced to the 30 credits held, got %d", s.CIssued)
+	}
+}
+
+func TestNegativeCIssuedIsReported(t *testing.T) {
+	var reasons []string
+	params := BWParametersDefault
+	params.Observer = &Observer{
+		OnInvariantViolation: func(reason string) { reasons = append(reasons, reason) },
+	}
+	bw := InitBreakwater(params)
+	id := registerWithCredits(bw, 10)
+	<-bw.cIssued
+	bw.cIssued <- 0
+
+	bw.issueCredits(id, 0, BestEffort, 1)
+	if len(reasons) != 1 {
+		t.Errorf("Expected cIssued falling below zero to be reported, got %v", reasons)
+	}
+}
+
+func TestCloseStopsInvariantChecker(t *testing.T) {
+	before := runtime.NumGoroutine()
+	params := BWParametersDefault
+	params.StallTimeoutRTTs = -1
+	params.InvariantCheckInterval = 1000
+	bw := InitBreakwater(params)
+	if runtime.NumGoroutine() <= before {
+		t.Fatalf("Expected the invariant checker to be running")
+	}
+	bw.Close()
+	expectGoroutinesExit(t, before)
+}
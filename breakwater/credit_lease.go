@@ -0,0 +1,90 @@
+package breakwater
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const leaseKey = "lease" // header carrying the lease of a grant in microseconds
+
+/*
+Credit leases
+Credits issued to a connection stay counted in cIssued until the client
+sends its next request, so a client that crashes holding credits keeps them
+from every other client. With leaseRTTs set, a grant is only valid for that
+many RTT update intervals, and every grant renews it. At an RTT update the
+credits of clients whose lease ran out are reclaimed down to the smallest
+grant. Grants carry the lease in a "lease" header, so a client that did not
+spend its credits in time drops them too.
+*/
+func (b *Breakwater) leaseDuration() time.Duration {
+	return time.Duration(b.leaseRTTs*b.updateInterval()) * time.Microsecond
+}
+
+/*
+Returns the header carrying the lease of a grant, nil without leases
+*/
+func (b *Breakwater) leaseHeader() metadata.MD {
+	if b.leaseRTTs <= 0 {
+		return nil
+	}
+	return metadata.Pairs(leaseKey, strconv.FormatInt(b.leaseDuration().Microseconds(), 10))
+}
+
+/*
+Reclaims the credits of clients whose lease expired. Called from rttUpdate
+while holding rttLock. Each reclaim takes the credits off cIssued while it
+still holds the connection, as a grant does, so a recount running
+alongside treats it like a grant in flight.
+*/
+func (b *Breakwater) reclaimExpiredLeases() {
+	if b.leaseRTTs <= 0 {
+		return
+	}
+	expired := b.now().Add(-b.leaseDuration())
+	floor := b.minGrant()
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		if issued := atomic.LoadInt64(&c.issued); issued > floor && c.lastUpdated.Before(expired) {
+			atomic.StoreInt64(&c.issued, floor)
+			cIssued := <-b.cIssued
+			b.cIssued <- cIssued - (issued - floor)
+			b.logger("[Credit Lease]:	Lease of client %s expired, reclaimed %d credits", c.id, issued-floor)
+		}
+		c.mu.Unlock()
+		return true
+	})
+}
+
+/*
+Client side: renews the lease on the target's credits after a grant, with
+the lease in the header or else the last one the target sent
+*/
+func (t *clientTarget) renewLease(header metadata.MD) {
+	if values := header[leaseKey]; len(values) > 0 {
+		if lease, err := strconv.ParseInt(values[len(values)-1], 10, 64); err == nil && lease > 0 {
+			atomic.StoreInt64(&t.lease, lease)
+		}
+	}
+	if lease := atomic.LoadInt64(&t.lease); lease > 0 {
+		atomic.StoreInt64(&t.leaseExpiry, time.Now().Add(time.Duration(lease)*time.Microsecond).UnixNano())
+	}
+}
+
+/*
+Client side: drops the unspent credits for the target once their lease
+expired, keeping one to fetch a new grant
+*/
+func (t *clientTarget) expireLease() {
+	expiry := atomic.LoadInt64(&t.leaseExpiry)
+	if expiry == 0 || time.Now().UnixNano() < expiry {
+		return
+	}
+	if atomic.CompareAndSwapInt64(&t.leaseExpiry, expiry, 0) {
+		t.logger("[Credit Lease]:	Lease on credits for target %s expired\n", t.target)
+		t.clampCredits(1)
+	}
+}
@@ -0,0 +1,79 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestReclaimExpiredLeases(t *testing.T) {
+	now := time.Unix(0, 0)
+	params := BWParametersDefault
+	params.CreditLeaseRTTs = 2
+	params.Clock = func() time.Time { return now }
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	crashed := registerWithCredits(bw, 200)
+	active := registerWithCredits(bw, 100)
+	c, _ := bw.clients.load(crashed)
+	c.lastUpdated = now
+	a, _ := bw.clients.load(active)
+
+	// the active client renews its lease every RTT
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Duration(bw.getRTT()) * time.Microsecond)
+		a.lastUpdated = now
+		bw.UpdateCredits()
+	}
+	if c.issued != 1 {
+		t.Errorf("Expected the expired lease to be reclaimed down to one credit, got %d", c.issued)
+	}
+	if a.issued != 100 {
+		t.Errorf("Expected the renewed lease to keep its credits, got %d", a.issued)
+	}
+	if cIssued := bw.Snapshot().CIssued; cIssued != 101 {
+		t.Errorf("Expected cIssued to drop by the reclaimed credits, got %d", cIssued)
+	}
+}
+
+func TestGrantsCarryLease(t *testing.T) {
+	params := BWParametersDefault
+	params.CreditLeaseRTTs = 3
+	bw := InitBreakwater(params)
+	if lease := bw.leaseHeader().Get(leaseKey); len(lease) != 1 || lease[0] != "15000" {
+		t.Errorf("Expected a lease of 3 RTTs, got %v", lease)
+	}
+	if header := InitBreakwater(BWParametersDefault).leaseHeader(); header != nil {
+		t.Errorf("Expected no lease header without leases, got %v", header)
+	}
+}
+
+func TestClientDropsCreditsAfterLease(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server-a")
+	target.setCredits(50)
+	target.renewLease(metadata.Pairs(leaseKey, "1000000"))
+	target.expireLease()
+	credits := <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 50 {
+		t.Fatalf("Expected credits to be kept within the lease, got %d", credits)
+	}
+
+	target.leaseExpiry = time.Now().Add(-time.Millisecond).UnixNano()
+	target.expireLease()
+	credits = <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 1 {
+		t.Errorf("Expected unspent credits to drop to one after the lease, got %d", credits)
+	}
+
+	// a grant over the control stream renews the last lease
+	target.setCredits(50)
+	target.renewLease(nil)
+	if target.leaseExpiry <= time.Now().UnixNano() {
+		t.Errorf("Expected the control stream grant to renew the lease")
+	}
+}
@@ -0,0 +1,97 @@
+package breakwater
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Credit revocation
+Normally credits issued to a connection only decay by one per request,
+which is far too slow when the server's queueing delay collapses. When the
+delay measured at an RTT update exceeds revocationFactor times the AQM
+threshold, every connection is clamped to its share of the new cTotal
+immediately, and the clamp is sent to the client in a "revoke" header on
+its next response (including shed ones), or pushed over its control stream,
+so the client drops its unspent credits too.
+*/
+func (b *Breakwater) shouldRevoke(delay float64) bool {
+	return b.revocationFactor > 0 && delay > b.revocationFactor*b.aqmDelay()
+}
+
+/*
+Clamps the credits issued to every connection to its share of cTotal.
+Called from rttUpdate while holding rttLock.
+*/
+func (b *Breakwater) revokeCredits() {
+	if b.registryEmpty() {
+		return
+	}
+	clamp := max(b.cTotal/b.clients.len(), 1)
+
+	var revoked int64 = 0
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		if issued := atomic.LoadInt64(&c.issued); issued > clamp {
+			revoked += issued - clamp
+			atomic.StoreInt64(&c.issued, clamp)
+			c.revokeTo = clamp
+			b.pushControlUpdate(c.id, &bwpb.CreditUpdate{RevokeTo: clamp})
+		}
+		c.mu.Unlock()
+		return true
+	})
+
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued - revoked
+	b.logger("[Credit Revocation]:	Revoked %d credits, clamped connections to %d", revoked, clamp)
+}
+
+/*
+Returns the header carrying a pending revocation for the client,
+nil if there is none
+*/
+func (b *Breakwater) revocationHeader(clientId uuid.UUID) metadata.MD {
+	c, ok := b.clients.load(clientId)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	revokeTo := c.revokeTo
+	c.revokeTo = 0
+	c.mu.Unlock()
+
+	if revokeTo == 0 {
+		return nil
+	}
+	b.logger("[Credit Revocation]:	Clamping client %s to %d credits", clientId, revokeTo)
+	return metadata.Pairs("revoke", strconv.FormatInt(revokeTo, 10))
+}
+
+/*
+Client side: applies a revocation received in a response header
+*/
+func (t *clientTarget) revokeCredits(header metadata.MD) {
+	if len(header["revoke"]) == 0 {
+		return
+	}
+	revokeTo, err := strconv.ParseInt(header["revoke"][0], 10, 64)
+	if err != nil {
+		return
+	}
+	t.clampCredits(revokeTo)
+}
+
+/*
+Clamps the unspent credits for the target to the revoked value
+*/
+func (t *clientTarget) clampCredits(revokeTo int64) {
+	creditBalance := <-t.outgoingCredits
+	t.outgoingCredits <- min(creditBalance, revokeTo)
+	t.logger("[Credit Revocation]:	Target %s revoked credits, balance clamped from %d to %d\n", t.target, creditBalance, min(creditBalance, revokeTo))
+}
@@ -0,0 +1,80 @@
+package breakwater
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+// Registers a client holding the given number of issued credits
+func registerWithCredits(bw *Breakwater, issued int64) uuid.UUID {
+	id := uuid.New()
+	bw.RegisterClient(id, issued)
+	c, _ := bw.clients.load(id)
+	c.issued = issued
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued + issued
+	return id
+}
+
+func TestRevokeCreditsOnCollapse(t *testing.T) {
+	params := BWParametersDefault
+	params.RevocationFactor = 4
+	bw := InitBreakwater(params)
+	clients := []uuid.UUID{registerWithCredits(bw, 800), registerWithCredits(bw, 200)}
+
+	// far beyond 4 * AQM threshold
+	setDelay(bw, 10*bw.aqmDelay())
+	bw.rttUpdate()
+
+	expectedClamp := max(bw.cTotal/2, 1)
+	var total int64 = 0
+	for _, id := range clients {
+		c, _ := bw.clients.load(id)
+		if c.issued > expectedClamp || (c.issued == expectedClamp && c.revokeTo != expectedClamp) {
+			t.Errorf("Expected client to be clamped to %d, got issued %d revokeTo %d", expectedClamp, c.issued, c.revokeTo)
+		}
+		total += c.issued
+	}
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued
+	if cIssued != total {
+		t.Errorf("Expected cIssued to match the clamped connections, got %d and %d", cIssued, total)
+	}
+}
+
+func TestNoRevocationBelowFactor(t *testing.T) {
+	params := BWParametersDefault
+	params.RevocationFactor = 4
+	bw := InitBreakwater(params)
+	id := registerWithCredits(bw, 800)
+
+	setDelay(bw, 2*bw.aqmDelay())
+	bw.rttUpdate()
+
+	connection, _ := bw.clients.load(id)
+	if connection.revokeTo != 0 {
+		t.Errorf("Expected no revocation below the revocation factor")
+	}
+}
+
+func TestClientClampsRevokedCredits(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server-a")
+	target.setCredits(50)
+
+	target.revokeCredits(metadata.Pairs("revoke", "5"))
+	credits := <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 5 {
+		t.Errorf("Expected credits to be clamped to 5, got %d", credits)
+	}
+
+	target.revokeCredits(metadata.Pairs("revoke", "20"))
+	credits = <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 5 {
+		t.Errorf("Expected a revocation never to raise credits, got %d", credits)
+	}
+}
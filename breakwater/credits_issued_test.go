@@ -9,8 +9,7 @@ import (
 
 func TestCreditsToOvercommit(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
-	<-bw.numClients
-	bw.numClients <- 21
+	setNumClients(bw, 21)
 	<-bw.cIssued
 	bw.cIssued <- 200
 	bw.cTotal = 5000
@@ -21,66 +20,75 @@ func TestCreditsToOvercommit(t *testing.T) {
 	}
 }
 
-func TestGetHigherCreditsIssuedCapped(t *testing.T) {
-	bw := InitBreakwater(BWParametersDefault)
+func TestCreditsToOvercommitBounds(t *testing.T) {
+	params := BWParametersDefault
+	params.MinOvercommit = 5
+	params.MaxOvercommit = 100
+	bw := InitBreakwater(params)
+	setNumClients(bw, 10)
 	<-bw.cIssued
-	bw.cIssued <- 300
-	bw.cTotal = 310
-	var (
-		cOvercommit int64 = 10
-		cPrevious   int64 = 30
-		demand      int64 = 31
-		expected    int64 = 40
-	)
-	actual := bw.getHigherCreditsIssued(cOvercommit, demand, cPrevious)
+	bw.cIssued <- 4990
+	bw.cTotal = 5000
+	if credits := bw.calculateCreditsToOvercommit(); credits != 5 {
+		t.Errorf("Expected the minimum overcommit of 5, got %d", credits)
+	}
+
+	<-bw.cIssued
+	bw.cIssued <- 0
+	if credits := bw.calculateCreditsToOvercommit(); credits != 100 {
+		t.Errorf("Expected overcommit to be capped at 100, got %d", credits)
+	}
+}
+
+func TestCreditsToOvercommitDisabled(t *testing.T) {
+	params := BWParametersDefault
+	params.DisableOvercommit = true
+	bw := InitBreakwater(params)
+	setNumClients(bw, 10)
+	bw.cTotal = 5000
+	if credits := bw.calculateCreditsToOvercommit(); credits != 0 {
+		t.Errorf("Expected no overcommit, got %d", credits)
+	}
+	// a client is issued exactly its demand
+	if credits := bw.calculateCreditsToIssue(7, 0, 1); credits != 7 {
+		t.Errorf("Expected credits to match demand of 7, got %d", credits)
+	}
+}
+
+func TestDemandGrantCapped(t *testing.T) {
+	s := GrantState{CIssued: 300, CTotal: 310, Overcommit: 10, Issued: 30, Demand: 31}
+	var expected int64 = 40
+	actual := DemandGrantPolicy{}.Grant(s)
 
 	if actual != expected {
 		t.Errorf("Expected credits to be %d, got %d", expected, actual)
 	}
 }
 
-func TestGetHigherCreditsIssuedUnCapped(t *testing.T) {
-	bw := InitBreakwater(BWParametersDefault)
-	<-bw.cIssued
-	bw.cIssued <- 300
-	bw.cTotal = 4000
-	var (
-		cOvercommit int64 = 10
-		cPrevious   int64 = 30
-		demand      int64 = 31
-		expected    int64 = 10 + 31
-	)
-	actual := bw.getHigherCreditsIssued(cOvercommit, demand, cPrevious)
+func TestDemandGrantUnCapped(t *testing.T) {
+	s := GrantState{CIssued: 300, CTotal: 4000, Overcommit: 10, Issued: 30, Demand: 31}
+	var expected int64 = 10 + 31
+	actual := DemandGrantPolicy{}.Grant(s)
 
 	if actual != expected {
 		t.Errorf("Expected credits to be %d, got %d", expected, actual)
 	}
 }
 
-func TestGetLowerCreditsIssuedDemandLowered(t *testing.T) {
-	bw := InitBreakwater(BWParametersDefault)
-	var (
-		cOvercommit int64 = 10
-		cPrevious   int64 = 30
-		demand      int64 = 10
-		expected    int64 = 10 + 10
-	)
-	actual := bw.getLowerCreditsIssued(cOvercommit, demand, cPrevious)
+func TestDemandGrantOverLimitDemandLowered(t *testing.T) {
+	s := GrantState{CIssued: 400, CTotal: 300, Overcommit: 10, Issued: 30, Demand: 10}
+	var expected int64 = 10 + 10
+	actual := DemandGrantPolicy{}.Grant(s)
 
 	if actual != expected {
 		t.Errorf("Expected credits to be %d, got %d", expected, actual)
 	}
 }
 
-func TestGetLowerCreditsIssuedDemandRaised(t *testing.T) {
-	bw := InitBreakwater(BWParametersDefault)
-	var (
-		cOvercommit int64 = 10
-		cPrevious   int64 = 30
-		demand      int64 = 31
-		expected    int64 = 30 - 1
-	)
-	actual := bw.getLowerCreditsIssued(cOvercommit, demand, cPrevious)
+func TestDemandGrantOverLimitDemandRaised(t *testing.T) {
+	s := GrantState{CIssued: 400, CTotal: 300, Overcommit: 10, Issued: 30, Demand: 31}
+	var expected int64 = 30 - 1
+	actual := DemandGrantPolicy{}.Grant(s)
 
 	if actual != expected {
 		t.Errorf("Expected credits to be %d, got %d", expected, actual)
@@ -98,9 +106,9 @@ Test for calculating new credits to issue less issued case
 */
 func TestCreditsIssuedSimpleDecrease(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
 
-	<-bw.numClients
-	bw.numClients <- 2
+	setNumClients(bw, 2)
 	<-bw.cIssued
 	bw.cIssued <- 60
 	bw.cTotal = 40
@@ -111,10 +119,8 @@ func TestCreditsIssuedSimpleDecrease(t *testing.T) {
 	bw.RegisterClient(clientId2, 30)
 
 	// set to 60
-	c1, _ := bw.clientMap.Load(clientId1)
-	conn1 := c1.(Connection)
+	conn1, _ := bw.clients.load(clientId1)
 	conn1.issued = 60
-	bw.clientMap.Store(clientId1, conn1)
 
 	// The delay is < threshold, so additive
 	// adds max(2 * 0.001,1) = 1, so cTotal=51
@@ -130,13 +136,13 @@ func TestCreditsIssuedSimpleDecrease(t *testing.T) {
 	actualCIssued := <-bw.cIssued
 	bw.cIssued <- actualCIssued
 
-	c1, ok := bw.clientMap.Load(clientId1)
+	c1, ok := bw.clients.load(clientId1)
 	if !ok {
-		t.Errorf("Expected client1 to be in clientMap")
+		t.Errorf("Expected client1 to be registered")
 	}
-	actualCreditsClient1 := c1.(Connection).issued
+	actualCreditsClient1 := c1.issued
 
-	actualId := c1.(Connection).id
+	actualId := c1.id
 	if actualId != clientId1 {
 		t.Errorf("Expected client1 id to be %s, got %s", clientId1, actualId)
 	}
@@ -159,6 +165,7 @@ func TestCreditsIssuedSimpleDecrease(t *testing.T) {
 */
 func TestCreditsIssuedSimpleIncrease(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
 
 	<-bw.cIssued
 	bw.cIssued <- 40
@@ -170,16 +177,12 @@ func TestCreditsIssuedSimpleIncrease(t *testing.T) {
 	bw.RegisterClient(clientId2, 20)
 
 	// set to issued 20
-	c1, _ := bw.clientMap.Load(clientId1)
-	conn1 := c1.(Connection)
+	conn1, _ := bw.clients.load(clientId1)
 	conn1.issued = 20
-	bw.clientMap.Store(clientId1, conn1)
 
 	// set to issued 20
-	c2, _ := bw.clientMap.Load(clientId2)
-	conn2 := c2.(Connection)
+	conn2, _ := bw.clients.load(clientId2)
 	conn2.issued = 20
-	bw.clientMap.Store(clientId2, conn2)
 
 	// Sleep so that we can update cTotal
 	time.Sleep(200 * time.Millisecond)
@@ -200,13 +203,13 @@ func TestCreditsIssuedSimpleIncrease(t *testing.T) {
 	actualCIssued := <-bw.cIssued
 	bw.cIssued <- actualCIssued
 
-	c1, ok := bw.clientMap.Load(clientId1)
+	c1, ok := bw.clients.load(clientId1)
 	if !ok {
-		t.Errorf("Expected client1 to be in clientMap")
+		t.Errorf("Expected client1 to be registered")
 	}
-	actualCreditsClient1 := c1.(Connection).issued
+	actualCreditsClient1 := c1.issued
 
-	actualId := c1.(Connection).id
+	actualId := c1.id
 	if actualId != clientId1 {
 		t.Errorf("Expected client1 id to be %s, got %s", clientId1, actualId)
 	}
@@ -228,6 +231,7 @@ func TestCreditsIssuedSimpleIncrease(t *testing.T) {
 */
 func TestCreditsIssuedIssuesOnlyOnceAfterUpdate(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
 
 	<-bw.cIssued
 	bw.cIssued <- 40
@@ -239,16 +243,12 @@ func TestCreditsIssuedIssuesOnlyOnceAfterUpdate(t *testing.T) {
 	bw.RegisterClient(clientId2, 20)
 
 	// set to issued 20
-	c1, _ := bw.clientMap.Load(clientId1)
-	conn1 := c1.(Connection)
+	conn1, _ := bw.clients.load(clientId1)
 	conn1.issued = 20
-	bw.clientMap.Store(clientId1, conn1)
 
 	// set to issued 20
-	c2, _ := bw.clientMap.Load(clientId2)
-	conn2 := c2.(Connection)
+	conn2, _ := bw.clients.load(clientId2)
 	conn2.issued = 20
-	bw.clientMap.Store(clientId2, conn2)
 
 	// Sleep so that we can update cTotal
 	time.Sleep(200 * time.Millisecond)
@@ -276,13 +276,13 @@ func TestCreditsIssuedIssuesOnlyOnceAfterUpdate(t *testing.T) {
 	actualCIssued := <-bw.cIssued
 	bw.cIssued <- actualCIssued
 
-	c1, ok := bw.clientMap.Load(clientId1)
+	c1, ok := bw.clients.load(clientId1)
 	if !ok {
-		t.Errorf("Expected client1 to be in clientMap")
+		t.Errorf("Expected client1 to be registered")
 	}
-	actualCreditsClient1 := c1.(Connection).issued
+	actualCreditsClient1 := c1.issued
 
-	actualId := c1.(Connection).id
+	actualId := c1.id
 	if actualId != clientId1 {
 		t.Errorf("Expected client1 id to be %s, got %s", clientId1, actualId)
 	}
@@ -306,6 +306,7 @@ func TestCreditsIssuedIssuesOnlyOnceAfterUpdate(t *testing.T) {
 */
 func TestCreditsIssuedInterlacedRttUpdate(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
 
 	<-bw.cIssued
 	bw.cIssued <- 40
@@ -318,16 +319,12 @@ func TestCreditsIssuedInterlacedRttUpdate(t *testing.T) {
 	bw.RegisterClient(clientId2, 10)
 
 	// set to issued 20
-	c1, _ := bw.clientMap.Load(clientId1)
-	conn1 := c1.(Connection)
+	conn1, _ := bw.clients.load(clientId1)
 	conn1.issued = 20
-	bw.clientMap.Store(clientId1, conn1)
 
 	// set to issued 20
-	c2, _ := bw.clientMap.Load(clientId2)
-	conn2 := c2.(Connection)
+	conn2, _ := bw.clients.load(clientId2)
 	conn2.issued = 20
-	bw.clientMap.Store(clientId2, conn2)
 
 	// Sleep so that we can update cTotal
 	time.Sleep(200 * time.Millisecond)
@@ -381,13 +378,13 @@ func TestCreditsIssuedInterlacedRttUpdate(t *testing.T) {
 	actualCIssued = <-bw.cIssued
 	bw.cIssued <- actualCIssued
 
-	c1, ok := bw.clientMap.Load(clientId1)
+	c1, ok := bw.clients.load(clientId1)
 	if !ok {
-		t.Errorf("Expected client1 to be in clientMap")
+		t.Errorf("Expected client1 to be registered")
 	}
-	actualCreditsClient1 := c1.(Connection).issued
+	actualCreditsClient1 := c1.issued
 
-	actualId := c1.(Connection).id
+	actualId := c1.id
 	if actualId != clientId1 {
 		t.Errorf("Expected client1 id to be %s, got %s", clientId1, actualId)
 	}
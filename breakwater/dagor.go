@@ -0,0 +1,265 @@
+package breakwater
+
+import (
+	"context"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	DAGOR_BUSINESS_LEVELS = 64  // business priorities, 0 is the most important
+	DAGOR_USER_LEVELS     = 128 // user priorities, 0 is the most important
+	dagorLevels           = DAGOR_BUSINESS_LEVELS * DAGOR_USER_LEVELS
+	dagorAlpha            = 0.05 // fraction of admitted load shed per overloaded window
+	dagorBeta             = 0.01 // fraction of admitted load restored per healthy window
+)
+
+/*
+DAGOR overload control (Zhou et al., SoCC 2018), as an alternative to
+Breakwater's credits.
+Every request carries a business and a user priority, which together form
+a compound level (lower is more important). The server admits requests up
+to an admission level. Once per window it checks the queueing delay: if it
+is over the threshold, the admission level is lowered until about dagorAlpha
+of the last window's admitted requests would have been shed, otherwise it is
+raised to admit about dagorBeta more. The server reports its level in a
+"dagor-level" header so clients can drop requests that would be shed
+without sending them (collaborative admission control).
+*/
+type DAGOR struct {
+	id              uuid.UUID
+	thresholdDelay  float64       // overload threshold on the queueing delay in microseconds
+	window          time.Duration // how often the admission level is adjusted
+	admissionLevel  int64         // highest admitted level, accessed atomically
+	counts          []int64       // requests per level in the current window, accessed atomically
+	adjustLock      chan int64    // lock for adjusting the admission level
+	windowStart     time.Time
+	prevHist        *metrics.Float64Histogram
+	delaySource     func() float64 // returns the queueing delay since the last sample in microseconds
+	delayPercentile float64        // percentile of the scheduler latency histogram used as the delay
+	targets         sync.Map       // reportedLevel last received from each target, by cc.Target()
+	verbose                        // writes debug lines, BWParameters.Verbose
+}
+
+/*
+Admission level reported by a target, trusted for one window so a client
+whose requests are all being dropped locally eventually probes the server again
+*/
+type reportedLevel struct {
+	level int64
+	at    time.Time
+}
+
+func InitDAGOR(param BWParameters) (d *DAGOR) {
+	param = param.WithDefaults()
+	if err := param.Validate(); err != nil {
+		alert("[DAGOR Init]:	Invalid parameters: %v", err)
+	}
+	d = &DAGOR{
+		id:              uuid.New(),
+		thresholdDelay:  float64(param.SLO) * DELAY_THRESHOLD_PERCENT,
+		window:          time.Duration(param.DAGORWindow) * time.Microsecond,
+		admissionLevel:  dagorLevels - 1,
+		counts:          make([]int64, dagorLevels),
+		adjustLock:      make(chan int64, 1),
+		windowStart:     time.Now(),
+		delayPercentile: param.DelayPercentile,
+		verbose:         verbose(param.Verbose),
+	}
+	d.delaySource = d.schedulerDelay
+	if !schedulerLatencySupported() {
+		alert("[DAGOR Init]:	Runtime metric %s unsupported, probing scheduling latency instead", schedulerLatencyMetric)
+		d.delaySource = newSchedulerProbe().delay
+	}
+	d.adjustLock <- 1
+	d.logger("[DAGOR Init]:	Initialized with threshold delay %f us, window %v", d.thresholdDelay, d.window)
+	return
+}
+
+type dagorPriorityKey struct{}
+
+type dagorPriority struct {
+	business int
+	user     int
+}
+
+/*
+Tags an outgoing request with DAGOR business and user priorities,
+lower values are more important
+*/
+func WithDAGORPriority(ctx context.Context, business int, user int) context.Context {
+	return context.WithValue(ctx, dagorPriorityKey{}, dagorPriority{business, user})
+}
+
+/*
+Compound level of a business and user priority, clamped to the valid range
+*/
+func dagorLevel(business int, user int) int64 {
+	business = int(min(max(int64(business), 0), DAGOR_BUSINESS_LEVELS-1))
+	user = int(min(max(int64(user), 0), DAGOR_USER_LEVELS-1))
+	return int64(business*DAGOR_USER_LEVELS + user)
+}
+
+/*
+Level of an outgoing request, untagged requests sit in the middle
+*/
+func dagorLevelFromContext(ctx context.Context) int64 {
+	if p, ok := ctx.Value(dagorPriorityKey{}).(dagorPriority); ok {
+		return dagorLevel(p.business, p.user)
+	}
+	return dagorLevel(DAGOR_BUSINESS_LEVELS/2, DAGOR_USER_LEVELS/2)
+}
+
+/*
+Level of an incoming request, from its "business-priority" and
+"user-priority" metadata
+*/
+func dagorLevelFromMetadata(md metadata.MD) int64 {
+	business, user := DAGOR_BUSINESS_LEVELS/2, DAGOR_USER_LEVELS/2
+	if len(md["business-priority"]) > 0 {
+		if b, err := strconv.Atoi(md["business-priority"][0]); err == nil {
+			business = b
+		}
+	}
+	if len(md["user-priority"]) > 0 {
+		if u, err := strconv.Atoi(md["user-priority"][0]); err == nil {
+			user = u
+		}
+	}
+	return dagorLevel(business, user)
+}
+
+/*
+Same measurement as Breakwater's default delay source
+*/
+func (d *DAGOR) schedulerDelay() float64 {
+	currHist := readHistogram()
+	if d.prevHist == nil || currHist == nil {
+		d.prevHist = currHist
+		return 0.0
+	}
+	delay := histogramDelay(d.prevHist, currHist, d.delayPercentile)
+	d.prevHist = currHist
+	return delay
+}
+
+/*
+Adjusts the admission level once per window
+*/
+func (d *DAGOR) maybeAdjust() {
+	if time.Since(d.windowStart) < d.window {
+		return
+	}
+	select {
+	case <-d.adjustLock:
+	default:
+		return
+	}
+	defer func() { d.adjustLock <- 1 }()
+	if time.Since(d.windowStart) < d.window {
+		return
+	}
+	d.windowStart = time.Now()
+
+	counts := make([]int64, dagorLevels)
+	for i := range d.counts {
+		counts[i] = atomic.SwapInt64(&d.counts[i], 0)
+	}
+	delay := d.delaySource()
+	level := atomic.LoadInt64(&d.admissionLevel)
+	newLevel := adjustAdmissionLevel(counts, level, delay > d.thresholdDelay)
+	atomic.StoreInt64(&d.admissionLevel, newLevel)
+	d.logger("[DAGOR]:	delay %f us, admission level %d -> %d", delay, level, newLevel)
+}
+
+/*
+Moves the admission level so the admitted load shrinks by dagorAlpha
+when overloaded, or grows by dagorBeta otherwise, based on the number of
+requests seen at each level in the last window
+*/
+func adjustAdmissionLevel(counts []int64, level int64, overloaded bool) int64 {
+	var admitted int64 = 0
+	for i := int64(0); i <= level; i++ {
+		admitted += counts[i]
+	}
+
+	var cumulative int64 = 0
+	if overloaded {
+		target := float64(admitted) * (1 - dagorAlpha)
+		for i := int64(0); i < level; i++ {
+			if float64(cumulative+counts[i]) > target {
+				return max(i-1, 0)
+			}
+			cumulative += counts[i]
+		}
+		return max(level-1, 0)
+	}
+
+	target := float64(admitted) * (1 + dagorBeta)
+	for i := int64(0); i < int64(len(counts)); i++ {
+		cumulative += counts[i]
+		if i > level && float64(cumulative) >= target {
+			return i
+		}
+	}
+	return int64(len(counts)) - 1
+}
+
+/*
+Server side DAGOR interceptor
+*/
+func (d *DAGOR) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	level := dagorLevelFromMetadata(md)
+	atomic.AddInt64(&d.counts[level], 1)
+	d.maybeAdjust()
+
+	admissionLevel := atomic.LoadInt64(&d.admissionLevel)
+	if err := grpc.SetHeader(ctx, metadata.Pairs("dagor-level", strconv.FormatInt(admissionLevel, 10))); err != nil {
+		d.logger("Failed to set header: %v", err)
+	}
+	if level > admissionLevel {
+		d.logger("[DAGOR]:	Shedding request at level %d, admission level %d", level, admissionLevel)
+		return nil, RejectionError(bwpb.BreakwaterRejection_REASON_SERVER_ADMISSION_LEVEL, d.id.String(), 0, d.window,
+			"Request priority is below the server's admission level")
+	}
+	return handler(ctx, req)
+}
+
+/*
+Client side DAGOR interceptor
+*/
+func (d *DAGOR) UnaryInterceptorClient(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	level := dagorLevelFromContext(ctx)
+	target := cc.Target()
+	if value, ok := d.targets.Load(target); ok {
+		reported := value.(reportedLevel)
+		if level > reported.level && time.Since(reported.at) < d.window {
+			d.logger("[DAGOR]:	Dropping request at level %d before sending to %s", level, target)
+			return RejectionError(bwpb.BreakwaterRejection_REASON_CLIENT_ADMISSION_LEVEL, d.id.String(), 0, d.window-time.Since(reported.at),
+				"Request priority is below the admission level reported by "+target)
+		}
+	}
+
+	business, user := level/DAGOR_USER_LEVELS, level%DAGOR_USER_LEVELS
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		"business-priority", strconv.FormatInt(business, 10),
+		"user-priority", strconv.FormatInt(user, 10))
+	var header metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+
+	if len(header["dagor-level"]) > 0 {
+		if reported, perr := strconv.ParseInt(header["dagor-level"][0], 10, 64); perr == nil {
+			d.targets.Store(target, reportedLevel{level: reported, at: time.Now()})
+		}
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNewController(t *testing.T) {
+	if _, ok := NewController(BWParametersDefault).(*Breakwater); !ok {
+		t.Errorf("Expected breakwater by default")
+	}
+	params := BWParametersDefault
+	params.Algorithm = AlgorithmDAGOR
+	if _, ok := NewController(params).(*DAGOR); !ok {
+		t.Errorf("Expected DAGOR when selected")
+	}
+}
+
+func TestAdjustAdmissionLevel(t *testing.T) {
+	counts := make([]int64, 10)
+	for i := range counts {
+		counts[i] = 10
+	}
+	// 100 requests admitted up to level 9, shedding 5% drops the last level
+	if level := adjustAdmissionLevel(counts, 9, true); level != 8 {
+		t.Errorf("Expected admission level 8 when overloaded, got %d", level)
+	}
+	// 50 admitted up to level 4, admitting 1% more opens the next level
+	if level := adjustAdmissionLevel(counts, 4, false); level != 5 {
+		t.Errorf("Expected admission level 5 when healthy, got %d", level)
+	}
+	if level := adjustAdmissionLevel(counts, 0, true); level != 0 {
+		t.Errorf("Expected admission level to stop at 0, got %d", level)
+	}
+}
+
+func TestDAGORSheddingByPriority(t *testing.T) {
+	d := InitDAGOR(BWParametersDefault)
+	d.admissionLevel = dagorLevel(10, 0)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	send := func(business string) error {
+		md := metadata.Pairs("business-priority", business, "user-priority", "0")
+		_, err := d.UnaryInterceptor(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{}, handler)
+		return err
+	}
+	if err := send("5"); err != nil {
+		t.Errorf("Expected important request to be admitted, got %v", err)
+	}
+	if err := send("20"); !isServerShed(err) {
+		t.Errorf("Expected unimportant request to be shed, got %v", err)
+	}
+}
+
+func TestDAGORClientDropsBelowReportedLevel(t *testing.T) {
+	d := InitDAGOR(BWParametersDefault)
+	cc, err := grpc.Dial("passthrough:///server-a", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client conn: %v", err)
+	}
+	defer cc.Close()
+	d.targets.Store(cc.Target(), reportedLevel{level: dagorLevel(10, 0), at: time.Now()})
+
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return nil
+	}
+	err = d.UnaryInterceptorClient(WithDAGORPriority(context.Background(), 20, 0), "/test", nil, nil, cc, invoker)
+	rejection, ok := RejectionFromError(err)
+	if !ok || rejection.GetReason() != bwpb.BreakwaterRejection_REASON_CLIENT_ADMISSION_LEVEL || calls != 0 {
+		t.Errorf("Expected request to be dropped locally, got %v after %d calls", err, calls)
+	}
+	if err := d.UnaryInterceptorClient(WithDAGORPriority(context.Background(), 5, 0), "/test", nil, nil, cc, invoker); err != nil || calls != 1 {
+		t.Errorf("Expected important request to be sent, got %v after %d calls", err, calls)
+	}
+}
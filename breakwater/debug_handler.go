@@ -0,0 +1,107 @@
+package breakwater
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+State of a client as shown by the debug handler
+*/
+type clientState struct {
+	ID          string    `json:"id"`
+	Issued      int64     `json:"issued"`
+	Demand      int64     `json:"demand"`
+	Weight      float64   `json:"weight"`
+	Share       int64     `json:"share,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	RTT         int64     `json:"rtt_us,omitempty"`
+	LastUpdated time.Time `json:"last_updated"`
+}
+
+type debugState struct {
+	Snapshot Snapshot       `json:"snapshot"`
+	Clients  []clientState  `json:"clients"`
+	History  []UpdateRecord `json:"history"`
+}
+
+var debugTemplate = template.Must(template.New("breakwater").Parse(`<!DOCTYPE html>
+<html><head><title>breakwater</title>
+<style>body{font-family:sans-serif} table{border-collapse:collapse} td,th{border:1px solid #ccc;padding:2px 8px;text-align:right}</style>
+</head><body>
+<h1>breakwater</h1>
+{{with .Snapshot}}<table>
+<tr><th>cTotal</th><td>{{.CTotal}}</td></tr>
+<tr><th>cIssued</th><td>{{.CIssued}}</td></tr>
+<tr><th>Clients</th><td>{{.NumClients}}</td></tr>
+<tr><th>SLO (us)</th><td>{{.SLO}}</td></tr>
+<tr><th>Delay (us)</th><td>{{printf "%.1f" .RawDelay}}</td></tr>
+<tr><th>Smoothed delay (us)</th><td>{{printf "%.1f" .SmoothedDelay}}</td></tr>
+<tr><th>Pass-through</th><td>{{.PassThrough}}</td></tr>
+</table>{{end}}
+<h2>Clients</h2>
+<table><tr><th>ID</th><th>Issued</th><th>Demand</th><th>Weight</th><th>Share</th><th>Tenant</th><th>RTT (us)</th><th>Last grant</th></tr>
+{{range .Clients}}<tr><td>{{.ID}}</td><td>{{.Issued}}</td><td>{{.Demand}}</td><td>{{.Weight}}</td><td>{{.Share}}</td><td>{{.Tenant}}</td><td>{{.RTT}}</td><td>{{.LastUpdated.Format "15:04:05.000"}}</td></tr>
+{{end}}</table>
+<h2>History</h2>
+{{if .History}}<table><tr><th>Time</th><th>Delay (us)</th><th>cTotal</th><th>cIssued</th><th>Clients</th><th>Action</th></tr>
+{{range .History}}<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{printf "%.1f" .Delay}}</td><td>{{.CTotal}}</td><td>{{.CIssued}}</td><td>{{.NumClients}}</td><td>{{.Action}}</td></tr>
+{{end}}</table>{{else}}<p>Set HistoryLength to record RTT updates.</p>{{end}}
+</body></html>
+`))
+
+/*
+DebugHandler renders the controller state, the registered clients and the
+update history, as HTML or as JSON with ?format=json (or an Accept header
+asking for it). Mount it next to pprof:
+
+	http.Handle("/debug/breakwater", b.DebugHandler())
+*/
+func (b *Breakwater) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := debugState{Snapshot: b.Snapshot(), Clients: b.clientStates(), History: b.History()}
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(state)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugTemplate.Execute(w, state); err != nil {
+			b.logger("[Debug Handler]:	Failed to render: %v", err)
+		}
+	})
+}
+
+/*
+Registered clients, most credits issued first
+*/
+func (b *Breakwater) clientStates() []clientState {
+	var clients []clientState
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		clients = append(clients, clientState{
+			ID:          c.id.String(),
+			Issued:      atomic.LoadInt64(&c.issued),
+			Demand:      atomic.LoadInt64(&c.demand),
+			Weight:      c.weight,
+			Share:       c.share,
+			Tenant:      c.tenant,
+			RTT:         c.rtt,
+			LastUpdated: c.lastUpdated,
+		})
+		c.mu.Unlock()
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Issued != clients[j].Issued {
+			return clients[i].Issued > clients[j].Issued
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
@@ -0,0 +1,39 @@
+package breakwater
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDebugHandler(t *testing.T) {
+	params := BWParametersDefault
+	params.HistoryLength = 4
+	bw := InitBreakwater(params)
+	id := uuid.New()
+	bw.RegisterClient(id, 5)
+	bw.updateCreditsToIssue(id, 5)
+	forceRTTUpdate(bw, 0)
+
+	w := httptest.NewRecorder()
+	bw.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/breakwater?format=json", nil))
+	var state debugState
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatalf("Expected JSON, got %v: %s", err, w.Body.String())
+	}
+	if len(state.Clients) != 1 || state.Clients[0].ID != id.String() || state.Clients[0].Issued == 0 {
+		t.Errorf("Expected the client and its credits, got %+v", state.Clients)
+	}
+	if len(state.History) != 1 || state.Snapshot.CTotal != bw.cTotal {
+		t.Errorf("Expected the snapshot and one update, got %+v", state)
+	}
+
+	w = httptest.NewRecorder()
+	bw.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/breakwater", nil))
+	if body := w.Body.String(); !strings.Contains(body, "<table>") || !strings.Contains(body, id.String()) {
+		t.Errorf("Expected an HTML page listing the client, got %s", body)
+	}
+}
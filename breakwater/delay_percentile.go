@@ -0,0 +1,53 @@
+package breakwater
+
+import (
+	"math"
+	"runtime/metrics"
+)
+
+/*
+Queueing delay in microseconds between two readings of the scheduler
+latency histogram.
+With a percentile p in (0, 1), this is the p-th percentile of the samples
+added between the readings, so a single outlier wakeup no longer sets the
+delay for the whole RTT. Otherwise it is the bucket of the largest new sample.
+*/
+func histogramDelay(earlier, later *metrics.Float64Histogram, p float64) float64 {
+	if p <= 0 || p >= 1 {
+		return maximumQueuingDelayus(earlier, later)
+	}
+	return queuingDelayPercentileus(earlier, later, p)
+}
+
+/*
+Percentile p of the samples in later but not in earlier, interpolated
+linearly within the bucket it falls in. Buckets without an upper bound
+report their lower bound. Returns 0 without new samples.
+*/
+func queuingDelayPercentileus(earlier, later *metrics.Float64Histogram, p float64) float64 {
+	var total uint64
+	for i := range later.Counts {
+		total += later.Counts[i] - earlier.Counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := p * float64(total)
+	var seen float64
+	for i := range later.Counts {
+		added := float64(later.Counts[i] - earlier.Counts[i])
+		if added == 0 || seen+added < rank {
+			seen += added
+			continue
+		}
+		lower, upper := later.Buckets[i], later.Buckets[i+1]
+		if math.IsInf(upper, 1) {
+			return math.Max(lower, 0) * 1000000
+		}
+		if math.IsInf(lower, -1) {
+			lower = 0
+		}
+		return (lower + (rank-seen)/added*(upper-lower)) * 1000000 // convert to microseconds
+	}
+	return 0
+}
@@ -0,0 +1,37 @@
+package breakwater
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+)
+
+func TestDelayPercentileIgnoresOutlier(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0, 0.0001, 0.001, 0.01, math.Inf(1)}
+	earlier := &metrics.Float64Histogram{Counts: []uint64{0, 50, 0, 0, 0}, Buckets: buckets}
+	// 100 new samples between 0 and 100us, and one outlier over 1ms
+	later := &metrics.Float64Histogram{Counts: []uint64{0, 150, 0, 1, 0}, Buckets: buckets}
+
+	if delay := histogramDelay(earlier, later, 0); delay != 1000 {
+		t.Errorf("Expected the largest bucket of 1000 us without a percentile, got %f", delay)
+	}
+	// the 50th of 101 new samples, about halfway through the first bucket
+	if delay := histogramDelay(earlier, later, 0.5); math.Abs(delay-50.5) > 0.01 {
+		t.Errorf("Expected a median of 50.5 us, got %f", delay)
+	}
+	if delay := histogramDelay(earlier, later, 0.99); delay > 100 {
+		t.Errorf("Expected the p99 to ignore the outlier, got %f us", delay)
+	}
+	if delay := histogramDelay(later, later, 0.99); delay != 0 {
+		t.Errorf("Expected delay to be 0 without new samples, got %f", delay)
+	}
+}
+
+func TestDelayPercentileUnboundedBucket(t *testing.T) {
+	buckets := []float64{0, 0.001, math.Inf(1)}
+	earlier := &metrics.Float64Histogram{Counts: []uint64{0, 0}, Buckets: buckets}
+	later := &metrics.Float64Histogram{Counts: []uint64{0, 10}, Buckets: buckets}
+	if delay := histogramDelay(earlier, later, 0.99); delay != 1000 {
+		t.Errorf("Expected the lower bound of the unbounded bucket, got %f", delay)
+	}
+}
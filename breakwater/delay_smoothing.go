@@ -0,0 +1,49 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+/*
+Smooths the delay measured at each RTT update with an EWMA before it
+drives cTotal, since the per-RTT delay is noisy enough to make cTotal
+oscillate. delaySmoothing is the weight of each new sample, 1 (or any
+value outside (0, 1]) uses the raw delay. Returns false until delayMinSamples samples have been seen, in which
+case cTotal is left as it is.
+Only called from getUpdatedTotalCredits, under the rtt lock.
+*/
+func (b *Breakwater) smoothDelay(delay float64) (float64, bool) {
+	weight := b.delayWeight()
+	smoothed := delay
+	if b.delaySamples > 0 {
+		prev := math.Float64frombits(atomic.LoadUint64(&b.smoothedDelay))
+		smoothed = weight*delay + (1-weight)*prev
+	}
+	b.delaySamples++
+	atomic.StoreUint64(&b.rawDelay, math.Float64bits(delay))
+	atomic.StoreUint64(&b.smoothedDelay, math.Float64bits(smoothed))
+	return smoothed, b.delaySamples >= b.delayMinSamples
+}
+
+/*
+Smooths the delay sampled at an RTT update with the weight of smoothDelay
+before admission compares it to the AQM threshold, so a single noisy RTT
+neither sheds a burst nor lets one through. Called under the rtt lock.
+*/
+func (b *Breakwater) smoothQueueingDelay(delay float64) float64 {
+	if b.queueingDelaySampled {
+		weight := b.delayWeight()
+		delay = weight*delay + (1-weight)*b.readQueueingDelay()
+	}
+	b.queueingDelaySampled = true
+	return delay
+}
+
+// EWMA weight of new delay samples, 1 when delaySmoothing is out of (0, 1]
+func (b *Breakwater) delayWeight() float64 {
+	if b.delaySmoothing <= 0 || b.delaySmoothing > 1 {
+		return 1
+	}
+	return b.delaySmoothing
+}
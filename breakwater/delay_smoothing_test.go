@@ -0,0 +1,60 @@
+package breakwater
+
+import (
+	"testing"
+)
+
+func TestDelaySmoothingDampsSpike(t *testing.T) {
+	params := BWParametersDefault
+	params.DelaySmoothing = 0.25
+	bw := InitBreakwater(params)
+	bw.cTotal = 100
+
+	setDelay(bw, 0)
+	bw.cTotal = bw.getUpdatedTotalCredits()
+	// one RTT at twice the threshold only moves the smoothed delay to half of it
+	setDelay(bw, 2*bw.thresholdDelay())
+	if cTotal := bw.getUpdatedTotalCredits(); cTotal <= 100 {
+		t.Errorf("Expected a single spike not to cut cTotal, got %d", cTotal)
+	}
+
+	snapshot := bw.Snapshot()
+	if snapshot.RawDelay != 2*bw.thresholdDelay() {
+		t.Errorf("Expected the raw delay in the snapshot, got %f", snapshot.RawDelay)
+	}
+	if snapshot.SmoothedDelay != bw.thresholdDelay()/2 {
+		t.Errorf("Expected a smoothed delay of %f, got %f", bw.thresholdDelay()/2, snapshot.SmoothedDelay)
+	}
+}
+
+func TestDelayMinSamples(t *testing.T) {
+	params := BWParametersDefault
+	params.DelayMinSamples = 3
+	bw := InitBreakwater(params)
+	bw.cTotal = 100
+	setDelay(bw, 0)
+
+	for i := 0; i < 2; i++ {
+		if cTotal := bw.getUpdatedTotalCredits(); cTotal != 100 {
+			t.Fatalf("Expected cTotal to stay put before 3 samples, got %d", cTotal)
+		}
+	}
+	if cTotal := bw.getUpdatedTotalCredits(); cTotal <= 100 {
+		t.Errorf("Expected cTotal to grow once enough samples were seen, got %d", cTotal)
+	}
+}
+
+func TestQueueingDelayIsSmoothed(t *testing.T) {
+	params := BWParametersDefault
+	params.DelaySmoothing = 0.25
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+
+	setDelay(bw, 0)
+	bw.UpdateCredits()
+	setDelay(bw, 2*bw.aqmDelay())
+	bw.UpdateCredits()
+	if delay := bw.readQueueingDelay(); delay != bw.aqmDelay()/2 {
+		t.Errorf("Expected admission to see a smoothed delay of %f, got %f", bw.aqmDelay()/2, delay)
+	}
+}
@@ -0,0 +1,68 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Time a request spent waiting on its downstream calls. Admit attaches one to
+every admitted request, and each call made with the request's context adds
+how long it took, including the wait for a credit. Done deducts it from the
+handler time, so a server in the middle of a call chain does not take a
+slow downstream service for its own overload.
+*/
+type downstreamTime struct {
+	us int64 // accessed atomically
+}
+
+type admissionKey struct{}
+
+func (d *downstreamTime) add(elapsed time.Duration) {
+	atomic.AddInt64(&d.us, elapsed.Microseconds())
+}
+
+func (d *downstreamTime) elapsed() time.Duration {
+	return time.Duration(atomic.LoadInt64(&d.us)) * time.Microsecond
+}
+
+/*
+Returns the downstream time of the request whose context ctx derives from,
+nil outside a request admitted by Admit
+*/
+func downstreamTimeOf(ctx context.Context) *downstreamTime {
+	if a, ok := ctx.Value(admissionKey{}).(*Admission); ok {
+		return a.downstream
+	}
+	return nil
+}
+
+/*
+Returns ctx carrying the request's downstream time accounting and its
+CallMeta. Handlers have to make their downstream calls with it, or a
+context derived from it, for their duration to be deducted; the gRPC
+interceptor hands it to the handler already.
+*/
+func (a *Admission) Context(ctx context.Context) context.Context {
+	if a.downstream == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, admissionKey{}, a)
+}
+
+/*
+Time spent in the handler, less the time it waited on downstream calls.
+Calls made in parallel can add up to more than the handler took, so it
+never goes below zero.
+*/
+func (a *Admission) handlerTime() time.Duration {
+	elapsed := time.Since(a.handlerStart)
+	if a.downstream != nil {
+		elapsed -= a.downstream.elapsed()
+	}
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
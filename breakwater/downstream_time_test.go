@@ -0,0 +1,40 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDownstreamTimeDeducted(t *testing.T) {
+	server := InitBreakwater(BWParametersDefault)
+	setDelay(server, 0)
+	client := InitBreakwater(BWParametersDefault)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", client.id.String()))
+	a, err := server.Admit(ctx)
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+
+	// the handler spends all its time waiting on a downstream call
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		time.Sleep(20 * time.Millisecond)
+		return metadata.Pairs("credits", "5"), nil, nil
+	}
+	if err := client.Invoke(a.Context(context.Background()), "downstream", send); err != nil {
+		t.Fatalf("Downstream call failed: %v", err)
+	}
+	if elapsed := a.downstream.elapsed(); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the downstream call to be accounted, got %v", elapsed)
+	}
+	if handlerTime := a.handlerTime(); handlerTime > 10*time.Millisecond {
+		t.Errorf("Expected the downstream wait to be deducted from the handler time, got %v", handlerTime)
+	}
+
+	// calls made outside a request are not accounted anywhere
+	if err := client.Invoke(context.Background(), "downstream", send); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+}
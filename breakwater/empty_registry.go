@@ -0,0 +1,13 @@
+package breakwater
+
+/*
+Empty registry
+RTT updates normally run on a request, so there is a registered client.
+Updates driven by UpdateCredits or timers can run before the first request,
+or after every client was evicted. With no clients there is nobody to split
+unissued credits or revocations across, so both are skipped rather than
+divided by zero, while cTotal keeps following the delay.
+*/
+func (b *Breakwater) registryEmpty() bool {
+	return b.clients.len() == 0
+}
@@ -0,0 +1,76 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A server whose RTT updates only run on UpdateCredits, on a fake clock
+func manualBreakwater(params BWParameters) (*Breakwater, func(time.Duration)) {
+	now := time.Unix(0, 0)
+	params.Clock = func() time.Time { return now }
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	return bw, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestUpdatesWithNoClients(t *testing.T) {
+	params := BWParametersDefault
+	params.WeightedFairSharing = true
+	params.TenantQuotas = true
+	params.RevocationFactor = 4
+	bw, advance := manualBreakwater(params)
+
+	for i := 0; i < 5; i++ {
+		advance(time.Duration(bw.getRTT()) * time.Microsecond)
+		bw.UpdateCredits()
+	}
+	if bw.cTotal != params.InitialCredits+5 {
+		t.Errorf("Expected cTotal to grow by the minimum additive factor, got %d", bw.cTotal)
+	}
+	if cOvercommit := bw.calculateCreditsToOvercommit(); cOvercommit != params.MinOvercommit {
+		t.Errorf("Expected the minimum overcommitment without clients, got %d", cOvercommit)
+	}
+
+	// overloaded, with nothing to revoke
+	setDelay(bw, 10*bw.aqmDelay())
+	advance(time.Duration(bw.getRTT()) * time.Microsecond)
+	bw.UpdateCredits()
+	if bw.cTotal >= params.InitialCredits {
+		t.Errorf("Expected cTotal to follow the delay down without clients, got %d", bw.cTotal)
+	}
+	if s := bw.Snapshot(); s.CIssued != 0 || s.NumClients != 0 {
+		t.Errorf("Expected no clients and no credits issued, got %+v", s)
+	}
+}
+
+func TestUpdatesWithOneClient(t *testing.T) {
+	params := BWParametersDefault
+	params.RevocationFactor = 4
+	bw, advance := manualBreakwater(params)
+	id := uuid.New()
+	bw.RegisterClient(id, 10)
+	if cOvercommit := bw.calculateCreditsToOvercommit(); cOvercommit != bw.cTotal {
+		t.Errorf("Expected the whole pool to be overcommitted to the only client, got %d", cOvercommit)
+	}
+	if issued := bw.updateCreditsToIssue(id, 10); issued != bw.cTotal {
+		t.Errorf("Expected the only client to be issued the whole pool, got %d", issued)
+	}
+
+	advance(time.Duration(bw.getRTT()) * time.Microsecond)
+	bw.UpdateCredits()
+	if bw.cTotal != params.InitialCredits+1 {
+		t.Errorf("Expected cTotal to grow by one, got %d", bw.cTotal)
+	}
+
+	// the only client is clamped to the whole of the shrunk pool
+	setDelay(bw, 10*bw.aqmDelay())
+	advance(time.Duration(bw.getRTT()) * time.Microsecond)
+	bw.UpdateCredits()
+	if c, _ := bw.clients.load(id); c.issued != bw.cTotal {
+		t.Errorf("Expected the client to be clamped to cTotal %d, got %d", bw.cTotal, c.issued)
+	}
+}
@@ -0,0 +1,135 @@
+package breakwater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Environment variables ParamsFromEnv reads, without their prefix, and the
+parameter each one sets. Durations are in microseconds like the fields.
+*/
+func envParams(p *BWParameters) map[string]interface{} {
+	return map[string]interface{}{
+		"ALGORITHM":                   &p.Algorithm,
+		"SERVER_SIDE":                 &p.ServerSide,
+		"BFACTOR":                     &p.BFactor,
+		"AFACTOR":                     &p.AFactor,
+		"SLO_US":                      &p.SLO,
+		"CLIENT_EXPIRATION_US":        &p.ClientExpiration,
+		"INITIAL_CREDITS":             &p.InitialCredits,
+		"MIN_CREDITS":                 &p.MinCredits,
+		"MAX_CREDITS":                 &p.MaxCredits,
+		"RECOVERY":                    &p.Recovery,
+		"GRANT_MODE":                  &p.GrantMode,
+		"STATE_FILE":                  &p.StateFile,
+		"MAX_STATE_AGE_US":            &p.MaxStateAge,
+		"WARMUP_PERIOD_US":            &p.WarmupPeriod,
+		"WARMUP_CREDITS":              &p.WarmupCredits,
+		"WARMUP_SCHEDULE":             &p.WarmupSchedule,
+		"IDLE_HALF_LIFE_US":           &p.IdleHalfLife,
+		"VERBOSE":                     &p.Verbose,
+		"USE_CLIENT_TIME_EXPIRATION":  &p.UseClientTimeExpiration,
+		"LOAD_SHEDDING":               &p.LoadShedding,
+		"USE_CLIENT_QUEUE_LENGTH":     &p.UseClientQueueLength,
+		"RTT_US":                      &p.RTT_MICROSECOND,
+		"SAFETY_VALVE":                &p.SafetyValve,
+		"EXPVAR_NAME":                 &p.ExpvarName,
+		"TRACE_SHED":                  &p.TraceShed,
+		"TRACE_CREDIT_WAIT_US":        &p.TraceCreditWait,
+		"INVARIANT_CHECK_INTERVAL_US": &p.InvariantCheckInterval,
+		"RECOUNT_RTTS":                &p.RecountRTTs,
+		"REPAIR_ACCOUNTING":           &p.RepairAccounting,
+		"DROP_FROM_FRONT":             &p.DropFromFront,
+		"SERVER_QUEUE_LENGTH":         &p.ServerQueueLength,
+		"SERVER_QUEUE_TIMEOUT_US":     &p.ServerQueueTimeout,
+		"MAX_RETRIES":                 &p.MaxRetries,
+		"RETRY_CREDITS":               &p.RetryCredits,
+		"RETRY_CREDIT_COST":           &p.RetryCreditCost,
+		"CLIENT_IDLE_TIMEOUT_US":      &p.ClientIdleTimeout,
+		"CREDIT_LEASE_RTTS":           &p.CreditLeaseRTTs,
+		"REVOCATION_FACTOR":           &p.RevocationFactor,
+		"MAX_GRANT_INCREASE":          &p.MaxGrantIncrease,
+		"MIN_OVERCOMMIT":              &p.MinOvercommit,
+		"MAX_OVERCOMMIT":              &p.MaxOvercommit,
+		"DISABLE_OVERCOMMIT":          &p.DisableOvercommit,
+		"TENANT":                      &p.Tenant,
+		"HIGH_AQM_FACTOR":             &p.HighAQMFactor,
+		"CRITICAL_AQM_FACTOR":         &p.CriticalAQMFactor,
+		"HIGH_PRIORITY_RESERVE":       &p.HighPriorityReserve,
+		"AQM":                         &p.AQM,
+		"SHED_RAMP":                   &p.ShedRamp,
+		"DELAY_SIGNAL":                &p.DelaySignal,
+		"GC_PRESSURE":                 &p.GCPressure,
+		"CPU_TARGET":                  &p.CPUTarget,
+		"THROTTLE_WINDOWS":            &p.ThrottleWindows,
+		"MAX_CONCURRENT_HANDLERS":     &p.MaxConcurrentHandlers,
+		"IDEMPOTENT_AQM_FACTOR":       &p.IdempotentAQMFactor,
+		"MEASURE_RTT":                 &p.MeasureRTT,
+		"TIMING_TRAILERS":             &p.TimingTrailers,
+		"RECOVER_PANICS":              &p.RecoverPanics,
+		"DEDUP_WINDOW_US":             &p.DedupWindow,
+		"ZERO_CREDITS":                &p.ZeroCredits,
+		"CONNECTION_TOKENS":           &p.ConnectionTokens,
+		"CLIENT_IDENTITY":             &p.ClientIdentity,
+		"TRUST_CLIENT_BYPASS":         &p.TrustClientBypass,
+	}
+}
+
+/*
+Builds parameters from the defaults and the environment variables starting
+with prefix, e.g. BW_SLO_US=500 and BW_LOAD_SHEDDING=false for prefix "BW",
+so containerized deployments can tune breakwater without code changes.
+Unknown variables with the prefix are rejected as likely typos. Returns an
+error naming every variable that could not be parsed, or the problems
+Validate finds with the result.
+*/
+func ParamsFromEnv(prefix string) (BWParameters, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	params := BWParametersDefault
+	fields := envParams(&params)
+	var problems []error
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		field, ok := fields[strings.TrimPrefix(name, prefix)]
+		if !ok {
+			if prefix != "" {
+				problems = append(problems, fmt.Errorf("%s is not a breakwater parameter", name))
+			}
+			continue
+		}
+		if err := setEnvParam(field, value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	// os.Environ is unordered
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Error() < problems[j].Error() })
+	if len(problems) > 0 {
+		return params, errors.Join(problems...)
+	}
+	return params, params.Validate()
+}
+
+func setEnvParam(field interface{}, value string) error {
+	var err error
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *bool:
+		*f, err = strconv.ParseBool(value)
+	case *int64:
+		*f, err = strconv.ParseInt(value, 10, 64)
+	case *float64:
+		*f, err = strconv.ParseFloat(value, 64)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package breakwater
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParamsFromEnv(t *testing.T) {
+	t.Setenv("BW_SLO_US", "500")
+	t.Setenv("BW_AFACTOR", "0.01")
+	t.Setenv("BW_LOAD_SHEDDING", "false")
+	t.Setenv("BW_RECOVERY", RecoverySlowStart)
+	params, err := ParamsFromEnv("BW")
+	if err != nil {
+		t.Fatalf("Failed to read the parameters: %v", err)
+	}
+	if params.SLO != 500 || params.AFactor != 0.01 || params.LoadShedding || params.Recovery != RecoverySlowStart {
+		t.Errorf("Expected the variables to be applied, got SLO %d, AFactor %g, LoadShedding %t, Recovery %q",
+			params.SLO, params.AFactor, params.LoadShedding, params.Recovery)
+	}
+	if params.BFactor != BWParametersDefault.BFactor || params.InitialCredits != BWParametersDefault.InitialCredits {
+		t.Errorf("Expected unset parameters to keep their defaults, got BFactor %g and InitialCredits %d", params.BFactor, params.InitialCredits)
+	}
+}
+
+func TestParamsFromEnvRejectsBadVariables(t *testing.T) {
+	t.Setenv("APP_SLO_US", "fast")
+	t.Setenv("APP_BFACTR", "0.1")
+	_, err := ParamsFromEnv("APP_")
+	if err == nil || !strings.Contains(err.Error(), "APP_SLO_US") || !strings.Contains(err.Error(), "APP_BFACTR") {
+		t.Errorf("Expected the unparsable and unknown variables to be reported, got %v", err)
+	}
+}
+
+func TestParamsFromEnvValidates(t *testing.T) {
+	t.Setenv("BW_BFACTOR", "1.5")
+	if _, err := ParamsFromEnv("BW"); err == nil || !strings.Contains(err.Error(), "BFactor") {
+		t.Errorf("Expected the parameters to be validated, got %v", err)
+	}
+}
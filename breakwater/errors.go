@@ -0,0 +1,119 @@
+package breakwater
+
+import (
+	"math"
+	"time"
+
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+/*
+Builds the ResourceExhausted error for a request rejected by breakwater.
+The status carries a BreakwaterRejection detail with the reason, and a
+RetryInfo detail with the suggested backoff, so callers can tell overload
+apart from other kinds of resource exhaustion.
+*/
+func RejectionError(reason bwpb.BreakwaterRejection_Reason, breakwaterID string, queueingDelay float64, retryAfter time.Duration, msg string) error {
+	st := status.New(codes.ResourceExhausted, msg)
+	detailed, err := st.WithDetails(
+		&bwpb.BreakwaterRejection{
+			Reason:          reason,
+			BreakwaterId:    breakwaterID,
+			QueueingDelayUs: queueingDelay,
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)},
+	)
+	if err != nil {
+		alert("Failed to attach rejection details: %v", err)
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func ClientQueueFullError(breakwaterID string, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_CLIENT_QUEUE_FULL, breakwaterID, 0, retryAfter,
+		"Client queue too long, request dropped at client "+breakwaterID)
+}
+
+func ClientExpirationError(breakwaterID string, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_CLIENT_EXPIRATION, breakwaterID, 0, retryAfter,
+		"Client id "+breakwaterID+" request expired in queue.")
+}
+
+func ClientDroppedFromFrontError(breakwaterID string, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_CLIENT_DROPPED_FROM_FRONT, breakwaterID, 0, retryAfter,
+		"Client id "+breakwaterID+" request dropped from front of full queue.")
+}
+
+func ServerAQMError(breakwaterID string, queueingDelay float64, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_SERVER_AQM, breakwaterID, queueingDelay, retryAfter,
+		"Server-side queuing delay is beyond AQM threshold")
+}
+
+func ServerQueueFullError(breakwaterID string, queueingDelay float64, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_FULL, breakwaterID, queueingDelay, retryAfter,
+		"Server-side queue is full")
+}
+
+func ServerQueueExpirationError(breakwaterID string, queueingDelay float64, retryAfter time.Duration) error {
+	return RejectionError(bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_EXPIRATION, breakwaterID, queueingDelay, retryAfter,
+		"Request expired in server-side queue")
+}
+
+/*
+Returns the BreakwaterRejection detail of err, false if err is not a
+breakwater rejection
+*/
+func RejectionFromError(err error) (*bwpb.BreakwaterRejection, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	for _, detail := range st.Details() {
+		if rejection, ok := detail.(*bwpb.BreakwaterRejection); ok {
+			return rejection, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Returns the backoff suggested by the RetryInfo detail of err,
+false if there is none
+*/
+func RetryDelayFromError(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return 0, false
+	}
+	for _, detail := range st.Details() {
+		if retryInfo, ok := detail.(*errdetails.RetryInfo); ok && retryInfo.GetRetryDelay() != nil {
+			return retryInfo.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+/*
+Suggested backoff for a request shed by the server, one RTT for every
+multiple of its target delay the queueing delay has reached
+*/
+func (b *Breakwater) serverRetryDelay(queueingDelay float64, t delayThresholds) time.Duration {
+	rtts := math.Max(queueingDelay/t.threshold, 1)
+	return time.Duration(rtts*float64(b.getRTT())) * time.Microsecond
+}
+
+/*
+Suggested backoff for a request rejected at the client, roughly the number
+of RTTs the current credits need to drain the queue
+*/
+func (t *clientTarget) retryDelay(rtt int64) time.Duration {
+	creditBalance := <-t.outgoingCredits
+	t.outgoingCredits <- creditBalance
+	rtts := max(int64(t.getDemand()), 1) / max(creditBalance, 1)
+	return time.Duration(max(rtts, 1)*rtt) * time.Microsecond
+}
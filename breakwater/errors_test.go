@@ -0,0 +1,59 @@
+package breakwater
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRejectionDetails(t *testing.T) {
+	err := ServerAQMError("server-a", 300, 10*time.Millisecond)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", status.Code(err))
+	}
+
+	rejection, ok := RejectionFromError(err)
+	if !ok {
+		t.Fatalf("Expected a rejection detail")
+	}
+	if rejection.GetReason() != bwpb.BreakwaterRejection_REASON_SERVER_AQM {
+		t.Errorf("Expected reason SERVER_AQM, got %v", rejection.GetReason())
+	}
+	if rejection.GetBreakwaterId() != "server-a" || rejection.GetQueueingDelayUs() != 300 {
+		t.Errorf("Unexpected rejection detail %v", rejection)
+	}
+
+	retryAfter, ok := RetryDelayFromError(err)
+	if !ok || retryAfter != 10*time.Millisecond {
+		t.Errorf("Expected a retry delay of 10ms, got %v", retryAfter)
+	}
+}
+
+func TestRejectionFromOtherErrors(t *testing.T) {
+	for _, err := range []error{
+		errors.New("not a status"),
+		status.Errorf(codes.ResourceExhausted, "quota exceeded"),
+	} {
+		if _, ok := RejectionFromError(err); ok {
+			t.Errorf("Expected %v not to be a breakwater rejection", err)
+		}
+		if _, ok := RetryDelayFromError(err); ok {
+			t.Errorf("Expected %v to have no retry delay", err)
+		}
+	}
+}
+
+func TestServerRetryDelay(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	rtt := time.Duration(bw.getRTT()) * time.Microsecond
+	if d := bw.serverRetryDelay(0, bw.thresholdsFor("")); d != rtt {
+		t.Errorf("Expected at least one RTT, got %v", d)
+	}
+	if d := bw.serverRetryDelay(3*bw.thresholdDelay(), bw.thresholdsFor("")); d != 3*rtt {
+		t.Errorf("Expected three RTTs at three times the target delay, got %v", d)
+	}
+}
@@ -0,0 +1,25 @@
+package breakwater
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	expvarOnce sync.Once
+	expvarMap  *expvar.Map // the "breakwater" map, published on first use
+)
+
+/*
+Publishes the Snapshot of the controller under name in the "breakwater"
+expvar map, served as JSON on /debug/vars by the default mux. A
+Breakwater published under a name already in use replaces the previous one.
+*/
+func (b *Breakwater) PublishExpvar(name string) {
+	expvarOnce.Do(func() {
+		expvarMap = expvar.NewMap("breakwater")
+	})
+	expvarMap.Set(name, expvar.Func(func() interface{} {
+		return b.Snapshot()
+	}))
+}
@@ -0,0 +1,34 @@
+package breakwater
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	params := BWParametersDefault
+	params.ExpvarName = "expvar-test"
+	bw := InitBreakwater(params)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	if _, err := bw.Admit(metadata.NewIncomingContext(context.Background(), md)); !isServerShed(err) {
+		t.Fatalf("Expected the request to be shed, got %v", err)
+	}
+
+	published := expvar.Get("breakwater").(*expvar.Map).Get("expvar-test")
+	if published == nil {
+		t.Fatalf("Expected the snapshot to be published")
+	}
+	var s Snapshot
+	if err := json.Unmarshal([]byte(published.String()), &s); err != nil {
+		t.Fatalf("Expected the published snapshot to be JSON: %v", err)
+	}
+	if s.CTotal != bw.Snapshot().CTotal || s.Shed != 1 {
+		t.Errorf("Expected the published snapshot to count the shed request, got %+v", s)
+	}
+}
@@ -0,0 +1,118 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+/*
+Weighted fair credit sharing.
+By default a client is issued its demand plus an even share of the unissued
+credits, so a client with a deep queue can take most of cTotal. With
+weightedFairSharing on, cTotal is instead split across connections by
+weighted max-min fairness at every RTT update: clients asking for less than
+their weighted share get their demand, the rest split what is left by
+weight, and any credits left over once every demand is met are spread by
+weight as overcommitment. A connection is then issued its share after each
+RTT update.
+*/
+func (b *Breakwater) allocateFairShares() {
+	var connections []*Connection
+	var weights []float64
+	var demands []int64
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		connections = append(connections, c)
+		weights = append(weights, c.weight)
+		demands = append(demands, atomic.LoadInt64(&c.demand))
+		c.mu.Unlock()
+		return true
+	})
+
+	shares := weightedMaxMin(b.cTotal, weights, demands)
+	for i, c := range connections {
+		c.mu.Lock()
+		c.share = shares[i]
+		c.mu.Unlock()
+	}
+	b.logger("[Fair Share]:	Allocated %d credits across %d clients", b.cTotal, len(connections))
+}
+
+/*
+Splits total by weighted max-min fairness over the given demands
+(water-filling), then spreads whatever is left by weight.
+Every share is at least 1 so each client can still send a request.
+*/
+func weightedMaxMin(total int64, weights []float64, demands []int64) []int64 {
+	allocated := make([]float64, len(weights))
+	remaining := float64(total)
+
+	var unsatisfied []int
+	for i, demand := range demands {
+		if demand > 0 && weights[i] > 0 {
+			unsatisfied = append(unsatisfied, i)
+		}
+	}
+	for len(unsatisfied) > 0 && remaining > 0 {
+		var sumWeights float64 = 0
+		for _, i := range unsatisfied {
+			sumWeights += weights[i]
+		}
+		perWeight := remaining / sumWeights
+
+		var next []int
+		for _, i := range unsatisfied {
+			if float64(demands[i]) <= weights[i]*perWeight {
+				allocated[i] = float64(demands[i])
+				remaining -= allocated[i]
+			} else {
+				next = append(next, i)
+			}
+		}
+		if len(next) == len(unsatisfied) {
+			// nobody fits within their share, split the rest by weight
+			for _, i := range next {
+				allocated[i] = weights[i] * perWeight
+			}
+			remaining = 0
+			break
+		}
+		unsatisfied = next
+	}
+
+	if remaining > 0 {
+		var sumWeights float64 = 0
+		for _, w := range weights {
+			sumWeights += w
+		}
+		if sumWeights > 0 {
+			for i, w := range weights {
+				allocated[i] += remaining * w / sumWeights
+			}
+		}
+	}
+
+	shares := make([]int64, len(allocated))
+	for i, a := range allocated {
+		shares[i] = max(int64(math.Floor(a)), 1)
+	}
+	return shares
+}
+
+/*
+Sets the weight of a client for weighted fair sharing, registering it if needed
+*/
+func (b *Breakwater) SetClientWeight(id uuid.UUID, weight float64) {
+	b.RegisterClient(id, 0)
+	c, _ := b.clients.load(id)
+	c.mu.Lock()
+	changed := c.weight != weight
+	b.setWeight(c, weight)
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+	b.logger("[Fair Share]:	Client %s weight set to %f", id, weight)
+}
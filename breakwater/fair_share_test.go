@@ -0,0 +1,58 @@
+package breakwater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestWeightedMaxMin(t *testing.T) {
+	cases := []struct {
+		name     string
+		total    int64
+		weights  []float64
+		demands  []int64
+		expected []int64
+	}{
+		// the small client gets its demand, the others split the rest evenly
+		{"max-min", 100, []float64{1, 1, 1}, []int64{10, 100, 100}, []int64{10, 45, 45}},
+		// with no spare capacity, shares follow the weights
+		{"weighted", 90, []float64{2, 1}, []int64{100, 100}, []int64{60, 30}},
+		// once every demand is met, the leftover is spread by weight
+		{"leftover", 100, []float64{3, 1}, []int64{10, 10}, []int64{70, 30}},
+		// idle clients still get a credit
+		{"idle", 10, []float64{1, 1}, []int64{0, 50}, []int64{1, 10}},
+	}
+	for _, c := range cases {
+		shares := weightedMaxMin(c.total, c.weights, c.demands)
+		if !reflect.DeepEqual(shares, c.expected) {
+			t.Errorf("%s: expected shares %v, got %v", c.name, c.expected, shares)
+		}
+	}
+}
+
+func TestFairShareIssuedAfterRTTUpdate(t *testing.T) {
+	params := BWParametersDefault
+	params.WeightedFairSharing = true
+	params.InitialCredits = 300
+	bw := InitBreakwater(params)
+	setDelay(bw, bw.thresholdDelay()*2)
+
+	heavy, light := uuid.New(), uuid.New()
+	bw.RegisterClient(heavy, 0)
+	bw.RegisterClient(light, 0)
+	bw.SetClientWeight(heavy, 2)
+	bw.updateCreditsToIssue(heavy, 1000)
+	bw.updateCreditsToIssue(light, 1000)
+
+	bw.rttUpdate()
+	heavyCredits := bw.updateCreditsToIssue(heavy, 1000)
+	lightCredits := bw.updateCreditsToIssue(light, 1000)
+	if heavyCredits != 2*lightCredits {
+		t.Errorf("Expected the weight 2 client to get twice the credits, got %d and %d", heavyCredits, lightCredits)
+	}
+	if heavyCredits+lightCredits > bw.cTotal {
+		t.Errorf("Expected shares to fit in cTotal %d, got %d", bw.cTotal, heavyCredits+lightCredits)
+	}
+}
@@ -0,0 +1,58 @@
+package breakwater
+
+import (
+	"math/rand"
+	"time"
+)
+
+/*
+Faults injected into a Breakwater, for tests of how a service degrades
+while the controller sheds load. The zero value injects nothing.
+*/
+type Faults struct {
+	ExtraDelay  time.Duration // added to every queueing delay the server measures
+	DropHeaders float64       // fraction of responses the server sends without breakwater headers
+	GrantDelay  time.Duration // how long a client holds credits it was granted before it can spend them
+}
+
+/*
+Starts injecting f, replacing the faults injected before
+*/
+func (b *Breakwater) InjectFaults(f Faults) {
+	b.faultsMu.Lock()
+	b.faults = f
+	b.faultsMu.Unlock()
+	b.logger("[Faults]:	Injecting %+v", f)
+}
+
+/*
+Stops injecting faults
+*/
+func (b *Breakwater) ClearFaults() {
+	b.InjectFaults(Faults{})
+}
+
+func (b *Breakwater) injectedFaults() Faults {
+	b.faultsMu.Lock()
+	defer b.faultsMu.Unlock()
+	return b.faults
+}
+
+/*
+Whether the headers of a response are dropped
+*/
+func (b *Breakwater) dropsHeader() bool {
+	p := b.injectedFaults().DropHeaders
+	return p > 0 && rand.Float64() < p
+}
+
+/*
+Applies a credit grant from a response, after GrantDelay if one is injected
+*/
+func (t *clientTarget) applyGrant(b *Breakwater, credits int64) {
+	if delay := b.injectedFaults().GrantDelay; delay > 0 {
+		time.AfterFunc(delay, func() { t.setCredits(credits) })
+		return
+	}
+	t.setCredits(credits)
+}
@@ -0,0 +1,57 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectedDelay(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 100)
+	bw.InjectFaults(Faults{ExtraDelay: 2 * time.Millisecond})
+	if delay := bw.getDelay(); delay != 2100 {
+		t.Errorf("Expected the injected delay to be added, got %f us", delay)
+	}
+	bw.ClearFaults()
+	if delay := bw.getDelay(); delay != 100 {
+		t.Errorf("Expected the measured delay once faults are cleared, got %f us", delay)
+	}
+}
+
+func TestInjectedHeaderDrops(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	bw.InjectFaults(Faults{DropHeaders: 1})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "5", "id", bw.id.String()))
+	a, err := bw.Admit(ctx)
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+	if a.Header != nil {
+		t.Errorf("Expected the response headers to be dropped, got %v", a.Header)
+	}
+}
+
+func TestInjectedGrantDelay(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.InjectFaults(Faults{GrantDelay: 20 * time.Millisecond})
+	target := newClientTarget("server-a")
+	<-target.outgoingCredits
+	target.outgoingCredits <- 0
+
+	target.applyGrant(bw, 5)
+	credits := <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 0 {
+		t.Errorf("Expected the grant to be held back, got %d credits", credits)
+	}
+	time.Sleep(50 * time.Millisecond)
+	credits = <-target.outgoingCredits
+	target.outgoingCredits <- credits
+	if credits != 5 {
+		t.Errorf("Expected the grant to arrive after the delay, got %d credits", credits)
+	}
+}
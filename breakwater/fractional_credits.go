@@ -0,0 +1,39 @@
+package breakwater
+
+import "sync/atomic"
+
+/*
+Fractional credits
+The AIMD update, warm-up and idle decay work on the exact, fractional size
+of the pool, so the fraction of a credit an update adds or removes is not
+rounded away but adds up across RTTs. A pool of 7 credits cut by 2% shrinks
+instead of rounding back to 7, and an additive increase of 1.4 credits is
+not rounded down to 1 every RTT. The increase is still at least one credit,
+so a server with few clients recovers. cTotal is the exact pool rounded to
+whole credits, which is what grants, snapshots and the wire see. Only
+accessed under the rtt lock.
+*/
+func (b *Breakwater) exactCredits() float64 {
+	if roundedInt(b.credits) != b.cTotal {
+		// cTotal was set elsewhere, e.g. at init
+		return float64(b.cTotal)
+	}
+	return b.credits
+}
+
+/*
+Sets the exact pool and cTotal from it
+*/
+func (b *Breakwater) setCredits(credits float64) {
+	b.credits = credits
+	atomic.StoreInt64(&b.cTotal, roundedInt(credits))
+}
+
+/*
+cTotal for readers that do not hold the rtt lock, such as requests and
+Snapshot. It only changes under the rtt lock, where it is stored
+atomically.
+*/
+func (b *Breakwater) getCTotal() int64 {
+	return atomic.LoadInt64(&b.cTotal)
+}
@@ -0,0 +1,42 @@
+package breakwater
+
+import "testing"
+
+func TestSmallPoolDecreases(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.cTotal = 7
+	// a 2% cut, which rounds back to 7 credits on its own
+	setDelay(bw, 2*bw.thresholdDelay())
+	for i := 0; i < 10; i++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+	}
+	if bw.cTotal >= 7 {
+		t.Errorf("Expected the cuts to add up and shrink the pool, got %d", bw.cTotal)
+	}
+}
+
+func TestFractionalIncreaseAddsUp(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	// 1.5 credits per RTT
+	setNumClients(bw, 1500)
+	setDelay(bw, 0)
+	for i := 0; i < 4; i++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+	}
+	if expected := BWParametersDefault.InitialCredits + 6; bw.cTotal != expected || bw.credits != float64(expected) {
+		t.Errorf("Expected 4 increases of 1.5 credits to add 6, got %d (exactly %f)", bw.cTotal, bw.credits)
+	}
+}
+
+func TestExactCreditsFollowsCTotal(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.setCredits(10.4)
+	if bw.cTotal != 10 || bw.exactCredits() != 10.4 {
+		t.Errorf("Expected cTotal 10 from 10.4 credits, got %d", bw.cTotal)
+	}
+	// cTotal set directly overrides the exact pool
+	bw.cTotal = 50
+	if exact := bw.exactCredits(); exact != 50 {
+		t.Errorf("Expected the exact pool to follow cTotal, got %f", exact)
+	}
+}
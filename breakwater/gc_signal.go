@@ -0,0 +1,87 @@
+package breakwater
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+)
+
+// Runtime metrics read by GCSignal
+const (
+	gcPausesMetric    = "/gc/pauses:seconds"
+	heapGoalMetric    = "/gc/heap/goal:bytes"
+	memoryLimitMetric = "/gc/gomemlimit:bytes"
+)
+
+const DefaultHeapGoalThreshold = 0.9 // share of GOMEMLIMIT from which the heap goal counts as pressure
+
+/*
+GCSignal is an overload signal for allocation-heavy services, read from
+runtime/metrics. Its delay in microseconds is the larger of
+1. the longest stop-the-world GC pause since the previous sample, during
+which every request waits as if queued
+2. how close the heap goal is to GOMEMLIMIT, scaled so a heap goal at the
+limit counts as an AQM breach. Pinned at the limit, the collector runs ever
+more often instead of letting the heap grow, so load is shed before the
+collector stalls the process. Without a memory limit this part is 0.
+Select it alone with DelaySignalGC, or count it alongside another signal
+with BWParameters.GCPressure.
+*/
+type GCSignal struct {
+	// Heap goal as a share of GOMEMLIMIT from which it adds to the delay,
+	// DefaultHeapGoalThreshold unless changed before the first sample
+	HeapGoalThreshold float64
+
+	aqmDelay   float64 // delay of a heap goal at the limit in microseconds
+	mu         sync.Mutex
+	prevPauses *metrics.Float64Histogram // pause histogram at the previous sample, nil before the first
+}
+
+/*
+Creates a GC signal whose heap pressure reaches aqmDelay, in microseconds,
+at the memory limit
+*/
+func NewGCSignal(aqmDelay float64) *GCSignal {
+	return &GCSignal{HeapGoalThreshold: DefaultHeapGoalThreshold, aqmDelay: aqmDelay}
+}
+
+/*
+Delay source, the GC pressure since the previous sample in microseconds
+*/
+func (s *GCSignal) Delay() float64 {
+	samples := []metrics.Sample{{Name: gcPausesMetric}, {Name: heapGoalMetric}, {Name: memoryLimitMetric}}
+	metrics.Read(samples)
+	return math.Max(s.pauseDelay(samples[0].Value), s.heapDelay(samples[1].Value, samples[2].Value))
+}
+
+func (s *GCSignal) pauseDelay(value metrics.Value) float64 {
+	if value.Kind() != metrics.KindFloat64Histogram {
+		return 0
+	}
+	pauses := value.Float64Histogram()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.prevPauses
+	s.prevPauses = pauses
+	if prev == nil || len(prev.Counts) != len(pauses.Counts) {
+		return 0
+	}
+	return maximumQueuingDelayus(prev, pauses)
+}
+
+func (s *GCSignal) heapDelay(goal metrics.Value, limit metrics.Value) float64 {
+	if goal.Kind() != metrics.KindUint64 || limit.Kind() != metrics.KindUint64 || limit.Uint64() == math.MaxInt64 {
+		return 0
+	}
+	return heapPressure(float64(goal.Uint64())/float64(limit.Uint64()), s.HeapGoalThreshold) * s.aqmDelay
+}
+
+/*
+0 below threshold, rising linearly to 1 with the heap goal at the limit
+*/
+func heapPressure(proximity float64, threshold float64) float64 {
+	if proximity <= threshold || threshold >= 1 {
+		return 0
+	}
+	return math.Min((proximity-threshold)/(1-threshold), 1)
+}
@@ -0,0 +1,47 @@
+package breakwater
+
+import (
+	"math"
+	"runtime"
+	runtimedebug "runtime/debug"
+	"testing"
+)
+
+func TestHeapPressure(t *testing.T) {
+	cases := []struct {
+		proximity, expected float64
+	}{{0.5, 0}, {0.9, 0}, {0.95, 0.5}, {1, 1}, {1.2, 1}}
+	for _, c := range cases {
+		if got := heapPressure(c.proximity, 0.9); math.Abs(got-c.expected) > 1e-9 {
+			t.Errorf("Expected a heap goal at %g of the limit to be pressure %g, got %g", c.proximity, c.expected, got)
+		}
+	}
+}
+
+func TestGCSignalShedsNearMemoryLimit(t *testing.T) {
+	signal := NewGCSignal(1000)
+	signal.Delay()
+	if delay := signal.Delay(); delay >= 1000 {
+		t.Fatalf("Expected no heap pressure without a memory limit, got %g", delay)
+	}
+
+	// a limit below the heap pins the heap goal to it
+	defer runtimedebug.SetMemoryLimit(runtimedebug.SetMemoryLimit(1))
+	runtime.GC()
+	if delay := signal.Delay(); delay < 1000 {
+		t.Errorf("Expected a heap goal at the memory limit to count as an AQM breach, got %g", delay)
+	}
+}
+
+func TestGCPressureJoinsDelay(t *testing.T) {
+	params := BWParametersDefault
+	params.GCPressure = true
+	bw := InitBreakwater(params)
+	bw.delaySource = func() float64 { return 0 }
+
+	defer runtimedebug.SetMemoryLimit(runtimedebug.SetMemoryLimit(1))
+	runtime.GC()
+	if delay := bw.getDelay(); delay < bw.aqmDelay() {
+		t.Errorf("Expected GC pressure to raise the delay to the AQM threshold, got %g", delay)
+	}
+}
@@ -0,0 +1,81 @@
+package breakwater
+
+import (
+	"math"
+)
+
+const (
+	gradientShortWindow = 10  // samples averaged in the short-term latency
+	gradientLongWindow  = 600 // samples averaged in the long-term latency
+	gradientSmoothing   = 0.2 // weight of a new limit against the previous one
+)
+
+/*
+Gradient controller for cTotal, after Netflix concurrency-limits' Gradient2,
+as an alternative to AIMD on the scheduler delay.
+It only looks at RPC latency: the handler time of every admitted request
+feeds a short-term and a long-term EWMA. Every RTT the limit is scaled by
+the gradient tolerance * long / short (clamped to [0.5, 1]), so it shrinks
+while recent latency is above its long-term trend, and grows by
+sqrt(limit) to leave room for a small queue.
+*/
+type gradientLimit struct {
+	lock      chan int64 // lock for the averages and the estimate
+	shortRTT  float64    // short-term latency EWMA in microseconds
+	longRTT   float64    // long-term latency EWMA in microseconds
+	samples   int64
+	estimate  float64 // unrounded limit, so small limits can still grow
+	tolerance float64 // how much short-term latency may exceed long-term before the limit shrinks
+	verbose           // writes debug lines, the Breakwater's BWParameters.Verbose
+}
+
+func newGradientLimit(tolerance float64, v verbose) *gradientLimit {
+	g := &gradientLimit{
+		lock:      make(chan int64, 1),
+		tolerance: tolerance,
+		verbose:   v,
+	}
+	g.lock <- 1
+	return g
+}
+
+/*
+Records the latency of a handled request in microseconds
+*/
+func (g *gradientLimit) sample(latency float64) {
+	<-g.lock
+	if g.samples == 0 {
+		g.shortRTT, g.longRTT = latency, latency
+	} else {
+		g.shortRTT += (latency - g.shortRTT) * 2 / (gradientShortWindow + 1)
+		g.longRTT += (latency - g.longRTT) * 2 / (gradientLongWindow + 1)
+	}
+	g.samples++
+	g.lock <- 1
+}
+
+/*
+Returns the next limit given the current one, unchanged until a latency
+has been sampled
+*/
+func (g *gradientLimit) update(limit int64) int64 {
+	<-g.lock
+	defer func() { g.lock <- 1 }()
+	if g.samples == 0 || g.shortRTT <= 0 {
+		return limit
+	}
+	if g.estimate == 0 || roundedInt(g.estimate) != limit {
+		// cTotal was set elsewhere, e.g. at init
+		g.estimate = float64(limit)
+	}
+
+	// let the long-term average recover quickly after a latency spike has passed
+	if g.longRTT/g.shortRTT > 2 {
+		g.longRTT *= 0.95
+	}
+	gradient := math.Max(0.5, math.Min(1.0, g.tolerance*g.longRTT/g.shortRTT))
+	newLimit := g.estimate*gradient + math.Sqrt(g.estimate)
+	g.estimate = math.Max(g.estimate*(1-gradientSmoothing)+newLimit*gradientSmoothing, 1)
+	g.logger("[Gradient]:	short %f us, long %f us, gradient %f, limit %f", g.shortRTT, g.longRTT, gradient, g.estimate)
+	return roundedInt(g.estimate)
+}
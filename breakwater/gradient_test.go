@@ -0,0 +1,52 @@
+package breakwater
+
+import (
+	"testing"
+)
+
+func TestGradientGrowsAtSteadyLatency(t *testing.T) {
+	g := newGradientLimit(1.5, false)
+	if limit := g.update(10); limit != 10 {
+		t.Errorf("Expected the limit to hold before any sample, got %d", limit)
+	}
+	for i := 0; i < 100; i++ {
+		g.sample(100)
+	}
+	limit := int64(1)
+	for i := 0; i < 10; i++ {
+		limit = g.update(limit)
+	}
+	if limit <= 1 {
+		t.Errorf("Expected a small limit to grow while latency is steady, got %d", limit)
+	}
+}
+
+func TestGradientShrinksWhenLatencyRises(t *testing.T) {
+	g := newGradientLimit(1.5, false)
+	for i := 0; i < 1000; i++ {
+		g.sample(100)
+	}
+	for i := 0; i < 20; i++ {
+		g.sample(1000)
+	}
+	if limit := g.update(100); limit >= 100 {
+		t.Errorf("Expected the limit to shrink when short-term latency rises, got %d", limit)
+	}
+}
+
+func TestGradientController(t *testing.T) {
+	params := BWParametersDefault
+	params.Algorithm = AlgorithmGradient
+	bw, ok := NewController(params).(*Breakwater)
+	if !ok || bw.gradient == nil {
+		t.Fatalf("Expected a breakwater with a gradient limit")
+	}
+	// the scheduler delay is not consulted
+	bw.delaySource = func() float64 { return bw.thresholdDelay() * 10 }
+	for i := 0; i < 100; i++ {
+		bw.gradient.sample(100)
+	}
+	if cTotal := bw.getUpdatedTotalCredits(); cTotal < bw.cTotal {
+		t.Errorf("Expected cTotal to follow latency alone, got %d from %d", cTotal, bw.cTotal)
+	}
+}
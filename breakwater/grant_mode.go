@@ -0,0 +1,34 @@
+package breakwater
+
+import "sync/atomic"
+
+/*
+When grants are recomputed, selected with BWParameters.GrantMode
+*/
+const (
+	GrantModeLazy = "lazy" // on each client's first request after an RTT update
+	GrantModeRTT  = "rtt"  // for all clients at once at the RTT update, as in the paper
+)
+
+/*
+RTT-synchronized grants
+By default each client's grant is recomputed on its first request after
+an RTT update, from the credits left unissued at that moment, so clients
+that send early in an RTT get the pool before those that send late. With
+GrantModeRTT the RTT update recomputes the grant of every client with
+demand in one pass, against the same new cTotal, and requests within the
+RTT only spend them. Clients that register during an RTT get their first
+grant on their first request. Called under the rtt lock.
+*/
+func (b *Breakwater) recomputeGrants() {
+	b.clients.rangeClients(func(c *Connection) bool {
+		demand := atomic.LoadInt64(&c.demand)
+		if demand <= 0 {
+			return true
+		}
+		c.mu.Lock()
+		b.grantLocked(c, demand, BestEffort, 1, true)
+		c.mu.Unlock()
+		return true
+	})
+}
@@ -0,0 +1,57 @@
+package breakwater
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func grantModeBreakwater(mode string) *Breakwater {
+	params := BWParametersDefault
+	params.GrantMode = mode
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	return bw
+}
+
+func issuedTo(bw *Breakwater, id uuid.UUID) int64 {
+	c, _ := bw.clients.load(id)
+	return atomic.LoadInt64(&c.issued)
+}
+
+func TestRTTGrantModeRecomputesAllClients(t *testing.T) {
+	bw := grantModeBreakwater(GrantModeRTT)
+	first, second, idle := uuid.New(), uuid.New(), uuid.New()
+	bw.RegisterClient(first, 5)
+	bw.RegisterClient(second, 5)
+	bw.RegisterClient(idle, 0)
+
+	bw.UpdateCredits()
+	granted := issuedTo(bw, first)
+	if granted < 5 || issuedTo(bw, second) < 5 {
+		t.Fatalf("Expected the update to grant both clients their demand, got %d and %d", granted, issuedTo(bw, second))
+	}
+	if issued := issuedTo(bw, idle); issued != 0 {
+		t.Errorf("Expected no grant for a client without demand, got %d", issued)
+	}
+	// requests within the RTT only spend their grant
+	if issued := bw.issueCredits(first, 50, BestEffort, 1); issued != granted-1 {
+		t.Errorf("Expected the request to spend a credit of %d, got %d", granted, issued)
+	}
+}
+
+func TestLazyGrantModeWaitsForRequest(t *testing.T) {
+	bw := grantModeBreakwater(GrantModeLazy)
+	id := uuid.New()
+	bw.RegisterClient(id, 5)
+
+	bw.UpdateCredits()
+	if issued := issuedTo(bw, id); issued != 0 {
+		t.Fatalf("Expected no grant before the client's request, got %d", issued)
+	}
+	if issued := bw.issueCredits(id, 5, BestEffort, 1); issued < 5 {
+		t.Errorf("Expected the request to be granted its demand, got %d", issued)
+	}
+}
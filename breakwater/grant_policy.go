@@ -0,0 +1,119 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+/*
+What a GrantPolicy knows when it recomputes a client's grant. The totals
+over all clients are those of the last RTT update.
+*/
+type GrantState struct {
+	Demand         int64   // requests the client has waiting or in flight
+	Issued         int64   // credits issued to the client before this grant
+	CTotal         int64   // credits the server can issue
+	CIssued        int64   // credits issued to all clients
+	Overcommit     int64   // credits a client may be issued beyond its demand, bounded by MinOvercommit and MaxOvercommit
+	Clients        int64   // clients with demand
+	TotalDemand    int64   // demand of all clients
+	Weight         float64 // weight of the client, 1 unless set with SetClientWeight or ClientWeight
+	WeightedDemand float64 // demand of all clients, each times its weight
+}
+
+/*
+Computes the credits issued to a client when its grant is recomputed, so
+issuance strategies can be tried without forking the interceptor.
+Breakwater still issues every client at least one credit, unless
+ZeroCredits is set, and applies MaxGrantIncrease, HighPriorityReserve and
+tenant quotas to the grant. WeightedFairSharing allocates grants itself
+and bypasses the policy.
+*/
+type GrantPolicy interface {
+	Grant(s GrantState) int64
+}
+
+/*
+Breakwater's policy: demand plus the overcommit, limited to the credits
+left in the pool while cIssued is below cTotal. Over the limit a client
+gets at most one credit less than it had, so the pool is reclaimed one
+request at a time.
+*/
+type DemandGrantPolicy struct{}
+
+func (DemandGrantPolicy) Grant(s GrantState) int64 {
+	if s.Demand+s.Overcommit < 0 {
+		return 1
+	}
+	// Here, CIssued is OVERALL issued credits, while Issued is credits issued to a connection
+	if s.CIssued < s.CTotal {
+		// There is still space to issue credits, but we cannot add more than cAvail
+		return min(s.Demand+s.Overcommit, s.Issued+s.CTotal-s.CIssued)
+	}
+	// At credit limit, so we only decrease
+	return min(s.Demand+s.Overcommit, s.Issued-1)
+}
+
+/*
+Splits cTotal across clients in proportion to their demand, whatever they
+were issued before
+*/
+type ProportionalGrantPolicy struct{}
+
+func (ProportionalGrantPolicy) Grant(s GrantState) int64 {
+	// the client's demand may have grown since the last RTT update
+	total := max(s.TotalDemand, s.Demand)
+	if total <= 0 {
+		return 0
+	}
+	return roundedInt(float64(s.CTotal) * float64(s.Demand) / float64(total))
+}
+
+/*
+Splits cTotal evenly across the clients with demand
+*/
+type EqualShareGrantPolicy struct{}
+
+func (EqualShareGrantPolicy) Grant(s GrantState) int64 {
+	return roundedInt(float64(s.CTotal) / float64(max(s.Clients, 1)))
+}
+
+/*
+Splits cTotal across clients in proportion to their demand times their
+weight, so clients given a higher weight for their priority get more of
+the pool for the same demand
+*/
+type WeightedGrantPolicy struct{}
+
+func (WeightedGrantPolicy) Grant(s GrantState) int64 {
+	weighted := s.Weight * float64(s.Demand)
+	total := math.Max(s.WeightedDemand, weighted)
+	if total <= 0 {
+		return 0
+	}
+	return roundedInt(float64(s.CTotal) * weighted / total)
+}
+
+/*
+Recomputes the grant of a client with the given demand, previously issued
+connCPrevious credits, with the grant policy
+*/
+func (b *Breakwater) calculateCreditsToIssue(demand int64, connCPrevious int64, weight float64) int64 {
+	cOverCommit := b.calculateCreditsToOvercommit()
+	b.logger("[Issuing credits]: cOverCommit is %d", cOverCommit)
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued
+	cNew := b.grantPolicy.Grant(GrantState{
+		Demand:         demand,
+		Issued:         connCPrevious,
+		CTotal:         b.getCTotal(),
+		CIssued:        cIssued,
+		Overcommit:     cOverCommit,
+		Clients:        b.overcommitClients(),
+		TotalDemand:    atomic.LoadInt64(&b.totalDemand),
+		Weight:         weight,
+		WeightedDemand: math.Float64frombits(atomic.LoadUint64(&b.weightedDemand)),
+	})
+	b.logger("[Issuing credits]: cIssued is %d, cTotal is %d, policy grants %d", cIssued, b.getCTotal(), cNew)
+	return max(cNew, b.minGrant())
+}
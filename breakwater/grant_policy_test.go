@@ -0,0 +1,48 @@
+package breakwater
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGrantPolicies(t *testing.T) {
+	s := GrantState{Demand: 30, CTotal: 100, Clients: 4, TotalDemand: 60, Weight: 3, WeightedDemand: 120}
+	cases := []struct {
+		policy   GrantPolicy
+		expected int64
+	}{
+		{ProportionalGrantPolicy{}, 50},
+		{EqualShareGrantPolicy{}, 25},
+		{WeightedGrantPolicy{}, 75},
+	}
+	for _, c := range cases {
+		if grant := c.policy.Grant(s); grant != c.expected {
+			t.Errorf("Expected %T to grant %d, got %d", c.policy, c.expected, grant)
+		}
+	}
+	// demand that grew since the last RTT update is not granted more than the pool
+	if grant := (ProportionalGrantPolicy{}).Grant(GrantState{Demand: 80, CTotal: 100, TotalDemand: 60}); grant != 100 {
+		t.Errorf("Expected the whole pool, got %d", grant)
+	}
+}
+
+func TestCustomGrantPolicy(t *testing.T) {
+	params := BWParametersDefault
+	params.GrantPolicy = EqualShareGrantPolicy{}
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	first, second := uuid.New(), uuid.New()
+	bw.RegisterClient(first, 1)
+	bw.RegisterClient(second, 500)
+	bw.UpdateCredits()
+
+	expected := roundedInt(float64(bw.cTotal) / 2)
+	if issued := bw.issueCredits(first, 1, High, 1); issued != expected {
+		t.Errorf("Expected an equal share of %d whatever the demand, got %d", expected, issued)
+	}
+	if issued := bw.issueCredits(second, 500, High, 1); issued != expected {
+		t.Errorf("Expected an equal share of %d whatever the demand, got %d", expected, issued)
+	}
+}
@@ -0,0 +1,17 @@
+package breakwater
+
+/*
+Grant smoothing
+A client whose demand jumps, or that gets a large share of a freshly grown
+cTotal, would be granted hundreds of credits at once, and clients updated
+in the same RTT would send their bursts together. With MaxGrantIncrease
+set, a client's grant grows by at most that many credits per RTT update.
+Decreases are never limited, so overload is still met at once.
+*/
+func (b *Breakwater) capGrantIncrease(cPrevious int64, cNew int64) int64 {
+	if b.maxGrantIncrease <= 0 || cNew-cPrevious <= b.maxGrantIncrease {
+		return cNew
+	}
+	b.logger("[Grant Smoothing]:	Grant of %d capped to %d", cNew, cPrevious+b.maxGrantIncrease)
+	return cPrevious + b.maxGrantIncrease
+}
@@ -0,0 +1,52 @@
+package breakwater
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Starts a new RTT so the next grant of each client is recalculated
+func newRTT(bw *Breakwater) {
+	<-bw.rttLock
+	bw.setLastUpdate(time.Now().Add(time.Millisecond))
+	bw.rttLock <- 1
+	time.Sleep(2 * time.Millisecond)
+}
+
+func TestGrantIncreaseCappedPerRTT(t *testing.T) {
+	params := BWParametersDefault
+	params.MaxGrantIncrease = 10
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	id := registerWithCredits(bw, 1)
+	newRTT(bw)
+
+	if issued := bw.issueCredits(id, 500, BestEffort, 1); issued != 11 {
+		t.Errorf("Expected a grant of at most 10 credits more, got %d", issued)
+	}
+	// within the same RTT the grant only decays
+	if issued := bw.issueCredits(id, 500, BestEffort, 1); issued != 10 {
+		t.Errorf("Expected the grant to decay within the RTT, got %d", issued)
+	}
+	newRTT(bw)
+	if issued := bw.issueCredits(id, 500, BestEffort, 1); issued != 20 {
+		t.Errorf("Expected the grant to grow by 10 again in the next RTT, got %d", issued)
+	}
+}
+
+func TestGrantDecreaseNotCapped(t *testing.T) {
+	params := BWParametersDefault
+	params.MaxGrantIncrease = 10
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	id := registerWithCredits(bw, 200)
+	// the pool shrank well below what the client holds
+	bw.cTotal = 50
+	newRTT(bw)
+
+	c, _ := bw.clients.load(id)
+	if issued := bw.issueCredits(id, 1, BestEffort, 1); issued >= 200 || issued != atomic.LoadInt64(&c.issued) {
+		t.Errorf("Expected the grant to drop below 200 at once, got %d", issued)
+	}
+}
@@ -0,0 +1,48 @@
+package breakwater
+
+import (
+	"sync/atomic"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+/*
+Health service integration
+When the queueing delay measured at RTT updates stays above the AQM
+threshold for overloadWindows updates in a row, the instance drains: the
+service is set to NOT_SERVING on the health server, if one is set, and
+ReadinessHandler fails, so load balancers stop sending it traffic. It
+recovers once the delay has stayed under the threshold for as many
+updates. Called from rttUpdate, which holds the rtt lock.
+*/
+func (b *Breakwater) updateHealth(delay float64) {
+	if delay >= b.aqmDelay() {
+		b.overloadedWindows++
+		b.healthyWindows = 0
+	} else {
+		b.healthyWindows++
+		b.overloadedWindows = 0
+	}
+
+	draining := b.Draining()
+	if !draining && b.overloadedWindows >= b.overloadWindows {
+		atomic.StoreInt32(&b.draining, 1)
+		b.logger("[Health]:	Delay above AQM threshold for %d RTTs, draining", b.overloadedWindows)
+		if b.healthServer != nil {
+			b.healthServer.SetServingStatus(b.healthService, healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	} else if draining && b.healthyWindows >= b.overloadWindows {
+		atomic.StoreInt32(&b.draining, 0)
+		b.logger("[Health]:	Delay recovered for %d RTTs, serving again", b.healthyWindows)
+		if b.healthServer != nil {
+			b.healthServer.SetServingStatus(b.healthService, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+}
+
+/*
+Returns true while sustained overload drains the instance
+*/
+func (b *Breakwater) Draining() bool {
+	return atomic.LoadInt32(&b.draining) == 1
+}
@@ -0,0 +1,47 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatus(t *testing.T, s *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	resp, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	return resp.GetStatus()
+}
+
+func TestDrainWhenOverloaded(t *testing.T) {
+	params := BWParametersDefault
+	params.HealthServer = health.NewServer()
+	params.HealthService = "echo"
+	params.OverloadWindows = 2
+	params.HealthServer.SetServingStatus("echo", healthpb.HealthCheckResponse_SERVING)
+	bw := InitBreakwater(params)
+
+	overloaded, healthy := bw.aqmDelay()*2, bw.aqmDelay()/2
+	bw.updateHealth(overloaded)
+	bw.updateHealth(healthy)
+	bw.updateHealth(overloaded)
+	if status := servingStatus(t, params.HealthServer, "echo"); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected a single overloaded RTT not to drain, got %v", status)
+	}
+	bw.updateHealth(overloaded)
+	if status := servingStatus(t, params.HealthServer, "echo"); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected NOT_SERVING after consecutive overloaded RTTs, got %v", status)
+	}
+	if status := servingStatus(t, params.HealthServer, ""); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected other services to be unaffected, got %v", status)
+	}
+
+	bw.updateHealth(healthy)
+	bw.updateHealth(healthy)
+	if status := servingStatus(t, params.HealthServer, "echo"); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING after recovering, got %v", status)
+	}
+}
@@ -0,0 +1,90 @@
+package breakwater
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Actions of the controller at an RTT update
+*/
+const (
+	ActionIncrease = "increase"
+	ActionDecrease = "decrease"
+	ActionHold     = "hold"
+)
+
+/*
+What the controller saw and did at one RTT update
+*/
+type UpdateRecord struct {
+	Time       time.Time
+	Delay      float64 // queueing delay measured at the update in microseconds
+	CTotal     int64   // cTotal after the update
+	CIssued    int64   // credits issued to clients at the update
+	NumClients int64   // registered clients
+	Action     string  // ActionIncrease, ActionDecrease or ActionHold on cTotal
+}
+
+/*
+Ring buffer of the last RTT updates, so what the controller did during an
+incident can be reconstructed without external metrics
+*/
+type updateHistory struct {
+	mu      sync.Mutex
+	records []UpdateRecord
+	next    int  // index the next record is written to
+	full    bool // records has wrapped around
+}
+
+func newUpdateHistory(length int) *updateHistory {
+	return &updateHistory{records: make([]UpdateRecord, length)}
+}
+
+func (h *updateHistory) add(r UpdateRecord) {
+	h.mu.Lock()
+	h.records[h.next] = r
+	h.next = (h.next + 1) % len(h.records)
+	h.full = h.full || h.next == 0
+	h.mu.Unlock()
+}
+
+/*
+Returns the last RTT updates, oldest first. Empty unless HistoryLength is set.
+*/
+func (b *Breakwater) History() []UpdateRecord {
+	h := b.history
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]UpdateRecord(nil), h.records[:h.next]...)
+	}
+	return append(append([]UpdateRecord(nil), h.records[h.next:]...), h.records[:h.next]...)
+}
+
+/*
+Records an RTT update, called under the rtt lock
+*/
+func (b *Breakwater) recordUpdate(delay float64, prevCTotal int64, cIssued int64) {
+	if b.history == nil {
+		return
+	}
+	action := ActionHold
+	if b.cTotal > prevCTotal {
+		action = ActionIncrease
+	} else if b.cTotal < prevCTotal {
+		action = ActionDecrease
+	}
+	numClients := b.clients.len()
+	b.history.add(UpdateRecord{
+		Time:       b.lastUpdateTime,
+		Delay:      delay,
+		CTotal:     b.cTotal,
+		CIssued:    cIssued,
+		NumClients: numClients,
+		Action:     action,
+	})
+}
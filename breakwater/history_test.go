@@ -0,0 +1,52 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+// Runs an RTT update with the given delay
+func forceRTTUpdate(bw *Breakwater, delay float64) {
+	setDelay(bw, delay)
+	<-bw.rttLock
+	bw.setLastUpdate(time.Now().Add(-time.Second))
+	bw.rttLock <- 1
+	bw.rttUpdate()
+}
+
+func TestHistoryKeepsLastUpdates(t *testing.T) {
+	params := BWParametersDefault
+	params.HistoryLength = 3
+	bw := InitBreakwater(params)
+	if history := bw.History(); len(history) != 0 {
+		t.Fatalf("Expected no history before any update, got %v", history)
+	}
+
+	delays := []float64{0, 100 * bw.thresholdDelay(), 0, 100 * bw.thresholdDelay()}
+	for _, delay := range delays {
+		forceRTTUpdate(bw, delay)
+	}
+	history := bw.History()
+	if len(history) != 3 {
+		t.Fatalf("Expected the last 3 updates, got %d", len(history))
+	}
+	for i, action := range []string{ActionDecrease, ActionIncrease, ActionDecrease} {
+		if history[i].Action != action {
+			t.Errorf("Expected update %d to be an %s, got %s", i, action, history[i].Action)
+		}
+		if history[i].Delay != delays[i+1] {
+			t.Errorf("Expected update %d to record a delay of %f, got %f", i, delays[i+1], history[i].Delay)
+		}
+	}
+	if last := history[2]; last.CTotal != bw.cTotal || last.Time.Before(history[1].Time) {
+		t.Errorf("Expected the newest update last, got %+v", last)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	forceRTTUpdate(bw, 0)
+	if history := bw.History(); history != nil {
+		t.Errorf("Expected no history without HistoryLength, got %v", history)
+	}
+}
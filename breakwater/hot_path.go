@@ -0,0 +1,58 @@
+package breakwater
+
+import (
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Allocation-free request path
+Every request runs through Admit and Done, so their allocations are kept
+to the ones gRPC's API forces: the Admission itself, the copy of the
+incoming metadata and the response header. Debug lines on the request
+path are guarded by the verbose flag, since boxing their arguments allocates even
+when nothing is logged; counts are formatted from a table and the client id once; the
+response header is built in place rather than joined from several; and
+the RTT update goroutine is only started when an update is due.
+BenchmarkAdmit and its siblings report allocations, and
+TestHotPathAllocations fails when they grow.
+*/
+
+// formatted small counts, most grants and demands fall in this range
+var countStrings [1024]string
+
+func init() {
+	for i := range countStrings {
+		countStrings[i] = strconv.Itoa(i)
+	}
+}
+
+func formatCount(n int64) string {
+	if n >= 0 && n < int64(len(countStrings)) {
+		return countStrings[n]
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+/*
+Joins headers like metadata.Join, but reuses the first non-empty one
+instead of copying them all into a new map. Only for headers built for
+the response being joined.
+*/
+func joinHeaders(mds ...metadata.MD) metadata.MD {
+	var out metadata.MD
+	for _, md := range mds {
+		if len(md) == 0 {
+			continue
+		}
+		if out == nil {
+			out = md
+			continue
+		}
+		for key, values := range md {
+			out[key] = append(out[key], values...)
+		}
+	}
+	return out
+}
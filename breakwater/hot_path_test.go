@@ -0,0 +1,96 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Accepts and drops headers, so the interceptor sets them as in a server
+without counting the transport's allocations
+*/
+type discardStream struct{}
+
+func (discardStream) Method() string                  { return "/test.Service/Echo" }
+func (discardStream) SetHeader(md metadata.MD) error  { return nil }
+func (discardStream) SendHeader(md metadata.MD) error { return nil }
+func (discardStream) SetTrailer(md metadata.MD) error { return nil }
+
+func hotPathServer() (*Breakwater, context.Context) {
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+	return bw, grpc.NewContextWithServerTransportStream(ctx, discardStream{})
+}
+
+func admitOnce(bw *Breakwater, ctx context.Context) {
+	a, _ := bw.Admit(ctx)
+	a.Done()
+}
+
+func interceptOnce(bw *Breakwater, ctx context.Context) {
+	bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Echo"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+}
+
+func invokeOnce(bw *Breakwater, ctx context.Context) {
+	header := metadata.Pairs("credits", "10")
+	bw.Invoke(ctx, "server-a", func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		return header, nil, nil
+	})
+}
+
+func BenchmarkAdmit(b *testing.B) {
+	bw, ctx := hotPathServer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		admitOnce(bw, ctx)
+	}
+}
+
+func BenchmarkUnaryInterceptor(b *testing.B) {
+	bw, ctx := hotPathServer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		interceptOnce(bw, ctx)
+	}
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	bw := InitBreakwater(BWParametersDefault)
+	ctx := context.Background()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		invokeOnce(bw, ctx)
+	}
+}
+
+/*
+Fails when the request path allocates more than it did when it was last
+trimmed. The remaining allocations are the Admission, the copy of the
+incoming metadata, the response header and the outgoing metadata.
+*/
+func TestHotPathAllocations(t *testing.T) {
+	bw, ctx := hotPathServer()
+	client := InitBreakwater(BWParametersDefault)
+	for _, tc := range []struct {
+		name string
+		max  float64
+		run  func()
+	}{
+		{"Admit", 10, func() { admitOnce(bw, ctx) }},
+		{"UnaryInterceptor", 12, func() { interceptOnce(bw, ctx) }},
+		{"Invoke", 7, func() { invokeOnce(client, context.Background()) }},
+	} {
+		if allocs := testing.AllocsPerRun(100, tc.run); allocs > tc.max {
+			t.Errorf("%s allocates %v times per request, up from %v", tc.name, allocs, tc.max)
+		}
+	}
+}
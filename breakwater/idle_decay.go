@@ -0,0 +1,51 @@
+package breakwater
+
+import (
+	"math"
+	"time"
+)
+
+/*
+Idle decay of the credit pool
+cTotal is only updated by requests, so when traffic stops it keeps its last
+value, which may be far from what the server can handle by the time the
+next burst arrives. With an idle half-life set, a timer moves cTotal back
+towards InitialCredits while no RTT update has run for two update
+intervals, halving the distance every half-life, until Close.
+*/
+func (b *Breakwater) decayIdlePool() {
+	tick := max(b.idleHalfLife/10, b.getRTT())
+	ticker := time.NewTicker(time.Duration(tick) * time.Microsecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			b.decayIdleTotal(now)
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *Breakwater) decayIdleTotal(now time.Time) {
+	if !b.isRTTUnlocked() {
+		return
+	}
+	defer func() { b.rttLock <- 1 }()
+
+	idleSince := b.lastUpdateTime
+	if now.Sub(idleSince).Microseconds() < 2*b.updateInterval() {
+		return
+	}
+	if b.lastIdleDecay.After(idleSince) {
+		idleSince = b.lastIdleDecay
+	}
+	halfLives := float64(now.Sub(idleSince).Microseconds()) / float64(b.idleHalfLife)
+	prevCTotal := b.cTotal
+	initial := float64(b.initialCredits)
+	b.setCredits(initial + (b.exactCredits()-initial)*math.Exp2(-halfLives))
+	b.lastIdleDecay = now
+	if b.cTotal != prevCTotal {
+		b.logger("[Idle Decay]:	cTotal %d -> %d", prevCTotal, b.cTotal)
+	}
+}
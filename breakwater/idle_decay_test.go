@@ -0,0 +1,60 @@
+package breakwater
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestIdlePoolDecays(t *testing.T) {
+	params := BWParametersDefault
+	params.IdleHalfLife = 1000000
+	bw := InitBreakwater(params)
+	now := time.Now()
+	bw.setLastUpdate(now.Add(-time.Second))
+	bw.cTotal = 5000
+
+	// one half-life since the last update
+	bw.decayIdleTotal(now)
+	if bw.cTotal != 3000 {
+		t.Errorf("Expected cTotal halfway back to 1000, got %d", bw.cTotal)
+	}
+	// the next step only decays the time since the previous one
+	bw.decayIdleTotal(now.Add(time.Second))
+	if bw.cTotal != 2000 {
+		t.Errorf("Expected cTotal to keep decaying, got %d", bw.cTotal)
+	}
+
+	// a shrunken pool grows back towards InitialCredits too
+	bw.cTotal = 200
+	bw.decayIdleTotal(now.Add(2 * time.Second))
+	if bw.cTotal != 600 {
+		t.Errorf("Expected a small pool to grow halfway back to 1000, got %d", bw.cTotal)
+	}
+}
+
+func TestActivePoolDoesNotDecay(t *testing.T) {
+	params := BWParametersDefault
+	params.IdleHalfLife = 1000000
+	bw := InitBreakwater(params)
+	now := time.Now()
+	bw.setLastUpdate(now)
+	bw.cTotal = 5000
+	bw.decayIdleTotal(now.Add(time.Millisecond))
+	if bw.cTotal != 5000 {
+		t.Errorf("Expected cTotal to be kept while updates run, got %d", bw.cTotal)
+	}
+}
+
+func TestCloseStopsIdleDecay(t *testing.T) {
+	before := runtime.NumGoroutine()
+	params := BWParametersDefault
+	params.StallTimeoutRTTs = -1
+	params.IdleHalfLife = 1000000
+	bw := InitBreakwater(params)
+	if runtime.NumGoroutine() <= before {
+		t.Fatalf("Expected the idle decay timer to be running")
+	}
+	bw.Close()
+	expectGoroutinesExit(t, before)
+}
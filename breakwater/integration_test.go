@@ -0,0 +1,172 @@
+package breakwater
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/*
+A server running UnaryInterceptor and clients each running their own
+UnaryInterceptorClient, connected over bufconn. Only requests breakwater
+admits reach the handler, where they are counted.
+*/
+type integration struct {
+	server   *Breakwater
+	queue    *AppQueue // drives the server's delay signal
+	clients  []*Breakwater
+	conns    []*grpc.ClientConn
+	admitted int64
+}
+
+func startIntegration(t *testing.T, clientParams BWParameters, numClients int) *integration {
+	it := &integration{}
+	params := BWParametersDefault
+	params.ServerSide = true
+	// without a stats handler no per-RPC delay is recorded, so the
+	// application queue is the whole delay signal
+	params.DelaySignal = DelaySignalRPC
+	it.server = InitBreakwater(params)
+	it.queue = it.server.RegisterAppQueue("test", 0)
+
+	counter := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt64(&it.admitted, 1)
+		return handler(ctx, req)
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(it.server.UnaryInterceptor, counter))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	for i := 0; i < numClients; i++ {
+		client := InitBreakwater(clientParams)
+		cc, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(client.UnaryInterceptorClient))
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		t.Cleanup(func() { cc.Close() })
+		// clients start with a single credit, so a burst before the first
+		// grant would wait for it and could expire
+		if _, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("First request failed: %v", err)
+		}
+		it.clients = append(it.clients, client)
+		it.conns = append(it.conns, cc)
+	}
+	return it
+}
+
+/*
+Sends requests from every client on several goroutines each, and returns
+how many succeeded and how many were shed, by the client or the server
+*/
+func (it *integration) drive(t *testing.T, perClient int) (succeeded int64, shed int64) {
+	var wg sync.WaitGroup
+	for _, cc := range it.conns {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(hc healthpb.HealthClient) {
+				defer wg.Done()
+				for i := 0; i < perClient/4; i++ {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					_, err := hc.Check(ctx, &healthpb.HealthCheckRequest{})
+					cancel()
+					switch {
+					case err == nil:
+						atomic.AddInt64(&succeeded, 1)
+					case status.Code(err) == codes.ResourceExhausted:
+						atomic.AddInt64(&shed, 1)
+					default:
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}
+			}(healthpb.NewHealthClient(cc))
+		}
+	}
+	wg.Wait()
+	return succeeded, shed
+}
+
+func TestIntegrationUnderCapacity(t *testing.T) {
+	clientParams := BWParametersDefault
+	// requests wait for the next grant rather than expiring after 1ms, so
+	// nothing is shed while the server keeps up
+	clientParams.ClientExpiration = 1000000
+	it := startIntegration(t, clientParams, 4)
+	succeeded, shed := it.drive(t, 40)
+	if succeeded != 160 || shed != 0 {
+		t.Fatalf("Expected all 160 requests to be admitted, got %d succeeded and %d shed", succeeded, shed)
+	}
+	if admitted := atomic.LoadInt64(&it.admitted) - 4; admitted != succeeded {
+		t.Errorf("Expected the handler to see every admitted request, got %d of %d", admitted, succeeded)
+	}
+
+	snapshot := it.server.Snapshot()
+	if snapshot.NumClients != 4 {
+		t.Errorf("Expected the server to register 4 clients, got %d", snapshot.NumClients)
+	}
+	// every client is left holding at least one credit, and requests never
+	// take cIssued past cTotal by more than those
+	if snapshot.CIssued < 4 || snapshot.CIssued > snapshot.CTotal+snapshot.NumClients {
+		t.Errorf("Expected cIssued between 4 and cTotal %d + 4, got %d", snapshot.CTotal, snapshot.CIssued)
+	}
+	for i, client := range it.clients {
+		target := client.getTarget(it.conns[i].Target())
+		credits := <-target.outgoingCredits
+		target.outgoingCredits <- credits
+		if credits < 1 {
+			t.Errorf("Expected client %d to hold credits, got %d", i, credits)
+		}
+	}
+}
+
+func TestIntegrationShedsUnderOverload(t *testing.T) {
+	it := startIntegration(t, BWParametersDefault, 4)
+	it.drive(t, 8)
+	initial := it.server.Snapshot().CTotal
+
+	it.queue.Report(0, 100*time.Millisecond)
+	// let an RTT pass so the next request triggers an update that reads the delay
+	time.Sleep(2 * time.Duration(it.server.getRTT()) * time.Microsecond)
+	it.drive(t, 4)
+	time.Sleep(2 * time.Duration(it.server.getRTT()) * time.Microsecond)
+	admittedBefore := atomic.LoadInt64(&it.admitted)
+	succeeded, shed := it.drive(t, 40)
+	if shed == 0 {
+		t.Errorf("Expected requests to be shed under overload, got %d succeeded", succeeded)
+	}
+	if admitted := atomic.LoadInt64(&it.admitted) - admittedBefore; admitted != succeeded {
+		t.Errorf("Expected only the %d successful requests to reach the handler, got %d", succeeded, admitted)
+	}
+	if cTotal := it.server.Snapshot().CTotal; cTotal >= initial {
+		t.Errorf("Expected cTotal to shrink from %d under overload, got %d", initial, cTotal)
+	}
+
+	// once the backlog clears, requests are admitted again
+	it.queue.Report(0, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		time.Sleep(2 * time.Duration(it.server.getRTT()) * time.Microsecond)
+		if succeeded, shed := it.drive(t, 4); shed == 0 && succeeded == 16 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected requests to be admitted after the overload, still shedding")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package breakwater
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type interceptedKey struct{}
+
+/*
+Installs the breakwater interceptor ahead of others, so requests are shed
+before authentication, deserialization-heavy validation or any other
+costly middleware runs on them:
+
+	s := grpc.NewServer(breakwater.ChainUnaryServer(b, authInterceptor, validateInterceptor))
+*/
+func ChainUnaryServer(b *Breakwater, others ...grpc.UnaryServerInterceptor) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(unaryChain(nil, b, others)...)
+}
+
+/*
+Like ChainUnaryServer, with a panic recovery interceptor running ahead of
+breakwater so a panic anywhere in the chain is caught. RecoverPanics only
+covers the handler and the interceptors after breakwater.
+*/
+func ChainUnaryServerWithRecovery(recovery grpc.UnaryServerInterceptor, b *Breakwater, others ...grpc.UnaryServerInterceptor) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(unaryChain(recovery, b, others)...)
+}
+
+func unaryChain(recovery grpc.UnaryServerInterceptor, b *Breakwater, others []grpc.UnaryServerInterceptor) []grpc.UnaryServerInterceptor {
+	chain := make([]grpc.UnaryServerInterceptor, 0, len(others)+2)
+	if recovery != nil {
+		chain = append(chain, recovery)
+	}
+	chain = append(chain, b.UnaryInterceptor)
+	for _, i := range others {
+		if i != nil {
+			chain = append(chain, i)
+		}
+	}
+	return chain
+}
+
+/*
+Returns true if a breakwater interceptor already admitted the request, as
+when the interceptor is installed twice on one server. Admitting it again
+would spend and issue credits twice for one request, so the later
+interceptor passes it straight through and reports the misconfiguration
+once.
+*/
+func (b *Breakwater) interceptedTwice(ctx context.Context) bool {
+	if !intercepted(ctx) {
+		return false
+	}
+	b.interceptedTwiceOnce.Do(func() {
+		alert("[Interceptor]:	breakwater interceptor installed twice on one server, only the first one admits requests")
+	})
+	return true
+}
+
+/*
+Marks a request admitted by a breakwater interceptor, which can also
+degrade it or replay the response of a duplicate
+*/
+func withIntercepted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, interceptedKey{}, true)
+}
+
+func intercepted(ctx context.Context) bool {
+	marked, _ := ctx.Value(interceptedKey{}).(bool)
+	return marked
+}
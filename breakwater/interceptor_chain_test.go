@@ -0,0 +1,78 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInterceptorInstalledTwice(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	id := uuid.New()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", id.String()))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Echo"}
+
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return "ok", nil
+	}
+	inner := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return bw.UnaryInterceptor(ctx, req, info, handler)
+	}
+	if resp, err := bw.UnaryInterceptor(ctx, nil, info, inner); err != nil || resp != "ok" {
+		t.Fatalf("Expected the request to be served, got %v, %v", resp, err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", calls)
+	}
+	if n := bw.clients.len(); n != 1 {
+		t.Errorf("Expected the client to be registered once, got %d clients", n)
+	}
+}
+
+func TestUnaryChainOrder(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	var order []string
+	named := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if admittedByBreakwater(ctx) {
+				name += " after breakwater"
+			}
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	chain := unaryChain(named("recovery"), bw, []grpc.UnaryServerInterceptor{named("auth"), nil, named("validate")})
+	if len(chain) != 4 {
+		t.Fatalf("Expected recovery, breakwater, auth and validate, got %d interceptors", len(chain))
+	}
+
+	// run the chain the way grpc.ChainUnaryInterceptor does
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Echo"}
+	var next func(i int) grpc.UnaryHandler
+	next = func(i int) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			if i == len(chain) {
+				return "ok", nil
+			}
+			return chain[i](ctx, req, info, next(i+1))
+		}
+	}
+	if _, err := next(0)(ctx, nil); err != nil {
+		t.Fatalf("Expected the request to be served, got %v", err)
+	}
+	if len(order) != 3 || order[0] != "recovery" || order[1] != "auth after breakwater" || order[2] != "validate after breakwater" {
+		t.Errorf("Unexpected order %v", order)
+	}
+}
+
+func admittedByBreakwater(ctx context.Context) bool {
+	return intercepted(ctx)
+}
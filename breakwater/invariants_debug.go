@@ -0,0 +1,6 @@
+//go:build bwdebug
+
+package breakwater
+
+// Built with the bwdebug tag, the credit invariant checker runs by default
+const debugBuild = true
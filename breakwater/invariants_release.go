@@ -0,0 +1,5 @@
+//go:build !bwdebug
+
+package breakwater
+
+const debugBuild = false
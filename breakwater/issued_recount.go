@@ -0,0 +1,76 @@
+package breakwater
+
+import (
+	"sync/atomic"
+)
+
+/*
+Incremental cIssued
+Grants, revocations, lease reclaims and evictions adjust cIssued as they
+change the credits of a connection, so an RTT update takes the counter as
+it stands instead of summing every connection while it holds the rtt lock,
+which costs O(clients) every RTT. Every recountRTTs updates, recountIssued
+still recounts the connections, so an update lost to a race is bounded in
+time. Called from rttUpdate, which holds the rtt lock; returns whether
+this update is due a recount.
+*/
+func (b *Breakwater) issuedAtUpdate() (int64, bool) {
+	b.rttUpdates++
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued
+	return cIssued, b.recountRTTs <= 1 || b.rttUpdates%b.recountRTTs == 0
+}
+
+/*
+Recounts the credits issued to every connection once the RTT update has
+released the rtt lock, holding the cIssued token for the whole scan as
+reconcileCredits does, so the counter cannot move while the connections
+are summed. A grant updates its connection before it takes the token, so
+a divergence seen once may be a grant in flight: the drift is only
+checked against MaxAccountingDrift, and the counter resynced to the
+recount, once two recounts in a row see the same divergence. An update
+that finds a recount still running skips its own.
+*/
+func (b *Breakwater) recountIssued() {
+	if !atomic.CompareAndSwapInt32(&b.recounting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&b.recounting, 0)
+	counted := <-b.cIssued
+	var recounted int64 = 0
+	b.clients.rangeClients(func(c *Connection) bool {
+		recounted += atomic.LoadInt64(&c.issued)
+		return true
+	})
+	divergence := counted - recounted
+	if divergence == 0 || divergence != b.recountDivergence {
+		b.recountDivergence = divergence
+		b.cIssued <- counted
+		return
+	}
+	b.recountDivergence = 0
+	b.cIssued <- recounted
+	b.checkAccountingDrift(counted, recounted)
+}
+
+/*
+Keeps the sum and count of client RTTs as a client's smoothed RTT changes
+from before to after, 0 meaning none, so the mean needs no scan either
+*/
+func (b *Breakwater) trackClientRTT(before, after int64) {
+	atomic.AddInt64(&b.rttSum, after-before)
+	if before == 0 && after > 0 {
+		atomic.AddInt64(&b.rttClients, 1)
+	} else if before > 0 && after == 0 {
+		atomic.AddInt64(&b.rttClients, -1)
+	}
+}
+
+/*
+Sets the RTT update interval to the mean RTT clients reported, if any
+*/
+func (b *Breakwater) updateMeasuredRTT() {
+	if n := atomic.LoadInt64(&b.rttClients); n > 0 {
+		atomic.StoreInt64(&b.measuredRTT, atomic.LoadInt64(&b.rttSum)/n)
+	}
+}
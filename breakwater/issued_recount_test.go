@@ -0,0 +1,98 @@
+package breakwater
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestIssuedRecountedEveryRecountRTTs(t *testing.T) {
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	params.RecountRTTs = 3
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	id := registerWithCredits(bw, 5)
+
+	// an update to the connection the counter missed
+	c, _ := bw.clients.load(id)
+	atomic.StoreInt64(&c.issued, 8)
+	// the first recount at the third update only suspects the divergence
+	for i := 1; i < 6; i++ {
+		bw.UpdateCredits()
+		if issued := bw.Snapshot().CIssued; issued != 5 {
+			t.Fatalf("Expected update %d to keep the counter at 5, got %d", i, issued)
+		}
+	}
+	bw.UpdateCredits()
+	if issued := bw.Snapshot().CIssued; issued != 8 {
+		t.Errorf("Expected the second recount at the sixth update to resync the counter to 8, got %d", issued)
+	}
+}
+
+func TestMeasuredRTTWithoutScan(t *testing.T) {
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	bw.setClientRTT(uuid.New(), 1000)
+	bw.setClientRTT(uuid.New(), 3000)
+	bw.UpdateCredits()
+	if rtt := bw.updateInterval(); rtt != 2000 {
+		t.Errorf("Expected the mean client RTT of 2000us, got %d", rtt)
+	}
+
+	bw.clientIdleTimeout = 1
+	bw.clients.rangeClients(func(c *Connection) bool {
+		atomic.StoreInt64(&c.lastSeen, 0)
+		return true
+	})
+	bw.evictIdleClients()
+	if n, sum := atomic.LoadInt64(&bw.rttClients), atomic.LoadInt64(&bw.rttSum); n != 0 || sum != 0 {
+		t.Errorf("Expected evicted clients to leave the RTT mean, %d still counted with %dus", n, sum)
+	}
+}
+
+/*
+RTT updates with 50k registered clients, recounting cIssued at every
+update against the default incremental counter
+*/
+func BenchmarkRTTUpdate(b *testing.B) {
+	for _, recount := range []int64{1, BWParametersDefault.RecountRTTs} {
+		b.Run(fmt.Sprintf("RecountRTTs=%d", recount), func(b *testing.B) {
+			params := BWParametersDefault
+			params.ManualUpdates = true
+			params.RecountRTTs = recount
+			bw := InitBreakwater(params)
+			setDelay(bw, 0)
+			for i := 0; i < 50000; i++ {
+				registerWithCredits(bw, 1)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bw.UpdateCredits()
+			}
+		})
+	}
+}
+
+func TestRecountKeepsGrantInFlight(t *testing.T) {
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	id := registerWithCredits(bw, 5)
+
+	// a grant has updated its connection but not yet the counter
+	c, _ := bw.clients.load(id)
+	atomic.StoreInt64(&c.issued, 7)
+	bw.recountIssued()
+	cIssued := <-bw.cIssued
+	bw.cIssued <- cIssued + 2
+	bw.recountIssued()
+	if issued := bw.Snapshot().CIssued; issued != 7 {
+		t.Errorf("Expected the grant to be counted once, got %d", issued)
+	}
+}
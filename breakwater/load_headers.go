@@ -0,0 +1,94 @@
+package breakwater
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Headers carrying the server's load with loadHeaders set
+const (
+	saturationKey = "credit-saturation" // cIssued as a fraction of cTotal
+	delayRatioKey = "delay-ratio"       // queueing delay as a fraction of the SLO
+)
+
+/*
+Load a server reported in its response headers
+*/
+type ServerLoad struct {
+	CreditSaturation float64 // cIssued as a fraction of cTotal, over 1 once the server overcommitted
+	DelayRatio       float64 // queueing delay as a fraction of the SLO, requests are shed past 2 * DELAY_THRESHOLD_PERCENT
+}
+
+/*
+Load headers
+A credit grant only tells a client how much it may send now. With
+loadHeaders set, every response, shed ones included, also carries the
+server's credit saturation and its queueing delay relative to the SLO, the
+same values reported over ORCA, so clients and proxies can slow down while
+the server approaches overload rather than after it starts shedding.
+*/
+func (b *Breakwater) loadHeader() metadata.MD {
+	if !b.loadHeaders {
+		return nil
+	}
+	header := metadata.Pairs(saturationKey, strconv.FormatFloat(b.creditSaturation(), 'f', 3, 64))
+	if b.loadShedding {
+		header.Set(delayRatioKey, strconv.FormatFloat(b.readQueueingDelay()/float64(atomic.LoadInt64(&b.SLO)), 'f', 3, 64))
+	}
+	return header
+}
+
+/*
+Reads the load a server reported in a response header, false if it
+reported none
+*/
+func LoadFromHeader(header metadata.MD) (ServerLoad, bool) {
+	saturation := header.Get(saturationKey)
+	if len(saturation) == 0 {
+		return ServerLoad{}, false
+	}
+	var load ServerLoad
+	var err error
+	if load.CreditSaturation, err = strconv.ParseFloat(saturation[0], 64); err != nil {
+		return ServerLoad{}, false
+	}
+	if delay := header.Get(delayRatioKey); len(delay) > 0 {
+		load.DelayRatio, _ = strconv.ParseFloat(delay[0], 64)
+	}
+	return load, true
+}
+
+/*
+Client side: keeps the last load the target reported
+*/
+func (t *clientTarget) recordLoad(header metadata.MD) {
+	load, ok := LoadFromHeader(header)
+	if !ok {
+		return
+	}
+	atomic.StoreUint64(&t.saturation, math.Float64bits(load.CreditSaturation))
+	atomic.StoreUint64(&t.delayRatio, math.Float64bits(load.DelayRatio))
+	atomic.StoreInt32(&t.loadReported, 1)
+}
+
+/*
+Returns the load the target last reported in its response headers, false
+if it never did
+*/
+func (b *Breakwater) TargetLoad(target string) (ServerLoad, bool) {
+	value, ok := b.targets.Load(target)
+	if !ok {
+		return ServerLoad{}, false
+	}
+	t := value.(*clientTarget)
+	if atomic.LoadInt32(&t.loadReported) == 0 {
+		return ServerLoad{}, false
+	}
+	return ServerLoad{
+		CreditSaturation: math.Float64frombits(atomic.LoadUint64(&t.saturation)),
+		DelayRatio:       math.Float64frombits(atomic.LoadUint64(&t.delayRatio)),
+	}, true
+}
@@ -0,0 +1,62 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLoadHeadersOnEveryResponse(t *testing.T) {
+	params := BWParametersDefault
+	params.LoadHeaders = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	md := metadata.Pairs("demand", "10", "id", uuid.New().String())
+
+	a, err := bw.Admit(metadata.NewIncomingContext(context.Background(), md))
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+	load, ok := LoadFromHeader(a.Header)
+	if !ok || load.CreditSaturation <= 0 || load.DelayRatio != 0 {
+		t.Errorf("Expected the credits just issued and no delay, got %+v", load)
+	}
+
+	// far beyond the AQM threshold
+	forceRTTUpdate(bw, 10*bw.aqmDelay())
+	a, err = bw.Admit(metadata.NewIncomingContext(context.Background(), md))
+	if err == nil {
+		t.Fatalf("Expected the request to be shed")
+	}
+	if load, ok := LoadFromHeader(a.Header); !ok || load.DelayRatio <= 1 {
+		t.Errorf("Expected the shed response to report the delay past the SLO, got %+v", load)
+	}
+}
+
+func TestClientKeepsTargetLoad(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	if _, ok := bw.TargetLoad("server"); ok {
+		t.Errorf("Expected no load before the target reported one")
+	}
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		return metadata.Pairs("credits", "5", saturationKey, "0.900", delayRatioKey, "0.500"), nil, nil
+	}
+	if err := bw.Invoke(context.Background(), "server", send); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if load, ok := bw.TargetLoad("server"); !ok || load.CreditSaturation != 0.9 || load.DelayRatio != 0.5 {
+		t.Errorf("Expected the reported load, got %+v", load)
+	}
+}
+
+func TestNoLoadHeadersByDefault(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	a, _ := bw.Admit(metadata.NewIncomingContext(context.Background(), md))
+	if _, ok := LoadFromHeader(a.Header); ok {
+		t.Errorf("Expected no load headers unless LoadHeaders is set")
+	}
+}
@@ -0,0 +1,208 @@
+package breakwater
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Logger receives the log lines of every Breakwater in the process. Debugf
+lines are only written with Verbose set and many come from the request
+path, so loggers should drop them cheaply; see SampledLogger.
+*/
+type Logger interface {
+	Debugf(format string, a ...interface{})
+	Errorf(format string, a ...interface{})
+}
+
+type loggerHolder struct{ Logger }
+
+var logOutput atomic.Value // loggerHolder, stdoutLogger until SetLogger
+
+/*
+Sends the log lines of every Breakwater in the process to l, nil restores
+the default of printing them to stdout
+*/
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdoutLogger{}
+	}
+	logOutput.Store(loggerHolder{l})
+}
+
+func currentLogger() Logger {
+	if h, ok := logOutput.Load().(loggerHolder); ok {
+		return h.Logger
+	}
+	return stdoutLogger{}
+}
+
+/*
+Default logger, printing to stdout with a timestamp
+*/
+type stdoutLogger struct{}
+
+func (stdoutLogger) Debugf(format string, a ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02T15:04:05.999999999-07:00")
+	fmt.Printf("LOG: "+timestamp+"|\t"+format+"\n", a...)
+}
+
+func (stdoutLogger) Errorf(format string, a ...interface{}) {
+	timestamp := time.Now().Format("2006-01-02T15:04:05.999999999-07:00")
+	fmt.Printf("CRITICAL: "+timestamp+"|\t"+format+"\n", a...)
+}
+
+/*
+Sampling of debug lines by SampledLogger: in every Tick, the first First
+lines of each format are written, then every Thereafter-th. Thereafter 0
+drops the rest. Admission decisions are sampled one in AdmitEvery instead
+when it is above 1, and RateLimit caps the debug lines written each second
+on top of sampling. Shed decisions are always written.
+*/
+type LogSampling struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+	AdmitEvery int // write one in this many admission decisions, 0 or 1 writes them all
+	RateLimit  int // debug lines written per second at most, 0 for no limit
+}
+
+/*
+Debug lines written and suppressed by every SampledLogger in the process
+*/
+type LogCounts struct {
+	Written     int64 // debug lines passed on to the wrapped logger
+	Sampled     int64 // debug lines dropped by sampling
+	RateLimited int64 // debug lines dropped by the rate limit
+}
+
+var logWritten, logSampled, logRateLimited int64
+
+/*
+Counts of the debug lines written and suppressed so far, so dropped lines
+are visible even when the lines themselves are not
+*/
+func LogLineCounts() LogCounts {
+	return LogCounts{
+		Written:     atomic.LoadInt64(&logWritten),
+		Sampled:     atomic.LoadInt64(&logSampled),
+		RateLimited: atomic.LoadInt64(&logRateLimited),
+	}
+}
+
+type lineKind int
+
+const (
+	lineDebug lineKind = iota
+	lineAdmit          // a request let through, sampled by AdmitEvery
+	lineShed           // a request shed, never sampled nor rate limited
+)
+
+/*
+Implemented by sampledLogger, so decisions can be told apart from other
+debug lines
+*/
+type decisionLogger interface {
+	decisionf(kind lineKind, format string, a ...interface{})
+}
+
+/*
+Lines are counted without a lock shared by every caller: each format has
+its own counter, and the counters of a tick or a second hold the window's
+number in their high 32 bits and the lines counted in it in the low 32,
+so they start over with one compare-and-swap when the window moves on.
+*/
+type sampledLogger struct {
+	Logger
+	sampling LogSampling
+	epoch    time.Time // start of the first tick and the first second
+	counts   sync.Map  // *uint64 windowed counter of each format's lines in the current tick
+	admits   uint64    // admission decisions seen, accessed atomically
+	second   uint64    // windowed counter of the lines written in the current second, accessed atomically
+}
+
+/*
+Wraps l so its debug lines are sampled by the line that logs them, so hot
+paths do not flood the log. Errors are always written. A zero Tick
+disables sampling by line.
+*/
+func SampledLogger(l Logger, sampling LogSampling) Logger {
+	return &sampledLogger{Logger: l, sampling: sampling, epoch: time.Now()}
+}
+
+func (s *sampledLogger) Debugf(format string, a ...interface{}) {
+	if s.sample(lineDebug, format, time.Now()) {
+		s.Logger.Debugf(format, a...)
+	}
+}
+
+func (s *sampledLogger) decisionf(kind lineKind, format string, a ...interface{}) {
+	if s.sample(kind, format, time.Now()) {
+		s.Logger.Debugf(format, a...)
+	}
+}
+
+// Defined rather than promoted, so every line is as deep in the stack
+// for loggers that report their caller
+func (s *sampledLogger) Errorf(format string, a ...interface{}) {
+	s.Logger.Errorf(format, a...)
+}
+
+func (s *sampledLogger) sample(kind lineKind, format string, now time.Time) bool {
+	if kind == lineShed {
+		atomic.AddInt64(&logWritten, 1)
+		return true
+	}
+	if !s.sampleLine(kind, format, now) {
+		atomic.AddInt64(&logSampled, 1)
+		return false
+	}
+	if s.sampling.RateLimit > 0 {
+		second := uint64(now.Sub(s.epoch) / time.Second)
+		if countInWindow(&s.second, second) > uint64(s.sampling.RateLimit) {
+			atomic.AddInt64(&logRateLimited, 1)
+			return false
+		}
+	}
+	atomic.AddInt64(&logWritten, 1)
+	return true
+}
+
+func (s *sampledLogger) sampleLine(kind lineKind, format string, now time.Time) bool {
+	if kind == lineAdmit && s.sampling.AdmitEvery > 1 {
+		n := atomic.AddUint64(&s.admits, 1)
+		return (n-1)%uint64(s.sampling.AdmitEvery) == 0
+	}
+	if s.sampling.Tick <= 0 {
+		return true
+	}
+	counter, ok := s.counts.Load(format)
+	if !ok {
+		counter, _ = s.counts.LoadOrStore(format, new(uint64))
+	}
+	n := countInWindow(counter.(*uint64), uint64(now.Sub(s.epoch)/s.sampling.Tick))
+	if n <= uint64(s.sampling.First) {
+		return true
+	}
+	return s.sampling.Thereafter > 0 && (n-uint64(s.sampling.First))%uint64(s.sampling.Thereafter) == 0
+}
+
+/*
+Counts a line in window and returns its number within the window, starting
+the counter over if it still counts an earlier window
+*/
+func countInWindow(counter *uint64, window uint64) uint64 {
+	window &= 1<<32 - 1
+	for {
+		old := atomic.LoadUint64(counter)
+		next := window<<32 | 1
+		if old>>32 == window {
+			next = old + 1
+		}
+		if atomic.CompareAndSwapUint64(counter, old, next) {
+			return next & (1<<32 - 1)
+		}
+	}
+}
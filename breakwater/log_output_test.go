@@ -0,0 +1,132 @@
+package breakwater
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Debugf(format string, a ...interface{}) {
+	r.lines = append(r.lines, "debug: "+fmt.Sprintf(format, a...))
+}
+
+func (r *recordingLogger) Errorf(format string, a ...interface{}) {
+	r.lines = append(r.lines, "error: "+fmt.Sprintf(format, a...))
+}
+
+func TestSetLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	verbose(false).logger("dropped %d", 1)
+	alert("kept %d", 2)
+	verbose(true).logger("kept %d", 3)
+	if len(rec.lines) != 2 || rec.lines[0] != "error: kept 2" || rec.lines[1] != "debug: kept 3" {
+		t.Errorf("Expected the alert and the verbose line, got %q", rec.lines)
+	}
+}
+
+func TestSampledLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	l := SampledLogger(rec, LogSampling{Tick: time.Hour, First: 2, Thereafter: 3}).(*sampledLogger)
+	for i := 1; i <= 8; i++ {
+		l.Debugf("hot %d", i)
+	}
+	l.Debugf("cold")
+	l.Errorf("failure")
+
+	expected := []string{"debug: hot 1", "debug: hot 2", "debug: hot 5", "debug: hot 8", "debug: cold", "error: failure"}
+	if fmt.Sprint(rec.lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, rec.lines)
+	}
+
+	// a new tick starts over
+	l.epoch = l.epoch.Add(-l.sampling.Tick)
+	l.Debugf("hot %d", 9)
+	if last := rec.lines[len(rec.lines)-1]; last != "debug: hot 9" {
+		t.Errorf("Expected the first line of a new tick to be written, got %q", last)
+	}
+}
+
+func TestSampledLoggerDecisions(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(SampledLogger(rec, LogSampling{AdmitEvery: 3, RateLimit: 4}))
+	defer SetLogger(nil)
+	v := verbose(true)
+	before := LogLineCounts()
+
+	for i := 1; i <= 7; i++ {
+		v.logAdmit("admit %d", i)
+	}
+	if expected := []string{"debug: admit 1", "debug: admit 4", "debug: admit 7"}; fmt.Sprint(rec.lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected one in three admissions, got %q", rec.lines)
+	}
+
+	// one line left this second, then the rate limit drops the rest but sheds
+	v.logger("other %d", 1)
+	v.logger("other %d", 2)
+	v.logShed("shed %d", 1)
+	v.logShed("shed %d", 2)
+	expected := []string{"debug: admit 1", "debug: admit 4", "debug: admit 7", "debug: other 1", "debug: shed 1", "debug: shed 2"}
+	if fmt.Sprint(rec.lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, rec.lines)
+	}
+
+	after := LogLineCounts()
+	if written := after.Written - before.Written; written != 6 {
+		t.Errorf("Expected 6 lines written, got %d", written)
+	}
+	if sampled := after.Sampled - before.Sampled; sampled != 4 {
+		t.Errorf("Expected 4 lines sampled away, got %d", sampled)
+	}
+	if limited := after.RateLimited - before.RateLimited; limited != 1 {
+		t.Errorf("Expected 1 line rate limited, got %d", limited)
+	}
+}
+
+func TestDecisionsWithoutSampling(t *testing.T) {
+	rec := &recordingLogger{}
+	SetLogger(rec)
+	defer SetLogger(nil)
+
+	verbose(false).logShed("dropped")
+	verbose(true).logAdmit("admit")
+	verbose(true).logShed("shed")
+	if expected := []string{"debug: admit", "debug: shed"}; fmt.Sprint(rec.lines) != fmt.Sprint(expected) {
+		t.Errorf("Expected every decision, got %q", rec.lines)
+	}
+}
+
+type countingLogger struct {
+	lines int64
+}
+
+func (c *countingLogger) Debugf(format string, a ...interface{}) { atomic.AddInt64(&c.lines, 1) }
+func (c *countingLogger) Errorf(format string, a ...interface{}) { atomic.AddInt64(&c.lines, 1) }
+
+func TestSampledLoggerConcurrent(t *testing.T) {
+	rec := &countingLogger{}
+	l := SampledLogger(rec, LogSampling{Tick: time.Hour, First: 5, Thereafter: 10})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				l.Debugf("hot")
+			}
+		}()
+	}
+	wg.Wait()
+	// the first 5 of 8000 lines, then one in ten of the other 7995
+	if lines := atomic.LoadInt64(&rec.lines); lines != 5+799 {
+		t.Errorf("Expected %d lines, got %d", 5+799, lines)
+	}
+}
@@ -0,0 +1,101 @@
+package breakwater
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+/*
+Latency targets and shedding preference of a single method, so a fast
+lookup and a slow analytics call on the same server are not judged against
+one SLO, and reads are shed before writes.
+Zero thresholds are derived from SLO like the server-wide ones, and a
+method without an SLO or thresholds keeps the server-wide ones.
+*/
+type MethodSLO struct {
+	SLO            int64   // SLA in microseconds
+	ThresholdDelay float64 // delay threshold in microseconds, 0 for 40% of SLO
+	AQMDelay       float64 // AQM threshold in microseconds, 0 for twice ThresholdDelay
+	Idempotent     bool    // safe to retry on another replica, like most reads, so shed first
+}
+
+/*
+Queueing delay thresholds a request is judged against, in microseconds
+*/
+type delayThresholds struct {
+	threshold float64 // target delay, sets the suggested retry delay
+	aqm       float64 // requests are shed beyond this delay, scaled by class
+}
+
+/*
+Thresholds of a method with a MethodSLO. A zero threshold follows the
+server-wide one, read at every request so SetSLO and calibration apply.
+*/
+type methodSLOLimits struct {
+	delayThresholds
+	aqmFactor float64 // scales the AQM threshold, idempotentFactor for Idempotent methods
+}
+
+/*
+Thresholds of every method in slos. Those of Idempotent methods have their
+AQM threshold scaled by idempotentFactor, so they are shed earlier.
+*/
+func methodThresholds(slos map[string]MethodSLO, idempotentFactor float64) map[string]methodSLOLimits {
+	thresholds := make(map[string]methodSLOLimits, len(slos))
+	for method, slo := range slos {
+		t := methodSLOLimits{delayThresholds{threshold: slo.ThresholdDelay, aqm: slo.AQMDelay}, 1}
+		// without an SLO or thresholds of its own the method follows the server
+		if t.threshold <= 0 && slo.SLO > 0 {
+			t.threshold = float64(slo.SLO) * DELAY_THRESHOLD_PERCENT
+		}
+		if t.aqm <= 0 && t.threshold > 0 {
+			t.aqm = t.threshold * 2.0
+		}
+		if slo.Idempotent {
+			t.aqmFactor = idempotentFactor
+		}
+		thresholds[method] = t
+	}
+	return thresholds
+}
+
+/*
+Returns the thresholds of a method, the server-wide ones if it has no MethodSLO
+*/
+func (b *Breakwater) thresholdsFor(method string) delayThresholds {
+	m, ok := b.methodSLOs[method]
+	if !ok {
+		return delayThresholds{threshold: b.thresholdDelay(), aqm: b.aqmDelay()}
+	}
+	t := m.delayThresholds
+	if t.threshold <= 0 {
+		t.threshold = b.thresholdDelay()
+	}
+	if t.aqm <= 0 {
+		t.aqm = b.aqmDelay()
+	}
+	t.aqm *= m.aqmFactor
+	return t
+}
+
+type methodKey struct{}
+
+/*
+Sets the full method name ("/package.Service/Method") of a request whose
+transport is not gRPC, so its MethodSLO applies in Admit
+*/
+func ContextWithMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, methodKey{}, method)
+}
+
+/*
+Full method name of an incoming request, "" if unknown
+*/
+func methodOf(ctx context.Context) string {
+	if method, ok := ctx.Value(methodKey{}).(string); ok {
+		return method
+	}
+	method, _ := grpc.Method(ctx)
+	return method
+}
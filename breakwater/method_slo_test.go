@@ -0,0 +1,92 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestMethodThresholdsDerivedFromSLO(t *testing.T) {
+	thresholds := methodThresholds(map[string]MethodSLO{
+		"/test.Service/Lookup":    {SLO: 5000},
+		"/test.Service/Analytics": {SLO: 2000000, AQMDelay: 3000000},
+	}, 0.5)
+	if lookup := thresholds["/test.Service/Lookup"]; lookup.threshold != 2000 || lookup.aqm != 4000 {
+		t.Errorf("Expected thresholds of 2000 and 4000 us, got %+v", lookup)
+	}
+	if analytics := thresholds["/test.Service/Analytics"]; analytics.threshold != 800000 || analytics.aqm != 3000000 {
+		t.Errorf("Expected the AQM delay to be kept, got %+v", analytics)
+	}
+}
+
+func TestMethodSLOSheds(t *testing.T) {
+	params := BWParametersDefault
+	params.MethodSLOs = map[string]MethodSLO{"/test.Service/Analytics": {SLO: 2000000}}
+	bw := InitBreakwater(params)
+	// beyond the server-wide AQM threshold, well within the analytics one
+	bw.storeQueueingDelay(10 * bw.aqmDelay())
+
+	admit := func(method string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+		_, err := bw.Admit(ContextWithMethod(ctx, method))
+		return err
+	}
+	if err := admit("/test.Service/Lookup"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected a method without its own SLO to be shed, got %v", err)
+	}
+	if err := admit("/test.Service/Analytics"); err != nil {
+		t.Errorf("Expected the analytics method to be admitted under its SLO, got %v", err)
+	}
+}
+
+func TestIdempotentMethodsShedFirst(t *testing.T) {
+	params := BWParametersDefault
+	params.MethodSLOs = map[string]MethodSLO{
+		"/test.Service/Get":    {Idempotent: true},
+		"/test.Service/Lookup": {SLO: 5000, Idempotent: true},
+	}
+	params.IdempotentAQMFactor = 0.5
+	bw := InitBreakwater(params)
+	if get := bw.thresholdsFor("/test.Service/Get"); get.threshold != bw.thresholdDelay() || get.aqm != bw.aqmDelay()*0.5 {
+		t.Errorf("Expected a read without an SLO to keep the server-wide thresholds, shedding at half the AQM threshold, got %+v", get)
+	}
+	if lookup := bw.thresholdsFor("/test.Service/Lookup"); lookup.aqm != 2000 {
+		t.Errorf("Expected the read's own AQM threshold to be halved, got %+v", lookup)
+	}
+
+	// beyond the reads' AQM threshold, within the writes' one
+	bw.storeQueueingDelay(0.75 * bw.aqmDelay())
+	admit := func(method string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+		_, err := bw.Admit(ContextWithMethod(ctx, method))
+		return err
+	}
+	if err := admit("/test.Service/Get"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the idempotent read to be shed, got %v", err)
+	}
+	if err := admit("/test.Service/Put"); err != nil {
+		t.Errorf("Expected the write to be admitted, got %v", err)
+	}
+}
+
+func TestIdempotentMethodsFollowSLOChanges(t *testing.T) {
+	params := BWParametersDefault
+	params.MethodSLOs = map[string]MethodSLO{"/test.Service/Get": {Idempotent: true}}
+	params.IdempotentAQMFactor = 0.5
+	bw := InitBreakwater(params)
+
+	bw.SetSLO(2 * params.SLO)
+	if get := bw.thresholdsFor("/test.Service/Get"); get.threshold != bw.thresholdDelay() || get.aqm != bw.aqmDelay()*0.5 {
+		t.Errorf("Expected the read to follow the new server-wide thresholds, got %+v", get)
+	}
+	// the same delay sheds neither reads nor writes under the doubled SLO
+	bw.storeQueueingDelay(0.75 * float64(params.SLO) * DELAY_THRESHOLD_PERCENT * 2)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+	if _, err := bw.Admit(ContextWithMethod(ctx, "/test.Service/Get")); err != nil {
+		t.Errorf("Expected the read to be admitted under the new SLO, got %v", err)
+	}
+}
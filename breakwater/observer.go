@@ -0,0 +1,76 @@
+package breakwater
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Observer receives notifications about notable events inside breakwater.
+Every callback is optional, nil callbacks are skipped.
+Callbacks run on the request path, so they should return quickly.
+*/
+type Observer struct {
+	// Called when the safety valve switches breakwater to pass-through mode
+	OnAnomaly func(reason string)
+	// Called when an overload signal is unavailable on this platform and
+	// breakwater falls back to a less precise one
+	OnDegraded func(reason string)
+	// Called with the time each RPC waited between arriving on the wire and
+	// reaching the interceptor, if the stats handler is installed
+	OnQueueingDelay func(delay time.Duration)
+	// Called with the method and the value of a handler panic recovered
+	// with RecoverPanics
+	OnPanic func(method string, recovered interface{})
+	// Called when the credits counted in cIssued disagree with the credits
+	// issued to the connections
+	OnInvariantViolation func(reason string)
+	// Called with the context of a request whose trace should be kept,
+	// with TraceShed or TraceCreditWait, and why
+	OnForceSample func(ctx context.Context, reason string)
+	// Called with the reason of every request this Breakwater rejects,
+	// on the client or the server
+	OnReject func(reason RejectReason)
+}
+
+func (o *Observer) anomaly(reason string) {
+	if o != nil && o.OnAnomaly != nil {
+		o.OnAnomaly(reason)
+	}
+}
+
+func (o *Observer) degraded(reason string) {
+	if o != nil && o.OnDegraded != nil {
+		o.OnDegraded(reason)
+	}
+}
+
+func (o *Observer) queueingDelay(delay time.Duration) {
+	if o != nil && o.OnQueueingDelay != nil {
+		o.OnQueueingDelay(delay)
+	}
+}
+
+func (o *Observer) panicked(method string, recovered interface{}) {
+	if o != nil && o.OnPanic != nil {
+		o.OnPanic(method, recovered)
+	}
+}
+
+func (o *Observer) invariantViolation(reason string) {
+	if o != nil && o.OnInvariantViolation != nil {
+		o.OnInvariantViolation(reason)
+	}
+}
+
+func (o *Observer) forceSample(ctx context.Context, reason string) {
+	if o != nil && o.OnForceSample != nil {
+		o.OnForceSample(ctx, reason)
+	}
+}
+
+func (o *Observer) rejected(reason RejectReason) {
+	if o != nil && o.OnReject != nil {
+		o.OnReject(reason)
+	}
+}
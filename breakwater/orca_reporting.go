@@ -0,0 +1,50 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/orca"
+)
+
+/*
+Named utilization metrics reported to ORCA-aware load balancers
+*/
+const (
+	ORCAQueueingDelayMetric    = "breakwater.queueing_delay"    // queueing delay as a fraction of the SLO
+	ORCACreditSaturationMetric = "breakwater.credit_saturation" // cIssued as a fraction of cTotal
+)
+
+/*
+Records the server's current load in the ORCA per-call metrics of the
+response, shed responses included, so load balancers can steer traffic away
+before breakwater has to shed it.
+The server has to be set up with orca.CallMetricsServerOption(), registered
+before the breakwater interceptor so its recorder is in the context.
+*/
+func (b *Breakwater) reportLoad(ctx context.Context) {
+	if !b.orcaReporting {
+		return
+	}
+	recorder := orca.CallMetricRecorderFromContext(ctx)
+	if recorder == nil {
+		return
+	}
+	if b.loadShedding {
+		recorder.SetUtilization(ORCAQueueingDelayMetric, b.readQueueingDelay()/float64(atomic.LoadInt64(&b.SLO)))
+	}
+	recorder.SetUtilization(ORCACreditSaturationMetric, b.creditSaturation())
+}
+
+/*
+Fraction of cTotal currently issued to clients
+*/
+func (b *Breakwater) creditSaturation() float64 {
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued
+	cTotal := b.getCTotal()
+	if cTotal <= 0 {
+		return 1
+	}
+	return float64(cIssued) / float64(cTotal)
+}
@@ -0,0 +1,47 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/orca"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestORCALoadReport(t *testing.T) {
+	params := BWParametersDefault
+	params.ORCAReporting = true
+	bw := InitBreakwater(params)
+	bw.storeQueueingDelay(float64(bw.SLO) / 2)
+	bw.cTotal = 100
+	<-bw.cIssued
+	bw.cIssued <- 25
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(orca.CallMetricsServerOption(), grpc.ChainUnaryInterceptor(bw.UnaryInterceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc := dialBufconn(t, lis)
+
+	var trailer metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "demand", "0", "id", bw.id.String())
+	if _, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer)); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	report, err := orca.ToLoadReport(trailer)
+	if err != nil || report == nil {
+		t.Fatalf("Expected an ORCA load report, got %v", err)
+	}
+	if delay := report.GetUtilization()[ORCAQueueingDelayMetric]; delay != 0.5 {
+		t.Errorf("Expected queueing delay utilization 0.5, got %f", delay)
+	}
+	// the load is read before this request is issued credits
+	if saturation := report.GetUtilization()[ORCACreditSaturationMetric]; saturation != 0.25 {
+		t.Errorf("Expected credit saturation 0.25, got %f", saturation)
+	}
+}
@@ -0,0 +1,37 @@
+package breakwater
+
+import (
+	"context"
+	runtimedebug "runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errHandlerPanic = status.Error(codes.Internal, "internal error")
+
+/*
+Calls the handler of an admitted request. With BWParameters.RecoverPanics
+a panic in the handler is turned into a codes.Internal error, so the
+request is still finished like any failed one: Done runs, the grant made
+while it was in flight is sent, and the RTT update it triggers is not
+lost. Without it the panic goes on to the gRPC server, which crashes.
+*/
+func (b *Breakwater) callHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if !b.recoverPanics {
+		return handler(ctx, req)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			method := ""
+			if info != nil {
+				method = info.FullMethod
+			}
+			alert("[Handler Panic]:\t%s panicked: %v\n%s", method, r, runtimedebug.Stack())
+			b.observer.panicked(method, r)
+			resp, err = nil, errHandlerPanic
+		}
+	}()
+	return handler(ctx, req)
+}
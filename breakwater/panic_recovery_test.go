@@ -0,0 +1,50 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoverHandlerPanic(t *testing.T) {
+	var method string
+	var recovered interface{}
+	params := BWParametersDefault
+	params.RecoverPanics = true
+	params.Observer = &Observer{OnPanic: func(m string, r interface{}) { method, recovered = m, r }}
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Panic"}
+	panicking := func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") }
+	if _, err := bw.UnaryInterceptor(ctx, nil, info, panicking); status.Code(err) != codes.Internal {
+		t.Fatalf("Expected the panic to become an Internal error, got %v", err)
+	}
+	if method != info.FullMethod || recovered != "boom" {
+		t.Errorf("Expected the observer to see the panic, got %q and %v", method, recovered)
+	}
+
+	// the client's next request is admitted as usual
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	if resp, err := bw.UnaryInterceptor(ctx, nil, info, ok); err != nil || resp != "ok" {
+		t.Errorf("Expected the next request to succeed, got %v and %v", resp, err)
+	}
+}
+
+func TestPanicPassesThroughByDefault(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "1", "id", uuid.New().String()))
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Errorf("Expected the panic to reach the caller, got %v", r)
+		}
+	}()
+	bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") })
+}
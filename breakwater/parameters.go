@@ -0,0 +1,146 @@
+package breakwater
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Returns the parameters with every unset field taken from
+BWParametersDefault, so parameters can be built from a literal naming only
+what differs. Booleans are left as they are. Settings on by default are
+turned off with a negative value rather than zero.
+*/
+func (p BWParameters) WithDefaults() BWParameters {
+	d := BWParametersDefault
+	fillInt := func(field *int64, def int64) {
+		if *field == 0 {
+			*field = def
+		}
+	}
+	fillFloat := func(field *float64, def float64) {
+		if *field == 0 {
+			*field = def
+		}
+	}
+	fillString := func(field *string, def string) {
+		if *field == "" {
+			*field = def
+		}
+	}
+	fillString(&p.Algorithm, d.Algorithm)
+	fillFloat(&p.BFactor, d.BFactor)
+	fillFloat(&p.AFactor, d.AFactor)
+	fillInt(&p.SLO, d.SLO)
+	fillInt(&p.ClientExpiration, d.ClientExpiration)
+	fillInt(&p.InitialCredits, d.InitialCredits)
+	fillString(&p.Recovery, d.Recovery)
+	fillString(&p.GrantMode, d.GrantMode)
+	fillInt(&p.WarmupCredits, d.WarmupCredits)
+	fillString(&p.WarmupSchedule, d.WarmupSchedule)
+	fillInt(&p.MaxStateAge, d.MaxStateAge)
+	// the deprecated package RTT still stands in for an unset one
+	fillInt(&p.RTT_MICROSECOND, max(RTT_MICROSECOND, 0))
+	fillInt(&p.RTT_MICROSECOND, d.RTT_MICROSECOND)
+	fillInt(&p.StallTimeoutRTTs, d.StallTimeoutRTTs)
+	fillInt(&p.MaxStallBackoff, d.MaxStallBackoff)
+	fillInt(&p.MaxAccountingDrift, d.MaxAccountingDrift)
+	fillInt(&p.MaxRTTUpdateStall, d.MaxRTTUpdateStall)
+	fillInt(&p.ServerQueueTimeout, d.ServerQueueTimeout)
+	fillInt(&p.RetryBaseBackoff, d.RetryBaseBackoff)
+	fillInt(&p.RetryMaxBackoff, d.RetryMaxBackoff)
+	fillString(&p.RetryCredits, d.RetryCredits)
+	fillFloat(&p.RetryCreditCost, d.RetryCreditCost)
+	fillInt(&p.MinOvercommit, d.MinOvercommit)
+	fillFloat(&p.HighAQMFactor, d.HighAQMFactor)
+	fillFloat(&p.CriticalAQMFactor, d.CriticalAQMFactor)
+	fillInt(&p.DAGORWindow, d.DAGORWindow)
+	fillString(&p.AQM, d.AQM)
+	fillInt(&p.CoDelInterval, d.CoDelInterval)
+	fillFloat(&p.ShedRamp, d.ShedRamp)
+	fillFloat(&p.GradientTolerance, d.GradientTolerance)
+	fillInt(&p.OverloadWindows, d.OverloadWindows)
+	fillInt(&p.RecountRTTs, d.RecountRTTs)
+	fillString(&p.DelaySignal, d.DelaySignal)
+	fillFloat(&p.CPUTarget, d.CPUTarget)
+	fillFloat(&p.DelayPercentile, d.DelayPercentile)
+	fillFloat(&p.IdempotentAQMFactor, d.IdempotentAQMFactor)
+	fillFloat(&p.DelaySmoothing, d.DelaySmoothing)
+	fillFloat(&p.DemandDecay, d.DemandDecay)
+	fillFloat(&p.CalibrationFactor, d.CalibrationFactor)
+	fillString(&p.ClientIdentity, d.ClientIdentity)
+	return p
+}
+
+/*
+Checks the parameters for settings that would make the controller
+misbehave, such as a negative delay threshold or a multiplicative decrease
+that never shrinks cTotal. Returns an error naming every problem found.
+*/
+func (p BWParameters) Validate() error {
+	var problems []error
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, a...))
+		}
+	}
+	oneOf := func(name string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		problems = append(problems, fmt.Errorf("%s %q is not one of %q", name, value, allowed))
+	}
+
+	oneOf("Algorithm", p.Algorithm, "", AlgorithmBreakwater, AlgorithmDAGOR, AlgorithmGradient)
+	check(p.SLO > 0, "SLO must be positive, got %d", p.SLO)
+	check(p.BFactor > 0 && p.BFactor < 1, "BFactor must be in (0, 1), got %g", p.BFactor)
+	check(p.AFactor >= 0, "AFactor must not be negative, got %g", p.AFactor)
+	check(p.InitialCredits > 0, "InitialCredits must be positive, got %d", p.InitialCredits)
+	check(p.ClientExpiration > 0, "ClientExpiration must be positive, got %d", p.ClientExpiration)
+	check(p.RTT_MICROSECOND > 0, "RTT_MICROSECOND must be positive, got %d", p.RTT_MICROSECOND)
+	check(p.MaxGrantIncrease >= 0, "MaxGrantIncrease must not be negative, got %d", p.MaxGrantIncrease)
+	check(p.RecountRTTs >= 0, "RecountRTTs must not be negative, got %d", p.RecountRTTs)
+	check(p.MaxConcurrentHandlers >= 0, "MaxConcurrentHandlers must not be negative, got %d", p.MaxConcurrentHandlers)
+	check(p.MinCredits >= 0, "MinCredits must not be negative, got %d", p.MinCredits)
+	check(p.MaxCredits == 0 || p.MaxCredits >= max(p.MinCredits, 1),
+		"MaxCredits %d must not be below MinCredits %d", p.MaxCredits, p.MinCredits)
+	oneOf("Recovery", p.Recovery, RecoveryAdditive, RecoverySlowStart)
+	oneOf("GrantMode", p.GrantMode, GrantModeLazy, GrantModeRTT)
+	if p.WarmupPeriod > 0 {
+		check(p.WarmupCredits > 0, "WarmupCredits must be positive with a WarmupPeriod, got %d", p.WarmupCredits)
+		oneOf("WarmupSchedule", p.WarmupSchedule, WarmupLinear, WarmupSlowStart)
+	}
+	// requests of a higher priority must be shed later than best effort ones
+	check(p.HighAQMFactor >= 1, "HighAQMFactor must be at least 1, got %g", p.HighAQMFactor)
+	check(p.CriticalAQMFactor >= p.HighAQMFactor,
+		"CriticalAQMFactor %g must not be below HighAQMFactor %g", p.CriticalAQMFactor, p.HighAQMFactor)
+	check(p.HighPriorityReserve >= 0 && p.HighPriorityReserve < 1,
+		"HighPriorityReserve must be in [0, 1), got %g", p.HighPriorityReserve)
+	check(p.IdempotentAQMFactor > 0 && p.IdempotentAQMFactor <= 1,
+		"IdempotentAQMFactor must be in (0, 1], got %g", p.IdempotentAQMFactor)
+	oneOf("AQM", p.AQM, AQMThreshold, AQMCoDel, AQMProbabilistic)
+	if p.AQM == AQMCoDel {
+		check(p.CoDelInterval > 0, "CoDelInterval must be positive with CoDel, got %d", p.CoDelInterval)
+		check(p.CoDelTarget >= 0, "CoDelTarget must not be negative, got %d", p.CoDelTarget)
+	}
+	if p.AQM == AQMProbabilistic {
+		check(p.ShedRamp > 1, "ShedRamp must be above 1 with AQMProbabilistic, got %g", p.ShedRamp)
+	}
+	oneOf("DelaySignal", p.DelaySignal, DelaySignalScheduler, DelaySignalRPC, DelaySignalGC, DelaySignalCPU)
+	if p.DelaySignal == DelaySignalCPU {
+		check(p.CPUTarget > 0 && p.CPUTarget <= 1, "CPUTarget must be in (0, 1] with DelaySignalCPU, got %g", p.CPUTarget)
+	}
+	check(p.DelayPercentile >= 0 && p.DelayPercentile <= 1, "DelayPercentile must be in [0, 1], got %g", p.DelayPercentile)
+	check(p.DelaySmoothing > 0 && p.DelaySmoothing <= 1, "DelaySmoothing must be in (0, 1], got %g", p.DelaySmoothing)
+	check(p.DemandDecay >= 0 && p.DemandDecay <= 1, "DemandDecay must be in [0, 1], got %g", p.DemandDecay)
+	check(p.MinOvercommit >= 0, "MinOvercommit must not be negative, got %d", p.MinOvercommit)
+	check(p.MaxOvercommit == 0 || p.MaxOvercommit >= p.MinOvercommit,
+		"MaxOvercommit %d must not be below MinOvercommit %d", p.MaxOvercommit, p.MinOvercommit)
+	oneOf("RetryCredits", p.RetryCredits, RetryCreditsFull, RetryCreditsDiscounted, RetryCreditsFree)
+	check(p.RetryCreditCost >= 0 && p.RetryCreditCost <= 1, "RetryCreditCost must be in [0, 1], got %g", p.RetryCreditCost)
+	oneOf("ClientIdentity", p.ClientIdentity, IdentityMetadata, IdentityTLS, IdentityFunc)
+	check(p.ClientIdentity != IdentityFunc || p.IdentityFunc != nil, "ClientIdentity %q needs an IdentityFunc", IdentityFunc)
+	return errors.Join(problems...)
+}
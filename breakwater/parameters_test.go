@@ -0,0 +1,89 @@
+package breakwater
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefaultsAreValid(t *testing.T) {
+	if err := BWParametersDefault.Validate(); err != nil {
+		t.Errorf("Expected the default parameters to be valid, got %v", err)
+	}
+	if err := (BWParameters{}).WithDefaults().Validate(); err != nil {
+		t.Errorf("Expected empty parameters filled with defaults to be valid, got %v", err)
+	}
+}
+
+func TestWithDefaultsKeepsSetFields(t *testing.T) {
+	params := BWParameters{SLO: 500, Recovery: RecoverySlowStart}.WithDefaults()
+	if params.SLO != 500 || params.Recovery != RecoverySlowStart {
+		t.Errorf("Expected set fields to be kept, got SLO %d and Recovery %q", params.SLO, params.Recovery)
+	}
+	if params.BFactor != BWParametersDefault.BFactor || params.InitialCredits != BWParametersDefault.InitialCredits {
+		t.Errorf("Expected unset fields to take their defaults, got BFactor %g and InitialCredits %d", params.BFactor, params.InitialCredits)
+	}
+	if params.IdleHalfLife != 0 {
+		t.Errorf("Expected a zero that disables a feature to be kept, got IdleHalfLife %d", params.IdleHalfLife)
+	}
+}
+
+func TestWithDefaultsMatchesDefaults(t *testing.T) {
+	filled := reflect.ValueOf(BWParameters{}.WithDefaults())
+	defaults := reflect.ValueOf(BWParametersDefault)
+	for i := 0; i < defaults.NumField(); i++ {
+		field := defaults.Type().Field(i)
+		if field.Type.Kind() == reflect.Bool {
+			continue
+		}
+		if !reflect.DeepEqual(filled.Field(i).Interface(), defaults.Field(i).Interface()) {
+			t.Errorf("Expected %s to default to %v, got %v", field.Name, defaults.Field(i).Interface(), filled.Field(i).Interface())
+		}
+	}
+}
+
+func TestValidateRejectsNonsense(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(p *BWParameters)
+		field  string
+	}{
+		{"negative SLO", func(p *BWParameters) { p.SLO = -160 }, "SLO"},
+		{"BFactor of one", func(p *BWParameters) { p.BFactor = 1 }, "BFactor"},
+		{"no initial credits", func(p *BWParameters) { p.InitialCredits = -1 }, "InitialCredits"},
+		{"high shed below the AQM threshold", func(p *BWParameters) { p.HighAQMFactor = 0.5 }, "HighAQMFactor"},
+		{"critical shed before high", func(p *BWParameters) { p.CriticalAQMFactor = 1.5 }, "CriticalAQMFactor"},
+		{"ceiling below floor", func(p *BWParameters) { p.MinCredits, p.MaxCredits = 100, 10 }, "MaxCredits"},
+		{"unknown recovery", func(p *BWParameters) { p.Recovery = "exponential" }, "Recovery"},
+		{"identity func missing", func(p *BWParameters) { p.ClientIdentity = IdentityFunc }, "IdentityFunc"},
+	} {
+		params := BWParametersDefault
+		tc.modify(&params)
+		err := params.Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.field) {
+			t.Errorf("%s: expected an error naming %s, got %v", tc.name, tc.field, err)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	params := BWParametersDefault
+	params.SLO = 0
+	params.BFactor = 2
+	err := params.Validate()
+	if err == nil || !strings.Contains(err.Error(), "SLO") || !strings.Contains(err.Error(), "BFactor") {
+		t.Errorf("Expected both problems to be reported, got %v", err)
+	}
+}
+
+func TestPackageRTTFillsUnsetRTT(t *testing.T) {
+	RTT_MICROSECOND = 800
+	t.Cleanup(func() { RTT_MICROSECOND = 0 })
+
+	if rtt := InitBreakwater(BWParameters{}).getRTT(); rtt != 800 {
+		t.Errorf("Expected the package RTT to stand in for an unset one, got %d", rtt)
+	}
+	if rtt := InitBreakwater(BWParameters{RTT_MICROSECOND: 2000}).getRTT(); rtt != 2000 {
+		t.Errorf("Expected a set RTT to be kept, got %d", rtt)
+	}
+}
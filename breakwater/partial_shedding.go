@@ -0,0 +1,27 @@
+package breakwater
+
+import "math/rand"
+
+/*
+Partial shedding with AQMProbabilistic.
+The fixed threshold sheds every request while the delay is over it, so
+goodput swings between all and nothing as the delay crosses it. Instead,
+each request is shed with a probability rising linearly from 0 at the
+class's AQM threshold to 1 at ShedRamp times it, so a small overshoot
+sheds a few requests and only a large one sheds them all.
+*/
+func shedProbability(queueingDelay float64, threshold float64, ramp float64) float64 {
+	if queueingDelay < threshold {
+		return 0
+	}
+	p := (queueingDelay - threshold) / (threshold * (ramp - 1))
+	if p > 1 {
+		return 1
+	}
+	return p
+}
+
+func (b *Breakwater) shedsProbabilistically(queueingDelay float64, threshold float64) bool {
+	p := shedProbability(queueingDelay, threshold, b.shedRamp)
+	return p >= 1 || (p > 0 && rand.Float64() < p)
+}
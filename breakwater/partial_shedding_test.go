@@ -0,0 +1,52 @@
+package breakwater
+
+import (
+	"math"
+	"testing"
+)
+
+func TestShedProbabilityRamp(t *testing.T) {
+	for _, c := range []struct {
+		delay    float64
+		expected float64
+	}{
+		{50, 0},
+		{100, 0},
+		{150, 0.5},
+		{200, 1},
+		{400, 1},
+	} {
+		if p := shedProbability(c.delay, 100, 2); math.Abs(p-c.expected) > 1e-9 {
+			t.Errorf("Expected a delay of %g to be shed with probability %g, got %g", c.delay, c.expected, p)
+		}
+	}
+}
+
+func TestPartialShedding(t *testing.T) {
+	params := BWParametersDefault
+	params.AQM = AQMProbabilistic
+	bw := InitBreakwater(params)
+	thresholds := delayThresholds{threshold: bw.thresholdDelay(), aqm: bw.aqmDelay()}
+
+	shedFraction := func(delay float64, class PriorityClass) float64 {
+		shed := 0
+		for i := 0; i < 2000; i++ {
+			if bw.shouldShed(delay, class, thresholds) {
+				shed++
+			}
+		}
+		return float64(shed) / 2000
+	}
+	if f := shedFraction(bw.aqmDelay()*0.9, BestEffort); f != 0 {
+		t.Errorf("Expected nothing shed under the threshold, got %g", f)
+	}
+	if f := shedFraction(bw.aqmDelay()*1.5, BestEffort); f < 0.4 || f > 0.6 {
+		t.Errorf("Expected about half the requests shed halfway up the ramp, got %g", f)
+	}
+	if f := shedFraction(bw.aqmDelay()*1.5, High); f != 0 {
+		t.Errorf("Expected high priority requests to keep their higher threshold, got %g shed", f)
+	}
+	if f := shedFraction(bw.aqmDelay()*2, BestEffort); f != 1 {
+		t.Errorf("Expected every request shed at the end of the ramp, got %g", f)
+	}
+}
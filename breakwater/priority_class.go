@@ -0,0 +1,116 @@
+package breakwater
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Request priority classes.
+Under AQM pressure the server sheds BestEffort requests first and Critical
+requests last: each class is only shed once the queueing delay passes its
+own multiple of the AQM threshold. A fraction of cTotal can also be
+reserved so credits issued on BestEffort requests never use it up.
+Requests without a class are BestEffort, which keeps the original behavior.
+*/
+type PriorityClass int
+
+const (
+	BestEffort PriorityClass = iota
+	High
+	Critical
+)
+
+func (c PriorityClass) String() string {
+	switch c {
+	case High:
+		return "HIGH"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "BEST_EFFORT"
+	}
+}
+
+/*
+Parses a class sent in metadata, unknown values are BestEffort
+*/
+func ParsePriorityClass(s string) PriorityClass {
+	switch s {
+	case "HIGH":
+		return High
+	case "CRITICAL":
+		return Critical
+	default:
+		return BestEffort
+	}
+}
+
+type priorityClassKey struct{}
+
+/*
+Tags an outgoing request with a priority class
+*/
+func WithPriorityClass(ctx context.Context, class PriorityClass) context.Context {
+	return context.WithValue(ctx, priorityClassKey{}, class)
+}
+
+/*
+Returns the class a request was tagged with, false if none
+*/
+func priorityClassFromContext(ctx context.Context) (PriorityClass, bool) {
+	class, ok := ctx.Value(priorityClassKey{}).(PriorityClass)
+	return class, ok
+}
+
+/*
+Returns the class of an incoming request
+*/
+func priorityClassOf(md metadata.MD) PriorityClass {
+	if len(md["priority-class"]) == 0 {
+		return BestEffort
+	}
+	return ParsePriorityClass(md["priority-class"][0])
+}
+
+/*
+Queueing delay beyond which requests of the class are shed, given the
+AQM threshold of their method
+*/
+func (b *Breakwater) aqmThreshold(class PriorityClass, aqmDelay float64) float64 {
+	switch class {
+	case High:
+		return aqmDelay * math.Float64frombits(atomic.LoadUint64(&b.highAQMFactor))
+	case Critical:
+		return aqmDelay * math.Float64frombits(atomic.LoadUint64(&b.criticalAQMFactor))
+	default:
+		return aqmDelay
+	}
+}
+
+/*
+Limits credits issued on a BestEffort request so that cIssued stays out of
+the share of cTotal reserved for higher classes, decaying them like an
+over-limit client if it is already in the reserve
+*/
+func (b *Breakwater) capToClassPool(class PriorityClass, cPrevious int64, cNew int64) int64 {
+	reserve := math.Float64frombits(atomic.LoadUint64(&b.highPriorityReserve))
+	if class != BestEffort || reserve <= 0 {
+		return cNew
+	}
+	if increase := cNew - cPrevious; increase > 0 {
+		cIssued := <-b.cIssued
+		b.cIssued <- cIssued
+		available := roundedInt(float64(b.getCTotal())*(1-reserve)) - cIssued
+		if available <= 0 {
+			b.logger("[Priority Class]:	Best effort pool exhausted, cIssued %d", cIssued)
+			cNew = cPrevious - 1
+		} else if increase > available {
+			cNew = cPrevious + available
+		}
+	}
+	return max(cNew, b.minGrant())
+}
@@ -0,0 +1,67 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParsePriorityClass(t *testing.T) {
+	for _, class := range []PriorityClass{BestEffort, High, Critical} {
+		if parsed := ParsePriorityClass(class.String()); parsed != class {
+			t.Errorf("Expected %v to round trip, got %v", class, parsed)
+		}
+	}
+	if class := ParsePriorityClass("bogus"); class != BestEffort {
+		t.Errorf("Expected unknown classes to be best effort, got %v", class)
+	}
+}
+
+func TestSheddingOrder(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	// beyond the best effort threshold, within the high one
+	bw.storeQueueingDelay(bw.aqmDelay() * 1.5)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	send := func(class PriorityClass) error {
+		md := metadata.Pairs("demand", "1", "id", uuid.New().String(), "priority-class", class.String())
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		return err
+	}
+
+	if err := send(BestEffort); !isServerShed(err) {
+		t.Errorf("Expected best effort request to be shed, got %v", err)
+	}
+	if err := send(High); err != nil {
+		t.Errorf("Expected high priority request to be admitted, got %v", err)
+	}
+	if err := send(Critical); err != nil {
+		t.Errorf("Expected critical request to be admitted, got %v", err)
+	}
+}
+
+func TestHighPriorityReserve(t *testing.T) {
+	params := BWParametersDefault
+	params.HighPriorityReserve = 0.2
+	bw := InitBreakwater(params)
+	bw.cTotal = 100
+	<-bw.cIssued
+	bw.cIssued <- 75
+
+	if cNew := bw.capToClassPool(BestEffort, 10, 20); cNew != 15 {
+		t.Errorf("Expected best effort credits to stop at the reserve, got %d", cNew)
+	}
+	<-bw.cIssued
+	bw.cIssued <- 90
+	if cNew := bw.capToClassPool(BestEffort, 10, 20); cNew != 9 {
+		t.Errorf("Expected best effort credits to decay inside the reserve, got %d", cNew)
+	}
+	if cNew := bw.capToClassPool(High, 10, 20); cNew != 20 {
+		t.Errorf("Expected high priority credits to use the reserve, got %d", cNew)
+	}
+}
@@ -0,0 +1,96 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Versions of the credit protocol spoken between clients and servers.
+Clients advertise their version in the bw-version request header and servers
+answer with theirs in the response header. Peers that send no version speak
+protocolV1. Each side only uses a feature once it knows the other side
+supports it, so either side can be upgraded first.
+The control stream and revocations need no negotiation: servers without the
+control service answer Unimplemented and clients ignore unknown headers.
+*/
+const (
+	protocolV1 = 1 // textual request metadata, a single credits value per response
+	protocolV2 = 2 // binary request metadata, a later credits value supersedes an earlier one
+
+	ProtocolVersion = protocolV2 // latest version
+)
+
+const versionHeader = "bw-version"
+
+/*
+Protocol version to speak, the latest unless pinned lower in the parameters
+*/
+func protocolVersionOf(param BWParameters) int64 {
+	if param.ProtocolVersion <= 0 || param.ProtocolVersion > ProtocolVersion {
+		return ProtocolVersion
+	}
+	return int64(param.ProtocolVersion)
+}
+
+/*
+Version a peer sent in its metadata, protocolV1 if none
+*/
+func peerVersion(md metadata.MD) int64 {
+	if len(md[versionHeader]) == 0 {
+		return protocolV1
+	}
+	version, err := strconv.ParseInt(md[versionHeader][0], 10, 64)
+	if err != nil || version < protocolV1 {
+		return protocolV1
+	}
+	return version
+}
+
+/*
+Version both sides speak
+*/
+func (b *Breakwater) negotiatedVersion(peer int64) int64 {
+	return min(b.protocolVersion, peer)
+}
+
+/*
+Client side: advertises the client's version, unless it speaks protocolV1
+which predates versioning
+*/
+func (b *Breakwater) withVersion(ctx context.Context) context.Context {
+	if b.protocolVersion == protocolV1 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, versionHeader, strconv.FormatInt(b.protocolVersion, 10))
+}
+
+/*
+Server side: adds the server's version to a response header
+*/
+func (b *Breakwater) setVersion(header metadata.MD) {
+	if b.protocolVersion > protocolV1 {
+		header.Set(versionHeader, strconv.FormatInt(b.protocolVersion, 10))
+	}
+}
+
+/*
+Client side: records the version the target answered with.
+Responses without credits, such as rejections, carry no version either.
+*/
+func (t *clientTarget) recordServerVersion(header metadata.MD) {
+	if len(header["credits"]) == 0 {
+		return
+	}
+	atomic.StoreInt64(&t.serverVersion, peerVersion(header))
+}
+
+/*
+Version spoken with the target, 0 until it has answered
+*/
+func (t *clientTarget) negotiatedVersion(b *Breakwater) int64 {
+	return min(b.protocolVersion, atomic.LoadInt64(&t.serverVersion))
+}
@@ -0,0 +1,91 @@
+package breakwater
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestProtocolVersionOf(t *testing.T) {
+	for configured, expected := range map[int]int64{0: ProtocolVersion, 1: protocolV1, 2: protocolV2, 99: ProtocolVersion} {
+		params := BWParametersDefault
+		params.ProtocolVersion = configured
+		if version := protocolVersionOf(params); version != expected {
+			t.Errorf("Expected ProtocolVersion %d to speak version %d, got %d", configured, expected, version)
+		}
+	}
+	if version := peerVersion(nil); version != protocolV1 {
+		t.Errorf("Expected peers without a version to speak protocolV1, got %d", version)
+	}
+}
+
+/*
+Every combination of old and new clients and servers keeps working, and the
+binary metadata is only used once both sides speak protocolV2
+*/
+func TestCompatibilityMatrix(t *testing.T) {
+	for _, clientVersion := range []int{protocolV1, protocolV2} {
+		for _, serverVersion := range []int{protocolV1, protocolV2} {
+			serverParams := BWParametersDefault
+			serverParams.ProtocolVersion = serverVersion
+			server := InitBreakwater(serverParams)
+			setDelay(server, 0)
+			clientParams := BWParametersDefault
+			clientParams.ProtocolVersion = clientVersion
+			clientParams.BinaryMetadata = true
+			client := InitBreakwater(clientParams)
+
+			var mu sync.Mutex
+			var binary []bool
+			recorder := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				mu.Lock()
+				binary = append(binary, len(md[requestMetadataKey]) > 0)
+				mu.Unlock()
+				return handler(ctx, req)
+			}
+
+			lis := bufconn.Listen(1 << 20)
+			s := grpc.NewServer(grpc.ChainUnaryInterceptor(recorder, server.UnaryInterceptor))
+			healthpb.RegisterHealthServer(s, health.NewServer())
+			go s.Serve(lis)
+			cc, err := grpc.Dial("bufnet",
+				grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithUnaryInterceptor(client.UnaryInterceptorClient))
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				if _, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+					t.Errorf("client v%d, server v%d: request %d failed: %v", clientVersion, serverVersion, i, err)
+				}
+			}
+			cc.Close()
+			s.Stop()
+
+			if _, ok := server.clients.load(client.id); !ok {
+				t.Errorf("client v%d, server v%d: expected the server to register the client", clientVersion, serverVersion)
+			}
+			if len(binary) != 3 {
+				t.Fatalf("client v%d, server v%d: expected the server to see 3 requests, got %d", clientVersion, serverVersion, len(binary))
+			}
+			negotiated := clientVersion == protocolV2 && serverVersion == protocolV2
+			for i, usedBinary := range binary {
+				// the first request is sent before the server's version is known
+				if expected := negotiated && i > 0; usedBinary != expected {
+					t.Errorf("client v%d, server v%d: expected binary metadata %v on request %d, got %v", clientVersion, serverVersion, expected, i, usedBinary)
+				}
+			}
+		}
+	}
+}
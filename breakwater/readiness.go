@@ -0,0 +1,34 @@
+package breakwater
+
+import (
+	"net/http"
+)
+
+/*
+ReadinessHandler serves a Kubernetes readiness probe that fails with 503
+while the instance drains after OverloadWindows RTTs over the AQM
+threshold, and passes again once the delay stayed under it for as many.
+Kubernetes then stops routing new connections to the pod while the
+requests on its existing connections finish. Otherwise the probe is
+answered by next, the application's own readiness check, or with 200 if
+next is nil:
+
+	http.Handle("/readyz", b.ReadinessHandler(nil))
+
+Each probe also runs an RTT update if one is due, so the instance
+recovers even after the traffic that drove the updates moved elsewhere.
+*/
+func (b *Breakwater) ReadinessHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.rttUpdate()
+		if b.Draining() {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if next != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,54 @@
+package breakwater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probe(h http.Handler) int {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec.Code
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	params := BWParametersDefault
+	params.OverloadWindows = 2
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	h := bw.ReadinessHandler(nil)
+
+	if code := probe(h); code != http.StatusOK {
+		t.Fatalf("Expected a fresh instance to be ready, got %d", code)
+	}
+	overloaded, healthy := bw.aqmDelay()*2, bw.aqmDelay()/2
+	bw.updateHealth(overloaded)
+	if code := probe(h); code != http.StatusOK {
+		t.Errorf("Expected a single overloaded RTT not to fail readiness, got %d", code)
+	}
+	bw.updateHealth(overloaded)
+	if code := probe(h); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after consecutive overloaded RTTs, got %d", code)
+	}
+	bw.updateHealth(healthy)
+	if code := probe(h); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a single healthy RTT not to recover, got %d", code)
+	}
+	bw.updateHealth(healthy)
+	if code := probe(h); code != http.StatusOK {
+		t.Errorf("Expected readiness to recover after stable RTTs, got %d", code)
+	}
+}
+
+func TestReadinessDelegatesToNext(t *testing.T) {
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if code := probe(bw.ReadinessHandler(next)); code != http.StatusTeapot {
+		t.Errorf("Expected the application's check to answer, got %d", code)
+	}
+}
@@ -0,0 +1,29 @@
+package breakwater
+
+import "math"
+
+/*
+Recovery policies for cTotal once the delay is back within the SLO,
+selected with BWParameters.Recovery
+*/
+const (
+	RecoveryAdditive  = "additive"   // grow by the additive factor every RTT, as in the paper
+	RecoverySlowStart = "slow-start" // double every RTT until the last cTotal that was within the SLO, then grow additively
+)
+
+/*
+cTotal for an RTT within the SLO.
+After a deep multiplicative decrease, additive growth can take thousands of
+RTTs to restore the pool. With RecoverySlowStart cTotal doubles instead,
+like TCP slow start, until it reaches the last cTotal that was within the
+SLO before the overload. Only called under the rtt lock.
+*/
+func (b *Breakwater) recoverCredits(addFactor float64) float64 {
+	credits := b.exactCredits()
+	if b.recovery == RecoverySlowStart && credits < b.lastGoodTotal {
+		b.logger("[Updating credits]: Slow start from %f towards %f", credits, b.lastGoodTotal)
+		return math.Max(math.Min(2*credits, b.lastGoodTotal), credits+addFactor)
+	}
+	b.lastGoodTotal = credits + addFactor
+	return b.lastGoodTotal
+}
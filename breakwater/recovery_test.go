@@ -0,0 +1,49 @@
+package breakwater
+
+import (
+	"testing"
+)
+
+// Drives cTotal through an overload and back, returning the RTTs it took
+// to recover the pool it had before
+func rttsToRecover(t *testing.T, recovery string) int {
+	params := BWParametersDefault
+	params.Recovery = recovery
+	bw := InitBreakwater(params)
+	setNumClients(bw, 1)
+
+	setDelay(bw, 0)
+	bw.cTotal = bw.getUpdatedTotalCredits()
+	good := bw.cTotal
+	setDelay(bw, 100*bw.thresholdDelay())
+	for i := 0; i < 10; i++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+	}
+	if bw.cTotal >= good/100 {
+		t.Fatalf("Expected the overload to cut cTotal from %d, got %d", good, bw.cTotal)
+	}
+
+	setDelay(bw, 0)
+	for rtts := 1; rtts <= 100000; rtts++ {
+		bw.cTotal = bw.getUpdatedTotalCredits()
+		if bw.cTotal >= good {
+			if bw.cTotal > good+1 {
+				t.Errorf("Expected recovery not to overshoot %d, got %d", good, bw.cTotal)
+			}
+			return rtts
+		}
+	}
+	t.Fatalf("cTotal did not recover to %d", good)
+	return 0
+}
+
+func TestSlowStartRecovery(t *testing.T) {
+	additive := rttsToRecover(t, RecoveryAdditive)
+	slowStart := rttsToRecover(t, RecoverySlowStart)
+	if slowStart > 10 {
+		t.Errorf("Expected slow start to recover within 10 RTTs, took %d", slowStart)
+	}
+	if additive < 900 {
+		t.Errorf("Expected additive recovery to take about one RTT per credit, took %d", additive)
+	}
+}
@@ -0,0 +1,140 @@
+package breakwater
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+/*
+Registry of named Breakwater instances, for binaries hosting several
+services or listeners, each with its own pool of credits. Instances are
+looked up by name, and their snapshots can be read one by one or summed up.
+*/
+type Registry struct {
+	mu        sync.RWMutex
+	instances map[string]*Breakwater
+}
+
+// Registry shared by the whole process
+var DefaultRegistry = NewRegistry()
+
+var errDuplicateInstance = errors.New("breakwater: instance name already registered")
+
+func NewRegistry() *Registry {
+	return &Registry{instances: make(map[string]*Breakwater)}
+}
+
+/*
+Registers b under name, failing if the name is taken
+*/
+func (r *Registry) Register(name string, b *Breakwater) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.instances[name]; ok {
+		return errDuplicateInstance
+	}
+	r.instances[name] = b
+	return nil
+}
+
+/*
+Removes the instance registered under name and closes it, stopping its
+background goroutines
+*/
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	b, ok := r.instances[name]
+	delete(r.instances, name)
+	r.mu.Unlock()
+	if ok {
+		b.Close()
+	}
+}
+
+func (r *Registry) Lookup(name string) (*Breakwater, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.instances[name]
+	return b, ok
+}
+
+/*
+Names of the registered instances, sorted
+*/
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.instances))
+	for name := range r.instances {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+/*
+Snapshot of every registered instance, by name
+*/
+func (r *Registry) Snapshots() map[string]Snapshot {
+	r.mu.RLock()
+	instances := make(map[string]*Breakwater, len(r.instances))
+	for name, b := range r.instances {
+		instances[name] = b
+	}
+	r.mu.RUnlock()
+	snapshots := make(map[string]Snapshot, len(instances))
+	for name, b := range instances {
+		snapshots[name] = b.Snapshot()
+	}
+	return snapshots
+}
+
+/*
+Sums the snapshots of every registered instance: credits, clients and
+rejections add up, while the SLO and delays are those of the most loaded
+instance, and PassThrough is set if any instance has tripped its valve
+*/
+func (r *Registry) Aggregate() Snapshot {
+	return aggregateSnapshots(r.Snapshots())
+}
+
+func aggregateSnapshots(snapshots map[string]Snapshot) Snapshot {
+	total := Snapshot{Rejections: make(map[RejectReason]int64)}
+	for _, s := range snapshots {
+		total.CTotal += s.CTotal
+		total.CIssued += s.CIssued
+		total.NumClients += s.NumClients
+		total.Shed += s.Shed
+		for reason, count := range s.Rejections {
+			total.Rejections[reason] += count
+		}
+		total.SLO = max(total.SLO, s.SLO)
+		total.RawDelay = math.Max(total.RawDelay, s.RawDelay)
+		total.SmoothedDelay = math.Max(total.SmoothedDelay, s.SmoothedDelay)
+		total.PassThrough = total.PassThrough || s.PassThrough
+	}
+	return total
+}
+
+type registryState struct {
+	Total     Snapshot            `json:"total"`
+	Instances map[string]Snapshot `json:"instances"`
+}
+
+/*
+Handler serves the aggregated snapshot and the snapshot of every
+registered instance as JSON:
+
+	http.Handle("/debug/breakwater/instances", breakwater.DefaultRegistry.Handler())
+*/
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		snapshots := r.Snapshots()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registryState{Total: aggregateSnapshots(snapshots), Instances: snapshots})
+	})
+}
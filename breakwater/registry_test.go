@@ -0,0 +1,85 @@
+package breakwater
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryLookup(t *testing.T) {
+	r := NewRegistry()
+	echo, search := InitBreakwater(BWParametersDefault), InitBreakwater(BWParametersDefault)
+	if err := r.Register("echo", echo); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := r.Register("search", search); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := r.Register("echo", search); err != errDuplicateInstance {
+		t.Errorf("Expected a taken name to be refused, got %v", err)
+	}
+	if b, ok := r.Lookup("echo"); !ok || b != echo {
+		t.Errorf("Expected to find the echo instance")
+	}
+	if names := r.Names(); len(names) != 2 || names[0] != "echo" || names[1] != "search" {
+		t.Errorf("Expected sorted names, got %v", names)
+	}
+	r.Unregister("echo")
+	if _, ok := r.Lookup("echo"); ok {
+		t.Errorf("Expected echo to be unregistered")
+	}
+	select {
+	case <-echo.stop:
+	default:
+		t.Errorf("Expected echo to be closed")
+	}
+}
+
+func TestRegistryInstancesKeepTheirFlags(t *testing.T) {
+	params := BWParametersDefault
+	params.Verbose = true
+	params.LoadShedding = false
+	loud := InitBreakwater(params)
+	quiet := InitBreakwater(BWParametersDefault)
+	if !bool(loud.verbose) || loud.loadShedding {
+		t.Errorf("Expected the first instance to keep its flags after a later init")
+	}
+	if bool(quiet.verbose) || !quiet.loadShedding {
+		t.Errorf("Expected the later instance to keep the default flags")
+	}
+}
+
+func TestRegistryAggregate(t *testing.T) {
+	r := NewRegistry()
+	params := BWParametersDefault
+	params.ManualUpdates = true
+	echo := InitBreakwater(params)
+	params.SLO = 2 * BWParametersDefault.SLO
+	search := InitBreakwater(params)
+	r.Register("echo", echo)
+	r.Register("search", search)
+	registerWithCredits(echo, 3)
+	registerWithCredits(search, 5)
+
+	total := r.Aggregate()
+	snapshots := r.Snapshots()
+	if total.CTotal != snapshots["echo"].CTotal+snapshots["search"].CTotal {
+		t.Errorf("Expected cTotal to add up, got %d", total.CTotal)
+	}
+	if total.CIssued != 8 || total.NumClients != 2 {
+		t.Errorf("Expected 8 credits issued to 2 clients, got %d to %d", total.CIssued, total.NumClients)
+	}
+	if total.SLO != params.SLO {
+		t.Errorf("Expected the largest SLO, got %d", total.SLO)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var state registryState
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode the response: %v", err)
+	}
+	if len(state.Instances) != 2 || state.Total.CIssued != 8 {
+		t.Errorf("Unexpected registry state %+v", state)
+	}
+}
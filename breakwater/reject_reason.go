@@ -0,0 +1,94 @@
+package breakwater
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+)
+
+/*
+Why breakwater rejected a request, usable as a metrics label.
+Every rejection error carries its reason, see RejectReasonFromError.
+*/
+type RejectReason string
+
+const (
+	ReasonUnknown                RejectReason = "unknown"                   // rejected by a breakwater that did not say why
+	ReasonClientQueueFull        RejectReason = "client_queue_full"         // the client's outgoing queue was full
+	ReasonClientExpired          RejectReason = "client_expired"            // waited longer than the client expiration for a credit
+	ReasonClientDroppedFromFront RejectReason = "client_dropped_from_front" // dropped from the front of a full client queue
+	ReasonClientAdmissionLevel   RejectReason = "client_admission_level"    // below the admission level the server last reported
+	ReasonServerAQM              RejectReason = "server_aqm"                // the server's queueing delay was beyond the AQM threshold
+	ReasonServerQueueFull        RejectReason = "server_queue_full"         // the server-side queue was full
+	ReasonServerQueueExpired     RejectReason = "server_queue_expired"      // expired in the server-side queue
+	ReasonServerAdmissionLevel   RejectReason = "server_admission_level"    // below the server's DAGOR admission level
+)
+
+var rejectReasons = map[bwpb.BreakwaterRejection_Reason]RejectReason{
+	bwpb.BreakwaterRejection_REASON_UNSPECIFIED:               ReasonUnknown,
+	bwpb.BreakwaterRejection_REASON_CLIENT_QUEUE_FULL:         ReasonClientQueueFull,
+	bwpb.BreakwaterRejection_REASON_CLIENT_EXPIRATION:         ReasonClientExpired,
+	bwpb.BreakwaterRejection_REASON_CLIENT_DROPPED_FROM_FRONT: ReasonClientDroppedFromFront,
+	bwpb.BreakwaterRejection_REASON_CLIENT_ADMISSION_LEVEL:    ReasonClientAdmissionLevel,
+	bwpb.BreakwaterRejection_REASON_SERVER_AQM:                ReasonServerAQM,
+	bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_FULL:         ReasonServerQueueFull,
+	bwpb.BreakwaterRejection_REASON_SERVER_QUEUE_EXPIRATION:   ReasonServerQueueExpired,
+	bwpb.BreakwaterRejection_REASON_SERVER_ADMISSION_LEVEL:    ReasonServerAdmissionLevel,
+}
+
+/*
+Returns true for reasons a server rejects requests for
+*/
+func (r RejectReason) ServerSide() bool {
+	return strings.HasPrefix(string(r), "server_")
+}
+
+/*
+Returns why err rejected a request, false if it is not a breakwater rejection
+*/
+func RejectReasonFromError(err error) (RejectReason, bool) {
+	rejection, ok := RejectionFromError(err)
+	if !ok {
+		return "", false
+	}
+	if reason, ok := rejectReasons[rejection.GetReason()]; ok {
+		return reason, true
+	}
+	return ReasonUnknown, true
+}
+
+/*
+Records a rejection about to be returned for the request of ctx and
+returns err unchanged. Rejections made by this Breakwater are counted by
+reason for Snapshot and passed to Observer.OnReject; rejections from
+servers are only sampled with TraceShed. Other errors are ignored.
+*/
+func (b *Breakwater) reject(ctx context.Context, err error) error {
+	rejection, ok := RejectionFromError(err)
+	if !ok {
+		return err
+	}
+	b.sampleShed(ctx)
+	if rejection.GetBreakwaterId() != b.id.String() {
+		return err
+	}
+	reason, _ := RejectReasonFromError(err)
+	counter, _ := b.rejections.LoadOrStore(reason, new(int64))
+	atomic.AddInt64(counter.(*int64), 1)
+	b.observer.rejected(reason)
+	return err
+}
+
+/*
+Rejections made by this Breakwater so far, by reason
+*/
+func (b *Breakwater) rejectionCounts() map[RejectReason]int64 {
+	counts := make(map[RejectReason]int64)
+	b.rejections.Range(func(key, value interface{}) bool {
+		counts[key.(RejectReason)] = atomic.LoadInt64(value.(*int64))
+		return true
+	})
+	return counts
+}
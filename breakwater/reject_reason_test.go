@@ -0,0 +1,53 @@
+package breakwater
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRejectReasonFromError(t *testing.T) {
+	id := uuid.New().String()
+	cases := map[RejectReason]error{
+		ReasonClientQueueFull: ClientQueueFullError(id, 0),
+		ReasonClientExpired:   ClientExpirationError(id, 0),
+		ReasonServerAQM:       ServerAQMError(id, 10, 0),
+		ReasonServerQueueFull: ServerQueueFullError(id, 10, 0),
+	}
+	for want, err := range cases {
+		if reason, ok := RejectReasonFromError(err); !ok || reason != want {
+			t.Errorf("Expected %s, got %s", want, reason)
+		}
+	}
+	if _, ok := RejectReasonFromError(errors.New("not a rejection")); ok {
+		t.Errorf("Expected other errors to have no reject reason")
+	}
+	if !ReasonServerAQM.ServerSide() || ReasonClientExpired.ServerSide() {
+		t.Errorf("Expected only server reasons to be server side")
+	}
+}
+
+func TestRejectionsCountedByReason(t *testing.T) {
+	params := BWParametersDefault
+	var observed []RejectReason
+	params.Observer = &Observer{OnReject: func(reason RejectReason) { observed = append(observed, reason) }}
+	bw := InitBreakwater(params)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	if _, err := bw.Admit(metadata.NewIncomingContext(context.Background(), md)); !isServerShed(err) {
+		t.Fatalf("Expected the request to be shed, got %v", err)
+	}
+	// rejected by another breakwater, not counted here
+	bw.reject(context.Background(), ServerAQMError(uuid.New().String(), 10, 0))
+
+	s := bw.Snapshot()
+	if s.Rejections[ReasonServerAQM] != 1 || s.Shed != 1 {
+		t.Errorf("Expected one AQM rejection, got %+v", s.Rejections)
+	}
+	if len(observed) != 1 || observed[0] != ReasonServerAQM {
+		t.Errorf("Expected the observer to see the AQM rejection, got %v", observed)
+	}
+}
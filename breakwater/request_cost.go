@@ -0,0 +1,109 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Outgoing metadata marking a bypassed request and the credits a request costs
+const (
+	bypassKey = "bypass"
+	costKey   = "cost"
+)
+
+type bypassKeyType struct{}
+type costKeyType struct{}
+
+/*
+Exempts the request made with ctx from admission control, for critical
+internal calls that must not wait for credits or be shed. The client sends
+it without taking a credit. The server admits it without shedding if an
+interceptor in front of breakwater marked its context, or if the server
+sets TrustClientBypass. A server context marked this way also exempts
+the calls its handler makes with it.
+*/
+func WithBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKeyType{}, true)
+}
+
+/*
+Tags the request made with ctx as costing n credits instead of one, for
+expensive calls. The client spends n credits of its balance, or what is
+left of it, and the server takes n off the client's outstanding credits
+when issuing its next grant.
+*/
+func WithCost(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, costKeyType{}, max(n, 1))
+}
+
+func bypassedByContext(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassKeyType{}).(bool)
+	return bypass
+}
+
+/*
+Credits an outgoing request costs, 1 unless tagged with WithCost
+*/
+func costOf(ctx context.Context) int64 {
+	if cost, ok := ctx.Value(costKeyType{}).(int64); ok {
+		return cost
+	}
+	return 1
+}
+
+/*
+Attaches the bypass and cost of an outgoing request to its metadata
+*/
+func withBypassAndCost(ctx context.Context) context.Context {
+	if bypassedByContext(ctx) {
+		ctx = metadata.AppendToOutgoingContext(ctx, bypassKey, "true")
+	}
+	if cost := costOf(ctx); cost != 1 {
+		ctx = metadata.AppendToOutgoingContext(ctx, costKey, strconv.FormatInt(cost, 10))
+	}
+	return ctx
+}
+
+/*
+Returns true if an incoming request is exempt from admission control
+*/
+func (b *Breakwater) bypassed(ctx context.Context, md metadata.MD) bool {
+	if bypassedByContext(ctx) {
+		return true
+	}
+	return b.trustClientBypass && len(md.Get(bypassKey)) > 0
+}
+
+/*
+Credits an incoming request cost the client: 0 if the client sent it
+without a credit, otherwise what its context or its metadata says, at
+least 1
+*/
+func incomingCost(ctx context.Context, md metadata.MD) int64 {
+	if bypassedByContext(ctx) || len(md.Get(bypassKey)) > 0 {
+		return 0
+	}
+	if cost, ok := ctx.Value(costKeyType{}).(int64); ok {
+		return cost
+	}
+	if values := md.Get(costKey); len(values) > 0 {
+		if cost, err := strconv.ParseInt(values[0], 10, 64); err == nil {
+			return max(cost, 1)
+		}
+	}
+	return 1
+}
+
+/*
+Takes the credits an expensive request costs beyond the one it waited for,
+as many as the balance holds
+*/
+func (t *clientTarget) spendExtraCredits(extra int64) {
+	if extra <= 0 {
+		return
+	}
+	creditBalance := <-t.outgoingCredits
+	t.outgoingCredits <- max(creditBalance-extra, 0)
+}
@@ -0,0 +1,112 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestBypassSendsWithoutCredit(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.getTarget("server").setCredits(0)
+
+	var bypass []string
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		bypass = md.Get(bypassKey)
+		return nil, nil, nil
+	}
+	if err := bw.Invoke(WithBypass(context.Background()), "server", send); err != nil {
+		t.Fatalf("Expected the bypassed request to be sent without a credit, got %v", err)
+	}
+	if len(bypass) != 1 {
+		t.Errorf("Expected the request to tell the server it bypassed, got %v", bypass)
+	}
+}
+
+func TestCostSpendsCredits(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server")
+	target.setCredits(5)
+
+	var balance int64
+	var cost []string
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		balance = <-target.outgoingCredits
+		target.outgoingCredits <- balance
+		md, _ := metadata.FromOutgoingContext(ctx)
+		cost = md.Get(costKey)
+		return metadata.Pairs("credits", "5"), nil, nil
+	}
+	if err := bw.Invoke(WithCost(context.Background(), 3), "server", send); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if balance != 2 {
+		t.Errorf("Expected the request to spend 3 of 5 credits, got a balance of %d", balance)
+	}
+	if len(cost) != 1 || cost[0] != "3" {
+		t.Errorf("Expected the cost to be sent to the server, got %v", cost)
+	}
+
+	// a cost beyond the balance spends what is left
+	target.setCredits(2)
+	if err := bw.Invoke(WithCost(context.Background(), 10), "server", send); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if balance != 0 {
+		t.Errorf("Expected the request to spend every credit, got a balance of %d", balance)
+	}
+}
+
+func admitWith(bw *Breakwater, ctx context.Context, id uuid.UUID, kv ...string) (*Admission, error) {
+	md := metadata.Pairs(append([]string{"demand", "1", "id", id.String()}, kv...)...)
+	return bw.Admit(metadata.NewIncomingContext(ctx, md))
+}
+
+func TestServerBypassesShedding(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+	id := uuid.New()
+
+	if _, err := admitWith(bw, context.Background(), id, bypassKey, "true"); err == nil {
+		t.Errorf("Expected a client's bypass to be ignored without TrustClientBypass")
+	}
+	if _, err := admitWith(bw, WithBypass(context.Background()), id); err != nil {
+		t.Errorf("Expected a request bypassed by a local interceptor to be admitted, got %v", err)
+	}
+
+	params := BWParametersDefault
+	params.TrustClientBypass = true
+	trusting := InitBreakwater(params)
+	trusting.storeQueueingDelay(trusting.aqmDelay() * 2)
+	if _, err := admitWith(trusting, context.Background(), id, bypassKey, "true"); err != nil {
+		t.Errorf("Expected a client's bypass to be admitted with TrustClientBypass, got %v", err)
+	}
+}
+
+func TestServerChargesCost(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	id := uuid.New()
+	// the first request after an update issues a fresh grant
+	if _, err := admitWith(bw, context.Background(), id); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	c, _ := bw.clients.load(id)
+	c.issued = 10
+
+	if _, err := admitWith(bw, context.Background(), id, costKey, "4"); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if c.issued != 6 {
+		t.Errorf("Expected a request costing 4 to take 4 of 10 outstanding credits, got %d", c.issued)
+	}
+	if _, err := admitWith(bw, context.Background(), id, bypassKey, "true"); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if c.issued != 6 {
+		t.Errorf("Expected a request sent without a credit to cost nothing, got %d outstanding", c.issued)
+	}
+}
@@ -0,0 +1,177 @@
+package breakwater
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Incoming metadata carrying the idempotency key of a request
+const idempotencyKeyKey = "reqid"
+
+/*
+Request deduplication
+A client whose response was lost retries a request the server already
+executed, which would charge it twice and run the handler twice. With
+DedupWindow set, the unary interceptor remembers the response to each
+request carrying an idempotency key for that long after it completes. A
+request with the key of one still in flight waits for it, and one with the
+key of a completed request is answered with its response. Either way the
+duplicate is admitted at no cost and is never shed, since it runs no
+handler. Keys are scoped to the identified client, so clients never see
+each other's responses. Failed requests, and those whose handler panicked,
+are forgotten once they complete, so their retries run again. Other
+transports have no response to replay, so their duplicates run.
+*/
+type dedupWindow struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[dedupKey]*dedupEntry
+	expiry  *list.List // completed entries, oldest first
+}
+
+/*
+Idempotency key of a request, scoped to the client that sent it
+*/
+type dedupKey struct {
+	client uuid.UUID
+	reqid  string
+}
+
+/*
+A request with an idempotency key, in flight until done is closed
+*/
+type dedupEntry struct {
+	key     dedupKey
+	done    chan struct{}
+	expires time.Time
+	resp    interface{}
+	err     error
+}
+
+func newDedupWindow(window time.Duration) *dedupWindow {
+	return &dedupWindow{window: window, entries: make(map[dedupKey]*dedupEntry), expiry: list.New()}
+}
+
+/*
+Sets the idempotency key of an outgoing request, so a server with
+DedupWindow set executes the request at most once however often it is
+retried. The key has to be unique to the logical request, e.g. an id the
+application stores with the operation.
+*/
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, idempotencyKeyKey, key)
+}
+
+/*
+Idempotency key of an incoming request, "" if it has none or it cannot be
+deduplicated
+*/
+func (b *Breakwater) idempotencyKey(ctx context.Context, md metadata.MD) string {
+	if b.dedup == nil {
+		return ""
+	}
+	// only the gRPC interceptor can replay a response
+	if !intercepted(ctx) {
+		return ""
+	}
+	if values := md.Get(idempotencyKeyKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+/*
+Drops the completed entries whose window has passed, called with mu held
+*/
+func (d *dedupWindow) expire(now time.Time) {
+	for front := d.expiry.Front(); front != nil; front = d.expiry.Front() {
+		e := front.Value.(*dedupEntry)
+		if now.Before(e.expires) {
+			return
+		}
+		d.expiry.Remove(front)
+		delete(d.entries, e.key)
+	}
+}
+
+/*
+Returns the request with the given key in flight or within the window, nil
+if there is none
+*/
+func (d *dedupWindow) lookup(key dedupKey) *dedupEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	return d.entries[key]
+}
+
+/*
+Records the request with the given key as in flight. Returns the earlier
+request instead, and true, if there is one.
+*/
+func (d *dedupWindow) claim(key dedupKey) (*dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	if e, ok := d.entries[key]; ok {
+		return e, true
+	}
+	e := &dedupEntry{key: key, done: make(chan struct{})}
+	d.entries[key] = e
+	return e, false
+}
+
+/*
+Completes a claimed request. Its response is kept for the window, while
+a failed request is forgotten so its retries run again.
+*/
+func (d *dedupWindow) finish(e *dedupEntry, resp interface{}, err error) {
+	d.mu.Lock()
+	e.resp, e.err = resp, err
+	if err != nil {
+		delete(d.entries, e.key)
+	} else {
+		e.expires = time.Now().Add(d.window)
+		d.expiry.PushBack(e)
+	}
+	d.mu.Unlock()
+	close(e.done)
+}
+
+/*
+Calls the handler of a request that claimed its idempotency key and
+finishes the claim. A panicking handler finishes it as failed before the
+panic goes on, so duplicates waiting on it do not wait forever.
+*/
+func (b *Breakwater) callClaimed(ctx context.Context, e *dedupEntry, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	finished := false
+	defer func() {
+		if !finished {
+			b.dedup.finish(e, nil, errHandlerPanic)
+		}
+	}()
+	resp, err = b.callHandler(ctx, req, info, handler)
+	finished = true
+	b.dedup.finish(e, resp, err)
+	return resp, err
+}
+
+/*
+Waits for the earlier request to complete and returns its outcome
+*/
+func (e *dedupEntry) wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-e.done:
+		return e.resp, e.err
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,149 @@
+package breakwater
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestDuplicateAnsweredWithResponse(t *testing.T) {
+	params := BWParametersDefault
+	params.DedupWindow = 1000000
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return "charged " + req.(string), nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Charge"}
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String(), idempotencyKeyKey, "payment-1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	for i := 0; i < 2; i++ {
+		resp, err := bw.UnaryInterceptor(ctx, "order", info, handler)
+		if err != nil || resp != "charged order" {
+			t.Fatalf("Expected the response of the first request, got %v and %v", resp, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the retry not to run the handler again, ran %d times", calls)
+	}
+
+	// duplicates are never shed, since they run no handler
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+	if resp, err := bw.UnaryInterceptor(ctx, "order", info, handler); err != nil || resp != "charged order" {
+		t.Errorf("Expected the duplicate to be answered while shedding, got %v and %v", resp, err)
+	}
+}
+
+func TestFailedRequestRunsAgain(t *testing.T) {
+	params := BWParametersDefault
+	params.DedupWindow = 1000000
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Charge"}
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String(), idempotencyKeyKey, "payment-2")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	if _, err := bw.UnaryInterceptor(ctx, "order", info, handler); err == nil {
+		t.Fatalf("Expected the first attempt to fail")
+	}
+	if resp, err := bw.UnaryInterceptor(ctx, "order", info, handler); err != nil || resp != "ok" || calls != 2 {
+		t.Errorf("Expected the retry of a failed request to run, got %v and %v after %d calls", resp, err, calls)
+	}
+}
+
+func TestDedupKeysScopedToClient(t *testing.T) {
+	params := BWParametersDefault
+	params.DedupWindow = 1000000
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		meta, _ := FromContext(ctx)
+		return "charged " + meta.ClientID.String(), nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Charge"}
+	for i := 0; i < 2; i++ {
+		id := uuid.New()
+		md := metadata.Pairs("demand", "1", "id", id.String(), idempotencyKeyKey, "payment-4")
+		resp, err := bw.UnaryInterceptor(metadata.NewIncomingContext(context.Background(), md), "order", info, handler)
+		if err != nil || resp != "charged "+id.String() {
+			t.Errorf("Expected each client its own response, got %v and %v", resp, err)
+		}
+	}
+}
+
+func TestPanickedRequestReleasesDuplicates(t *testing.T) {
+	params := BWParametersDefault
+	params.DedupWindow = 1000000
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+			panic("handler bug")
+		}
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Charge"}
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String(), idempotencyKeyKey, "payment-5")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		bw.UnaryInterceptor(ctx, "order", info, handler)
+	}()
+	<-started
+	duplicate := make(chan error)
+	go func() {
+		_, err := bw.UnaryInterceptor(ctx, "order", info, handler)
+		duplicate <- err
+	}()
+	// let the duplicate start waiting on the first request
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if r := <-panicked; r == nil {
+		t.Errorf("Expected the panic to go on to the server")
+	}
+	select {
+	case err := <-duplicate:
+		if err == nil {
+			t.Errorf("Expected the duplicate of a panicked request to fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the duplicate not to wait forever")
+	}
+	if resp, err := bw.UnaryInterceptor(ctx, "order", info, handler); err != nil || resp != "ok" {
+		t.Errorf("Expected the retry of a panicked request to run, got %v and %v", resp, err)
+	}
+}
+
+func TestWithIdempotencyKey(t *testing.T) {
+	ctx := WithIdempotencyKey(context.Background(), "payment-3")
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if keys := md.Get(idempotencyKeyKey); len(keys) != 1 || keys[0] != "payment-3" {
+		t.Errorf("Expected the key in the outgoing metadata, got %v", md)
+	}
+}
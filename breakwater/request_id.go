@@ -0,0 +1,39 @@
+package breakwater
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const traceparentKey = "traceparent"
+
+/*
+Identity of a request across hops: the trace id of its W3C traceparent
+header, which OpenTelemetry propagates over gRPC and HTTP, so breakwater's
+logs line up with distributed traces. "" if the request carries no valid
+traceparent. Breakwater never sends a request id of its own.
+*/
+func requestIDOf(md metadata.MD) string {
+	values := md[traceparentKey]
+	if len(values) == 0 {
+		return ""
+	}
+	// version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	fields := strings.Split(strings.TrimSpace(values[0]), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || len(fields[1]) != 32 {
+		return ""
+	}
+	traceID, err := hex.DecodeString(fields[1])
+	if err != nil || strings.ToLower(fields[1]) != fields[1] {
+		return ""
+	}
+	for _, b := range traceID {
+		if b != 0 {
+			return fields[1]
+		}
+	}
+	// an all-zero trace id is invalid
+	return ""
+}
@@ -0,0 +1,39 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDFromTraceparent(t *testing.T) {
+	for header, expected := range map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01": "",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01":                 "",
+		"garbage": "",
+	} {
+		if id := requestIDOf(metadata.Pairs(traceparentKey, header)); id != expected {
+			t.Errorf("Expected traceparent %q to give request id %q, got %q", header, expected, id)
+		}
+	}
+	if id := requestIDOf(metadata.MD{}); id != "" {
+		t.Errorf("Expected no request id without a traceparent, got %q", id)
+	}
+}
+
+func TestAdmissionCarriesRequestID(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	md := metadata.Pairs("demand", "1", "id", bw.id.String(), traceparentKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	a, err := bw.Admit(metadata.NewIncomingContext(context.Background(), md))
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+	if a.RequestID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace id as the request id, got %q", a.RequestID)
+	}
+}
@@ -0,0 +1,102 @@
+package breakwater
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const requestMetadataKey = "breakwater-bin" // binary request metadata, a bwpb.RequestMetadata
+
+var errMalformedMetadata = errors.New("malformed breakwater metadata")
+
+/*
+Breakwater metadata carried by a request
+*/
+type requestMetadata struct {
+	clientId uuid.UUID
+	demand   int64
+	class    PriorityClass
+}
+
+/*
+Attaches the client's id, demand and the request's priority class.
+With binaryMetadata set they are sent as a single binary header to targets
+that speak protocolV2, otherwise as textual headers.
+*/
+func (b *Breakwater) withRequestMetadata(ctx context.Context, t *clientTarget, demand int64, class PriorityClass, hasClass bool) context.Context {
+	if b.binaryMetadata && t.negotiatedVersion(b) >= protocolV2 {
+		encoded, err := proto.Marshal(&bwpb.RequestMetadata{
+			ClientId: b.id[:],
+			Demand:   demand,
+			Priority: int32(class),
+		})
+		if err == nil {
+			return metadata.AppendToOutgoingContext(b.withVersion(ctx), requestMetadataKey, string(encoded))
+		}
+		b.logger("[Request Metadata]:	Failed to encode metadata, sending it as text: %v", err)
+	}
+	// a single append, each one copies the pairs and wraps ctx
+	kv := make([]string, 0, 8)
+	kv = append(kv, "demand", formatCount(demand))
+	if b.protocolVersion != protocolV1 {
+		// as withVersion
+		kv = append(kv, versionHeader, formatCount(b.protocolVersion))
+	}
+	if token := atomic.LoadInt64(&t.token); token != 0 {
+		kv = append(kv, tokenHeader, strconv.FormatInt(token, 10))
+	} else {
+		kv = append(kv, "id", b.idString)
+	}
+	if hasClass {
+		kv = append(kv, "priority-class", class.String())
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+/*
+Reads the breakwater metadata of an incoming request, binary or textual
+*/
+func (b *Breakwater) parseRequestMetadata(md metadata.MD) (requestMetadata, error) {
+	if values := md[requestMetadataKey]; len(values) > 0 && b.protocolVersion >= protocolV2 {
+		var encoded bwpb.RequestMetadata
+		if err := proto.Unmarshal([]byte(values[0]), &encoded); err != nil {
+			return requestMetadata{}, err
+		}
+		clientId, err := uuid.FromBytes(encoded.GetClientId())
+		if err != nil {
+			return requestMetadata{}, err
+		}
+		class := PriorityClass(encoded.GetPriority())
+		if class < BestEffort || class > Critical {
+			class = BestEffort
+		}
+		return requestMetadata{clientId: clientId, demand: encoded.GetDemand(), class: class}, nil
+	}
+
+	if len(md["demand"]) == 0 || (len(md["id"]) == 0 && len(md[tokenHeader]) == 0) {
+		return requestMetadata{}, errMalformedMetadata
+	}
+	demand, err := strconv.ParseInt(md["demand"][0], 10, 64)
+	if err != nil {
+		return requestMetadata{}, err
+	}
+	if len(md["id"]) == 0 {
+		token, err := strconv.ParseInt(md[tokenHeader][0], 10, 64)
+		if err != nil {
+			return requestMetadata{}, err
+		}
+		return requestMetadata{clientId: b.clientOfToken(token), demand: demand, class: priorityClassOf(md)}, nil
+	}
+	clientId, err := uuid.Parse(md["id"][0])
+	if err != nil {
+		return requestMetadata{}, err
+	}
+	return requestMetadata{clientId: clientId, demand: demand, class: priorityClassOf(md)}, nil
+}
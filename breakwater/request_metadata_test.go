@@ -0,0 +1,46 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestMetadataRoundTrip(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		params := BWParametersDefault
+		params.BinaryMetadata = binary
+		bw := InitBreakwater(params)
+
+		target := newClientTarget("server-a")
+		target.serverVersion = ProtocolVersion
+		ctx := bw.withRequestMetadata(context.Background(), target, 7, Critical, true)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if _, ok := md[requestMetadataKey]; ok != binary {
+			t.Errorf("Expected the binary header only with BinaryMetadata, got %v with BinaryMetadata %v", md, binary)
+		}
+		rm, err := bw.parseRequestMetadata(md)
+		if err != nil {
+			t.Fatalf("Failed to parse metadata: %v", err)
+		}
+		if rm.clientId != bw.id || rm.demand != 7 || rm.class != Critical {
+			t.Errorf("Expected client %s, demand 7 and class CRITICAL, got %+v", bw.id, rm)
+		}
+	}
+}
+
+func TestMalformedRequestMetadata(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	for _, md := range []metadata.MD{
+		nil,
+		metadata.Pairs("id", uuid.New().String()),
+		metadata.Pairs("demand", "x", "id", uuid.New().String()),
+		metadata.Pairs(requestMetadataKey, "\xff"),
+	} {
+		if _, err := bw.parseRequestMetadata(md); err == nil {
+			t.Errorf("Expected %v to be rejected", md)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package breakwater
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+Client side response cache
+Read-heavy clients such as dashboards would rather show a slightly stale
+response than an error during an incident. For the methods in
+CachedMethods, the client interceptor keeps the latest responses of that
+many distinct requests in an LRU, keyed by a hash of the request, and
+answers a request that is shed, by the server or while waiting for a
+credit, with its cached response if it has one.
+Only methods that are safe to answer from a cache, such as idempotent
+reads, should be listed. Requests and responses that are not protobuf
+messages are not cached.
+*/
+type responseCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List                 // most recently used first, of *cachedResponse
+	entries map[[32]byte]*list.Element // by request hash
+}
+
+type cachedResponse struct {
+	key   [32]byte
+	reply proto.Message
+}
+
+func newResponseCaches(sizes map[string]int) map[string]*responseCache {
+	caches := make(map[string]*responseCache, len(sizes))
+	for method, size := range sizes {
+		if size > 0 {
+			caches[method] = &responseCache{size: size, order: list.New(), entries: make(map[[32]byte]*list.Element)}
+		}
+	}
+	return caches
+}
+
+/*
+Hash of a request, false if it is not a protobuf message
+*/
+func requestHash(req interface{}) ([32]byte, bool) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return [32]byte{}, false
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return [32]byte{}, false
+	}
+	return sha256.Sum256(data), true
+}
+
+func (c *responseCache) store(key [32]byte, reply proto.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cachedResponse).reply = proto.Clone(reply)
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedResponse{key: key, reply: proto.Clone(reply)})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+/*
+Copies the cached response of a request into reply, false if there is none
+*/
+func (c *responseCache) load(key [32]byte, reply proto.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	c.order.MoveToFront(e)
+	proto.Reset(reply)
+	proto.Merge(reply, e.Value.(*cachedResponse).reply)
+	return true
+}
+
+/*
+Sends a request of a cached method with invoke, caching its response, or
+answering it from the cache if it is shed
+*/
+func (b *Breakwater) invokeCached(cache *responseCache, method string, req interface{}, reply interface{}, invoke func() error) error {
+	key, ok := requestHash(req)
+	out, isMessage := reply.(proto.Message)
+	if !ok || !isMessage {
+		return invoke()
+	}
+	err := invoke()
+	if err == nil {
+		cache.store(key, out)
+		return nil
+	}
+	if _, shed := RejectionFromError(err); shed && cache.load(key, out) {
+		b.logger("[Response Cache]:	Answering shed request to %s from the cache", method)
+		return nil
+	}
+	return err
+}
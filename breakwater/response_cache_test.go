@@ -0,0 +1,61 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestShedRequestAnsweredFromCache(t *testing.T) {
+	params := BWParametersDefault
+	params.CachedMethods = map[string]int{"/test.Dashboard/Get": 1}
+	bw := InitBreakwater(params)
+	cc, err := grpc.Dial("passthrough:///server-a", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client conn: %v", err)
+	}
+	defer cc.Close()
+
+	shedding := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if shedding {
+			return ServerAQMError("server-a", 300, time.Millisecond)
+		}
+		reply.(*wrapperspb.StringValue).Value = "panel of " + req.(*wrapperspb.StringValue).Value
+		return nil
+	}
+	call := func(method string, panel string) (string, error) {
+		reply := &wrapperspb.StringValue{}
+		err := bw.UnaryInterceptorClient(context.Background(), method, wrapperspb.String(panel), reply, cc, invoker)
+		return reply.Value, err
+	}
+
+	for _, panel := range []string{"cpu", "memory"} {
+		if _, err := call("/test.Dashboard/Get", panel); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if _, err := call("/test.Dashboard/Update", panel); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+	shedding = true
+	if reply, err := call("/test.Dashboard/Get", "memory"); err != nil || reply != "panel of memory" {
+		t.Errorf("Expected the shed request to be answered from the cache, got %q and %v", reply, err)
+	}
+	// evicted by the newer response, the cache keeps one
+	if _, err := call("/test.Dashboard/Get", "cpu"); !isShed(err) {
+		t.Errorf("Expected the evicted request to be shed, got %v", err)
+	}
+	if _, err := call("/test.Dashboard/Update", "memory"); !isShed(err) {
+		t.Errorf("Expected a method without a cache to be shed, got %v", err)
+	}
+}
+
+func isShed(err error) bool {
+	_, ok := RejectionFromError(err)
+	return ok
+}
@@ -0,0 +1,90 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Credits a retry attempt takes, selected with BWParameters.RetryCredits
+*/
+const (
+	RetryCreditsFull       = "full"       // a retry waits for a credit like any request
+	RetryCreditsDiscounted = "discounted" // a retry takes RetryCreditCost of a credit
+	RetryCreditsFree       = "free"       // a retry is sent without a credit
+)
+
+// Outgoing metadata retry interceptors mark attempts with, as set by
+// go-grpc-middleware v2 and v1
+var retryAttemptKeys = []string{"x-retry-attempt", "x-retry-attempty"}
+
+type retryAttemptKey struct{}
+
+/*
+Marks ctx as the given retry attempt of a request, counting the first
+retry as 1, for applications that retry on their own. Retries made by the
+client retry policy and by retry interceptors in front of breakwater are
+recognized without it.
+*/
+func WithRetryAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, retryAttemptKey{}, attempt)
+}
+
+/*
+Retry attempt of an outgoing request, 0 for the first attempt
+*/
+func retryAttemptOf(ctx context.Context) int {
+	if attempt, ok := ctx.Value(retryAttemptKey{}).(int); ok {
+		return attempt
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, key := range retryAttemptKeys {
+		if values := md.Get(key); len(values) > 0 {
+			if attempt, err := strconv.Atoi(values[len(values)-1]); err == nil {
+				return attempt
+			}
+		}
+	}
+	return 0
+}
+
+/*
+Retry attempts
+A retry is the same logical request as the attempt before it, so taking a
+full credit for it charges the client twice for one request, and a hedge
+waiting alongside the original counts twice in its demand. With
+RetryCreditsFree retries are sent right away without a credit, and are not
+counted in the demand sent to the server. With RetryCreditsDiscounted each
+retry adds RetryCreditCost to a per target debt, and takes a credit only
+when the debt reaches a whole one; the others go free.
+*/
+func (b *Breakwater) retryCreditCost() float64 {
+	switch b.retryCredits {
+	case RetryCreditsFree:
+		return 0
+	case RetryCreditsDiscounted:
+		return b.retryCreditDiscount
+	default:
+		return 1
+	}
+}
+
+/*
+Adds the cost of a retry to the target's retry debt, returns true if the
+retry has to take a credit
+*/
+func (t *clientTarget) chargeRetry(cost float64) bool {
+	if cost >= 1 {
+		return true
+	}
+	creditBalance := <-t.outgoingCredits
+	t.retryDebt += cost
+	charged := t.retryDebt >= 1
+	if charged {
+		t.retryDebt--
+	}
+	t.outgoingCredits <- creditBalance
+	return charged
+}
@@ -0,0 +1,69 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRetryAttemptDetected(t *testing.T) {
+	ctx := context.Background()
+	if attempt := retryAttemptOf(ctx); attempt != 0 {
+		t.Errorf("Expected a first attempt, got %d", attempt)
+	}
+	if attempt := retryAttemptOf(WithRetryAttempt(ctx, 2)); attempt != 2 {
+		t.Errorf("Expected the marked attempt, got %d", attempt)
+	}
+	if attempt := retryAttemptOf(metadata.AppendToOutgoingContext(ctx, "x-retry-attempty", "1")); attempt != 1 {
+		t.Errorf("Expected the attempt set by a retry interceptor, got %d", attempt)
+	}
+}
+
+// Sends a retry to a target left without credits, returning the demand it
+// reported and the error if it was not sent
+func sendRetry(retryCredits string) (demand string, err error) {
+	params := BWParametersDefault
+	params.RetryCredits = retryCredits
+	bw := InitBreakwater(params)
+	target := bw.getTarget("server")
+	target.setCredits(0)
+	// another request is waiting for a credit
+	target.queueRequest()
+
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		demand = md.Get("demand")[0]
+		return metadata.Pairs("credits", "0"), nil, nil
+	}
+	err = bw.Invoke(WithRetryAttempt(context.Background(), 1), "server", send)
+	return demand, err
+}
+
+func TestFreeRetrySkipsQueue(t *testing.T) {
+	demand, err := sendRetry(RetryCreditsFree)
+	if err != nil {
+		t.Fatalf("Expected the free retry to be sent without a credit, got %v", err)
+	}
+	if demand != "1" {
+		t.Errorf("Expected the retry not to count in the demand, got %s", demand)
+	}
+}
+
+func TestFullRetryWaitsForCredit(t *testing.T) {
+	if _, err := sendRetry(RetryCreditsFull); err == nil {
+		t.Errorf("Expected the retry to wait for a credit and expire")
+	}
+}
+
+func TestDiscountedRetriesChargeEveryOther(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	target := bw.getTarget("server")
+	var charged []bool
+	for i := 0; i < 4; i++ {
+		charged = append(charged, target.chargeRetry(0.5))
+	}
+	if charged[0] || !charged[1] || charged[2] || !charged[3] {
+		t.Errorf("Expected every other retry to take a credit at half cost, got %v", charged)
+	}
+}
@@ -0,0 +1,85 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/stats"
+)
+
+/*
+Overload signals, selected with BWParameters.DelaySignal
+*/
+const (
+	DelaySignalScheduler = "scheduler" // Go scheduler latency histogram
+	DelaySignalRPC       = "rpc"       // per-RPC time from wire arrival to the interceptor, needs StatsHandler
+	DelaySignalGC        = "gc"        // GC pauses and heap goal pressure, see GCSignal
+	DelaySignalCPU       = "cpu"       // CPU utilization of the process within its cgroup quota, see CPUSignal
+)
+
+type rpcArrivalKey struct{}
+
+type rpcArrival struct {
+	at time.Time // when the request headers arrived, zero until InHeader
+}
+
+/*
+stats.Handler that records when each request arrives on the wire,
+install with grpc.StatsHandler(b.StatsHandler())
+*/
+type rpcDelayHandler struct{}
+
+func (b *Breakwater) StatsHandler() stats.Handler {
+	return rpcDelayHandler{}
+}
+
+func (rpcDelayHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcArrivalKey{}, &rpcArrival{})
+}
+
+/*
+InHeader is handled on the transport goroutine before the request is
+dispatched to its handler goroutine, so the interceptor sees the time
+*/
+func (rpcDelayHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if in, ok := s.(*stats.InHeader); ok && !in.IsClient() {
+		if arrival, ok := ctx.Value(rpcArrivalKey{}).(*rpcArrival); ok {
+			arrival.at = time.Now()
+		}
+	}
+}
+
+func (rpcDelayHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (rpcDelayHandler) HandleConn(ctx context.Context, s stats.ConnStats) {}
+
+/*
+Samples the time the request took from wire arrival to the interceptor,
+keeping the largest since the last RTT update
+*/
+func (b *Breakwater) recordRPCDelay(ctx context.Context) {
+	arrival, ok := ctx.Value(rpcArrivalKey{}).(*rpcArrival)
+	if !ok || arrival.at.IsZero() {
+		return
+	}
+	delay := time.Since(arrival.at)
+	b.observer.queueingDelay(delay)
+	us := delay.Microseconds()
+	for {
+		prev := atomic.LoadInt64(&b.rpcDelayMax)
+		if us <= prev || atomic.CompareAndSwapInt64(&b.rpcDelayMax, prev, us) {
+			return
+		}
+	}
+}
+
+/*
+Delay source for DelaySignalRPC, the largest per-RPC delay since the
+previous sample
+*/
+func (b *Breakwater) rpcDelay() float64 {
+	return float64(atomic.SwapInt64(&b.rpcDelayMax, 0))
+}
@@ -0,0 +1,57 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRPCDelayKeepsLargest(t *testing.T) {
+	params := BWParametersDefault
+	params.DelaySignal = DelaySignalRPC
+	bw := InitBreakwater(params)
+	for _, ago := range []time.Duration{2 * time.Millisecond, 10 * time.Millisecond, 5 * time.Millisecond} {
+		ctx := context.WithValue(context.Background(), rpcArrivalKey{}, &rpcArrival{at: time.Now().Add(-ago)})
+		bw.recordRPCDelay(ctx)
+	}
+	if delay := bw.getDelay(); delay < 10000 || delay > 20000 {
+		t.Errorf("Expected the largest delay of about 10ms, got %f us", delay)
+	}
+	if delay := bw.getDelay(); delay != 0 {
+		t.Errorf("Expected the delay to reset after a sample, got %f us", delay)
+	}
+}
+
+func TestStatsHandlerMeasuresArrival(t *testing.T) {
+	params := BWParametersDefault
+	params.DelaySignal = DelaySignalRPC
+	samples := make(chan time.Duration, 1)
+	params.Observer = &Observer{OnQueueingDelay: func(delay time.Duration) { samples <- delay }}
+	bw := InitBreakwater(params)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.StatsHandler(bw.StatsHandler()), grpc.UnaryInterceptor(bw.UnaryInterceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc := dialBufconn(t, lis)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "demand", "0", "id", bw.id.String())
+	if _, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	select {
+	case delay := <-samples:
+		if delay < 0 || delay > time.Second {
+			t.Errorf("Expected a plausible queueing delay, got %v", delay)
+		}
+	default:
+		t.Errorf("Expected the observer to receive the request's queueing delay")
+	}
+}
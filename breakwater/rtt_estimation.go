@@ -0,0 +1,110 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+const rttGain = 0.125 // EWMA gain for RTT samples, as for TCP's smoothed RTT
+
+/*
+RTT measurement
+Instead of assuming RTT_MICROSECOND, the client stamps each request with its
+send time in "rtt-ts". The server echoes it in the "rtt-echo" trailer along
+with the time it spent on the request in "rtt-server", so the client can
+take a network RTT sample that excludes the handler. The client keeps an
+EWMA per target and reports it to the server in "rtt", where it is smoothed
+again per client. The server then updates cTotal once per mean client RTT,
+and the client never expires a request before one RTT to its target.
+*/
+func ewmaRTT(prev int64, sample int64) int64 {
+	if prev == 0 {
+		return sample
+	}
+	return prev + roundedInt(float64(sample-prev)*rttGain)
+}
+
+/*
+Smoothed RTT to the target in microseconds, the configured one until measured
+*/
+func (t *clientTarget) rttEstimate(configured int64) int64 {
+	if rtt := atomic.LoadInt64(&t.srtt); rtt > 0 {
+		return rtt
+	}
+	return configured
+}
+
+/*
+Adds the send time, and the RTT measured so far, to an outgoing request
+*/
+func (t *clientTarget) stampRTT(ctx context.Context) context.Context {
+	ctx = metadata.AppendToOutgoingContext(ctx, "rtt-ts", strconv.FormatInt(time.Now().UnixNano(), 10))
+	if rtt := atomic.LoadInt64(&t.srtt); rtt > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "rtt", strconv.FormatInt(rtt, 10))
+	}
+	return ctx
+}
+
+/*
+Takes an RTT sample from the timestamp echoed by the server
+*/
+func (t *clientTarget) recordRTT(trailer metadata.MD) {
+	if len(trailer["rtt-echo"]) == 0 || len(trailer["rtt-server"]) == 0 {
+		return
+	}
+	sent, err1 := strconv.ParseInt(trailer["rtt-echo"][0], 10, 64)
+	serverTime, err2 := strconv.ParseInt(trailer["rtt-server"][0], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	sample := time.Since(time.Unix(0, sent)).Microseconds() - serverTime
+	if sample <= 0 {
+		return
+	}
+	for {
+		prev := atomic.LoadInt64(&t.srtt)
+		if atomic.CompareAndSwapInt64(&t.srtt, prev, ewmaRTT(prev, sample)) {
+			return
+		}
+	}
+}
+
+/*
+Server side: returns the trailer echoing the client's timestamp with the
+time spent since start, nil if the client sent none
+*/
+func rttEcho(md metadata.MD, start time.Time) metadata.MD {
+	if len(md["rtt-ts"]) == 0 {
+		return nil
+	}
+	return metadata.Pairs("rtt-echo", md["rtt-ts"][0], "rtt-server", strconv.FormatInt(time.Since(start).Microseconds(), 10))
+}
+
+/*
+Records the RTT a client reported, registering it if needed
+*/
+func (b *Breakwater) setClientRTT(id uuid.UUID, rtt int64) {
+	b.RegisterClient(id, 0)
+	c, _ := b.clients.load(id)
+	c.mu.Lock()
+	before := c.rtt
+	c.rtt = ewmaRTT(c.rtt, rtt)
+	b.trackClientRTT(before, c.rtt)
+	c.mu.Unlock()
+}
+
+/*
+Interval between RTT updates in microseconds, the mean measured client RTT
+if any client reported one
+*/
+func (b *Breakwater) updateInterval() int64 {
+	if rtt := atomic.LoadInt64(&b.measuredRTT); rtt > 0 {
+		return rtt
+	}
+	return b.getRTT()
+}
@@ -0,0 +1,72 @@
+package breakwater
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRecordRTT(t *testing.T) {
+	target := newClientTarget("server-a")
+	if rtt := target.rttEstimate(5000); rtt != 5000 {
+		t.Errorf("Expected the configured RTT before any sample, got %d", rtt)
+	}
+	sent := time.Now().Add(-3 * time.Millisecond).UnixNano()
+	target.recordRTT(metadata.Pairs("rtt-echo", strconv.FormatInt(sent, 10), "rtt-server", "1000"))
+	// 3ms since sending, 1ms of which was spent on the server
+	if rtt := target.rttEstimate(5000); rtt < 2000 || rtt > 2500 {
+		t.Errorf("Expected an RTT of about 2000 us, got %d", rtt)
+	}
+	if rtt := ewmaRTT(2000, 10000); rtt != 3000 {
+		t.Errorf("Expected samples to be smoothed, got %d", rtt)
+	}
+}
+
+func TestMeasuredRTTDrivesUpdateInterval(t *testing.T) {
+	params := BWParametersDefault
+	params.MeasureRTT = true
+	server := InitBreakwater(params)
+	setDelay(server, 0)
+	client := InitBreakwater(params)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(server.UnaryInterceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(client.UnaryInterceptorClient))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	for i := 0; i < 2; i++ {
+		if _, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+			t.Fatalf("Health check failed: %v", err)
+		}
+	}
+	if rtt := client.getTarget(cc.Target()).rttEstimate(client.getRTT()); rtt == client.getRTT() {
+		t.Fatalf("Expected the client to measure the RTT")
+	}
+
+	<-server.rttLock
+	server.setLastUpdate(time.Now().Add(-time.Second))
+	server.rttLock <- 1
+	server.rttUpdate()
+	// the second request reported the RTT measured by the first
+	if interval := server.updateInterval(); interval == server.getRTT() {
+		t.Errorf("Expected the server to update at the RTT reported by the client, got %d", interval)
+	}
+}
@@ -0,0 +1,71 @@
+package breakwater
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Explicit credit issuance at RTT boundaries.
+Credits are otherwise only recalculated when a client sends a request, so a
+client waiting for credits never learns that some were freed up. After
+every RTT update the grant of each client with demand is recalculated.
+Clients with a control stream get it pushed right away. With rttGrants set,
+others get it on the response to their next in-flight request, even if that
+request was issued credits before the update.
+*/
+func (b *Breakwater) issueRTTGrants() {
+	if !b.rttGrants && atomic.LoadInt64(&b.openControlStreams) == 0 {
+		// nobody to send a grant to, spare the scan
+		return
+	}
+	b.clients.rangeClients(func(c *Connection) bool {
+		demand := atomic.LoadInt64(&c.demand)
+		if demand <= 0 {
+			return true
+		}
+		_, streaming := b.controlStreams.Load(c.id)
+		if !streaming && !b.rttGrants {
+			return true
+		}
+		var issuedCredits int64
+		if b.grantMode == GrantModeRTT {
+			// recomputed by the RTT update already
+			issuedCredits = atomic.LoadInt64(&c.issued)
+		} else {
+			issuedCredits = b.updateCreditsToIssue(c.id, demand)
+		}
+		if b.pushControlUpdate(c.id, &bwpb.CreditUpdate{Credits: issuedCredits}) {
+			return true
+		}
+		c.mu.Lock()
+		c.pendingGrant = issuedCredits
+		c.mu.Unlock()
+		return true
+	})
+}
+
+/*
+Returns the header carrying a grant made at an RTT boundary while the
+client's request was in flight, nil if there is none. The client uses the
+last credits value it receives.
+*/
+func (b *Breakwater) pendingGrantHeader(clientId uuid.UUID) metadata.MD {
+	c, ok := b.clients.load(clientId)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	grant := c.pendingGrant
+	c.pendingGrant = 0
+	c.mu.Unlock()
+	if grant == 0 {
+		return nil
+	}
+	b.logger("[RTT Grants]:	Piggybacking %d credits granted at the RTT update to client %s", grant, clientId)
+	return metadata.Pairs("credits", strconv.FormatInt(grant, 10))
+}
@@ -0,0 +1,93 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRTTGrantIssuedToWaitingClient(t *testing.T) {
+	params := BWParametersDefault
+	params.RTTGrants = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	id := uuid.New()
+	bw.RegisterClient(id, 10)
+	bw.updateCreditsToIssue(id, 10)
+
+	<-bw.rttLock
+	bw.setLastUpdate(time.Now().Add(-time.Second))
+	bw.rttLock <- 1
+	bw.rttUpdate()
+
+	c, _ := bw.clients.load(id)
+	c.mu.Lock()
+	grant := c.pendingGrant
+	c.mu.Unlock()
+	if grant <= 0 || grant != atomic.LoadInt64(&c.issued) {
+		t.Fatalf("Expected a pending grant of the client's credits %d, got %d", atomic.LoadInt64(&c.issued), grant)
+	}
+}
+
+func TestPendingGrantPiggybacked(t *testing.T) {
+	params := BWParametersDefault
+	params.RTTGrants = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	id := uuid.New()
+
+	var header metadata.MD
+	var grantedInFlight int64
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		// an RTT update happens while the request is being handled
+		<-bw.rttLock
+		bw.setLastUpdate(time.Now().Add(-time.Second))
+		bw.rttLock <- 1
+		bw.rttUpdate()
+		c, _ := bw.clients.load(id)
+		grantedInFlight = atomic.LoadInt64(&c.issued)
+		return nil, nil
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("demand", "10", "id", id.String(), versionHeader, "2"))
+	ctx = grpc.NewContextWithServerTransportStream(ctx, &headerStream{header: &header})
+	if _, err := bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	credits := header.Get("credits")
+	if len(credits) < 2 {
+		t.Fatalf("Expected the RTT update grant to follow the request's grant, got %v", credits)
+	}
+	if last := credits[len(credits)-1]; last != strconv.FormatInt(grantedInFlight, 10) {
+		t.Errorf("Expected the last credits value to be %d, got %s", grantedInFlight, last)
+	}
+	c, _ := bw.clients.load(id)
+	if c.pendingGrant != 0 {
+		t.Errorf("Expected the pending grant to be cleared once sent, got %d", c.pendingGrant)
+	}
+}
+
+/*
+Records the headers set by the interceptor
+*/
+type headerStream struct {
+	grpc.ServerTransportStream
+	header *metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/test/Method" }
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	*s.header = metadata.Join(*s.header, md)
+	return nil
+}
+
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *headerStream) SetTrailer(md metadata.MD) error { return nil }
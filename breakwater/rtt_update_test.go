@@ -2,6 +2,8 @@ package breakwater
 
 import (
 	"math"
+	"runtime/metrics"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,39 +13,61 @@ import (
 var defaultSLO int64 = BWParametersDefault.SLO
 var targetThreshold float64 = float64(defaultSLO) * DELAY_THRESHOLD_PERCENT
 
+// Replaces the scheduler histogram with a fixed delay in microseconds
+func setDelay(bw *Breakwater, delay float64) {
+	bw.delaySource = func() float64 { return delay }
+}
+
+// Overrides the number of registered clients the credit math sees
+func setNumClients(bw *Breakwater, numClients int64) {
+	atomic.StoreInt64(&bw.clients.count, numClients)
+}
+
 // Test getDelay
 func TestGetDelay(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 100
-	bw.prevGreatestDelay <- 500.0
+	setDelay(bw, 500.0)
 	delay := bw.getDelay()
 	if delay != 500.0 {
 		t.Errorf("Expected delay to be 500.0, got %f", delay)
 	}
 }
 
+func TestMaximumQueuingDelay(t *testing.T) {
+	earlier := &metrics.Float64Histogram{
+		Counts:  []uint64{5, 3, 1, 0},
+		Buckets: []float64{0, 0.00001, 0.0001, 0.001, 0.01},
+	}
+	later := &metrics.Float64Histogram{
+		Counts:  []uint64{9, 3, 2, 0},
+		Buckets: earlier.Buckets,
+	}
+	// the highest bucket with new samples starts at 100us
+	delay := maximumQueuingDelayus(earlier, later)
+	if delay != 100.0 {
+		t.Errorf("Expected delay to be 100.0, got %f", delay)
+	}
+	if delay := maximumQueuingDelayus(later, later); delay != 0 {
+		t.Errorf("Expected delay to be 0 without new samples, got %f", delay)
+	}
+}
+
 func TestGetAdditiveFactor(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
-	<-bw.numClients
-	bw.numClients <- 100
+	setNumClients(bw, 100)
 	aFactor := bw.getAdditiveFactor()
-	expected := max(roundedInt(100*0.001), 1)
+	expected := math.Max(100*BWParametersDefault.AFactor, 1)
 	if aFactor != expected {
-		t.Errorf("Expected aFactor to be %d, got %d", expected, aFactor)
+		t.Errorf("Expected aFactor to be %f, got %f", expected, aFactor)
 	}
 }
 
 func TestGetMultiplicativeFactor(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 	// Target threshold should be 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 300
-	bw.prevGreatestDelay <- 100
+	setDelay(bw, 300)
 	mFactor := bw.getMultiplicativeFactor(bw.getDelay())
-	expected := math.Max(1.0-BWParametersDefault.bFactor*((300.0-targetThreshold)/targetThreshold), 0.5)
+	expected := math.Max(1.0-BWParametersDefault.BFactor*((300.0-targetThreshold)/targetThreshold), 0.5)
 	if mFactor != expected {
 		t.Errorf("Expected mFactor to be %f, got %f", expected, mFactor)
 	}
@@ -65,23 +89,18 @@ func TestRegisterClient(t *testing.T) {
 		<-counter
 	}
 
-	numClients := <-bw.numClients
-	bw.numClients <- numClients
+	numClients := bw.clients.len()
 	if numClients != 20 {
-		t.Errorf("Expected 20 clients, got %d", bw.numClients)
+		t.Errorf("Expected 20 clients, got %d", numClients)
 	}
 }
 
 func TestGetTotalCreditIncrement(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 	var numClients int64 = 10000
-	<-bw.numClients
-	bw.numClients <- numClients
+	setNumClients(bw, numClients)
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 20
-	bw.prevGreatestDelay <- 10
+	setDelay(bw, 20)
 	totalCredits := bw.getUpdatedTotalCredits()
 	expected := BWParametersDefault.InitialCredits + max(roundedInt(float64(numClients)*0.001), 1)
 	if totalCredits != expected {
@@ -92,12 +111,9 @@ func TestGetTotalCreditIncrement(t *testing.T) {
 func TestGetTotalCreditDecrement(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 500
-	bw.prevGreatestDelay <- 30
+	setDelay(bw, 500)
 	totalCredits := bw.getUpdatedTotalCredits()
-	expectedMultFact := math.Max(1.0-BWParametersDefault.bFactor*((500.0-targetThreshold)/targetThreshold), 0.5)
+	expectedMultFact := math.Max(1.0-BWParametersDefault.BFactor*((500.0-targetThreshold)/targetThreshold), 0.5)
 	expected := roundedInt(float64(BWParametersDefault.InitialCredits) * expectedMultFact)
 	if totalCredits != expected {
 		t.Errorf("Expected totalCredits to be %d, got %d", expected, totalCredits)
@@ -107,14 +123,10 @@ func TestGetTotalCreditDecrement(t *testing.T) {
 func TestRTTUpdateIncrement(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 	var numClients int64 = 600
-	<-bw.numClients
-	bw.numClients <- numClients
+	setNumClients(bw, numClients)
 
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 60
-	bw.prevGreatestDelay <- 30
+	setDelay(bw, 60)
 	time.Sleep(400 * time.Millisecond)
 	bw.rttUpdate()
 	time.Sleep(400 * time.Millisecond)
@@ -130,27 +142,21 @@ func TestRttUpdateDecrement(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 500
-	bw.prevGreatestDelay <- 30
+	setDelay(bw, 500)
 	time.Sleep(400 * time.Millisecond)
 	bw.rttUpdate()
 
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 300
-	bw.prevGreatestDelay <- 30
+	setDelay(bw, 300)
 	time.Sleep(400 * time.Millisecond)
 	bw.rttUpdate()
 
 	totalCredits := bw.cTotal
 
 	// Calculate expected
-	expectedMultFact := math.Max(1.0-BWParametersDefault.bFactor*((500.0-targetThreshold)/targetThreshold), 0.5)
+	expectedMultFact := math.Max(1.0-BWParametersDefault.BFactor*((500.0-targetThreshold)/targetThreshold), 0.5)
 	expected := roundedInt(float64(BWParametersDefault.InitialCredits) * expectedMultFact)
-	expectedMultFact = math.Max(1.0-BWParametersDefault.bFactor*((300.0-targetThreshold)/targetThreshold), 0.5)
+	expectedMultFact = math.Max(1.0-BWParametersDefault.BFactor*((300.0-targetThreshold)/targetThreshold), 0.5)
 	expected = roundedInt(float64(expected) * expectedMultFact)
 
 	if totalCredits != expected {
@@ -160,6 +166,8 @@ func TestRttUpdateDecrement(t *testing.T) {
 
 func TestRttUpdateNotReached(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
+	// an update just happened, so the next one is less than an RTT away
+	bw.setLastUpdate(time.Now())
 
 	bw.rttUpdate()
 
@@ -174,14 +182,10 @@ func TestRttUpdateNotReached(t *testing.T) {
 func TestRTTUpdateOnlyOnceRace(t *testing.T) {
 	bw := InitBreakwater(BWParametersDefault)
 	var numClients int64 = 600
-	<-bw.numClients
-	bw.numClients <- numClients
+	setNumClients(bw, numClients)
 
 	// Both of these are below SLO threshold of 160 * 0.4 = 64
-	<-bw.currGreatestDelay
-	<-bw.prevGreatestDelay
-	bw.currGreatestDelay <- 60
-	bw.prevGreatestDelay <- 30
+	setDelay(bw, 60)
 	time.Sleep(400 * time.Millisecond)
 	bw.rttUpdate()
 	bw.rttUpdate()
@@ -0,0 +1,169 @@
+package breakwater
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+)
+
+/*
+A ConfigSource delivers tunables to a running Breakwater, e.g. a config
+file, an xDS control plane or the gRPC service config. Run applies every
+update until ctx is done.
+*/
+type ConfigSource interface {
+	Run(ctx context.Context) error
+}
+
+/*
+Tunables are the parameters that can be changed on a running Breakwater,
+e.g. by a config reloader or a control plane. Zero values (and nil flags)
+leave the current setting unchanged.
+*/
+type Tunables struct {
+	SLO                     int64   // SLA in microseconds
+	AFactor                 float64 // aggressive factor for increasing credits
+	BFactor                 float64 // multiplicative factor for decreasing credits
+	ClientExpiration        int64   // client expiration time in microseconds
+	RTT_MICROSECOND         int64   // RTT in microseconds
+	UseClientTimeExpiration *bool
+	UseClientQueueLength    *bool
+	HighAQMFactor           float64  // HIGH requests are shed at this multiple of the AQM threshold
+	CriticalAQMFactor       float64  // CRITICAL requests are shed at this multiple of the AQM threshold
+	HighPriorityReserve     *float64 // fraction of cTotal BEST_EFFORT requests cannot be issued, 0 disables
+}
+
+/*
+Applies every set field of the tunables through the runtime setters
+*/
+func (b *Breakwater) ApplyTunables(t Tunables) {
+	if t.SLO > 0 {
+		b.SetSLO(t.SLO)
+	}
+	if t.AFactor > 0 {
+		b.SetAFactor(t.AFactor)
+	}
+	if t.BFactor > 0 {
+		b.SetBFactor(t.BFactor)
+	}
+	if t.ClientExpiration > 0 {
+		b.SetClientExpiration(t.ClientExpiration)
+	}
+	if t.RTT_MICROSECOND > 0 {
+		b.SetRTT(t.RTT_MICROSECOND)
+	}
+	if t.UseClientTimeExpiration != nil {
+		atomic.StoreInt32(&b.useClientTimeExpiration, boolFlag(*t.UseClientTimeExpiration))
+	}
+	if t.UseClientQueueLength != nil {
+		atomic.StoreInt32(&b.useClientQueueLength, boolFlag(*t.UseClientQueueLength))
+	}
+	if t.HighAQMFactor > 0 || t.CriticalAQMFactor > 0 {
+		b.SetPriorityAQMFactors(t.HighAQMFactor, t.CriticalAQMFactor)
+	}
+	if t.HighPriorityReserve != nil {
+		b.SetHighPriorityReserve(*t.HighPriorityReserve)
+	}
+}
+
+/*
+Runtime setters
+Controller parameters are swapped while holding the rtt lock,
+so an RTT update never runs against a half-applied change. Settings the
+request path reads are also stored atomically, so requests never take
+the lock.
+*/
+func (b *Breakwater) SetSLO(SLO int64) {
+	<-b.rttLock
+	b.setSLO(SLO)
+	b.rttLock <- 1
+}
+
+/*
+Sets the SLO and the delay thresholds derived from it, the rtt lock has to be held
+*/
+func (b *Breakwater) setSLO(SLO int64) {
+	atomic.StoreInt64(&b.SLO, SLO)
+	b.logger("[Reconfigure]:	SLO: %d, thresholdDelay: %f, aqmDelay: %f", SLO, b.thresholdDelay(), b.aqmDelay())
+}
+
+/*
+Threshold delay (for server-side token reduction) in microseconds
+*/
+func (b *Breakwater) thresholdDelay() float64 {
+	return float64(atomic.LoadInt64(&b.SLO)) * DELAY_THRESHOLD_PERCENT
+}
+
+/*
+AQM threshold (for server-side AQM) in microseconds
+*/
+func (b *Breakwater) aqmDelay() float64 {
+	return b.thresholdDelay() * 2.0
+}
+
+func (b *Breakwater) SetAFactor(aFactor float64) {
+	<-b.rttLock
+	b.aFactor = aFactor
+	b.rttLock <- 1
+	b.logger("[Reconfigure]:	aFactor: %f", aFactor)
+}
+
+func (b *Breakwater) SetBFactor(bFactor float64) {
+	<-b.rttLock
+	b.bFactor = bFactor
+	b.rttLock <- 1
+	b.logger("[Reconfigure]:	bFactor: %f", bFactor)
+}
+
+func (b *Breakwater) SetClientExpiration(clientExpiration int64) {
+	atomic.StoreInt64(&b.clientExpiration, clientExpiration)
+	b.logger("[Reconfigure]:	clientExpiration: %d", clientExpiration)
+}
+
+func (b *Breakwater) SetRTT(rtt int64) {
+	<-b.rttLock
+	atomic.StoreInt64(&b.rtt, rtt)
+	b.rttLock <- 1
+	b.logger("[Reconfigure]:	RTT: %d", rtt)
+}
+
+/*
+Configured RTT in microseconds
+*/
+func (b *Breakwater) getRTT() int64 {
+	return atomic.LoadInt64(&b.rtt)
+}
+
+/*
+Whether requests waiting for a credit expire at the client
+*/
+func (b *Breakwater) expiresWaiters() bool {
+	return atomic.LoadInt32(&b.useClientTimeExpiration) == 1
+}
+
+func boolFlag(on bool) int32 {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+/*
+Sets the multiples of the AQM threshold HIGH and CRITICAL requests are shed
+at, a zero factor is left unchanged
+*/
+func (b *Breakwater) SetPriorityAQMFactors(high float64, critical float64) {
+	if high > 0 {
+		atomic.StoreUint64(&b.highAQMFactor, math.Float64bits(high))
+	}
+	if critical > 0 {
+		atomic.StoreUint64(&b.criticalAQMFactor, math.Float64bits(critical))
+	}
+	b.logger("[Reconfigure]:	highAQMFactor: %f, criticalAQMFactor: %f",
+		math.Float64frombits(atomic.LoadUint64(&b.highAQMFactor)), math.Float64frombits(atomic.LoadUint64(&b.criticalAQMFactor)))
+}
+
+func (b *Breakwater) SetHighPriorityReserve(reserve float64) {
+	atomic.StoreUint64(&b.highPriorityReserve, math.Float64bits(reserve))
+	b.logger("[Reconfigure]:	highPriorityReserve: %f", reserve)
+}
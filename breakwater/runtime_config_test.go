@@ -0,0 +1,49 @@
+package breakwater
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTunablesArePerInstance(t *testing.T) {
+	a := InitBreakwater(BWParametersDefault)
+	b := InitBreakwater(BWParametersDefault)
+	on, reserve := true, 0.2
+	a.ApplyTunables(Tunables{SLO: 320, RTT_MICROSECOND: 1000, UseClientQueueLength: &on, HighPriorityReserve: &reserve})
+
+	if a.getRTT() != 1000 || a.aqmDelay() != 256 {
+		t.Errorf("Expected an RTT of 1000 and an AQM threshold of 256, got %d and %f", a.getRTT(), a.aqmDelay())
+	}
+	if b.getRTT() != BWParametersDefault.RTT_MICROSECOND || b.SLO != BWParametersDefault.SLO || b.useClientQueueLength != 0 || b.highPriorityReserve != 0 {
+		t.Errorf("Expected tunables of one instance to leave the other alone")
+	}
+}
+
+func TestTunablesChangeUnderLoad(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	md := metadata.Pairs("id", "4b6e5fa5-2c1e-4a41-8a4b-0c1f6e1a2d3e", "demand", "1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(1); i <= 100; i++ {
+			bw.SetSLO(100 + i)
+			bw.SetClientExpiration(i)
+			bw.SetPriorityAQMFactors(1+float64(i), 2+float64(i))
+			bw.SetHighPriorityReserve(0.1)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		a, _ := bw.Admit(ctx)
+		a.Done()
+	}
+	wg.Wait()
+	if bw.SLO != 200 {
+		t.Errorf("Expected the last SLO set, got %d", bw.SLO)
+	}
+}
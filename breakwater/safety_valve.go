@@ -0,0 +1,82 @@
+package breakwater
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Safety valve
+If the controller detects anomalies in its own inputs, it stops throttling
+and lets every request through rather than shedding based on garbage.
+Anomalies checked:
+1. The delay estimator returns NaN, Inf or a negative value
+2. cIssued drifts from the per-connection sum by more than maxAccountingDrift
+3. An RTT update holds the rtt lock for longer than maxRTTUpdateStall
+Once tripped, breakwater stays in pass-through mode until ResetSafetyValve
+*/
+func (b *Breakwater) tripSafetyValve(reason string) {
+	if !b.safetyValve {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&b.passThrough, 0, 1) {
+		alert("[Safety Valve]:	Switching to pass-through mode: %s", reason)
+		b.observer.anomaly(reason)
+	}
+}
+
+/*
+Returns true if the safety valve has tripped and requests bypass admission control
+*/
+func (b *Breakwater) PassThrough() bool {
+	return atomic.LoadInt32(&b.passThrough) == 1
+}
+
+/*
+Re-enables admission control after the safety valve has tripped
+*/
+func (b *Breakwater) ResetSafetyValve() {
+	if atomic.CompareAndSwapInt32(&b.passThrough, 1, 0) {
+		b.logger("[Safety Valve]:	Reset, admission control re-enabled")
+	}
+}
+
+/*
+Returns false (and trips the safety valve) if the delay is not a usable measurement
+*/
+func (b *Breakwater) isValidDelay(delay float64) bool {
+	if math.IsNaN(delay) || math.IsInf(delay, 0) || delay < 0 {
+		b.tripSafetyValve(fmt.Sprintf("delay estimator returned %f", delay))
+		return false
+	}
+	return true
+}
+
+/*
+Compares the running cIssued counter against the recount from the client map
+*/
+func (b *Breakwater) checkAccountingDrift(counted int64, recounted int64) {
+	drift := counted - recounted
+	if drift < 0 {
+		drift = -drift
+	}
+	if b.maxAccountingDrift > 0 && drift > b.maxAccountingDrift {
+		b.tripSafetyValve(fmt.Sprintf("cIssued drifted by %d credits (counter %d, recount %d)", drift, counted, recounted))
+	}
+}
+
+/*
+Checks whether an RTT update has been holding the rtt lock for too long
+*/
+func (b *Breakwater) checkRTTUpdateStall() {
+	started := atomic.LoadInt64(&b.rttUpdateStarted)
+	if started == 0 || b.maxRTTUpdateStall <= 0 {
+		return
+	}
+	stalled := b.now().Sub(time.Unix(0, started))
+	if stalled.Microseconds() > b.maxRTTUpdateStall {
+		b.tripSafetyValve(fmt.Sprintf("RTT update stalled for %v", stalled))
+	}
+}
@@ -0,0 +1,81 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newSafetyValveBreakwater(reasons *[]string) *Breakwater {
+	params := BWParametersDefault
+	params.SafetyValve = true
+	params.Observer = &Observer{
+		OnAnomaly: func(reason string) { *reasons = append(*reasons, reason) },
+	}
+	return InitBreakwater(params)
+}
+
+func TestSafetyValveTripsOnNaNDelay(t *testing.T) {
+	var reasons []string
+	bw := newSafetyValveBreakwater(&reasons)
+	setDelay(bw, math.NaN())
+
+	bw.rttUpdate()
+
+	if !bw.PassThrough() {
+		t.Errorf("Expected pass-through mode after NaN delay")
+	}
+	if len(reasons) != 1 {
+		t.Errorf("Expected exactly one anomaly notification, got %d", len(reasons))
+	}
+	if bw.cTotal != BWParametersDefault.InitialCredits {
+		t.Errorf("Expected cTotal to stay at %d, got %d", BWParametersDefault.InitialCredits, bw.cTotal)
+	}
+
+	bw.ResetSafetyValve()
+	if bw.PassThrough() {
+		t.Errorf("Expected admission control after reset")
+	}
+}
+
+func TestSafetyValveTripsOnAccountingDrift(t *testing.T) {
+	var reasons []string
+	bw := newSafetyValveBreakwater(&reasons)
+	setDelay(bw, 0)
+	// drift is only checked when the connections are recounted
+	bw.recountRTTs = 1
+	<-bw.cIssued
+	bw.cIssued <- BWParametersDefault.MaxAccountingDrift + 1
+
+	// No clients are registered, so the recount is 0, confirmed by a second one
+	bw.UpdateCredits()
+	bw.UpdateCredits()
+
+	if !bw.PassThrough() {
+		t.Errorf("Expected pass-through mode after accounting drift")
+	}
+}
+
+func TestSafetyValveTripsOnStalledRTTUpdate(t *testing.T) {
+	var reasons []string
+	bw := newSafetyValveBreakwater(&reasons)
+	atomic.StoreInt64(&bw.rttUpdateStarted, time.Now().Add(-2*time.Second).UnixNano())
+
+	bw.checkRTTUpdateStall()
+
+	if !bw.PassThrough() {
+		t.Errorf("Expected pass-through mode after stalled RTT update")
+	}
+}
+
+func TestSafetyValveDisabled(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, -1)
+
+	bw.rttUpdate()
+
+	if bw.PassThrough() {
+		t.Errorf("Expected safety valve to stay closed when disabled")
+	}
+}
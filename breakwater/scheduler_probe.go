@@ -0,0 +1,70 @@
+package breakwater
+
+import (
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const schedulerProbeInterval = time.Millisecond // how often the fallback probe wakes up
+
+// Runtime metric read by the default delay source
+var schedulerLatencyMetric = "/sched/latencies:seconds"
+
+/*
+Returns true if the runtime exports the scheduling latency histogram.
+Runtimes that don't (older releases, some ports) report KindBad.
+*/
+func schedulerLatencySupported() bool {
+	sample := []metrics.Sample{{Name: schedulerLatencyMetric}}
+	metrics.Read(sample)
+	return sample[0].Value.Kind() == metrics.KindFloat64Histogram
+}
+
+/*
+Fallback for the scheduler latency signal when the runtime metric is
+unavailable. A goroutine sleeps for schedulerProbeInterval at a constant
+rate, and how late it wakes up approximates how long runnable goroutines
+wait for a P. The smallest lateness seen is taken as the timer granularity
+of the platform (about 15ms on some Windows configurations) and subtracted.
+The probe is started by the first sample, so clients never run it.
+*/
+type schedulerProbe struct {
+	start       sync.Once
+	maxLateness int64 // largest lateness since the last sample in microseconds, accessed atomically
+	minLateness int64 // timer granularity in microseconds, only accessed by the probe goroutine
+}
+
+func newSchedulerProbe() *schedulerProbe {
+	return &schedulerProbe{minLateness: -1}
+}
+
+func (p *schedulerProbe) run() {
+	for {
+		before := time.Now()
+		time.Sleep(schedulerProbeInterval)
+		lateness := (time.Since(before) - schedulerProbeInterval).Microseconds()
+		if p.minLateness < 0 || lateness < p.minLateness {
+			p.minLateness = lateness
+		}
+		p.record(lateness - p.minLateness)
+	}
+}
+
+func (p *schedulerProbe) record(us int64) {
+	for {
+		prev := atomic.LoadInt64(&p.maxLateness)
+		if us <= prev || atomic.CompareAndSwapInt64(&p.maxLateness, prev, us) {
+			return
+		}
+	}
+}
+
+/*
+Delay source, the largest probe lateness since the previous sample
+*/
+func (p *schedulerProbe) delay() float64 {
+	p.start.Do(func() { go p.run() })
+	return float64(atomic.SwapInt64(&p.maxLateness, 0))
+}
@@ -0,0 +1,58 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnsupportedSchedulerMetricFallsBack(t *testing.T) {
+	supported := schedulerLatencyMetric
+	schedulerLatencyMetric = "/sched/unsupported:seconds"
+	t.Cleanup(func() { schedulerLatencyMetric = supported })
+
+	if readHistogram() != nil {
+		t.Fatalf("Expected no histogram for an unsupported metric")
+	}
+	var reasons []string
+	params := BWParametersDefault
+	params.Observer = &Observer{OnDegraded: func(reason string) { reasons = append(reasons, reason) }}
+	bw := InitBreakwater(params)
+	if len(reasons) != 1 {
+		t.Fatalf("Expected the observer to be told about the fallback once, got %v", reasons)
+	}
+	// the first sample starts the probe
+	if delay := bw.getDelay(); delay < 0 {
+		t.Errorf("Expected a valid delay, got %f", delay)
+	}
+	time.Sleep(10 * schedulerProbeInterval)
+	if delay := bw.getDelay(); delay < 0 || delay > float64(time.Second.Microseconds()) {
+		t.Errorf("Expected a plausible probed delay, got %f us", delay)
+	}
+}
+
+func TestSchedulerProbeKeepsLargest(t *testing.T) {
+	p := newSchedulerProbe()
+	p.start.Do(func() {}) // keep the probe goroutine from running
+	for _, us := range []int64{200, 900, 400} {
+		p.record(us)
+	}
+	if delay := p.delay(); delay != 900 {
+		t.Errorf("Expected the largest lateness of 900 us, got %f", delay)
+	}
+	if delay := p.delay(); delay != 0 {
+		t.Errorf("Expected the lateness to reset after a sample, got %f", delay)
+	}
+}
+
+func TestSchedulerMetricSupported(t *testing.T) {
+	if !schedulerLatencySupported() {
+		t.Skip("runtime does not export the scheduling latency histogram")
+	}
+	var degraded bool
+	params := BWParametersDefault
+	params.Observer = &Observer{OnDegraded: func(string) { degraded = true }}
+	InitBreakwater(params)
+	if degraded {
+		t.Errorf("Expected no fallback when the runtime metric is supported")
+	}
+}
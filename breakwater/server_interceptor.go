@@ -5,14 +5,11 @@ import (
 	"fmt"
 	"math"
 	"runtime/metrics"
-	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 /*
@@ -46,45 +43,65 @@ loaded is true if the value was loaded instead of stored
 
 // Jiali: We need another fast function for server side interceptor to check and register client
 func (b *Breakwater) RegisterClient(id uuid.UUID, demand int64) {
-	// Check if the client already exists, if so, return.
-	if _, exists := b.clientMap.Load(id); exists {
+	// Check if the client already exists, if so, refresh it and return.
+	if c, exists := b.clients.load(id); exists {
+		atomic.StoreInt64(&c.lastSeen, b.now().UnixNano())
 		return
 	}
 
 	// Only create a new Connection if the client does not already exist.
-	c := Connection{
-		issued:          0,
-		issuedWriteLock: make(chan int64, 1),
-		demand:          demand,
-		demandWriteLock: make(chan int64, 1),
-		id:              id,
-		lastUpdated:     make(chan time.Time, 1),
-	}
-	c.demandWriteLock <- 1
-	c.issuedWriteLock <- 1
-	c.lastUpdated <- time.Now().Add(-1 * time.Second)
-
-	// Use LoadOrStore to attempt to store the new connection, and also safely check if it was already present.
-	b.clientMap.Store(id, c)
-	num := <-b.numClients
-	b.numClients <- num + 1
-	return
+	c := &Connection{
+		issued:      0,
+		id:          id,
+		lastUpdated: b.now().Add(-1 * time.Second),
+		lastSeen:    b.now().UnixNano(),
+		weight:      1,
+	}
+	// held until its demand is counted, so a grant cannot overtake it
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Use loadOrStore so a client registered concurrently is only counted once
+	if existing, loaded := b.clients.loadOrStore(id, c); loaded {
+		atomic.StoreInt64(&existing.lastSeen, b.now().UnixNano())
+		return
+	}
+	b.recordDemand(c, demand, b.now())
 }
 
 /*
-Helper to get current time delay
+Helper to get current time delay, including the backlog of application
+queues, the worker pool, GC pressure and CFS throttling if counted
 */
 func (b *Breakwater) getDelay() float64 {
+	delay := math.Max(b.delaySource(), b.appQueueDelay())
+	if b.gcPressure != nil {
+		delay = math.Max(delay, b.gcPressure.Delay())
+	}
+	if b.workers != nil {
+		delay = math.Max(delay, b.workers.delay())
+	}
+	if b.throttling != nil && b.throttling.sustained() {
+		delay = math.Max(delay, b.aqmDelay())
+	}
+	return delay + float64(b.injectedFaults().ExtraDelay.Microseconds())
+}
+
+/*
+Default delay source, the largest scheduling latency observed by the Go
+runtime since the previous sample
+*/
+func (b *Breakwater) schedulerDelay() float64 {
 	// get the current histogram
 	b.currHist = readHistogram()
 
-	if b.prevHist == nil {
+	if b.prevHist == nil || b.currHist == nil {
 		// If prevHist is nil, there is no previous data to compute latency against
 		b.prevHist = b.currHist
 		return 0.0
 	}
 
-	gapLatency := maximumQueuingDelayus(b.prevHist, b.currHist) // in microseconds
+	gapLatency := histogramDelay(b.prevHist, b.currHist, b.delayPercentile) // in microseconds
 	// Store the current histogram for future reference
 	b.prevHist = b.currHist
 
@@ -102,10 +119,10 @@ func maximumQueuingDelayus(earlier, later *metrics.Float64Histogram) float64 {
 	return 0
 }
 
-// this function reads the currHist from metrics
+// this function reads the currHist from metrics, nil if the runtime does not support it
 func readHistogram() *metrics.Float64Histogram {
 	// Create a sample for metric /sched/latencies:seconds and /sync/mutex/wait/total:seconds
-	const queueingDelay = "/sched/latencies:seconds"
+	queueingDelay := schedulerLatencyMetric
 	measureMutexWait := false
 
 	// Create a sample for the metric.
@@ -121,9 +138,9 @@ func readHistogram() *metrics.Float64Histogram {
 
 	// Check if the metric is actually supported.
 	// If it's not, the resulting value will always have
-	// kind KindBad.
-	if sample[0].Value.Kind() == metrics.KindBad {
-		panic(fmt.Sprintf("metric %q no longer supported", queueingDelay))
+	// kind KindBad. InitBreakwater falls back to a probe then.
+	if sample[0].Value.Kind() != metrics.KindFloat64Histogram {
+		return nil
 	}
 
 	// get the current histogram
@@ -132,15 +149,16 @@ func readHistogram() *metrics.Float64Histogram {
 	return currHist
 }
 
-// Helper to calculate A additive Factor
-func (b *Breakwater) getAdditiveFactor() int64 {
-	numClients := <-b.numClients
-	b.numClients <- numClients
-	return max(roundedInt(b.aFactor*float64(numClients)), 1)
+// Helper to calculate A additive Factor, at least one credit, fractions
+// of a credit above it add up across RTTs
+func (b *Breakwater) getAdditiveFactor() float64 {
+	numClients := b.clients.len()
+	return math.Max(b.aFactor*float64(numClients), 1)
 }
 
 func (b *Breakwater) getMultiplicativeFactor(delay float64) float64 {
-	adjustingFactor := 1.0 - b.bFactor*((delay-b.thresholdDelay)/b.thresholdDelay)
+	thresholdDelay := b.thresholdDelay()
+	adjustingFactor := 1.0 - b.bFactor*((delay-thresholdDelay)/thresholdDelay)
 	adjustingFactor = math.Max(adjustingFactor, 0.5)
 	return adjustingFactor
 }
@@ -163,22 +181,43 @@ Runs once every RTT
 1. Check the queueing delay against SLA
 2. If queueing delay is within SLA, increase cTotal additively
 3. If queueing delay is beyond SLA, decrease cTotal multiplicatively
+With AlgorithmGradient, cTotal follows the RPC latency gradient instead
+Either way cTotal follows the warm-up schedule after startup, and stays
+within MinCredits and MaxCredits
 */
 func (b *Breakwater) getUpdatedTotalCredits() int64 {
+	b.credits = b.boundCredits(b.warmUp(b.nextTotalCredits()))
+	return roundedInt(b.credits)
+}
+
+func (b *Breakwater) nextTotalCredits() float64 {
+	if b.gradient != nil {
+		return float64(b.gradient.update(b.cTotal))
+	}
+	credits := b.exactCredits()
 	delay := b.getDelay()
+	if !b.isValidDelay(delay) {
+		b.logger("[Updating credits]: Invalid delay %f, keeping cTotal", delay)
+		return credits
+	}
+	delay, ready := b.smoothDelay(delay)
+	if !ready {
+		b.logger("[Updating credits]: %d of %d delay samples, keeping cTotal", b.delaySamples, b.delayMinSamples)
+		return credits
+	}
 
-	if delay < b.thresholdDelay {
-		logger("[Updating credits]: Within SLA")
+	if delay < b.thresholdDelay() {
+		b.logger("[Updating credits]: Within SLA")
 		addFactor := b.getAdditiveFactor()
-		return b.cTotal + addFactor
+		return b.recoverCredits(addFactor)
 		// b.cTotal += addFactor
 	} else {
-		logger("[Updating credits]: Beyond SLA, delay is %f threshold is %f", delay, b.thresholdDelay)
+		b.logger("[Updating credits]: Beyond SLA, delay is %f threshold is %f", delay, b.thresholdDelay())
 		adjustingFactor := b.getMultiplicativeFactor(delay)
-		newTotal := roundedInt(adjustingFactor * float64(b.cTotal))
+		newTotal := adjustingFactor * credits
 		// Addresses edge case: credits is 0, but we need to process at least 1 request
 		// as credits are calculated lazily
-		return max(newTotal, 1)
+		return math.Max(newTotal, 1)
 		// TODO: Is there need to send negative credits here? Breakwater is unclear but likely not
 	}
 }
@@ -191,99 +230,118 @@ Every RTT,
 (2) reset greatestDelay
 */
 func (b *Breakwater) rttUpdate() {
-	timeSinceLastUpdate := time.Since(b.lastUpdateTime)
-	if timeSinceLastUpdate.Microseconds() > RTT_MICROSECOND {
-		if b.isRTTUnlocked() {
-			if loadShedding {
-				newDelay := b.getDelay() // Assume this function returns the new delay
-				b.queueingDelayChan <- DelayOperation{Value: newDelay}
-				// log the delay
-				logger("[RTT Update]: delay is %f", newDelay)
-			}
-			prevCTotal := b.cTotal
-			b.lastUpdateTime = time.Now()
-
-			// Re-calculate total issued (should not be too expensive as # clients are limited)
-			var totalIssued int64 = 0
-			b.clientMap.Range(func(key, value interface{}) bool {
-				totalIssued += value.(Connection).issued
-				return true
-			})
-			<-b.cIssued
-			b.cIssued <- totalIssued
-			b.cTotal = b.getUpdatedTotalCredits()
-
-			// // Reset greatest delay
-			// <-b.prevGreatestDelay
-			// b.prevGreatestDelay <- <-b.currGreatestDelay
-			// b.currGreatestDelay <- 0
-
-			logger("[Updating credits]: prev cTotal: %d, new cTotal: %d, cIssued: %d", prevCTotal, b.cTotal, totalIssued)
-			b.rttLock <- 1
-		}
+	if b.rttUpdateDue() && b.isRTTUnlocked() {
+		b.updateCredits()
 	}
 }
 
 /*
-Number of over-committed credits per client
+Sets the time of the last RTT update, called holding rttLock
 */
-func (b *Breakwater) calculateCreditsToOvercommit() int64 {
-	numClients := <-b.numClients
-	b.numClients <- numClients
-	cIssued := <-b.cIssued
-	b.cIssued <- cIssued
-	return roundedInt(math.Max(float64(b.cTotal-cIssued)/float64(numClients), 1))
+func (b *Breakwater) setLastUpdate(t time.Time) {
+	b.lastUpdateTime = t
+	atomic.StoreInt64(&b.lastUpdateNanos, t.UnixNano())
 }
 
 /*
-Returns the lower of demand + cOvercommit
-and cPrevious - 1
+Returns true if an RTT has passed since the last update
 */
-func (b *Breakwater) getLowerCreditsIssued(cOvercommit int64, demand int64, cPrevious int64) int64 {
-	if (demand + cOvercommit) < 0 {
-		logger("WARNING: demand + cOvercommit < 0")
-		return 1
-	}
-	cNew := min(demand+cOvercommit, cPrevious-1)
-	return cNew
+func (b *Breakwater) rttUpdateDue() bool {
+	return !b.manualUpdates && b.now().Sub(time.Unix(0, atomic.LoadInt64(&b.lastUpdateNanos))).Microseconds() > b.updateInterval()
+}
+
+/*
+Runs an RTT update now, however recently the last one ran. With
+BWParameters.ManualUpdates set this is the only way credits are updated,
+so simulations and external timers decide when updates happen.
+*/
+func (b *Breakwater) UpdateCredits() {
+	<-b.rttLock
+	b.updateCredits()
 }
 
 /*
-Returns the lower of demand + cOvercommit
-and cCurr + cAvail (ie we cannot add more than cAvail)
+Body of an RTT update, called holding rttLock which it releases
 */
-func (b *Breakwater) getHigherCreditsIssued(cOvercommit int64, demand int64, cPrevious int64) int64 {
-	if (demand + cOvercommit) < 0 {
-		logger("WARNING: demand + cOvercommit < 0")
-		return 1
+func (b *Breakwater) updateCredits() {
+	atomic.StoreInt64(&b.rttUpdateStarted, b.now().UnixNano())
+	var newDelay float64
+	if b.throttling != nil {
+		b.throttling.sample()
+	}
+	if b.loadShedding {
+		newDelay = b.getDelay() // Assume this function returns the new delay
+		if b.isValidDelay(newDelay) {
+			b.storeQueueingDelay(b.smoothQueueingDelay(newDelay))
+			b.updateHealth(newDelay)
+			b.calibrate(newDelay)
+		}
+		// log the delay
+		b.logger("[RTT Update]: delay is %f", newDelay)
+	}
+	prevCTotal := b.cTotal
+	windowStart := b.lastUpdateTime
+	b.setLastUpdate(b.now())
+
+	b.evictIdleClients()
+	b.reclaimExpiredLeases()
+
+	totalIssued, recount := b.issuedAtUpdate()
+	b.updateMeasuredRTT()
+	atomic.StoreInt64(&b.cTotal, b.getUpdatedTotalCredits())
+	b.recordUpdate(newDelay, prevCTotal, totalIssued)
+	b.decayIdleDemand(windowStart)
+	if b.shouldRevoke(newDelay) {
+		b.revokeCredits()
+	}
+	if b.weightedFairSharing {
+		b.allocateFairShares()
+	}
+	if b.tenantQuotas {
+		b.recountTenants()
+	}
+	if b.grantMode == GrantModeRTT {
+		b.recomputeGrants()
+	}
+
+	// // Reset greatest delay
+	// <-b.prevGreatestDelay
+	// b.prevGreatestDelay <- <-b.currGreatestDelay
+	// b.currGreatestDelay <- 0
+
+	b.logger("[Updating credits]: prev cTotal: %d, new cTotal: %d, cIssued: %d", prevCTotal, b.cTotal, totalIssued)
+	atomic.StoreInt64(&b.rttUpdateStarted, 0)
+	b.rttLock <- 1
+
+	if recount {
+		b.recountIssued()
 	}
-	cIssued := <-b.cIssued
-	b.cIssued <- cIssued
 
-	cAvail := b.cTotal - cIssued
-	cNew := min(demand+cOvercommit, cPrevious+cAvail)
-	// logger("cAvail: %d, cNew: %d, cOvercommit %d, cTotal %d, cPrevious %d", cAvail, cNew, cOvercommit, b.cTotal, cPrevious)
-	return cNew
+	// Clients waiting on credits get their new grants without sending a request
+	b.issueRTTGrants()
 }
 
-func (b *Breakwater) calculateCreditsToIssue(demand int64, connCPrevious int64) (cNew int64) {
-	cOverCommit := b.calculateCreditsToOvercommit()
-	logger("[Issuing credits]: cOverCommit is %d", cOverCommit)
+/*
+Number of over-committed credits per client, the unissued credits split
+evenly across clients with demand, bounded by minOvercommit and
+maxOvercommit (if set).
+Zero if overcommitment is disabled, minOvercommit with no clients.
+*/
+func (b *Breakwater) calculateCreditsToOvercommit() int64 {
+	if b.disableOvercommit {
+		return 0
+	}
+	if b.registryEmpty() {
+		return b.minOvercommit
+	}
+	numClients := b.overcommitClients()
 	cIssued := <-b.cIssued
 	b.cIssued <- cIssued
-
-	// Here, b.cIssued is OVERALL issued credits, while c.issued is credits issued to a connection
-	if cIssued < b.cTotal {
-		// There is still space to issue credits
-		logger("[Issuing credits]: Under limit, cIssued is %d, cTotal is %d", cIssued, b.cTotal)
-		cNew = b.getHigherCreditsIssued(cOverCommit, demand, connCPrevious)
-	} else {
-		// At credit limit, so we only decrease
-		logger("[Issuing credits]: Over limit, cIssued is %d, cTotal is %d", cIssued, b.cTotal)
-		cNew = b.getLowerCreditsIssued(cOverCommit, demand, connCPrevious)
+	cOvercommit := roundedInt(math.Max(float64(b.getCTotal()-cIssued)/float64(numClients), float64(b.minOvercommit)))
+	if b.maxOvercommit > 0 {
+		cOvercommit = min(cOvercommit, b.maxOvercommit)
 	}
-
-	return max(cNew, 1)
+	return cOvercommit
 }
 
 /*
@@ -297,50 +355,72 @@ Ideal to be issued is demandX + cOC, but limited by total available (cTotal - cI
 We need to rate limit, so we issue demandX + cOC, OR just cX - 1 (ie we do not grant any new credits)
 */
 func (b *Breakwater) updateCreditsToIssue(clientID uuid.UUID, demand int64) (cNew int64) {
+	return b.issueCredits(clientID, demand, BestEffort, 1)
+}
 
-	connection, ok := b.clientMap.Load(clientID)
+/*
+Updates credits issued to a connection on a request of the given priority
+class that cost the client the given credits
+*/
+func (b *Breakwater) issueCredits(clientID uuid.UUID, demand int64, class PriorityClass, cost int64) (cNew int64) {
+
+	c, ok := b.clients.load(clientID)
 	if !ok {
-		logger("WARNING: client not found")
+		b.logger("WARNING: client not found")
 		// throw an error
 		return 0
 	}
-	c := connection.(Connection)
 
 	// Lock the connections issued credits
-	<-c.issuedWriteLock
-
-	connection, _ = b.clientMap.Load(clientID)
-	c = connection.(Connection)
-
-	connTimeOfLastUpdate := <-c.lastUpdated
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// recomputed on the first request after an RTT update
+	return b.grantLocked(c, demand, class, cost, c.lastUpdated.UnixNano() <= atomic.LoadInt64(&b.lastUpdateNanos))
+}
 
-	connCPrevious := c.issued
-	if connTimeOfLastUpdate.After(b.lastUpdateTime) {
+/*
+Body of issueCredits, called holding c.mu. Recomputes the connection's
+grant if recompute is set, and otherwise takes the cost of the request off
+its credits.
+*/
+func (b *Breakwater) grantLocked(c *Connection, demand int64, class PriorityClass, cost int64, recompute bool) (cNew int64) {
+	clientID := c.id
+	connCPrevious := atomic.LoadInt64(&c.issued)
+	if !recompute {
 		// It was already updated after the last RTT update
-		logger("[Issuing credits]: Auto Decr")
-		cNew = max(connCPrevious-1, 1)
+		b.logger("[Issuing credits]: Auto Decr")
+		cNew = max(connCPrevious-cost, b.minGrant())
+	} else if b.weightedFairSharing {
+		b.logger("[Issuing credits]: Post RTT, fair share")
+		cNew = b.capGrantIncrease(connCPrevious, max(c.share, b.minGrant()))
 	} else {
-		// not yet updated after the last RT update, so have to update
-		logger("[Issuing credits]: Post RTT")
-		cNew = b.calculateCreditsToIssue(demand, connCPrevious)
+		b.logger("[Issuing credits]: Post RTT")
+		cNew = b.capGrantIncrease(connCPrevious, b.calculateCreditsToIssue(demand, connCPrevious, c.weight))
+	}
+
+	cNew = b.capToClassPool(class, connCPrevious, cNew)
+	if b.tenantQuotas {
+		cNew = b.capToTenantQuota(c.tenant, connCPrevious, cNew)
 	}
 
-	logger("[Issuing credits]: Client %s, cPrev issued: %d, cNew: %d", clientID, connCPrevious, cNew)
+	if b.verbose {
+		b.logger("[Issuing credits]: Client %s, cPrev issued: %d, cNew: %d", clientID, connCPrevious, cNew)
+	}
 
 	// update conn credits
-	c.issued = cNew
-	b.clientMap.Store(clientID, c)
+	atomic.StoreInt64(&c.issued, cNew)
+	b.recordDemand(c, demand, b.now())
+	// this grant supersedes one left over from an RTT update
+	c.pendingGrant = 0
+	c.lastUpdated = b.now()
 
 	// update overall cIssued
 	diff := cNew - connCPrevious
 	prevCIssued := <-b.cIssued
 	b.cIssued <- prevCIssued + diff
 	if (prevCIssued + diff) < 0 {
-		logger("WARNING: cIssued < 0")
+		b.invariantViolated(fmt.Sprintf("cIssued fell to %d", prevCIssued+diff))
 	}
-
-	c.issuedWriteLock <- 1
-	c.lastUpdated <- time.Now()
 	return
 }
 
@@ -353,60 +433,51 @@ It should
 4. Occassionally update cTotal
 */
 func (b *Breakwater) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	if loadShedding {
-		responseChan := make(chan float64)
-		b.queueingDelayChan <- DelayOperation{Response: responseChan}
-		queueingDelay := <-responseChan // This will wait for the response
-		// logger("[Req handled]: Server-side queuing delay is %f microseconds", queueingDelay)
-
-		if queueingDelay < b.aqmDelay {
-			logger("[Load Shedding] not applied, server-side queuing delay %f us is within AQM threshold", queueingDelay)
-		} else {
-			logger("[Load Shedding] applied, server-side queuing delay %f us is beyond AQM threshold", queueingDelay)
-			return nil, status.Errorf(codes.ResourceExhausted, "Server-side queuing delay is beyond AQM threshold")
-		}
-	}
-
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, errMissingMetadata
-	}
-
-	demand, err1 := strconv.ParseInt(md["demand"][0], 10, 64)
-	clientId, err2 := uuid.Parse(md["id"][0])
-	// reqId, err3 := uuid.Parse(md["reqid"][0])
-
-	if err1 != nil || err2 != nil {
-		logger("[Received Req]:	Error: malformed metadata")
-		return nil, errMissingMetadata
+	if b.interceptedTwice(ctx) {
+		return handler(ctx, req)
 	}
-
-	logger("[Received Req]:	ClientId: %s, Demand %d", clientId, demand)
-
-	// Register client if unregistered
-	b.RegisterClient(clientId, demand)
-
-	issuedCredits := b.updateCreditsToIssue(clientId, demand)
-	logger("[Received Req]:	issued credits is %d", issuedCredits)
-
-	// Piggyback updated credits issued
-	header := metadata.Pairs("credits", strconv.FormatInt(issuedCredits, 10))
-	// grpc.SendHeader(ctx, header)
+	ctx = withIntercepted(ctx)
+	admission, err := b.Admit(ctx)
 	// Set the header to be sent with the response or error
-	err := grpc.SetHeader(ctx, header)
+	if len(admission.Header) > 0 {
+		if err := grpc.SetHeader(ctx, admission.Header); err != nil {
+			b.logger("Failed to set header: %v", err)
+		}
+	}
 	if err != nil {
-		logger("Failed to set header: %v", err)
+		return nil, err
 	}
 
 	// Call the handler function to handle the request
-	logger("[Handling Req]:	Handling req")
-	m, err := handler(ctx, req)
-
-	// Does update once every rtt in separate goroutine
-	go b.rttUpdate()
+	var m interface{}
+	if admission.duplicate {
+		b.logger("[Handling Req]:	Replaying req %s", admission.dedup.key.reqid)
+		m, err = admission.dedup.wait(ctx)
+	} else if admission.degraded {
+		b.logger("[Handling Req]:	Degrading req")
+		m, err = b.degradeFunc(admission.Context(ctx), req)
+	} else {
+		b.logger("[Handling Req]:	Handling req")
+		if admission.dedup != nil {
+			m, err = b.callClaimed(admission.Context(ctx), admission.dedup, req, info, handler)
+		} else {
+			m, err = b.callHandler(admission.Context(ctx), req, info, handler)
+		}
+	}
+	header, trailer := admission.Done()
+	if len(header) > 0 {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			b.logger("Failed to set header: %v", err)
+		}
+	}
+	if len(trailer) > 0 {
+		if err := grpc.SetTrailer(ctx, trailer); err != nil {
+			b.logger("Failed to set trailer: %v", err)
+		}
+	}
 
 	if err != nil {
-		logger("RPC failed with error %v", err)
+		b.logger("RPC failed with error %v", err)
 	}
 	return m, err
 }
@@ -464,13 +535,16 @@ Used as a simple test for client side interceptors
 // }
 
 func (b *Breakwater) PrintOutgoingCredits() {
-	o := <-b.outgoingCredits
-	logger("Outgoing credits: ", o)
-	b.outgoingCredits <- o
+	b.targets.Range(func(key, value interface{}) bool {
+		t := value.(*clientTarget)
+		o := <-t.outgoingCredits
+		b.logger("Outgoing credits for %s: %d", t.target, o)
+		t.outgoingCredits <- o
+		return true
+	})
 }
 
 /*
 TODO List of harder problems:
-1. Deterministically de-queue client side
-2. Measure queuing delay more precisely instead of using processing time
+1. Measure queuing delay more precisely instead of using processing time
 */
@@ -0,0 +1,153 @@
+package breakwater
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errClosed = status.Error(codes.Unavailable, "breakwater closed")
+
+/*
+Optional bounded server side queue.
+Instead of shedding a request as soon as the queueing delay passes the AQM
+threshold, the request waits here until the delay falls back under the
+threshold or its deadline passes, which smooths out short bursts.
+*/
+type serverQueue struct {
+	entries chan *queuedRequest
+	slots   chan struct{} // one token per waiting request, bounds the queue length
+	timeout time.Duration // longest a request may wait in the queue
+}
+
+/*
+A request waiting in the server queue. admit receives nil once the request
+may be handed to the handler, or the error to fail it with.
+*/
+type queuedRequest struct {
+	ctx        context.Context
+	deadline   time.Time
+	class      PriorityClass   // shed against its own multiple of the AQM threshold
+	thresholds delayThresholds // of the request's method
+	admit      chan error
+}
+
+func newServerQueue(capacity int64, timeout time.Duration) *serverQueue {
+	return &serverQueue{
+		entries: make(chan *queuedRequest, capacity),
+		slots:   make(chan struct{}, capacity),
+		timeout: timeout,
+	}
+}
+
+/*
+Helper to read the smoothed queueing delay as of the last RTT update. Every
+request reads it, so it is a single atomic load rather than a round trip
+to a goroutine owning it.
+*/
+func (b *Breakwater) readQueueingDelay() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&b.queueingDelay))
+}
+
+/*
+Publishes the queueing delay admission compares to the AQM threshold
+*/
+func (b *Breakwater) storeQueueingDelay(delay float64) {
+	atomic.StoreUint64(&b.queueingDelay, math.Float64bits(delay))
+}
+
+/*
+Places a request that tripped AQM into the server queue and waits for the
+drain worker. Returns nil once the request may be handled, an error if the
+queue is full, the request expired in the queue, ctx is done or the
+Breakwater is closed.
+*/
+func (b *Breakwater) enqueueRequest(ctx context.Context, class PriorityClass, t delayThresholds) error {
+	q := b.serverQueue
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		b.logShed("[Server Queue]:	Queue full, shedding request")
+		queueingDelay := b.readQueueingDelay()
+		return ServerQueueFullError(b.id.String(), queueingDelay, b.serverRetryDelay(queueingDelay, t))
+	}
+
+	deadline := time.Now().Add(q.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	e := &queuedRequest{ctx: ctx, deadline: deadline, class: class, thresholds: t, admit: make(chan error, 1)}
+	q.entries <- e
+	b.logger("[Server Queue]:	Queued request, %d waiting", len(q.slots))
+
+	select {
+	case err := <-e.admit:
+		return err
+	case <-ctx.Done():
+		// the drain worker drops the entry when it reaches it
+		return status.FromContextError(ctx.Err()).Err()
+	case <-b.stop:
+		return errClosed
+	}
+}
+
+/*
+Drain worker for the server queue. Takes requests in arrival order and hands
+each to the handler once admission would no longer shed it at its class's
+threshold, failing it instead if it expires first. Since queued requests do
+not reach the handler, the worker drives the RTT updates itself while it
+waits. It returns once the Breakwater is closed, and the waiting requests
+fail.
+*/
+func (b *Breakwater) drainServerQueue() {
+	q := b.serverQueue
+	poll := time.Duration(b.getRTT()) * time.Microsecond
+	for {
+		var e *queuedRequest
+		select {
+		case e = <-q.entries:
+		case <-b.stop:
+			return
+		}
+		for {
+			if err := e.ctx.Err(); err != nil {
+				e.admit <- status.FromContextError(err).Err()
+				break
+			}
+			b.rttUpdate()
+			queueingDelay := b.readQueueingDelay()
+			if time.Now().After(e.deadline) {
+				// ctx may not be done yet when its own deadline is the one that passed
+				if ctxDeadline, ok := e.ctx.Deadline(); ok && !ctxDeadline.After(e.deadline) {
+					e.admit <- status.FromContextError(context.DeadlineExceeded).Err()
+					break
+				}
+				b.logShed("[Server Queue]:	Request expired in queue")
+				e.admit <- ServerQueueExpirationError(b.id.String(), queueingDelay, b.serverRetryDelay(queueingDelay, e.thresholds))
+				break
+			}
+			if !b.shouldShed(queueingDelay, e.class, e.thresholds) {
+				b.logger("[Server Queue]:	Admitting request to handler")
+				e.admit <- nil
+				break
+			}
+			wait := time.Until(e.deadline)
+			if wait > poll {
+				wait = poll
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-b.stop:
+				timer.Stop()
+				e.admit <- errClosed
+				return
+			}
+		}
+		<-q.slots
+	}
+}
@@ -0,0 +1,128 @@
+package breakwater
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Creates a breakwater with a server queue whose delay source can be
+// changed while the drain worker is running
+func newQueueingBreakwater(length int64, timeout int64) (*Breakwater, *uint64) {
+	params := BWParametersDefault
+	params.ServerQueueLength = length
+	params.ServerQueueTimeout = timeout
+	bw := InitBreakwater(params)
+	delay := new(uint64)
+	bw.delaySource = func() float64 { return math.Float64frombits(atomic.LoadUint64(delay)) }
+	return bw, delay
+}
+
+func setQueueingDelay(bw *Breakwater, delay *uint64, d float64) {
+	atomic.StoreUint64(delay, math.Float64bits(d))
+	bw.storeQueueingDelay(d)
+}
+
+func TestServerQueueExpires(t *testing.T) {
+	bw, delay := newQueueingBreakwater(10, 20000)
+	setQueueingDelay(bw, delay, 1000)
+
+	start := time.Now()
+	err := bw.enqueueRequest(context.Background(), BestEffort, bw.thresholdsFor(""))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("Expected request to wait for its deadline, waited %v", waited)
+	}
+}
+
+func TestServerQueueAdmitsWhenDelayDrops(t *testing.T) {
+	bw, delay := newQueueingBreakwater(10, 1000000)
+	setQueueingDelay(bw, delay, 1000)
+
+	result := make(chan error)
+	go func() { result <- bw.enqueueRequest(context.Background(), BestEffort, bw.thresholdsFor("")) }()
+
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreUint64(delay, math.Float64bits(0))
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Expected request to be admitted, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected request to be admitted once the delay dropped")
+	}
+}
+
+func TestServerQueueFull(t *testing.T) {
+	bw, delay := newQueueingBreakwater(1, 1000000)
+	setQueueingDelay(bw, delay, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go bw.enqueueRequest(ctx, BestEffort, bw.thresholdsFor(""))
+	for len(bw.serverQueue.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := bw.enqueueRequest(context.Background(), BestEffort, bw.thresholdsFor(""))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected a full queue to shed the request, got %v", err)
+	}
+}
+
+func TestServerQueueRespectsContextDeadline(t *testing.T) {
+	bw, delay := newQueueingBreakwater(10, 1000000)
+	setQueueingDelay(bw, delay, 1000)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := bw.enqueueRequest(ctx, BestEffort, bw.thresholdsFor(""))
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestServerQueueClosed(t *testing.T) {
+	before := runtime.NumGoroutine()
+	bw, delay := newQueueingBreakwater(10, 1000000)
+	setQueueingDelay(bw, delay, 1000)
+
+	result := make(chan error)
+	go func() { result <- bw.enqueueRequest(context.Background(), BestEffort, bw.thresholdsFor("")) }()
+	time.Sleep(20 * time.Millisecond)
+	bw.Close()
+
+	select {
+	case err := <-result:
+		if status.Code(err) != codes.Unavailable {
+			t.Errorf("Expected Unavailable once closed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected the queued request to fail once closed")
+	}
+	expectGoroutinesExit(t, before)
+}
+
+func TestServerQueueAdmitsByClass(t *testing.T) {
+	bw, delay := newQueueingBreakwater(10, 50000)
+	thresholds := bw.thresholdsFor("")
+	// past the BestEffort threshold but within the CRITICAL one
+	setQueueingDelay(bw, delay, thresholds.aqm*1.5)
+
+	if err := bw.enqueueRequest(context.Background(), Critical, thresholds); err != nil {
+		t.Errorf("Expected a CRITICAL request to be admitted under its own threshold, got %v", err)
+	}
+	if err := bw.enqueueRequest(context.Background(), BestEffort, thresholds); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected a BestEffort request to expire in the queue, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package breakwater
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const calibrationPercentile = 0.99 // percentile of the baseline delay the SLO is derived from
+
+/*
+SLO calibration
+Instead of guessing an SLO that matches the hardware, the server can observe
+its queueing delay for CalibrationPeriod after startup, at low load, and
+set thresholdDelay to CalibrationFactor times the p99 of the delays it saw.
+aqmDelay follows thresholdDelay as with SetSLO. Until then the configured
+SLO applies.
+*/
+type calibration struct {
+	until   time.Time
+	factor  float64
+	samples []float64 // delay at each RTT update in microseconds
+}
+
+/*
+Records the delay of an RTT update while calibrating, and derives the SLO
+once the calibration period is over. Called under the rtt lock.
+*/
+func (b *Breakwater) calibrate(delay float64) {
+	c := b.calibration
+	if c == nil {
+		return
+	}
+	c.samples = append(c.samples, delay)
+	if b.now().Before(c.until) {
+		return
+	}
+	b.calibration = nil
+
+	sort.Float64s(c.samples)
+	baseline := c.samples[int(math.Ceil(calibrationPercentile*float64(len(c.samples))))-1]
+	thresholdDelay := baseline * c.factor
+	if thresholdDelay <= 0 {
+		b.logger("[SLO Calibration]:	No queueing delay observed in %d samples, keeping SLO %d", len(c.samples), atomic.LoadInt64(&b.SLO))
+		return
+	}
+	b.logger("[SLO Calibration]:	Baseline p99 delay %f us over %d samples", baseline, len(c.samples))
+	b.setSLO(max(roundedInt(thresholdDelay/DELAY_THRESHOLD_PERCENT), 1))
+}
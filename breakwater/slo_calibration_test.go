@@ -0,0 +1,43 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrationDerivesSLO(t *testing.T) {
+	params := BWParametersDefault
+	params.CalibrationPeriod = 1000000
+	bw := InitBreakwater(params)
+
+	for delay := 1; delay <= 100; delay++ {
+		bw.calibrate(float64(delay))
+	}
+	if slo := bw.Snapshot().SLO; slo != BWParametersDefault.SLO {
+		t.Fatalf("Expected the configured SLO while calibrating, got %d", slo)
+	}
+	bw.calibration.until = time.Now()
+	bw.calibrate(0)
+
+	// the p99 of 0..100 us is 99 us, so thresholdDelay becomes 198 us
+	if bw.thresholdDelay() != 198 || bw.aqmDelay() != 396 {
+		t.Errorf("Expected thresholds of 198 and 396 us, got %f and %f", bw.thresholdDelay(), bw.aqmDelay())
+	}
+	if slo := bw.Snapshot().SLO; slo != 495 {
+		t.Errorf("Expected a calibrated SLO of 495 us, got %d", slo)
+	}
+	if bw.calibration != nil {
+		t.Errorf("Expected calibration to stop")
+	}
+}
+
+func TestCalibrationWithoutDelayKeepsSLO(t *testing.T) {
+	params := BWParametersDefault
+	params.CalibrationPeriod = 1
+	bw := InitBreakwater(params)
+	time.Sleep(time.Millisecond)
+	bw.calibrate(0)
+	if bw.SLO != BWParametersDefault.SLO {
+		t.Errorf("Expected the configured SLO without any observed delay, got %d", bw.SLO)
+	}
+}
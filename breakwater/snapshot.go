@@ -0,0 +1,49 @@
+package breakwater
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+/*
+Point in time view of a server's controller state, for metrics and debugging
+*/
+type Snapshot struct {
+	CTotal        int64                  // global pool of credits
+	SLO           int64                  // SLA in microseconds, calibrated if CalibrationPeriod is set
+	CIssued       int64                  // credits currently issued to clients
+	NumClients    int64                  // registered clients
+	Shed          int64                  // requests shed by the server since it started
+	Rejections    map[RejectReason]int64 // requests rejected by this Breakwater since it started, on either side, by reason
+	RawDelay      float64                // delay measured at the last cTotal update in microseconds
+	SmoothedDelay float64                // smoothed delay that drove the last cTotal update in microseconds
+	PassThrough   bool                   // the safety valve has tripped
+}
+
+/*
+Returns the current controller state. Fields are read one at a time, so
+they may come from different RTT updates.
+*/
+func (b *Breakwater) Snapshot() Snapshot {
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued
+	numClients := b.clients.len()
+	rejections := b.rejectionCounts()
+	var shed int64
+	for reason, count := range rejections {
+		if reason.ServerSide() {
+			shed += count
+		}
+	}
+	return Snapshot{
+		CTotal:        b.getCTotal(),
+		SLO:           atomic.LoadInt64(&b.SLO),
+		CIssued:       cIssued,
+		NumClients:    numClients,
+		Shed:          shed,
+		Rejections:    rejections,
+		RawDelay:      math.Float64frombits(atomic.LoadUint64(&b.rawDelay)),
+		SmoothedDelay: math.Float64frombits(atomic.LoadUint64(&b.smoothedDelay)),
+		PassThrough:   b.PassThrough(),
+	}
+}
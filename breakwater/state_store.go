@@ -0,0 +1,188 @@
+package breakwater
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+Controller state saved across restarts
+A restarted server would otherwise start over at InitialCredits, and a
+rolling restart under load sets off the overload and recovery all over
+again on every instance. With a StateStore, SaveState saves cTotal and the
+credits issued to each client, typically on shutdown, and InitBreakwater
+restores them unless they are older than MaxStateAge. Restored clients
+are evicted like any other if they do not come back. WarmupPeriod, if set,
+still caps cTotal.
+*/
+type SavedState struct {
+	SavedAt       time.Time     `json:"savedAt"`
+	Credits       float64       `json:"credits"`       // exact pool cTotal is rounded from
+	LastGoodTotal float64       `json:"lastGoodTotal"` // last pool within the SLO, for slow-start recovery
+	Clients       []SavedClient `json:"clients"`
+}
+
+/*
+Grant state of one client in a SavedState
+*/
+type SavedClient struct {
+	ID     uuid.UUID `json:"id"`
+	Issued int64     `json:"issued"` // credits the client holds
+	Demand int64     `json:"demand"`
+	Weight float64   `json:"weight"`
+	Tenant string    `json:"tenant,omitempty"`
+}
+
+/*
+Where SaveState saves the controller state, and InitBreakwater restores it
+from
+*/
+type StateStore interface {
+	Save(state SavedState) error
+	// Returns false if no state was saved
+	Load() (SavedState, bool, error)
+}
+
+/*
+Saves the state as JSON in a file, replaced atomically so a crash while
+saving keeps the previous state
+*/
+type FileStateStore struct {
+	Path string
+}
+
+func (s FileStateStore) Save(state SavedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func (s FileStateStore) Load() (SavedState, bool, error) {
+	var state SavedState
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, err
+	}
+	return state, true, nil
+}
+
+var errNoStateStore = errors.New("breakwater: no StateStore configured")
+
+/*
+Saves cTotal and the credits issued to each client to the StateStore, to
+be called when the server shuts down
+*/
+func (b *Breakwater) SaveState() error {
+	if b.stateStore == nil {
+		return errNoStateStore
+	}
+	state := b.ExportState()
+	b.logger("[State]:	Saving cTotal %f and the grants of %d clients", state.Credits, len(state.Clients))
+	return b.stateStore.Save(state)
+}
+
+/*
+Returns the live controller state: cTotal and the credits issued to each
+client. In active-passive deployments the active instance exports it
+regularly and ships it to the standby, which imports it with ImportState
+so it takes over with realistic credit levels rather than cold. The state
+is JSON serializable.
+*/
+func (b *Breakwater) ExportState() SavedState {
+	<-b.rttLock
+	state := SavedState{SavedAt: b.now(), Credits: b.exactCredits(), LastGoodTotal: b.lastGoodTotal}
+	b.rttLock <- 1
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		state.Clients = append(state.Clients, SavedClient{
+			ID:     c.id,
+			Issued: atomic.LoadInt64(&c.issued),
+			Demand: atomic.LoadInt64(&c.demand),
+			Weight: c.weight,
+			Tenant: c.tenant,
+		})
+		c.mu.Unlock()
+		return true
+	})
+	return state
+}
+
+/*
+Restores the state saved by an earlier instance, called by InitBreakwater
+before the controller starts
+*/
+func (b *Breakwater) restoreState() {
+	state, ok, err := b.stateStore.Load()
+	if err != nil {
+		alert("[State]:	Failed to load the saved state: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if age := b.now().Sub(state.SavedAt); b.maxStateAge > 0 && age.Microseconds() > b.maxStateAge {
+		b.logger("[State]:	Saved state is %v old, starting over", age)
+		return
+	}
+	b.ImportState(state)
+}
+
+/*
+Takes over a state exported by another instance with ExportState: cTotal,
+bounded by MinCredits and MaxCredits, and the credits issued to each of
+its clients, which replace what this instance issued them. Clients only
+this instance knows keep their credits. Safe to call on a running server.
+*/
+func (b *Breakwater) ImportState(state SavedState) {
+	<-b.rttLock
+	b.setCredits(b.boundCredits(state.Credits))
+	b.lastGoodTotal = state.LastGoodTotal
+	b.rttLock <- 1
+	var diff int64
+	for _, saved := range state.Clients {
+		b.RegisterClient(saved.ID, saved.Demand)
+		c, ok := b.clients.load(saved.ID)
+		if !ok {
+			// evicted concurrently
+			continue
+		}
+		c.mu.Lock()
+		diff += saved.Issued - atomic.SwapInt64(&c.issued, saved.Issued)
+		if saved.Weight > 0 {
+			b.setWeight(c, saved.Weight)
+		}
+		if saved.Tenant != "" {
+			c.tenant = saved.Tenant
+		}
+		c.mu.Unlock()
+	}
+	cIssued := <-b.cIssued
+	b.cIssued <- cIssued + diff
+	b.logger("[State]:	Imported cTotal %d and the grants of %d clients", b.getCTotal(), len(state.Clients))
+}
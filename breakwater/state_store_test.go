@@ -0,0 +1,89 @@
+package breakwater
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStateRestoredAfterRestart(t *testing.T) {
+	params := BWParametersDefault
+	params.StateFile = filepath.Join(t.TempDir(), "state.json")
+	before := InitBreakwater(params)
+	before.cTotal = 250
+	id := registerWithCredits(before, 40)
+	before.SetClientWeight(id, 2)
+	if err := before.SaveState(); err != nil {
+		t.Fatalf("SaveState failed: %v", err)
+	}
+
+	after := InitBreakwater(params)
+	if after.cTotal != 250 {
+		t.Errorf("Expected cTotal to be restored to 250, got %d", after.cTotal)
+	}
+	c, ok := after.clients.load(id)
+	if !ok {
+		t.Fatalf("Expected the client to be restored")
+	}
+	if issued := atomic.LoadInt64(&c.issued); issued != 40 || c.weight != 2 {
+		t.Errorf("Expected the client's 40 credits and weight 2, got %d and %f", issued, c.weight)
+	}
+	if s := after.Snapshot(); s.CIssued != 40 {
+		t.Errorf("Expected the restored credits to count as issued, got %d", s.CIssued)
+	}
+}
+
+func TestStaleStateIgnored(t *testing.T) {
+	params := BWParametersDefault
+	params.StateFile = filepath.Join(t.TempDir(), "state.json")
+	stale := SavedState{
+		SavedAt: time.Now().Add(-time.Duration(2*params.MaxStateAge) * time.Microsecond),
+		Credits: 250,
+		Clients: []SavedClient{{ID: uuid.New(), Issued: 40}},
+	}
+	if err := (FileStateStore{Path: params.StateFile}).Save(stale); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	bw := InitBreakwater(params)
+	if bw.cTotal != params.InitialCredits || bw.clients.len() != 0 {
+		t.Errorf("Expected a stale state to be ignored, got cTotal %d and %d clients", bw.cTotal, bw.clients.len())
+	}
+}
+
+func TestSaveStateWithoutStore(t *testing.T) {
+	if err := InitBreakwater(BWParametersDefault).SaveState(); err != errNoStateStore {
+		t.Errorf("Expected an error without a store, got %v", err)
+	}
+}
+
+func TestStandbyImportsActiveState(t *testing.T) {
+	active := InitBreakwater(BWParametersDefault)
+	active.cTotal = 300
+	moved := registerWithCredits(active, 40)
+	shared := registerWithCredits(active, 10)
+
+	standby := InitBreakwater(BWParametersDefault)
+	own := registerWithCredits(standby, 7)
+	standby.RegisterClient(shared, 1)
+	sharedConn, _ := standby.clients.load(shared)
+	sharedConn.issued = 5
+	cIssued := <-standby.cIssued
+	standby.cIssued <- cIssued + 5
+
+	standby.ImportState(active.ExportState())
+	if standby.cTotal != 300 {
+		t.Errorf("Expected the standby to take over cTotal 300, got %d", standby.cTotal)
+	}
+	for id, expected := range map[uuid.UUID]int64{moved: 40, shared: 10, own: 7} {
+		c, _ := standby.clients.load(id)
+		if issued := atomic.LoadInt64(&c.issued); issued != expected {
+			t.Errorf("Expected client %s to hold %d credits, got %d", id, expected, issued)
+		}
+	}
+	if s := standby.Snapshot(); s.CIssued != 57 {
+		t.Errorf("Expected 57 credits issued after the import, got %d", s.CIssued)
+	}
+}
@@ -0,0 +1,54 @@
+package breakwater
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/tap"
+)
+
+type tapAdmittedKey struct{}
+
+/*
+AQM decision shared by the tap handle and the interceptor, returns the
+current queueing delay and whether a request of the class should be shed
+*/
+func (b *Breakwater) aqmDecision(class PriorityClass, t delayThresholds) (float64, bool) {
+	queueingDelay := b.readQueueingDelay()
+	return queueingDelay, b.shouldShed(queueingDelay, class, t)
+}
+
+/*
+Server side tap handle, install with grpc.InTapHandle(b.TapHandle)
+alongside the interceptor.
+It runs when the request headers arrive, before the message is read and
+decompressed, so shedding there is much cheaper than in the interceptor.
+Requests it admits skip the interceptor's AQM check, which still issues
+credits. Rejections from the tap handle carry only the RESOURCE_EXHAUSTED
+code and message: the transport drops status details and headers this
+early, so clients do not see the rejection details or a credit update.
+With a server queue, requests are left to the interceptor to queue.
+*/
+func (b *Breakwater) TapHandle(ctx context.Context, info *tap.Info) (context.Context, error) {
+	if b.PassThrough() || !b.loadShedding || b.serverQueue != nil || b.degradeFunc != nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	// the class is all that is needed, so malformed metadata is left to the interceptor
+	rm, mdErr := b.parseRequestMetadata(md)
+	thresholds := b.thresholdsFor(info.FullMethodName)
+	queueingDelay, shed := b.aqmDecision(rm.class, thresholds)
+	if shed {
+		b.logShed("[Load Shedding] applied at tap, server-side queuing delay %f us is beyond AQM threshold", queueingDelay)
+		return ctx, b.reject(ctx, ServerAQMError(b.id.String(), queueingDelay, b.serverRetryDelay(queueingDelay, thresholds)))
+	}
+	return withParsedMetadata(context.WithValue(ctx, tapAdmittedKey{}, true), rm, mdErr), nil
+}
+
+/*
+Returns true if the tap handle already ran the AQM check for the request
+*/
+func admittedByTap(ctx context.Context) bool {
+	admitted, _ := ctx.Value(tapAdmittedKey{}).(bool)
+	return admitted
+}
@@ -0,0 +1,59 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/tap"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestTapHandleSheds(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+
+	var handled int32
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.InTapHandle(bw.TapHandle), grpc.ChainUnaryInterceptor(bw.UnaryInterceptor,
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			atomic.StoreInt32(&handled, 1)
+			return handler(ctx, req)
+		}))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	cc := dialBufconn(t, lis)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "demand", "0", "id", bw.id.String())
+	_, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected the tap handle to shed the request, got %v", err)
+	}
+	if atomic.LoadInt32(&handled) == 1 {
+		t.Errorf("Expected the request to be shed before the interceptors")
+	}
+}
+
+func TestTapAdmittedSkipsInterceptorAQM(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	md := metadata.Pairs("demand", "1", "id", bw.id.String())
+	ctx, err := bw.TapHandle(metadata.NewIncomingContext(context.Background(), md), &tap.Info{FullMethodName: "/test"})
+	if err != nil || !admittedByTap(ctx) {
+		t.Fatalf("Expected the tap handle to admit the request, got %v", err)
+	}
+
+	// the delay rises between the tap and the interceptor, the request was already admitted
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	if _, err := bw.UnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+		t.Errorf("Expected the interceptor not to repeat the AQM check, got %v", err)
+	}
+}
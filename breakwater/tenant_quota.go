@@ -0,0 +1,133 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Tenant quotas
+Credits are normally shared per client, so a tenant running many client
+processes gets a proportionally larger slice of cTotal even though each of
+its clients looks well-behaved. With tenantQuotas on, every tenant gets an
+equal sub-pool of cTotal, and a client is never issued credits that would
+take its tenant past that pool.
+The tenant of a request comes from tenantFunc if set, otherwise from the
+"tenant" metadata sent by clients with BWParameters.Tenant. Requests with
+no tenant share the "" pool.
+*/
+type tenantPool struct {
+	issued chan int64 // credits issued to the tenant's clients, also guards the pool
+}
+
+/*
+Returns the tenant of an incoming request
+*/
+func (b *Breakwater) tenantOf(ctx context.Context) string {
+	if b.tenantFunc != nil {
+		return b.tenantFunc(ctx)
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md["tenant"]) == 0 {
+		return ""
+	}
+	return md["tenant"][0]
+}
+
+/*
+Returns the pool for a tenant, creating it on first use
+*/
+func (b *Breakwater) getTenantPool(tenant string) *tenantPool {
+	if pool, ok := b.tenants.Load(tenant); ok {
+		return pool.(*tenantPool)
+	}
+	pool := &tenantPool{issued: make(chan int64, 1)}
+	pool.issued <- 0
+	stored, loaded := b.tenants.LoadOrStore(tenant, pool)
+	if !loaded {
+		atomic.AddInt64(&b.numTenants, 1)
+		b.logger("[Tenant Quota]:	New tenant %q", tenant)
+	}
+	return stored.(*tenantPool)
+}
+
+/*
+Credits available to each tenant, an equal split of cTotal
+*/
+func (b *Breakwater) tenantQuota() int64 {
+	numTenants := max(atomic.LoadInt64(&b.numTenants), 1)
+	return max(b.cTotal/numTenants, 1)
+}
+
+/*
+Moves a client to a tenant, along with the credits issued to it
+*/
+func (b *Breakwater) setClientTenant(id uuid.UUID, tenant string) {
+	c, ok := b.clients.load(id)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tenant == tenant {
+		return
+	}
+
+	newPool := b.getTenantPool(tenant)
+	if connIssued := atomic.LoadInt64(&c.issued); connIssued != 0 {
+		oldPool := b.getTenantPool(c.tenant)
+		issued := <-oldPool.issued
+		oldPool.issued <- issued - connIssued
+		issued = <-newPool.issued
+		newPool.issued <- issued + connIssued
+	}
+
+	c.tenant = tenant
+	b.logger("[Tenant Quota]:	Client %s belongs to tenant %q", id, tenant)
+}
+
+/*
+Limits a client's new credits so its tenant stays within its quota,
+decaying them like an over-limit client if the tenant is already over.
+Called while holding the client's mutex.
+*/
+func (b *Breakwater) capToTenantQuota(tenant string, cPrevious int64, cNew int64) int64 {
+	pool := b.getTenantPool(tenant)
+	issued := <-pool.issued
+	if increase := cNew - cPrevious; increase > 0 {
+		available := b.tenantQuota() - issued
+		if available <= 0 {
+			b.logger("[Tenant Quota]:	Tenant %q at quota, issued %d", tenant, issued)
+			cNew = cPrevious - 1
+		} else if increase > available {
+			cNew = cPrevious + available
+		}
+	}
+	cNew = max(cNew, b.minGrant())
+	pool.issued <- issued + cNew - cPrevious
+	return cNew
+}
+
+/*
+Re-calculates the credits issued per tenant, and the number of tenants
+with registered clients, at every RTT update
+*/
+func (b *Breakwater) recountTenants() {
+	counted := make(map[string]int64)
+	b.clients.rangeClients(func(c *Connection) bool {
+		c.mu.Lock()
+		counted[c.tenant] += atomic.LoadInt64(&c.issued)
+		c.mu.Unlock()
+		return true
+	})
+	b.tenants.Range(func(key, value interface{}) bool {
+		pool := value.(*tenantPool)
+		<-pool.issued
+		pool.issued <- counted[key.(string)]
+		return true
+	})
+	atomic.StoreInt64(&b.numTenants, int64(len(counted)))
+}
@@ -0,0 +1,77 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTenantOf(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("tenant", "team-a"))
+	if tenant := bw.tenantOf(ctx); tenant != "team-a" {
+		t.Errorf("Expected tenant from metadata, got %q", tenant)
+	}
+	bw.tenantFunc = func(ctx context.Context) string { return "from-func" }
+	if tenant := bw.tenantOf(ctx); tenant != "from-func" {
+		t.Errorf("Expected TenantFunc to take precedence, got %q", tenant)
+	}
+}
+
+func TestTenantCannotCrowdOutAnother(t *testing.T) {
+	params := BWParametersDefault
+	params.TenantQuotas = true
+	params.InitialCredits = 100
+	bw := InitBreakwater(params)
+
+	crowd := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, id := range crowd {
+		bw.RegisterClient(id, 40)
+		bw.setClientTenant(id, "crowd")
+	}
+	quiet := uuid.New()
+	bw.RegisterClient(quiet, 40)
+	bw.setClientTenant(quiet, "quiet")
+	// every connection is due a post-RTT credit update
+	bw.setLastUpdate(time.Now())
+
+	var crowdCredits int64 = 0
+	for _, id := range crowd {
+		crowdCredits += bw.updateCreditsToIssue(id, 40)
+	}
+	quietCredits := bw.updateCreditsToIssue(quiet, 40)
+
+	// one credit per client may exceed the quota, so every client can send
+	if quota := bw.tenantQuota(); crowdCredits > quota+int64(len(crowd)) {
+		t.Errorf("Expected the crowded tenant to stay near its quota of %d, got %d", quota, crowdCredits)
+	}
+	if quietCredits < 40 {
+		t.Errorf("Expected the quiet tenant's client to get its demand of 40, got %d", quietCredits)
+	}
+}
+
+func TestRecountTenants(t *testing.T) {
+	params := BWParametersDefault
+	params.TenantQuotas = true
+	bw := InitBreakwater(params)
+	id := registerWithCredits(bw, 30)
+	bw.setClientTenant(id, "team-a")
+
+	// drift the pool, the recount restores it
+	pool := bw.getTenantPool("team-a")
+	<-pool.issued
+	pool.issued <- 999
+	bw.recountTenants()
+
+	issued := <-pool.issued
+	pool.issued <- issued
+	if issued != 30 {
+		t.Errorf("Expected the tenant to have 30 credits issued, got %d", issued)
+	}
+	if bw.numTenants != 1 {
+		t.Errorf("Expected 1 tenant, got %d", bw.numTenants)
+	}
+}
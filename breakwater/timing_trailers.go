@@ -0,0 +1,65 @@
+package breakwater
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Response trailers carrying how long a request queued and was handled
+const (
+	queueingDelayKey = "queueing-delay-us"
+	handlerTimeKey   = "handler-time-us"
+)
+
+/*
+Where the time of an admitted request went on the server
+*/
+type RequestTiming struct {
+	QueueingDelay time.Duration // from arrival, on the wire if StatsHandler is installed, until the handler started
+	HandlerTime   time.Duration // time spent in the handler
+}
+
+/*
+When the request arrived: on the wire if the stats handler saw it, when
+Admit started otherwise
+*/
+func arrivalOf(ctx context.Context, admitted time.Time) time.Time {
+	if arrival, ok := ctx.Value(rpcArrivalKey{}).(*rpcArrival); ok && !arrival.at.IsZero() {
+		return arrival.at
+	}
+	return admitted
+}
+
+/*
+Trailer with the request's queueing delay and handler time, for
+BWParameters.TimingTrailers
+*/
+func (a *Admission) timingTrailer() metadata.MD {
+	return metadata.Pairs(
+		queueingDelayKey, strconv.FormatInt(a.handlerStart.Sub(a.arrival).Microseconds(), 10),
+		handlerTimeKey, strconv.FormatInt(time.Since(a.handlerStart).Microseconds(), 10),
+	)
+}
+
+/*
+Reads the timing a server sent in the trailer of a response, false if it
+sent none
+*/
+func TimingFromTrailer(md metadata.MD) (RequestTiming, bool) {
+	queueing, handler := md.Get(queueingDelayKey), md.Get(handlerTimeKey)
+	if len(queueing) == 0 || len(handler) == 0 {
+		return RequestTiming{}, false
+	}
+	queueingUs, err1 := strconv.ParseInt(queueing[0], 10, 64)
+	handlerUs, err2 := strconv.ParseInt(handler[0], 10, 64)
+	if err1 != nil || err2 != nil {
+		return RequestTiming{}, false
+	}
+	return RequestTiming{
+		QueueingDelay: time.Duration(queueingUs) * time.Microsecond,
+		HandlerTime:   time.Duration(handlerUs) * time.Microsecond,
+	}, true
+}
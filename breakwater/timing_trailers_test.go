@@ -0,0 +1,54 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestTimingTrailer(t *testing.T) {
+	params := BWParametersDefault
+	params.TimingTrailers = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+
+	// the request arrived on the wire 3ms before it reached the interceptor
+	ctx := context.WithValue(context.Background(), rpcArrivalKey{}, &rpcArrival{at: time.Now().Add(-3 * time.Millisecond)})
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	a, err := bw.Admit(metadata.NewIncomingContext(ctx, md))
+	if err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	_, trailer := a.Done()
+
+	timing, ok := TimingFromTrailer(trailer)
+	if !ok {
+		t.Fatalf("Expected the trailer to carry the request's timing, got %v", trailer)
+	}
+	if timing.QueueingDelay < 3*time.Millisecond || timing.QueueingDelay > time.Second {
+		t.Errorf("Expected a queueing delay from the wire arrival of about 3ms, got %v", timing.QueueingDelay)
+	}
+	if timing.HandlerTime < 5*time.Millisecond || timing.HandlerTime > time.Second {
+		t.Errorf("Expected a handler time of about 5ms, got %v", timing.HandlerTime)
+	}
+}
+
+func TestNoTimingTrailerByDefault(t *testing.T) {
+	bw := InitBreakwater(BWParametersDefault)
+	setDelay(bw, 0)
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	a, err := bw.Admit(metadata.NewIncomingContext(context.Background(), md))
+	if err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if _, trailer := a.Done(); len(trailer) > 0 {
+		t.Errorf("Expected no trailer without TimingTrailers, got %v", trailer)
+	}
+	if _, ok := TimingFromTrailer(metadata.Pairs(queueingDelayKey, "x", handlerTimeKey, "1")); ok {
+		t.Errorf("Expected a malformed trailer to be ignored")
+	}
+}
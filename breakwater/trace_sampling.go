@@ -0,0 +1,36 @@
+package breakwater
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Reasons passed to Observer.OnForceSample
+*/
+const (
+	SampleReasonShed       = "shed"        // the request was shed, by the server or the client
+	SampleReasonCreditWait = "credit-wait" // the request waited longer than TraceCreditWait for a credit
+)
+
+/*
+Tail sampling of shed and slow requests
+Under aggressive head sampling, the traces of the few requests breakwater
+rejects or delays are usually dropped, although they are the ones worth
+looking at. With TraceShed, every request shed on either side is passed
+to Observer.OnForceSample with its context; with TraceCreditWait, so is
+every request that waited longer than that for a credit. The callback
+marks the request's span, e.g. with an attribute a tail sampling policy
+keeps, so its trace is captured whatever the head sampler decided.
+*/
+func (b *Breakwater) sampleShed(ctx context.Context) {
+	if b.traceShed {
+		b.observer.forceSample(ctx, SampleReasonShed)
+	}
+}
+
+func (b *Breakwater) sampleCreditWait(ctx context.Context, waited time.Duration) {
+	if b.traceCreditWait > 0 && waited.Microseconds() > b.traceCreditWait {
+		b.observer.forceSample(ctx, SampleReasonCreditWait)
+	}
+}
@@ -0,0 +1,69 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+)
+
+type spanKey struct{}
+
+// Records the span of every request passed to OnForceSample, by reason
+func sampledSpans(params *BWParameters) map[string][]string {
+	sampled := make(map[string][]string)
+	params.Observer = &Observer{OnForceSample: func(ctx context.Context, reason string) {
+		span, _ := ctx.Value(spanKey{}).(string)
+		sampled[reason] = append(sampled[reason], span)
+	}}
+	return sampled
+}
+
+func TestShedRequestsSampled(t *testing.T) {
+	params := BWParametersDefault
+	params.TraceShed = true
+	sampled := sampledSpans(&params)
+	bw := InitBreakwater(params)
+	bw.storeQueueingDelay(bw.aqmDelay() * 2)
+
+	ctx := context.WithValue(context.Background(), spanKey{}, "server-span")
+	md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+	if _, err := bw.Admit(metadata.NewIncomingContext(ctx, md)); !isServerShed(err) {
+		t.Fatalf("Expected the request to be shed, got %v", err)
+	}
+	ctx = context.WithValue(context.Background(), spanKey{}, "client-span")
+	bw.Invoke(ctx, "server-a", func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		return nil, nil, ServerAQMError("server-a", 300, time.Millisecond)
+	})
+
+	if spans := sampled[SampleReasonShed]; len(spans) != 2 || spans[0] != "server-span" || spans[1] != "client-span" {
+		t.Errorf("Expected the request shed on either side to be sampled, got %v", sampled)
+	}
+}
+
+func TestSlowCreditWaitSampled(t *testing.T) {
+	params := BWParametersDefault
+	params.TraceCreditWait = 5000
+	params.ClientExpiration = 1000000
+	sampled := sampledSpans(&params)
+	bw := InitBreakwater(params)
+	target := bw.getTarget("server-a")
+	stallTarget(target)
+	ok := func(ctx context.Context) (metadata.MD, metadata.MD, error) { return nil, nil, nil }
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		target.addCredits(1)
+	}()
+	if err := bw.Invoke(context.WithValue(context.Background(), spanKey{}, "slow"), "server-a", ok); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	target.addCredits(1)
+	bw.Invoke(context.WithValue(context.Background(), spanKey{}, "fast"), "server-a", ok)
+
+	if spans := sampled[SampleReasonCreditWait]; len(spans) != 1 || spans[0] != "slow" {
+		t.Errorf("Expected only the request that waited for its credit to be sampled, got %v", sampled)
+	}
+}
@@ -1,11 +1,12 @@
 package breakwater
 
 import (
-	"fmt"
+	"context"
 	"math"
 	"time"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/status"
 )
 
@@ -13,15 +14,48 @@ var (
 	errMissingMetadata = status.Errorf(codes.InvalidArgument, "missing metadata")
 )
 
-// logger is to mock a sophisticated logging system. To simplify the example, we just print out the content.
-func logger(format string, a ...interface{}) {
-	if debug {
-		// print to stdout with timestamp
-		timestamp := time.Now().Format("2006-01-02T15:04:05.999999999-07:00")
-		fmt.Printf("LOG: "+timestamp+"|\t"+format+"\n", a...)
+/*
+Verbose flag of one controller, embedded in it and in the state it hands
+out, so its debug lines follow its own BWParameters.Verbose
+*/
+type verbose bool
+
+// logger writes debug lines to the Logger set with SetLogger, stdout by default
+func (v verbose) logger(format string, a ...interface{}) {
+	if v {
+		currentLogger().Debugf(format, a...)
+	}
+}
+
+// logAdmit writes the debug line of a request let through, which a
+// SampledLogger samples by LogSampling.AdmitEvery
+func (v verbose) logAdmit(format string, a ...interface{}) {
+	if v {
+		logDecision(lineAdmit, format, a...)
+	}
+}
+
+// logShed writes the debug line of a shed request, which is never sampled
+func (v verbose) logShed(format string, a ...interface{}) {
+	if v {
+		logDecision(lineShed, format, a...)
 	}
 }
 
+func logDecision(kind lineKind, format string, a ...interface{}) {
+	l := currentLogger()
+	if d, ok := l.(decisionLogger); ok {
+		d.decisionf(kind, format, a...)
+		return
+	}
+	l.Debugf(format, a...)
+}
+
+// alert writes critical messages regardless of the verbose flag
+func alert(format string, a ...interface{}) {
+	currentLogger().Errorf(format, a...)
+}
+
 func min(a, b int64) int64 {
 	if a < b {
 		return a
@@ -41,17 +75,125 @@ func roundedInt(x float64) int64 {
 }
 
 type BWParameters struct {
-	ServerSide              bool
-	BFactor                 float64
-	AFactor                 float64
-	SLO                     int64
-	ClientExpiration        int64
-	InitialCredits          int64
-	Verbose                 bool
-	UseClientTimeExpiration bool
-	LoadShedding            bool
-	UseClientQueueLength    bool
-	RTT_MICROSECOND         int64
+	Algorithm                string // overload control strategy used by NewController, AlgorithmBreakwater, AlgorithmDAGOR or AlgorithmGradient
+	ServerSide               bool
+	BFactor                  float64
+	AFactor                  float64
+	SLO                      int64
+	ClientExpiration         int64
+	InitialCredits           int64
+	MinCredits               int64  // floor on cTotal, 0 for 1
+	MaxCredits               int64  // ceiling on cTotal, 0 for none
+	Recovery                 string // how cTotal grows back within the SLO, RecoveryAdditive or RecoverySlowStart
+	GrantMode                string // when client grants are recomputed, GrantModeLazy or GrantModeRTT
+	StateFile                string // file SaveState saves the controller state to, restored at startup, "" for none
+	MaxStateAge              int64  // microseconds after which a saved state is too old to restore, negative for any age
+	WarmupPeriod             int64  // microseconds after startup over which cTotal ramps up to InitialCredits, 0 starts at InitialCredits
+	WarmupCredits            int64  // cTotal at startup when WarmupPeriod is set
+	WarmupSchedule           string // how cTotal ramps up, WarmupLinear or WarmupSlowStart
+	IdleHalfLife             int64  // microseconds for cTotal to move halfway back to InitialCredits without traffic, 0 disables
+	HistoryLength            int    // RTT updates kept for History, 0 disables
+	Verbose                  bool
+	UseClientTimeExpiration  bool
+	LoadShedding             bool
+	UseClientQueueLength     bool
+	RTT_MICROSECOND          int64
+	StallTimeoutRTTs         int64 // RTTs without a credit grant before the client sends a probe, negative disables
+	MaxStallBackoff          int64 // maximum backoff between probes in microseconds
+	Observer                 *Observer
+	ExpvarName               string               // publish Snapshot under this name in the "breakwater" expvar map, "" disables
+	TraceShed                bool                 // pass shed requests to Observer.OnForceSample to keep their traces
+	TraceCreditWait          int64                // microseconds waiting for a credit after which a request is passed to Observer.OnForceSample, 0 disables
+	SafetyValve              bool                 // switch to pass-through mode when the controller detects anomalies
+	MaxAccountingDrift       int64                // credits cIssued may drift from its recount before tripping the valve, negative disables
+	RecountRTTs              int64                // RTT updates between recounts of cIssued from every connection, 1 recounts at each
+	MaxRTTUpdateStall        int64                // microseconds an RTT update may run before tripping the valve, negative disables
+	InvariantCheckInterval   int64                // microseconds between recounts of cIssued, 0 disables outside bwdebug builds, negative in all
+	RepairAccounting         bool                 // resync cIssued to its recount when the invariant checker finds them apart
+	DropFromFront            bool                 // when the client queue is full, drop the oldest waiting request instead of the new one
+	ServerQueueLength        int64                // requests that may wait in the server queue when AQM triggers, 0 sheds them immediately
+	ServerQueueTimeout       int64                // longest a request may wait in the server queue in microseconds
+	MaxRetries               int64                // times the client retries a request shed by the server, 0 disables
+	RetryBaseBackoff         int64                // backoff before the first retry in microseconds, doubled on every retry
+	RetryCredits             string               // credits a retry attempt takes, RetryCreditsFull, RetryCreditsDiscounted or RetryCreditsFree
+	RetryCreditCost          float64              // fraction of a credit a retry takes with RetryCreditsDiscounted
+	RetryMaxBackoff          int64                // upper bound on the retry backoff in microseconds
+	ClientIdleTimeout        int64                // microseconds without a request before a client is evicted, 0 keeps clients forever
+	CreditLeaseRTTs          int64                // RTT update intervals a grant stays valid for unless renewed, 0 never expires
+	RevocationFactor         float64              // revoke outstanding credits when the delay exceeds this multiple of the AQM threshold, 0 disables
+	MaxGrantIncrease         int64                // credits a client's grant may grow by per RTT update, 0 for no limit
+	MinOvercommit            int64                // minimum credits overcommitted to each client beyond its demand
+	MaxOvercommit            int64                // maximum credits overcommitted to each client, 0 for no cap
+	DisableOvercommit        bool                 // issue clients only their demand, for deployments where unused credits cause stragglers
+	WeightedFairSharing      bool                 // split cTotal across clients by weighted max-min fairness every RTT
+	ClientWeight             float64              // weight this client sends to servers for fair sharing, 0 to use the server's default of 1
+	TenantQuotas             bool                 // split cTotal into equal sub-pools per tenant
+	Tenant                   string               // tenant this client sends to servers, "" for none
+	HighAQMFactor            float64              // HIGH requests are shed once the delay passes this multiple of the AQM threshold
+	CriticalAQMFactor        float64              // CRITICAL requests are shed once the delay passes this multiple of the AQM threshold
+	HighPriorityReserve      float64              // fraction of cTotal that credits issued on BEST_EFFORT requests cannot use
+	DAGORWindow              int64                // how often DAGOR adjusts its admission level in microseconds
+	AQM                      string               // AQMThreshold sheds and expires requests at fixed delays, AQMCoDel uses CoDel on both sides, AQMProbabilistic sheds part of the requests
+	CoDelTarget              int64                // delay CoDel keeps requests under in microseconds, 0 for 40% of the SLO
+	CoDelInterval            int64                // how long the delay must stay above target before CoDel drops, in microseconds
+	ShedRamp                 float64              // with AQMProbabilistic, multiple of the AQM threshold at which every request is shed
+	GradientTolerance        float64              // with AlgorithmGradient, how far short-term latency may exceed long-term before cTotal shrinks
+	LoadHeaders              bool                 // send the server's credit saturation and delay relative to the SLO in response headers
+	TimingTrailers           bool                 // send each admitted request's queueing delay and handler time in response trailers
+	RecoverPanics            bool                 // turn handler panics into codes.Internal errors, finishing the request like any failed one
+	DedupWindow              int64                // microseconds the response to a unary request with an idempotency key answers its duplicates, 0 disables
+	ORCAReporting            bool                 // report queueing delay and credit saturation in ORCA per-call metrics
+	HealthServer             *health.Server       // health server whose status is set to NOT_SERVING while overloaded, nil disables
+	HealthService            string               // service name to drain on the health server, "" for the whole server
+	OverloadWindows          int64                // consecutive RTTs over the AQM threshold before draining, and under it before recovering
+	DelaySignal              string               // overload signal, DelaySignalScheduler, DelaySignalRPC, DelaySignalGC or DelaySignalCPU
+	CPUTarget                float64              // CPU utilization that DelaySignalCPU treats as the delay threshold
+	ThrottleWindows          int64                // consecutive RTTs with CFS throttling before it counts as overload, 0 disables
+	MaxConcurrentHandlers    int64                // handlers admitted requests may run at once, 0 for no limit
+	GCPressure               bool                 // count GCSignal in the delay alongside DelaySignal
+	DelayPercentile          float64              // percentile of new scheduler latency samples taken as the delay, 1 for the largest
+	DelaySmoothing           float64              // EWMA weight of each new delay sample driving cTotal, 1 disables smoothing
+	DelayMinSamples          int64                // delay samples before cTotal starts to change
+	CalibrationPeriod        int64                // microseconds of baseline delay observed at startup to derive the SLO from, 0 disables
+	CalibrationFactor        float64              // calibrated thresholdDelay as a multiple of the baseline p99 delay
+	MethodSLOs               map[string]MethodSLO // latency targets by full method name, for shedding requests of those methods; other methods use SLO
+	IdempotentAQMFactor      float64              // AQM threshold of Idempotent methods as a share of their own, at most 1 to shed them first
+	CachedMethods            map[string]int       // full method names whose latest responses clients keep, that many each, to answer shed requests
+	MeasureRTT               bool                 // measure RTTs with timestamps echoed in metadata instead of assuming RTT_MICROSECOND
+	DemandDecay              float64              // fraction of a client's demand kept for every RTT it sends no requests, 1 disables decay
+	RTTGrants                bool                 // piggyback grants recalculated at RTT updates on responses to in-flight requests
+	ZeroCredits              bool                 // issue zero-credit grants to stop clients sending, as in the Breakwater paper; set on clients and servers
+	BinaryMetadata           bool                 // send request metadata in a single binary header, only to servers that support it
+	ConnectionTokens         bool                 // assign clients a numeric token to send instead of their UUID
+	ProtocolVersion          int                  // highest credit protocol version to speak, 0 for the latest; pin it lower during rollouts
+	Clock                    func() time.Time     // time source of the server's credit controller, nil for time.Now; simulations run it on a fake clock
+	ManualUpdates            bool                 // only update credits when UpdateCredits is called, not after requests
+	ClientIdentity           string               // how the server identifies clients, IdentityMetadata, IdentityTLS or IdentityFunc
+	MetadataIdentityFallback bool                 // accept the self-declared id of clients the server cannot otherwise identify
+	SubdivideClients         bool                 // account each key a client sends in its client-key metadata as a separate client
+	TrustClientBypass        bool                 // admit requests clients marked with WithBypass without shedding, not only those marked by local interceptors
+	// Priority of an outgoing request, higher values are served first when
+	// credits are scarce. Requests of equal priority are served in FIFO order.
+	PriorityFunc func(ctx context.Context) int
+	// Identity of an incoming request's client with IdentityFunc, false if
+	// it has none; typically set by a trusted authentication interceptor
+	IdentityFunc func(ctx context.Context) (string, bool)
+	// Key an outgoing request's credits are kept under, e.g. the tenant a
+	// proxy forwards it for; each key gets its own credits from the server
+	// if it sets SubdivideClients. "" for the client's shared credits.
+	ClientKeyFunc func(ctx context.Context) string
+	// Tenant of an incoming request when TenantQuotas is set,
+	// defaults to the "tenant" metadata sent by clients
+	TenantFunc func(ctx context.Context) string
+	// Response to a unary request AQM would shed, e.g. a cached or partial
+	// one, nil to shed it with an error
+	DegradeFunc DegradeFunc
+	// Computes a client's grant when it is recomputed, DemandGrantPolicy
+	// if nil
+	GrantPolicy GrantPolicy
+	// Where SaveState saves the controller state, restored at startup;
+	// overrides StateFile
+	StateStore StateStore
 }
 
 /*
@@ -62,15 +204,100 @@ d_t = 40% of SLA,
 AQM threshold = 2 * d_t
 */
 var BWParametersDefault BWParameters = BWParameters{
-	ServerSide:              false,
-	BFactor:                 0.02,
-	AFactor:                 0.001,
-	SLO:                     160,
-	ClientExpiration:        1000,
-	InitialCredits:          1000,
-	Verbose:                 false,
-	UseClientTimeExpiration: true,
-	LoadShedding:            true,
-	UseClientQueueLength:    false,
-	RTT_MICROSECOND:         5000,
+	Algorithm:                AlgorithmBreakwater,
+	ServerSide:               false,
+	BFactor:                  0.02,
+	AFactor:                  0.001,
+	SLO:                      160,
+	ClientExpiration:         1000,
+	InitialCredits:           1000,
+	MinCredits:               0,
+	MaxCredits:               0,
+	Recovery:                 RecoveryAdditive,
+	GrantMode:                GrantModeLazy,
+	StateFile:                "",
+	MaxStateAge:              60000000,
+	WarmupPeriod:             0,
+	WarmupCredits:            10,
+	WarmupSchedule:           WarmupLinear,
+	IdleHalfLife:             0,
+	HistoryLength:            0,
+	Verbose:                  false,
+	UseClientTimeExpiration:  true,
+	LoadShedding:             true,
+	UseClientQueueLength:     false,
+	RTT_MICROSECOND:          5000,
+	StallTimeoutRTTs:         20,
+	MaxStallBackoff:          1000000,
+	ExpvarName:               "",
+	TraceShed:                false,
+	TraceCreditWait:          0,
+	SafetyValve:              false,
+	MaxAccountingDrift:       1000,
+	RecountRTTs:              10,
+	MaxRTTUpdateStall:        1000000,
+	InvariantCheckInterval:   0,
+	RepairAccounting:         false,
+	DropFromFront:            false,
+	ServerQueueLength:        0,
+	ServerQueueTimeout:       1000,
+	MaxRetries:               0,
+	RetryBaseBackoff:         5000,
+	RetryMaxBackoff:          100000,
+	RetryCredits:             RetryCreditsFull,
+	RetryCreditCost:          0.5,
+	ClientIdleTimeout:        0,
+	CreditLeaseRTTs:          0,
+	RevocationFactor:         0,
+	MaxGrantIncrease:         0,
+	MinOvercommit:            1,
+	MaxOvercommit:            0,
+	DisableOvercommit:        false,
+	WeightedFairSharing:      false,
+	ClientWeight:             0,
+	TenantQuotas:             false,
+	Tenant:                   "",
+	HighAQMFactor:            2,
+	CriticalAQMFactor:        4,
+	HighPriorityReserve:      0,
+	DAGORWindow:              1000000,
+	AQM:                      AQMThreshold,
+	CoDelTarget:              0,
+	CoDelInterval:            5000,
+	ShedRamp:                 2,
+	GradientTolerance:        1.5,
+	LoadHeaders:              false,
+	TimingTrailers:           false,
+	RecoverPanics:            false,
+	DedupWindow:              0,
+	ORCAReporting:            false,
+	HealthServer:             nil,
+	HealthService:            "",
+	OverloadWindows:          3,
+	DelaySignal:              DelaySignalScheduler,
+	GCPressure:               false,
+	CPUTarget:                DefaultCPUTarget,
+	ThrottleWindows:          0,
+	MaxConcurrentHandlers:    0,
+	DelayPercentile:          0.99,
+	DelaySmoothing:           1,
+	DelayMinSamples:          0,
+	CalibrationPeriod:        0,
+	CalibrationFactor:        2,
+	MethodSLOs:               nil,
+	IdempotentAQMFactor:      0.75,
+	CachedMethods:            nil,
+	MeasureRTT:               false,
+	DemandDecay:              0.5,
+	RTTGrants:                false,
+	ZeroCredits:              false,
+	BinaryMetadata:           false,
+	ConnectionTokens:         false,
+	ProtocolVersion:          0,
+	Clock:                    nil,
+	ManualUpdates:            false,
+	ClientIdentity:           IdentityMetadata,
+	MetadataIdentityFallback: false,
+	SubdivideClients:         false,
+	TrustClientBypass:        false,
 }
@@ -0,0 +1,85 @@
+package breakwater
+
+/*
+A request waiting for a credit. ready is signalled (once) with true when
+a credit has been handed to the waiter, or false when the waiter was
+dropped by client side AQM.
+*/
+type waiter struct {
+	priority int
+	ready    chan bool
+}
+
+/*
+Explicitly ordered queue of waiting requests.
+Waiters are served by descending priority, and in arrival order (FIFO)
+within the same priority, so dequeue order no longer depends on the Go
+runtime's channel wakeup order.
+Not thread-safe, callers hold the target's outgoingCredits semaphore.
+*/
+type waitQueue struct {
+	waiters []*waiter
+}
+
+/*
+Adds a waiter behind every waiter of the same or higher priority
+*/
+func (q *waitQueue) push(priority int) *waiter {
+	w := &waiter{priority: priority, ready: make(chan bool, 1)}
+	i := len(q.waiters)
+	for i > 0 && q.waiters[i-1].priority < priority {
+		i--
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = w
+	return w
+}
+
+/*
+Removes and returns the head of the queue, nil if empty
+*/
+func (q *waitQueue) pop() *waiter {
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	w := q.waiters[0]
+	q.waiters[0] = nil
+	q.waiters = q.waiters[1:]
+	return w
+}
+
+/*
+Removes and returns the oldest waiter of the lowest priority, nil if empty.
+This is the request that has waited longest without being next in line.
+*/
+func (q *waitQueue) dropOldest() *waiter {
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	lowest := q.waiters[len(q.waiters)-1].priority
+	i := len(q.waiters) - 1
+	for i > 0 && q.waiters[i-1].priority == lowest {
+		i--
+	}
+	w := q.waiters[i]
+	q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+	return w
+}
+
+/*
+Removes a waiter that gave up, returns false if it was already served
+*/
+func (q *waitQueue) remove(w *waiter) bool {
+	for i, queued := range q.waiters {
+		if queued == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *waitQueue) len() int {
+	return len(q.waiters)
+}
@@ -0,0 +1,62 @@
+package breakwater
+
+import (
+	"math"
+	"time"
+)
+
+/*
+Warm-up schedules, selected with BWParameters.WarmupSchedule
+*/
+const (
+	WarmupLinear    = "linear"     // cTotal ramps from WarmupCredits to InitialCredits in equal steps
+	WarmupSlowStart = "slow-start" // cTotal ramps by a constant factor, slowly at first
+)
+
+/*
+Startup warm-up
+A freshly started server has cold caches, so instead of granting
+InitialCredits right away, cTotal starts at WarmupCredits and may only grow
+along the schedule until it reaches InitialCredits at the end of
+WarmupPeriod. Within the SLO cTotal follows the schedule, beyond it the
+usual decrease applies and the schedule stays a ceiling.
+*/
+type warmup struct {
+	start    time.Time
+	period   time.Duration
+	from     int64 // cTotal at startup
+	to       int64 // cTotal at the end of the period
+	schedule string
+}
+
+/*
+Largest cTotal the schedule allows now
+*/
+func (w *warmup) ceiling(now time.Time) float64 {
+	progress := math.Min(float64(now.Sub(w.start))/float64(w.period), 1)
+	if w.schedule == WarmupSlowStart {
+		return float64(w.from) * math.Pow(float64(w.to)/float64(w.from), progress)
+	}
+	return float64(w.from) + float64(w.to-w.from)*progress
+}
+
+/*
+Applies the warm-up schedule to the next cTotal, and ends the warm-up once
+the period is over. Only called under the rtt lock.
+*/
+func (b *Breakwater) warmUp(cTotal float64) float64 {
+	w := b.warmup
+	if w == nil {
+		return cTotal
+	}
+	now := b.now()
+	if now.Sub(w.start) >= w.period {
+		b.logger("[Warm-up]:	Done, cTotal is %f", cTotal)
+		b.warmup = nil
+	}
+	ceiling := w.ceiling(now)
+	if cTotal > b.exactCredits() {
+		cTotal = math.Max(cTotal, ceiling)
+	}
+	return math.Min(cTotal, ceiling)
+}
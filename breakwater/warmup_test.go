@@ -0,0 +1,56 @@
+package breakwater
+
+import (
+	"testing"
+	"time"
+)
+
+func newWarmingBreakwater(schedule string) *Breakwater {
+	params := BWParametersDefault
+	params.WarmupPeriod = 10000000
+	params.WarmupCredits = 10
+	params.InitialCredits = 1000
+	params.WarmupSchedule = schedule
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	return bw
+}
+
+func TestWarmupSchedules(t *testing.T) {
+	for _, tc := range []struct {
+		schedule string
+		halfway  int64
+	}{
+		{WarmupLinear, 505},
+		{WarmupSlowStart, 100},
+	} {
+		bw := newWarmingBreakwater(tc.schedule)
+		if bw.cTotal != 10 {
+			t.Fatalf("Expected cTotal to start at WarmupCredits, got %d", bw.cTotal)
+		}
+		bw.warmup.start = time.Now().Add(-5 * time.Second)
+		if cTotal := bw.getUpdatedTotalCredits(); cTotal < tc.halfway || cTotal > tc.halfway+1 {
+			t.Errorf("Expected a %s warm-up to be at %d halfway, got %d", tc.schedule, tc.halfway, cTotal)
+		}
+	}
+}
+
+func TestWarmupCeilingUnderOverload(t *testing.T) {
+	bw := newWarmingBreakwater(WarmupLinear)
+	bw.warmup.start = time.Now().Add(-5 * time.Second)
+	setDelay(bw, 100*bw.thresholdDelay())
+	if cTotal := bw.getUpdatedTotalCredits(); cTotal >= 10 {
+		t.Errorf("Expected cTotal to decrease beyond the SLO during warm-up, got %d", cTotal)
+	}
+}
+
+func TestWarmupEnds(t *testing.T) {
+	bw := newWarmingBreakwater(WarmupSlowStart)
+	bw.warmup.start = time.Now().Add(-time.Minute)
+	if cTotal := bw.getUpdatedTotalCredits(); cTotal != 1000 {
+		t.Errorf("Expected cTotal to reach InitialCredits at the end of warm-up, got %d", cTotal)
+	}
+	if bw.warmup != nil {
+		t.Errorf("Expected the warm-up to be over")
+	}
+}
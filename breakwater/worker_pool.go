@@ -0,0 +1,67 @@
+package breakwater
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+/*
+Handler concurrency limit
+Credits pace how many requests are admitted, but not how many handlers run
+at once. With MaxConcurrentHandlers set, an admitted request waits for one
+of that many handler slots before it is issued credits and handled, and
+frees the slot in Done. The longest wait for a slot since the previous
+sample is part of the delay, so a full pool shrinks cTotal and sheds like
+any other queue.
+*/
+type workerPool struct {
+	slots   chan struct{}
+	waitMax int64 // longest wait for a slot since the last sample in microseconds, accessed atomically
+}
+
+func newWorkerPool(size int64) *workerPool {
+	return &workerPool{slots: make(chan struct{}, size)}
+}
+
+/*
+Waits for a free handler slot, returns the status of ctx if it is done first
+*/
+func (p *workerPool) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+		p.recordWait(time.Since(start).Microseconds())
+		return nil
+	case <-ctx.Done():
+		p.recordWait(time.Since(start).Microseconds())
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+func (p *workerPool) recordWait(us int64) {
+	for {
+		prev := atomic.LoadInt64(&p.waitMax)
+		if us <= prev || atomic.CompareAndSwapInt64(&p.waitMax, prev, us) {
+			return
+		}
+	}
+}
+
+/*
+Delay of the pool, the longest wait for a slot since the previous sample
+*/
+func (p *workerPool) delay() float64 {
+	return float64(atomic.SwapInt64(&p.waitMax, 0))
+}
@@ -0,0 +1,55 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestWorkerPoolCapsHandlers(t *testing.T) {
+	params := BWParametersDefault
+	params.MaxConcurrentHandlers = 1
+	params.ManualUpdates = true
+	bw := InitBreakwater(params)
+	setDelay(bw, 0)
+	admit := func(ctx context.Context) (*Admission, error) {
+		md := metadata.Pairs("demand", "1", "id", uuid.New().String())
+		return bw.Admit(metadata.NewIncomingContext(ctx, md))
+	}
+
+	first, err := admit(context.Background())
+	if err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := admit(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected the second handler to wait for the first until its deadline, got %v", err)
+	}
+
+	admitted := make(chan error)
+	go func() {
+		a, err := admit(context.Background())
+		if err == nil {
+			a.Done()
+		}
+		admitted <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	first.Done()
+	if err := <-admitted; err != nil {
+		t.Fatalf("Expected the waiting request to take the freed slot, got %v", err)
+	}
+	// the 10ms timed out wait is the longest
+	if delay := bw.getDelay(); delay < 10000 {
+		t.Errorf("Expected the wait for a slot to feed the delay, got %g", delay)
+	}
+	if delay := bw.getDelay(); delay != 0 {
+		t.Errorf("Expected the wait to be reset by the sample, got %g", delay)
+	}
+}
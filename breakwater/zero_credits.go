@@ -0,0 +1,16 @@
+package breakwater
+
+/*
+Smallest grant issued to a client.
+Credits are calculated lazily, so by default every client keeps at least one
+credit to send the request that fetches its next grant. With zeroCredits set,
+a server over its limit can issue zero credits as in the Breakwater paper,
+and the client stops sending until a positive grant arrives over the control
+stream or from a stall probe, or its requests expire.
+*/
+func (b *Breakwater) minGrant() int64 {
+	if b.zeroCredits {
+		return 0
+	}
+	return 1
+}
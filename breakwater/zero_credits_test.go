@@ -0,0 +1,78 @@
+package breakwater
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestZeroCreditGrant(t *testing.T) {
+	for _, zeroCredits := range []bool{false, true} {
+		params := BWParametersDefault
+		params.ZeroCredits = zeroCredits
+		bw := InitBreakwater(params)
+		id := uuid.New()
+		bw.RegisterClient(id, 1)
+		// over the limit, the client's single credit decays
+		<-bw.cIssued
+		bw.cIssued <- 100
+		bw.cTotal = 10
+		bw.updateCreditsToIssue(id, 1)
+		issued := bw.updateCreditsToIssue(id, 1)
+
+		if expected := bw.minGrant(); issued != expected {
+			t.Errorf("Expected a grant of %d with ZeroCredits %v, got %d", expected, zeroCredits, issued)
+		}
+	}
+}
+
+func TestClientBlocksOnZeroCredits(t *testing.T) {
+	params := BWParametersDefault
+	params.ZeroCredits = true
+	params.StallTimeoutRTTs = -1
+	bw := InitBreakwater(params)
+	cc, err := grpc.Dial("server-a", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	// the server grants zero credits
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, opt := range opts {
+			if h, ok := opt.(grpc.HeaderCallOption); ok {
+				*h.HeaderAddr = metadata.Pairs("credits", "0")
+			}
+		}
+		return nil
+	}
+	if err := bw.UnaryInterceptorClient(context.Background(), "/test/Method", nil, nil, cc, invoker); err != nil {
+		t.Fatalf("Expected the first request to use the initial credit, got %v", err)
+	}
+
+	target := bw.getTarget(cc.Target())
+	done := make(chan error, 1)
+	go func() {
+		target.queueRequest()
+		done <- target.acquireCredit(context.Background(), 0, nil)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("Expected the request to block after a zero grant")
+	case <-time.After(50 * time.Millisecond):
+	}
+	target.setCredits(1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a positive grant to unblock the request, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected a positive grant to unblock the request")
+	}
+}
@@ -0,0 +1,169 @@
+// Package breakwaterconnect adds breakwater overload control to connect-go
+// clients and handlers, sharing the same Breakwater as the gRPC interceptors:
+//
+//	interceptor := breakwaterconnect.NewInterceptor(bw)
+//	path, handler := pingv1connect.NewPingServiceHandler(svc, connect.WithInterceptors(interceptor))
+//	client := pingv1connect.NewPingServiceClient(http.DefaultClient, url, connect.WithInterceptors(interceptor))
+package breakwaterconnect
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/breakwaterhttp"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+Interceptor runs breakwater on both sides of connect-go calls.
+Unary calls are handled like the gRPC interceptors: clients wait for a
+credit and servers admit each request and piggyback credits on the
+response. Streams take no credits on the client, as with gRPC, but carry
+the client's metadata so servers still admit each new stream.
+Rejections carry the same error details as over gRPC. Calls shed by the
+server are not retried.
+*/
+type Interceptor struct {
+	b *bw.Breakwater
+}
+
+var _ connect.Interceptor = (*Interceptor)(nil)
+
+func NewInterceptor(b *bw.Breakwater) *Interceptor {
+	return &Interceptor{b: b}
+}
+
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return i.unaryClient(ctx, req, next)
+		}
+		return i.unaryHandler(ctx, req, next)
+	}
+}
+
+func (i *Interceptor) unaryClient(ctx context.Context, req connect.AnyRequest, next connect.UnaryFunc) (connect.AnyResponse, error) {
+	var res connect.AnyResponse
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		breakwaterhttp.SetHeaders(req.Header(), md)
+		var err error
+		res, err = next(ctx, req)
+		if err != nil {
+			var connectErr *connect.Error
+			if errors.As(err, &connectErr) {
+				return breakwaterhttp.HeaderMetadata(connectErr.Meta()), nil, err
+			}
+			return nil, nil, err
+		}
+		return breakwaterhttp.HeaderMetadata(res.Header()), breakwaterhttp.HeaderMetadata(res.Trailer()), nil
+	}
+	// only the breakwater metadata ends up in the request headers
+	ctx = metadata.NewOutgoingContext(ctx, metadata.MD{})
+	if err := i.b.Invoke(ctx, req.Peer().Addr, send); err != nil {
+		return nil, connectError(err)
+	}
+	return res, nil
+}
+
+func (i *Interceptor) unaryHandler(ctx context.Context, req connect.AnyRequest, next connect.UnaryFunc) (res connect.AnyResponse, err error) {
+	ctx = metadata.NewIncomingContext(ctx, breakwaterhttp.HeaderMetadata(req.Header()))
+	admission, err := i.b.Admit(bw.ContextWithMethod(ctx, req.Spec().Procedure))
+	if err != nil {
+		err = connectError(err)
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) {
+			breakwaterhttp.SetHeaders(connectErr.Meta(), admission.Header)
+		}
+		return nil, err
+	}
+
+	// deferred so a panicking handler still frees its admission
+	defer func() {
+		header, trailer := admission.Done()
+		responseHeader := metadata.Join(admission.Header, header)
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) {
+			breakwaterhttp.SetHeaders(connectErr.Meta(), responseHeader)
+		} else if err == nil && res != nil {
+			breakwaterhttp.SetHeaders(res.Header(), responseHeader)
+			breakwaterhttp.SetHeaders(res.Trailer(), trailer)
+		}
+	}()
+	return next(admission.Context(ctx), req)
+}
+
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		md, _ := metadata.FromOutgoingContext(i.b.WithMetadata(metadata.NewOutgoingContext(ctx, metadata.MD{}), conn.Peer().Addr))
+		breakwaterhttp.SetHeaders(conn.RequestHeader(), md)
+		return conn
+	}
+}
+
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx = metadata.NewIncomingContext(ctx, breakwaterhttp.HeaderMetadata(conn.RequestHeader()))
+		admission, err := i.b.Admit(bw.ContextWithMethod(ctx, conn.Spec().Procedure))
+		breakwaterhttp.SetHeaders(conn.ResponseHeader(), admission.Header)
+		if err != nil {
+			return connectError(err)
+		}
+		defer func() {
+			// the header only goes out if the handler sent no message
+			header, trailer := admission.Done()
+			breakwaterhttp.SetHeaders(conn.ResponseHeader(), header)
+			breakwaterhttp.SetHeaders(conn.ResponseTrailer(), trailer)
+		}()
+		return next(admission.Context(ctx), conn)
+	}
+}
+
+/*
+Converts a breakwater rejection, a gRPC status error, to a connect error
+with the same code, message and details
+*/
+func connectError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	connectErr := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, detail := range st.Details() {
+		msg, ok := detail.(proto.Message)
+		if !ok {
+			continue
+		}
+		if errorDetail, err := connect.NewErrorDetail(msg); err == nil {
+			connectErr.AddDetail(errorDetail)
+		}
+	}
+	return connectErr
+}
+
+/*
+Returns the BreakwaterRejection detail of a connect error, false if err is
+not a breakwater rejection
+*/
+func RejectionFromError(err error) (*bwpb.BreakwaterRejection, bool) {
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		return nil, false
+	}
+	for _, detail := range connectErr.Details() {
+		value, err := detail.Value()
+		if err != nil {
+			continue
+		}
+		if rejection, ok := value.(*bwpb.BreakwaterRejection); ok {
+			return rejection, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,99 @@
+package breakwaterconnect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/bwpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const pingProcedure = "/breakwater.test.v1.TestService/Ping"
+
+// Serves an empty unary procedure guarded by the server's interceptor
+func startServer(t *testing.T, server *bw.Breakwater) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(pingProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithInterceptors(NewInterceptor(server))))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestCreditsOverConnect(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+	ts := startServer(t, server)
+	ping := connect.NewClient[emptypb.Empty, emptypb.Empty](ts.Client(), ts.URL+pingProcedure,
+		connect.WithInterceptors(NewInterceptor(client)))
+
+	for i := 0; i < 3; i++ {
+		res, err := ping.CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		if res.Header().Get("Credits") == "" {
+			t.Errorf("Expected credits in the response headers")
+		}
+	}
+}
+
+func TestConnectRejection(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	// any queueing delay is beyond the AQM threshold
+	server.SetSLO(0)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+	ts := startServer(t, server)
+	ping := connect.NewClient[emptypb.Empty, emptypb.Empty](ts.Client(), ts.URL+pingProcedure,
+		connect.WithInterceptors(NewInterceptor(client)))
+
+	_, err := ping.CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{}))
+	if connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Fatalf("Expected the request to be shed with RESOURCE_EXHAUSTED, got %v", err)
+	}
+	rejection, ok := RejectionFromError(err)
+	if !ok || rejection.GetReason() != bwpb.BreakwaterRejection_REASON_SERVER_AQM {
+		t.Errorf("Expected a server AQM rejection detail, got %v", rejection)
+	}
+}
+
+func TestHandlerPanicReleasesSlot(t *testing.T) {
+	params := bw.BWParametersDefault
+	params.MaxConcurrentHandlers = 1
+	server := bw.InitBreakwater(params)
+	var calls int
+	mux := http.NewServeMux()
+	mux.Handle(pingProcedure, connect.NewUnaryHandler(pingProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			calls++
+			if calls == 1 {
+				panic(http.ErrAbortHandler)
+			}
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithInterceptors(NewInterceptor(server))))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	newPing := func() *connect.Client[emptypb.Empty, emptypb.Empty] {
+		return connect.NewClient[emptypb.Empty, emptypb.Empty](ts.Client(), ts.URL+pingProcedure,
+			connect.WithInterceptors(NewInterceptor(bw.InitBreakwater(bw.BWParametersDefault))))
+	}
+
+	if _, err := newPing().CallUnary(context.Background(), connect.NewRequest(&emptypb.Empty{})); err == nil {
+		t.Fatalf("Expected the panicking handler to abort the call")
+	}
+	// the aborted call carried no credits, so a fresh client sends the next one
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := newPing().CallUnary(ctx, connect.NewRequest(&emptypb.Empty{})); err != nil {
+		t.Fatalf("Expected the handler slot to be free after the panic: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package breakwatergateway passes breakwater overload status through
+// grpc-gateway, so REST callers of a gRPC service get actionable backpressure:
+//
+//	mux := runtime.NewServeMux(runtime.WithErrorHandler(breakwatergateway.ErrorHandler(nil)))
+package breakwatergateway
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+/*
+ErrorHandler answers breakwater rejections with a 429, a Retry-After header
+from their RetryInfo detail and the rejection reason in Bw-Rejection, then
+writes the error body with next. Other errors go to next unchanged.
+next is runtime.DefaultHTTPErrorHandler if nil.
+*/
+func ErrorHandler(next runtime.ErrorHandlerFunc) runtime.ErrorHandlerFunc {
+	if next == nil {
+		next = runtime.DefaultHTTPErrorHandler
+	}
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+		rejection, ok := bw.RejectionFromError(err)
+		if !ok {
+			next(ctx, mux, marshaler, w, r, err)
+			return
+		}
+		if delay, ok := bw.RetryDelayFromError(err); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		}
+		w.Header().Set("Bw-Rejection", rejection.GetReason().String())
+		// next may map ResourceExhausted to another status
+		next(ctx, mux, marshaler, w, r, &runtime.HTTPStatusError{HTTPStatus: http.StatusTooManyRequests, Err: err})
+	}
+}
@@ -0,0 +1,47 @@
+package breakwatergateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func handleError(err error) *httptest.ResponseRecorder {
+	mux := runtime.NewServeMux()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/ping", nil)
+	ErrorHandler(nil)(context.Background(), mux, &runtime.JSONPb{}, w, r, err)
+	return w
+}
+
+func TestRejectionIsTooManyRequests(t *testing.T) {
+	w := handleError(bw.ServerAQMError("server-a", 5000, 1500*time.Millisecond))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a 429, got %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected the retry delay rounded up to 2 seconds, got %q", retryAfter)
+	}
+	if reason := w.Header().Get("Bw-Rejection"); reason != "REASON_SERVER_AQM" {
+		t.Errorf("Expected the rejection reason, got %q", reason)
+	}
+	if w.Body.Len() == 0 {
+		t.Errorf("Expected the status in the body")
+	}
+}
+
+func TestOtherErrorsUnchanged(t *testing.T) {
+	w := handleError(errors.New("boom"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the default status, got %d", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "" {
+		t.Errorf("Expected no Retry-After header, got %q", retryAfter)
+	}
+}
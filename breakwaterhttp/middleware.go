@@ -0,0 +1,214 @@
+// Package breakwaterhttp adds breakwater overload control to net/http
+// servers and clients.
+//
+// Credits travel in HTTP headers the same way they travel in gRPC metadata,
+// so mixed gRPC and REST services can share one Breakwater:
+//
+//	mux.Handle("/", breakwaterhttp.Handler(bw, handler))
+//	client := &http.Client{Transport: &breakwaterhttp.RoundTripper{Breakwater: bw}}
+package breakwaterhttp
+
+import (
+	"context"
+	"encoding/base64"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const binarySuffix = "-bin"
+
+/*
+Handler runs admission control before next, and sends the credits issued
+to the client in the response headers.
+The request path is taken as the method name for MethodSLOs.
+Rejected requests get a 429 with a Retry-After header and the rejection
+reason in Bw-Rejection, malformed breakwater headers a 400, and clients
+without a required identity a 401.
+A grant made while the request is handled is sent in the response headers
+when next starts writing them, or once it returns if it wrote none. RTT
+echoes and timing go in HTTP trailers.
+*/
+func Handler(b *bw.Breakwater, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := metadata.NewIncomingContext(r.Context(), HeaderMetadata(r.Header))
+		if r.TLS != nil {
+			// lets the server identify clients by their certificate
+			ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+		}
+		admission, err := b.Admit(bw.ContextWithMethod(ctx, r.URL.Path))
+		SetHeaders(w.Header(), admission.Header)
+		if err != nil {
+			writeRejection(w, err)
+			return
+		}
+		gw := &grantWriter{ResponseWriter: w, admission: admission}
+		// deferred so a handler panic, which net/http recovers, still finishes the request
+		defer func() {
+			header, trailer := admission.Done()
+			if !gw.wroteHeader {
+				SetHeaders(w.Header(), header)
+			}
+			SetHeaders(w.Header(), trailerMetadata(trailer))
+		}()
+		next.ServeHTTP(gw, r.WithContext(admission.Context(r.Context())))
+	})
+}
+
+/*
+Adds the grant pending when the handler starts writing the response to its
+headers, and declares the trailers Done will set
+*/
+type grantWriter struct {
+	http.ResponseWriter
+	admission   *bw.Admission
+	wroteHeader bool
+}
+
+func (w *grantWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		SetHeaders(w.Header(), w.admission.PendingHeader())
+		for _, key := range w.admission.TrailerKeys() {
+			w.Header().Add("Trailer", key)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *grantWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *grantWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Lets http.ResponseController reach the underlying ResponseWriter
+func (w *grantWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+/*
+Prefixes the keys of md with http.TrailerPrefix, so SetHeaders sends them
+as trailers once the body is written
+*/
+func trailerMetadata(md metadata.MD) metadata.MD {
+	prefixed := make(metadata.MD, len(md))
+	for key, values := range md {
+		prefixed[http.TrailerPrefix+key] = values
+	}
+	return prefixed
+}
+
+/*
+RoundTripper waits for a credit before sending each request with Base, and
+applies the credits granted in the response headers. Requests to the same
+host share its credits.
+Requests rejected on the client fail with the breakwater rejection error,
+which breakwater.RejectionFromError reads once unwrapped from the
+*url.Error. Rejections by the server are returned as their 429 response and
+are not retried.
+*/
+type RoundTripper struct {
+	Breakwater *bw.Breakwater
+	Base       http.RoundTripper // http.DefaultTransport if nil
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := rt.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var resp *http.Response
+	send := func(ctx context.Context) (metadata.MD, metadata.MD, error) {
+		out := req.Clone(ctx)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		SetHeaders(out.Header, md)
+		var err error
+		resp, err = base.RoundTrip(out)
+		if err != nil {
+			return nil, nil, err
+		}
+		return HeaderMetadata(resp.Header), nil, nil
+	}
+	// only the breakwater metadata ends up in the request headers
+	ctx := metadata.NewOutgoingContext(req.Context(), metadata.MD{})
+	if err := rt.Breakwater.Invoke(ctx, req.URL.Host, send); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+/*
+Converts HTTP headers to metadata, decoding binary values as gRPC does
+*/
+func HeaderMetadata(h http.Header) metadata.MD {
+	md := make(metadata.MD, len(h))
+	for key, values := range h {
+		key = strings.ToLower(key)
+		if !strings.HasSuffix(key, binarySuffix) {
+			md[key] = values
+			continue
+		}
+		for _, v := range values {
+			decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(v, "="))
+			if err != nil {
+				continue
+			}
+			md[key] = append(md[key], string(decoded))
+		}
+	}
+	return md
+}
+
+/*
+Sets metadata in HTTP headers, replacing earlier values of its keys and
+base64 encoding binary values as gRPC does
+*/
+func SetHeaders(h http.Header, md metadata.MD) {
+	for key, values := range md {
+		h.Del(key)
+		for _, v := range values {
+			if strings.HasSuffix(key, binarySuffix) {
+				v = base64.RawStdEncoding.EncodeToString([]byte(v))
+			}
+			h.Add(key, v)
+		}
+	}
+}
+
+func writeRejection(w http.ResponseWriter, err error) {
+	code := http.StatusServiceUnavailable
+	switch status.Code(err) {
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	}
+	if delay, ok := bw.RetryDelayFromError(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	if rejection, ok := bw.RejectionFromError(err); ok {
+		w.Header().Set("Bw-Rejection", rejection.GetReason().String())
+	}
+	http.Error(w, status.Convert(err).Message(), code)
+}
@@ -0,0 +1,146 @@
+package breakwaterhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func TestCreditsOverHTTP(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	params := bw.BWParametersDefault
+	params.BinaryMetadata = true
+	client := bw.InitBreakwater(params)
+
+	var mu sync.Mutex
+	var binary []bool
+	ts := httptest.NewServer(Handler(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		binary = append(binary, r.Header.Get("Breakwater-Bin") != "")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	t.Cleanup(ts.Close)
+	httpClient := &http.Client{Transport: &RoundTripper{Breakwater: client}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := httpClient.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("Expected the request to be handled, got status %d", resp.StatusCode)
+		}
+		if resp.Header.Get("Credits") == "" {
+			t.Errorf("Expected credits in the response headers")
+		}
+	}
+	// the binary header is sent once the server's version is known
+	if len(binary) != 2 || binary[0] || !binary[1] {
+		t.Errorf("Expected only the second request to use the binary header, got %v", binary)
+	}
+}
+
+func TestHandlerRejectsMissingMetadata(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	ts := httptest.NewServer(Handler(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the request to be rejected before the handler")
+	})))
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a request without breakwater headers to get a 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerSheds(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	// any queueing delay is beyond the AQM threshold
+	server.SetSLO(0)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+	ts := httptest.NewServer(Handler(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the request to be shed before the handler")
+	})))
+	t.Cleanup(ts.Close)
+	httpClient := &http.Client{Transport: &RoundTripper{Breakwater: client}}
+
+	resp, err := httpClient.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected a shed request to get a 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" || resp.Header.Get("Bw-Rejection") != "REASON_SERVER_AQM" {
+		t.Errorf("Expected Retry-After and the rejection reason, got %v", resp.Header)
+	}
+}
+
+func TestHandlerPanicReleasesSlot(t *testing.T) {
+	params := bw.BWParametersDefault
+	params.MaxConcurrentHandlers = 1
+	server := bw.InitBreakwater(params)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+	var calls int
+	ts := httptest.NewServer(Handler(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	t.Cleanup(ts.Close)
+	httpClient := &http.Client{Transport: &RoundTripper{Breakwater: client}, Timeout: 2 * time.Second}
+
+	if resp, err := httpClient.Get(ts.URL); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Expected the panicking handler to abort the response")
+	}
+	// the aborted response carried no credits, so a fresh client sends the next request
+	httpClient.Transport = &RoundTripper{Breakwater: bw.InitBreakwater(bw.BWParametersDefault)}
+	resp, err := httpClient.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Expected the handler slot to be free after the panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected the second request to be handled, got status %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerSendsTrailers(t *testing.T) {
+	params := bw.BWParametersDefault
+	params.TimingTrailers = true
+	server := bw.InitBreakwater(params)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+	ts := httptest.NewServer(Handler(server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})))
+	t.Cleanup(ts.Close)
+	httpClient := &http.Client{Transport: &RoundTripper{Breakwater: client}}
+
+	resp, err := httpClient.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Credits") == "" {
+		t.Errorf("Expected credits in the response headers")
+	}
+	if _, ok := bw.TimingFromTrailer(HeaderMetadata(resp.Trailer)); !ok {
+		t.Errorf("Expected the request timing in the response trailers, got %v", resp.Trailer)
+	}
+}
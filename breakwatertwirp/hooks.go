@@ -0,0 +1,139 @@
+// Package breakwatertwirp adds breakwater overload control to Twirp servers
+// and clients, sharing the same Breakwater as the gRPC interceptors:
+//
+//	hooks := breakwatertwirp.ServerHooks(bw)
+//	server := breakwatertwirp.WithHeaders(pingv1.NewPingServiceServer(svc, twirp.WithServerHooks(hooks)))
+//	client := pingv1.NewPingServiceProtobufClient(url, breakwatertwirp.HTTPClient(bw, http.DefaultClient))
+package breakwatertwirp
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/breakwaterhttp"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type admissionKey struct{}
+
+/*
+State of an admitted request, kept in its context between hooks
+*/
+type admitted struct {
+	admission *bw.Admission
+	done      bool
+}
+
+/*
+WithHeaders wraps a Twirp server so its hooks can read the breakwater
+request headers, which Twirp does not pass to the server's context itself
+*/
+func WithHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := metadata.NewIncomingContext(r.Context(), breakwaterhttp.HeaderMetadata(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/*
+ServerHooks runs admission control once a request is routed, and sends the
+credits issued to the client in the response headers.
+Rejected requests fail with a resource_exhausted Twirp error, a 429, with a
+Retry-After header and the rejection reason in the breakwater_reason meta.
+The server has to be wrapped with WithHeaders.
+*/
+func ServerHooks(b *bw.Breakwater) *twirp.ServerHooks {
+	return &twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			admission, err := b.Admit(bw.ContextWithMethod(ctx, fullMethod(ctx)))
+			setResponseHeaders(ctx, admission.Header)
+			if err != nil {
+				return ctx, twirpError(ctx, err)
+			}
+			return context.WithValue(admission.Context(ctx), admissionKey{}, &admitted{admission: admission}), nil
+		},
+		ResponsePrepared: func(ctx context.Context) context.Context {
+			if a, ok := ctx.Value(admissionKey{}).(*admitted); ok && !a.done {
+				a.done = true
+				// the response header is not written yet, so a grant made
+				// while the request was handled still makes it
+				header, _ := a.admission.Done()
+				setResponseHeaders(ctx, header)
+			}
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			// requests that failed in the handler skip ResponsePrepared
+			if a, ok := ctx.Value(admissionKey{}).(*admitted); ok && !a.done {
+				a.done = true
+				a.admission.Done()
+			}
+		},
+	}
+}
+
+/*
+HTTPClient returns a copy of client for Twirp clients that waits for a
+credit before each request and applies the credits granted in responses.
+Requests rejected on the client fail with an internal Twirp error whose
+cause is the breakwater rejection.
+*/
+func HTTPClient(b *bw.Breakwater, client *http.Client) *http.Client {
+	wrapped := *client
+	wrapped.Transport = &breakwaterhttp.RoundTripper{Breakwater: b, Base: client.Transport}
+	return &wrapped
+}
+
+func setResponseHeaders(ctx context.Context, md metadata.MD) {
+	header := make(http.Header)
+	breakwaterhttp.SetHeaders(header, md)
+	for key, values := range header {
+		for _, v := range values {
+			if err := twirp.AddHTTPResponseHeader(ctx, key, v); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/*
+Converts a breakwater rejection, a gRPC status error, to a Twirp error
+*/
+func twirpError(ctx context.Context, err error) twirp.Error {
+	st := status.Convert(err)
+	code := twirp.Unavailable
+	switch st.Code() {
+	case codes.ResourceExhausted:
+		code = twirp.ResourceExhausted
+	case codes.InvalidArgument:
+		code = twirp.InvalidArgument
+	}
+	twerr := twirp.NewError(code, st.Message())
+	if delay, ok := bw.RetryDelayFromError(err); ok {
+		twerr = twerr.WithMeta("retry_after", delay.String())
+		twirp.SetHTTPResponseHeader(ctx, "Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	if rejection, ok := bw.RejectionFromError(err); ok {
+		twerr = twerr.WithMeta("breakwater_reason", rejection.GetReason().String())
+	}
+	return twerr
+}
+
+/*
+Full method name of a routed request, as a gRPC server would see it
+*/
+func fullMethod(ctx context.Context) string {
+	pkg, _ := twirp.PackageName(ctx)
+	service, _ := twirp.ServiceName(ctx)
+	method, _ := twirp.MethodName(ctx)
+	if pkg != "" {
+		service = pkg + "." + service
+	}
+	return "/" + service + "/" + method
+}
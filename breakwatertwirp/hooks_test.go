@@ -0,0 +1,71 @@
+package breakwatertwirp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/breakwaterhttp"
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+	"google.golang.org/grpc/metadata"
+)
+
+// Calls the hooks the way a generated Twirp server does, returning the
+// response and the error returned by RequestRouted
+func serveWithHooks(server, client *bw.Breakwater) (*httptest.ResponseRecorder, error) {
+	hooks := ServerHooks(server)
+	var routedErr error
+	handler := WithHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ctxsetters.WithResponseWriter(r.Context(), w)
+		ctx, routedErr = hooks.RequestRouted(ctx)
+		if routedErr != nil {
+			twirp.WriteError(w, routedErr)
+			return
+		}
+		ctx = hooks.ResponsePrepared(ctx)
+		w.WriteHeader(http.StatusOK)
+		hooks.ResponseSent(ctx)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/twirp/breakwater.test.v1.TestService/Ping", nil)
+	md, _ := metadata.FromOutgoingContext(client.WithMetadata(context.Background(), "server-a"))
+	breakwaterhttp.SetHeaders(req.Header, md)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w, routedErr
+}
+
+func TestHooksIssueCredits(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+
+	w, err := serveWithHooks(server, client)
+	if err != nil {
+		t.Fatalf("Expected the request to be admitted, got %v", err)
+	}
+	if w.Header().Get("Credits") == "" {
+		t.Errorf("Expected credits in the response headers, got %v", w.Header())
+	}
+}
+
+func TestHooksShed(t *testing.T) {
+	server := bw.InitBreakwater(bw.BWParametersDefault)
+	// any queueing delay is beyond the AQM threshold
+	server.SetSLO(0)
+	client := bw.InitBreakwater(bw.BWParametersDefault)
+
+	w, err := serveWithHooks(server, client)
+	twerr, ok := err.(twirp.Error)
+	if !ok || twerr.Code() != twirp.ResourceExhausted {
+		t.Fatalf("Expected a resource_exhausted Twirp error, got %v", err)
+	}
+	if twerr.Meta("breakwater_reason") != "REASON_SERVER_AQM" {
+		t.Errorf("Expected the rejection reason in the error meta, got %v", twerr.MetaMap())
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,260 @@
+// Package bwbalancer turns breakwater credits into a load balancing signal.
+//
+// When a client reaches several replicas of a service through one
+// ClientConn, the replicas grant credits independently. A Tracker records
+// the last grant of each replica, and the "breakwater_credits" balancer
+// picks the ready replica with the most credits left, so requests drift
+// away from replicas that are shedding or granting little.
+//
+//	tracker := bwbalancer.NewTracker(breakwater)
+//	conn, err := grpc.Dial(target, append(tracker.DialOptions(), opts...)...)
+package bwbalancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+const Name = "breakwater_credits" // name of the balancer in service configs
+
+// a replica nothing has been heard from yet is assumed to hold the single
+// credit every client starts with
+const initialCredits = 1
+
+var (
+	trackers    sync.Map // *Tracker by id, for balancers to find theirs
+	nextTracker int64
+)
+
+func init() {
+	balancer.Register(builder{})
+}
+
+/*
+Credits each replica behind a ClientConn last granted, less the requests
+sent to it since
+*/
+type Tracker struct {
+	id       string
+	bw       *bw.Breakwater
+	mu       sync.Mutex
+	replicas map[string]*int64 // credits by replica address, accessed atomically
+}
+
+/*
+Returns a Tracker whose interceptor runs b's client interceptor and
+records the grants of each replica
+*/
+func NewTracker(b *bw.Breakwater) *Tracker {
+	t := &Tracker{
+		id:       strconv.FormatInt(atomic.AddInt64(&nextTracker, 1), 10),
+		bw:       b,
+		replicas: make(map[string]*int64),
+	}
+	trackers.Store(t.id, t)
+	return t
+}
+
+/*
+Dial options selecting the balancer for this Tracker and installing its
+interceptor, in place of the Breakwater's own UnaryInterceptorClient
+*/
+func (t *Tracker) DialOptions() []grpc.DialOption {
+	config := fmt.Sprintf(`{"loadBalancingConfig":[{%q:{"tracker":%q}}]}`, Name, t.id)
+	return []grpc.DialOption{
+		grpc.WithDefaultServiceConfig(config),
+		grpc.WithUnaryInterceptor(t.UnaryInterceptorClient),
+	}
+}
+
+/*
+Credits replica addr is believed to hold
+*/
+func (t *Tracker) Credits(addr string) int64 {
+	return atomic.LoadInt64(t.replica(addr))
+}
+
+func (t *Tracker) replica(addr string) *int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	credits, ok := t.replicas[addr]
+	if !ok {
+		credits = new(int64)
+		*credits = initialCredits
+		t.replicas[addr] = credits
+	}
+	return credits
+}
+
+/*
+Records the outcome of a request sent to replica addr. A grant replaces
+what the replica was believed to hold, and a rejection leaves it with none
+until it grants again.
+*/
+func (t *Tracker) record(addr string, header metadata.MD, err error) {
+	if addr == "" {
+		return
+	}
+	credits := t.replica(addr)
+	if grants := header.Get("credits"); len(grants) > 0 {
+		if grant, perr := strconv.ParseInt(grants[len(grants)-1], 10, 64); perr == nil {
+			atomic.StoreInt64(credits, grant)
+			return
+		}
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		atomic.StoreInt64(credits, 0)
+	}
+}
+
+type pickKey struct{}
+
+/*
+Where the picker leaves the address it chose for a request, so the
+interceptor can attribute the response to that replica
+*/
+type pick struct {
+	mu   sync.Mutex
+	addr string
+}
+
+func (p *pick) set(addr string) {
+	p.mu.Lock()
+	p.addr = addr
+	p.mu.Unlock()
+}
+
+func (p *pick) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr
+}
+
+/*
+Runs the Breakwater's client interceptor, and records the grant in the
+response against the replica the request was sent to
+*/
+func (t *Tracker) UnaryInterceptorClient(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	p := &pick{}
+	ctx = context.WithValue(ctx, pickKey{}, p)
+	tracked := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		var header metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+		t.record(p.get(), header, err)
+		return err
+	}
+	return t.bw.UnaryInterceptorClient(ctx, method, req, reply, cc, tracked, opts...)
+}
+
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig
+	Tracker string `json:"tracker"`
+}
+
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+func (builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &lbConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("bwbalancer: invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+/*
+A base balancer managing the subconns, with a picker builder that learns
+its Tracker from the balancer config
+*/
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &pickerBuilder{}
+	return &creditBalancer{
+		Balancer: base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pb:       pb,
+	}
+}
+
+type creditBalancer struct {
+	balancer.Balancer
+	pb *pickerBuilder
+}
+
+func (b *creditBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*lbConfig); ok {
+		if t, ok := trackers.Load(cfg.Tracker); ok {
+			b.pb.setTracker(t.(*Tracker))
+		}
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+type pickerBuilder struct {
+	mu      sync.Mutex
+	tracker *Tracker // nil without a config, picks then rotate evenly
+}
+
+func (pb *pickerBuilder) setTracker(t *Tracker) {
+	pb.mu.Lock()
+	pb.tracker = t
+	pb.mu.Unlock()
+}
+
+func (pb *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	pb.mu.Lock()
+	p := &picker{tracker: pb.tracker}
+	pb.mu.Unlock()
+	for sc, sci := range info.ReadySCs {
+		p.subConns = append(p.subConns, sc)
+		p.addrs = append(p.addrs, sci.Address.Addr)
+	}
+	return p
+}
+
+type picker struct {
+	tracker  *Tracker
+	subConns []balancer.SubConn
+	addrs    []string
+	next     uint32 // where the scan starts, rotated so ties spread out
+}
+
+/*
+Picks the ready replica with the most credits and spends one of them
+*/
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	start := int(atomic.AddUint32(&p.next, 1))
+	best := start % len(p.subConns)
+	if p.tracker != nil {
+		most := p.tracker.Credits(p.addrs[best])
+		for i := 1; i < len(p.subConns); i++ {
+			j := (start + i) % len(p.subConns)
+			if credits := p.tracker.Credits(p.addrs[j]); credits > most {
+				best, most = j, credits
+			}
+		}
+		atomic.AddInt64(p.tracker.replica(p.addrs[best]), -1)
+	}
+	if pk, ok := info.Ctx.Value(pickKey{}).(*pick); ok {
+		pk.set(p.addrs[best])
+	}
+	return balancer.PickResult{SubConn: p.subConns[best]}, nil
+}
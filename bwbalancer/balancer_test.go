@@ -0,0 +1,99 @@
+package bwbalancer
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/*
+Starts a replica on bufconn counting the requests that reach it, behind
+interceptor
+*/
+func startReplica(t *testing.T, interceptor grpc.UnaryServerInterceptor) (*bufconn.Listener, *int64) {
+	handled := new(int64)
+	counter := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt64(handled, 1)
+		return handler(ctx, req)
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(counter, interceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis, handled
+}
+
+func TestPrefersReplicaWithCredits(t *testing.T) {
+	params := bw.BWParametersDefault
+	params.ServerSide = true
+	params.DelaySignal = bw.DelaySignalRPC
+	healthy, healthyHandled := startReplica(t, bw.InitBreakwater(params).UnaryInterceptor)
+	overloaded, overloadedHandled := startReplica(t,
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return nil, status.Error(codes.ResourceExhausted, "overloaded")
+		})
+	listeners := map[string]*bufconn.Listener{"healthy": healthy, "overloaded": overloaded}
+
+	r := manual.NewBuilderWithScheme("bwbalancer")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: "healthy"}, {Addr: "overloaded"}}})
+	clientParams := bw.BWParametersDefault
+	clientParams.ClientExpiration = 1000000
+	tracker := NewTracker(bw.InitBreakwater(clientParams))
+	cc, err := grpc.Dial(r.Scheme()+":///replicas", append(tracker.DialOptions(),
+		grpc.WithResolvers(r),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) { return listeners[addr].DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	hc := healthpb.NewHealthClient(cc)
+	for i := 0; i < 50; i++ {
+		_, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		if err != nil && status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+	// the replicas tie until the first responses, then only the healthy one grants
+	if n := atomic.LoadInt64(overloadedHandled); n > 2 {
+		t.Errorf("Expected the overloaded replica to be avoided once it rejected, got %d of 50 requests", n)
+	}
+	if n := atomic.LoadInt64(healthyHandled); n < 48 {
+		t.Errorf("Expected the healthy replica to take the rest, got %d of 50 requests", n)
+	}
+}
+
+func TestRecordKeepsLatestGrant(t *testing.T) {
+	tracker := NewTracker(bw.InitBreakwater(bw.BWParametersDefault))
+	if credits := tracker.Credits("a"); credits != initialCredits {
+		t.Fatalf("Expected an unseen replica to hold %d credit, got %d", initialCredits, credits)
+	}
+	tracker.record("a", metadata.Pairs("credits", "3", "credits", "7"), nil)
+	if credits := tracker.Credits("a"); credits != 7 {
+		t.Errorf("Expected the last grant of 7 credits, got %d", credits)
+	}
+	// a failure without a grant leaves the count alone
+	tracker.record("a", nil, status.Error(codes.Unavailable, "down"))
+	if credits := tracker.Credits("a"); credits != 7 {
+		t.Errorf("Expected 7 credits after a failure, got %d", credits)
+	}
+	tracker.record("a", nil, status.Error(codes.ResourceExhausted, "shed"))
+	if credits := tracker.Credits("a"); credits != 0 {
+		t.Errorf("Expected no credits after a rejection, got %d", credits)
+	}
+}
@@ -0,0 +1,226 @@
+// Package bwbench generates load against an in-process gRPC server, with and
+// without breakwater, to validate controller changes.
+//
+// The server runs over bufconn with a fixed number of workers, each request
+// holding one for its service time. Requests waiting for a worker are
+// reported to breakwater as an application queue, so the controller sees
+// the backlog. Clients send open-loop Poisson traffic, each through its own
+// Breakwater:
+//
+//	result, err := bwbench.Run(bwbench.Config{Breakwater: true, Rate: 8000, ...})
+package bwbench
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+/*
+Distribution of the time a request holds a server worker
+*/
+type ServiceTime func(rng *rand.Rand) time.Duration
+
+func Constant(d time.Duration) ServiceTime {
+	return func(*rand.Rand) time.Duration { return d }
+}
+
+func Exponential(mean time.Duration) ServiceTime {
+	return func(rng *rand.Rand) time.Duration { return time.Duration(rng.ExpFloat64() * float64(mean)) }
+}
+
+/*
+Bimodal service times, slow with probability pSlow
+*/
+func Bimodal(fast, slow time.Duration, pSlow float64) ServiceTime {
+	return func(rng *rand.Rand) time.Duration {
+		if rng.Float64() < pSlow {
+			return slow
+		}
+		return fast
+	}
+}
+
+type Config struct {
+	Breakwater  bool            // run the server and clients with the breakwater interceptors
+	Params      bw.BWParameters // breakwater parameters for the server and the clients
+	Clients     int             // client connections, each with its own Breakwater
+	Workers     int             // requests the server handles at once
+	Rate        float64         // mean requests per second across all clients
+	Duration    time.Duration   // how long load is generated
+	Timeout     time.Duration   // deadline of each request
+	ServiceTime ServiceTime     // time each request holds a worker
+	Seed        int64           // seed of the arrival and service time generators
+}
+
+type Result struct {
+	Sent      int64         // requests sent
+	Succeeded int64         // requests that completed within their deadline
+	Shed      int64         // requests rejected by breakwater, on the client or the server
+	Failed    int64         // requests that failed otherwise, mostly by missing their deadline
+	Goodput   float64       // succeeded requests per second
+	ShedRate  float64       // fraction of requests shed
+	P50       time.Duration // median latency of succeeded requests
+	P99       time.Duration // 99th percentile latency of succeeded requests
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("sent %d, goodput %.0f/s, shed %.1f%%, failed %d, p50 %v, p99 %v",
+		r.Sent, r.Goodput, 100*r.ShedRate, r.Failed, r.P50, r.P99)
+}
+
+/*
+Echo server whose handlers wait for one of a fixed number of workers, and
+hold it for the request's service time
+*/
+type server struct {
+	pb.UnimplementedEchoServer
+	workers    chan struct{}
+	queue      *bw.AppQueue // nil without breakwater
+	mu         sync.Mutex
+	waiting    int64         // requests waiting for a worker
+	oldestWait time.Duration // wait of the last request to get a worker
+}
+
+func (s *server) UnaryEcho(ctx context.Context, in *pb.EchoRequest) (*pb.EchoResponse, error) {
+	serviceTime, err := time.ParseDuration(in.Message)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	arrived := time.Now()
+	s.report(1, -1)
+	select {
+	case s.workers <- struct{}{}:
+	case <-ctx.Done():
+		s.report(-1, -1)
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	// workers are handed out roughly in arrival order, so this was the oldest waiter
+	s.report(-1, time.Since(arrived))
+	time.Sleep(serviceTime)
+	<-s.workers
+	return &pb.EchoResponse{Message: in.Message}, nil
+}
+
+/*
+Reports the worker queue to breakwater, wait is negative if unchanged
+*/
+func (s *server) report(delta int64, wait time.Duration) {
+	s.mu.Lock()
+	s.waiting += delta
+	if wait >= 0 {
+		s.oldestWait = wait
+	}
+	if s.waiting == 0 {
+		s.oldestWait = 0
+	}
+	if s.queue != nil {
+		s.queue.Report(s.waiting, s.oldestWait)
+	}
+	s.mu.Unlock()
+}
+
+/*
+Runs the benchmark described by cfg
+*/
+func Run(cfg Config) (Result, error) {
+	serverParams := cfg.Params
+	serverParams.ServerSide = true
+	srv := &server{workers: make(chan struct{}, cfg.Workers)}
+	var opts []grpc.ServerOption
+	if cfg.Breakwater {
+		b := bw.InitBreakwater(serverParams)
+		srv.queue = b.RegisterAppQueue("workers", 0)
+		opts = append(opts, grpc.UnaryInterceptor(b.UnaryInterceptor))
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(opts...)
+	pb.RegisterEchoServer(s, srv)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	clients := make([]pb.EchoClient, cfg.Clients)
+	for i := range clients {
+		dialOpts := []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		}
+		if cfg.Breakwater {
+			dialOpts = append(dialOpts, grpc.WithUnaryInterceptor(bw.InitBreakwater(cfg.Params).UnaryInterceptorClient))
+		}
+		cc, err := grpc.Dial("bufnet", dialOpts...)
+		if err != nil {
+			return Result{}, err
+		}
+		defer cc.Close()
+		clients[i] = pb.NewEchoClient(cc)
+	}
+
+	var (
+		result    Result
+		mu        sync.Mutex
+		latencies []time.Duration
+		wg        sync.WaitGroup
+	)
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	start := time.Now()
+	next := start
+	for i := 0; time.Since(start) < cfg.Duration; i++ {
+		// Poisson arrivals, independent of how fast the server responds
+		next = next.Add(time.Duration(rng.ExpFloat64() / cfg.Rate * float64(time.Second)))
+		time.Sleep(time.Until(next))
+		client := clients[i%len(clients)]
+		serviceTime := cfg.ServiceTime(rng)
+		result.Sent++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			defer cancel()
+			sent := time.Now()
+			_, err := client.UnaryEcho(ctx, &pb.EchoRequest{Message: serviceTime.String()})
+			latency := time.Since(sent)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result.Succeeded++
+				latencies = append(latencies, latency)
+			case status.Code(err) == codes.ResourceExhausted:
+				result.Shed++
+			default:
+				result.Failed++
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.Goodput = float64(result.Succeeded) / cfg.Duration.Seconds()
+	if result.Sent > 0 {
+		result.ShedRate = float64(result.Shed) / float64(result.Sent)
+	}
+	result.P50 = percentile(latencies, 0.5)
+	result.P99 = percentile(latencies, 0.99)
+	return result, nil
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+}
@@ -0,0 +1,83 @@
+package bwbench
+
+import (
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func benchConfig(withBreakwater bool, rate float64) Config {
+	params := bw.BWParametersDefault
+	params.SLO = 5000
+	return Config{
+		Breakwater:  withBreakwater,
+		Params:      params,
+		Clients:     10,
+		Workers:     4,
+		Rate:        rate,
+		Duration:    time.Second,
+		Timeout:     50 * time.Millisecond,
+		ServiceTime: Exponential(time.Millisecond),
+		Seed:        1,
+	}
+}
+
+func TestRunUnderCapacity(t *testing.T) {
+	cfg := benchConfig(true, 500)
+	cfg.Params.SLO = 100000
+	cfg.Duration = 200 * time.Millisecond
+	result, err := Run(cfg)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.Sent == 0 || result.Succeeded != result.Sent {
+		t.Errorf("Expected every request to succeed under capacity, got %v", result)
+	}
+	if result.P99 < time.Millisecond/10 || result.P50 > result.P99 {
+		t.Errorf("Expected plausible latencies, got %v", result)
+	}
+}
+
+// Twice the capacity of the server, with and without breakwater
+func BenchmarkOverload(b *testing.B) {
+	for _, withBreakwater := range []bool{true, false} {
+		name := "plain"
+		if withBreakwater {
+			name = "breakwater"
+		}
+		b.Run(name, func(b *testing.B) {
+			var result Result
+			for i := 0; i < b.N; i++ {
+				var err error
+				if result, err = Run(benchConfig(withBreakwater, 8000)); err != nil {
+					b.Fatalf("Run failed: %v", err)
+				}
+			}
+			b.ReportMetric(result.Goodput, "goodput/s")
+			b.ReportMetric(float64(result.P99.Microseconds()), "p99-us")
+			b.ReportMetric(100*result.ShedRate, "shed-%")
+		})
+	}
+}
+
+// Twice the capacity of the server, with grants recomputed lazily or once
+// per RTT
+func BenchmarkGrantMode(b *testing.B) {
+	for _, mode := range []string{bw.GrantModeLazy, bw.GrantModeRTT} {
+		b.Run(mode, func(b *testing.B) {
+			cfg := benchConfig(true, 8000)
+			cfg.Params.GrantMode = mode
+			var result Result
+			for i := 0; i < b.N; i++ {
+				var err error
+				if result, err = Run(cfg); err != nil {
+					b.Fatalf("Run failed: %v", err)
+				}
+			}
+			b.ReportMetric(result.Goodput, "goodput/s")
+			b.ReportMetric(float64(result.P99.Microseconds()), "p99-us")
+			b.ReportMetric(100*result.ShedRate, "shed-%")
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// Package bwconfig loads breakwater tunables from a JSON or YAML file and
+// reloads them into a running Breakwater whenever the file changes.
+//
+// The file is a flat object using the same keys as bwxds, for example
+//
+//	slo_us: 500
+//	a_factor: 0.001
+//	use_client_time_expiration: true
+//	high_aqm_factor: 2
+//
+// Keys left out leave the current setting unchanged, so platform teams can
+// manage overload control policy as config rather than code.
+package bwconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultInterval = time.Second // how often Watcher checks the file by default
+
+/*
+Keys of a config file. Pointers tell a key left out from one set to zero,
+which is rejected where zero makes no sense.
+*/
+type config struct {
+	SLO                     *int64   `json:"slo_us" yaml:"slo_us"`
+	AFactor                 *float64 `json:"a_factor" yaml:"a_factor"`
+	BFactor                 *float64 `json:"b_factor" yaml:"b_factor"`
+	ClientExpiration        *int64   `json:"client_expiration_us" yaml:"client_expiration_us"`
+	RTT                     *int64   `json:"rtt_us" yaml:"rtt_us"`
+	UseClientTimeExpiration *bool    `json:"use_client_time_expiration" yaml:"use_client_time_expiration"`
+	UseClientQueueLength    *bool    `json:"use_client_queue_length" yaml:"use_client_queue_length"`
+	HighAQMFactor           *float64 `json:"high_aqm_factor" yaml:"high_aqm_factor"`
+	CriticalAQMFactor       *float64 `json:"critical_aqm_factor" yaml:"critical_aqm_factor"`
+	HighPriorityReserve     *float64 `json:"high_priority_reserve" yaml:"high_priority_reserve"`
+}
+
+/*
+Reads the tunables from a file, JSON if its extension is .json and YAML if
+it is .yaml or .yml. Unlike bwxds, unknown keys are rejected, since in a
+hand written file they are most likely typos.
+*/
+func LoadConfig(path string) (bw.Tunables, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return bw.Tunables{}, fmt.Errorf("bwconfig: %w", err)
+	}
+	return parseConfig(path, data)
+}
+
+/*
+Parses tunables from a JSON object using the keys of a config file, for
+sources other than files such as the gRPC service config
+*/
+func ParseJSON(data []byte) (bw.Tunables, error) {
+	var c config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return bw.Tunables{}, fmt.Errorf("bwconfig: parsing JSON: %w", err)
+	}
+	return c.tunables()
+}
+
+func parseConfig(path string, data []byte) (bw.Tunables, error) {
+	var c config
+	var err error
+	switch filepath.Ext(path) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&c)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&c); err != nil && len(bytes.TrimSpace(data)) == 0 {
+			// an empty file changes nothing
+			err = nil
+		}
+	default:
+		return bw.Tunables{}, fmt.Errorf("bwconfig: %s is neither JSON nor YAML", path)
+	}
+	if err != nil {
+		return bw.Tunables{}, fmt.Errorf("bwconfig: parsing %s: %w", path, err)
+	}
+	return c.tunables()
+}
+
+func (c config) tunables() (bw.Tunables, error) {
+	var t bw.Tunables
+	positiveInt := func(key string, value *int64, field *int64) error {
+		if value == nil {
+			return nil
+		}
+		if *value <= 0 {
+			return fmt.Errorf("bwconfig: %s must be positive, got %d", key, *value)
+		}
+		*field = *value
+		return nil
+	}
+	inRange := func(key string, value *float64, low float64, high float64, field *float64) error {
+		if value == nil {
+			return nil
+		}
+		if *value < low || *value >= high {
+			return fmt.Errorf("bwconfig: %s must be in [%g, %g), got %g", key, low, high, *value)
+		}
+		*field = *value
+		return nil
+	}
+	var reserve float64
+	for _, err := range []error{
+		positiveInt("slo_us", c.SLO, &t.SLO),
+		positiveInt("client_expiration_us", c.ClientExpiration, &t.ClientExpiration),
+		positiveInt("rtt_us", c.RTT, &t.RTT_MICROSECOND),
+		inRange("a_factor", c.AFactor, 1e-9, 1, &t.AFactor),
+		inRange("b_factor", c.BFactor, 1e-9, 1, &t.BFactor),
+		// higher priorities are never shed before best effort requests
+		inRange("high_aqm_factor", c.HighAQMFactor, 1, 1e9, &t.HighAQMFactor),
+		inRange("critical_aqm_factor", c.CriticalAQMFactor, 1, 1e9, &t.CriticalAQMFactor),
+		inRange("high_priority_reserve", c.HighPriorityReserve, 0, 1, &reserve),
+	} {
+		if err != nil {
+			return bw.Tunables{}, err
+		}
+	}
+	if c.HighAQMFactor != nil && c.CriticalAQMFactor != nil && *c.CriticalAQMFactor < *c.HighAQMFactor {
+		return bw.Tunables{}, fmt.Errorf("bwconfig: critical_aqm_factor %g must not be below high_aqm_factor %g", *c.CriticalAQMFactor, *c.HighAQMFactor)
+	}
+	if c.HighPriorityReserve != nil {
+		t.HighPriorityReserve = &reserve
+	}
+	t.UseClientTimeExpiration = c.UseClientTimeExpiration
+	t.UseClientQueueLength = c.UseClientQueueLength
+	return t, nil
+}
+
+/*
+Watcher applies a config file to a Breakwater, and again every time the
+file changes
+*/
+type Watcher struct {
+	bw   *bw.Breakwater
+	path string
+	// How often the file is checked for changes, DefaultInterval if zero
+	Interval time.Duration
+	// Called with every error reloading the file, optional. The last
+	// good config stays applied.
+	OnError func(err error)
+}
+
+var _ bw.ConfigSource = (*Watcher)(nil)
+
+func NewWatcher(b *bw.Breakwater, path string) *Watcher {
+	return &Watcher{bw: b, path: path}
+}
+
+/*
+Applies the file, then reloads it whenever its content changes until ctx is
+done. Returns the error loading the file at first, as a Breakwater should not
+start on a broken config.
+*/
+func (w *Watcher) Run(ctx context.Context) error {
+	last, err := w.reload(nil)
+	if err != nil {
+		return err
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		// config files are small, so their content is compared rather than
+		// trusting modification times, which may be coarse
+		next, err := w.reload(last)
+		if err != nil {
+			w.reportError(err)
+		}
+		last = next
+	}
+}
+
+/*
+Loads and applies the file unless its content is the one last loaded,
+returns the content loaded
+*/
+func (w *Watcher) reload(last []byte) ([]byte, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return last, fmt.Errorf("bwconfig: %w", err)
+	}
+	if last != nil && bytes.Equal(data, last) {
+		return last, nil
+	}
+	t, err := parseConfig(w.path, data)
+	if err != nil {
+		// reported once, until the file changes again
+		return data, err
+	}
+	w.bw.ApplyTunables(t)
+	return data, nil
+}
+
+func (w *Watcher) reportError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
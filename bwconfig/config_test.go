@@ -0,0 +1,113 @@
+package bwconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func writeConfig(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write the config: %v", err)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"config.yaml": "slo_us: 500\nb_factor: 0.05\nuse_client_queue_length: true\nhigh_priority_reserve: 0\n",
+		"config.json": `{"slo_us": 500, "b_factor": 0.05, "use_client_queue_length": true, "high_priority_reserve": 0}`,
+	} {
+		path := filepath.Join(dir, name)
+		writeConfig(t, path, content)
+		tunables, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: failed to load: %v", name, err)
+		}
+		if tunables.SLO != 500 || tunables.BFactor != 0.05 || tunables.AFactor != 0 {
+			t.Errorf("%s: expected the SLO and BFactor to be set and AFactor left unchanged, got %+v", name, tunables)
+		}
+		if tunables.UseClientQueueLength == nil || !*tunables.UseClientQueueLength || tunables.UseClientTimeExpiration != nil {
+			t.Errorf("%s: expected only use_client_queue_length to be set, got %+v", name, tunables)
+		}
+		if tunables.HighPriorityReserve == nil || *tunables.HighPriorityReserve != 0 {
+			t.Errorf("%s: expected a reserve of zero to disable the reserve, got %v", name, tunables.HighPriorityReserve)
+		}
+	}
+}
+
+func TestLoadConfigRejectsBadValues(t *testing.T) {
+	dir := t.TempDir()
+	for content, problem := range map[string]string{
+		"slo_us: -5\n":    "slo_us",
+		"b_factor: 1.5\n": "b_factor",
+		"high_aqm_factor: 3\ncritical_aqm_factor: 2\n": "critical_aqm_factor",
+		"slo: 500\n": "slo",
+	} {
+		path := filepath.Join(dir, "config.yml")
+		writeConfig(t, path, content)
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q to be rejected for %s, got %v", content, problem, err)
+		}
+	}
+	path := filepath.Join(dir, "config.toml")
+	writeConfig(t, path, "slo_us = 500\n")
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("Expected an unknown format to be rejected")
+	}
+}
+
+func TestWatcherReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breakwater.yaml")
+	writeConfig(t, path, "slo_us: 500\n")
+	b := bw.InitBreakwater(bw.BWParametersDefault)
+	w := NewWatcher(b, path)
+	w.Interval = 10 * time.Millisecond
+	errs := make(chan error, 10)
+	w.OnError = func(err error) { errs <- err }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitForSLO := func(slo int64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for b.Snapshot().SLO != slo {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the SLO to become %d, still %d", slo, b.Snapshot().SLO)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForSLO(500)
+
+	writeConfig(t, path, "slo_us: 800\n")
+	waitForSLO(800)
+
+	// a broken file is reported and the last good config stays applied
+	writeConfig(t, path, "slo_us: [\n")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the broken config to be reported")
+	}
+	if slo := b.Snapshot().SLO; slo != 800 {
+		t.Errorf("Expected the last good SLO to be kept, got %d", slo)
+	}
+}
+
+func TestWatcherFailsOnBrokenStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breakwater.json")
+	writeConfig(t, path, `{"slo_us": 0}`)
+	if err := NewWatcher(bw.InitBreakwater(bw.BWParametersDefault), path).Run(context.Background()); err == nil {
+		t.Errorf("Expected a broken config to stop the watcher from starting")
+	}
+}
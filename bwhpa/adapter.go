@@ -0,0 +1,125 @@
+// Package bwhpa exposes breakwater's saturation as Kubernetes custom
+// metrics, so a HorizontalPodAutoscaler can scale on the overload
+// controller's signals rather than on raw CPU:
+//
+//	http.Handle("/apis/custom.metrics.k8s.io/", bwhpa.Handler(b, bwhpa.PodFromEnv()))
+//
+// The handler answers with a MetricValueList of custom.metrics.k8s.io/v1beta2
+// describing the pod. It is meant to be scraped by a metrics adapter that
+// serves the custom or external metrics API to the cluster, such as KEDA's
+// metrics-api scaler or prometheus-adapter, rather than registered as an
+// APIService itself.
+package bwhpa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+const (
+	// credits issued to clients over the global pool, 1 once every credit is out
+	CreditUtilization = "breakwater_credit_utilization"
+	// smoothed queueing delay over the SLO, 1 once the delay reaches it
+	DelaySLORatio = "breakwater_delay_slo_ratio"
+)
+
+// The object the metrics describe, usually the pod serving them
+type Object struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion"`
+}
+
+type metricIdentifier struct {
+	Name string `json:"name"`
+}
+
+type metricValue struct {
+	DescribedObject Object           `json:"describedObject"`
+	Metric          metricIdentifier `json:"metric"`
+	Timestamp       time.Time        `json:"timestamp"`
+	Value           string           `json:"value"`
+}
+
+type metricValueList struct {
+	Kind       string        `json:"kind"`
+	APIVersion string        `json:"apiVersion"`
+	Items      []metricValue `json:"items"`
+}
+
+/*
+The pod named by the POD_NAME and POD_NAMESPACE environment variables, set
+through the downward API, falling back to the hostname, which Kubernetes
+sets to the pod name
+*/
+func PodFromEnv() Object {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return Object{Kind: "Pod", Namespace: os.Getenv("POD_NAMESPACE"), Name: name, APIVersion: "v1"}
+}
+
+/*
+Saturation ratios of the controller state in s, by metric name
+*/
+func Metrics(s bw.Snapshot) map[string]float64 {
+	return map[string]float64{
+		CreditUtilization: ratio(float64(s.CIssued), float64(s.CTotal)),
+		DelaySLORatio:     ratio(s.SmoothedDelay, float64(s.SLO)),
+	}
+}
+
+func ratio(x, y float64) float64 {
+	if y <= 0 {
+		if x > 0 {
+			return 1
+		}
+		return 0
+	}
+	return x / y
+}
+
+/*
+Formats v as a Kubernetes quantity in thousandths, so ratios under 1
+survive the integer targets of an HPA
+*/
+func quantity(v float64) string {
+	return fmt.Sprintf("%dm", int64(math.Round(v*1000)))
+}
+
+/*
+Serves the metrics of b for obj. A request whose last path segment names a
+metric, as in the custom metrics API paths, gets only that metric; any
+other gets all of them.
+*/
+func Handler(b *bw.Breakwater, obj Object) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics := Metrics(b.Snapshot())
+		now := time.Now()
+		list := metricValueList{Kind: "MetricValueList", APIVersion: "custom.metrics.k8s.io/v1beta2", Items: []metricValue{}}
+		names := []string{CreditUtilization, DelaySLORatio}
+		name := path.Base(r.URL.Path)
+		if _, ok := metrics[name]; ok {
+			names = []string{name}
+		}
+		for _, name := range names {
+			list.Items = append(list.Items, metricValue{
+				DescribedObject: obj,
+				Metric:          metricIdentifier{Name: name},
+				Timestamp:       now,
+				Value:           quantity(metrics[name]),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	})
+}
@@ -0,0 +1,58 @@
+package bwhpa
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func TestMetricsRatios(t *testing.T) {
+	m := Metrics(bw.Snapshot{CTotal: 200, CIssued: 150, SLO: 100, SmoothedDelay: 50})
+	if got := m[CreditUtilization]; got != 0.75 {
+		t.Errorf("Expected a credit utilization of 0.75, got %v", got)
+	}
+	if got := m[DelaySLORatio]; got != 0.5 {
+		t.Errorf("Expected a delay to SLO ratio of 0.5, got %v", got)
+	}
+	if got := Metrics(bw.Snapshot{})[CreditUtilization]; got != 0 {
+		t.Errorf("Expected an empty pool to report 0, got %v", got)
+	}
+}
+
+func TestHandlerServesMetricValueList(t *testing.T) {
+	params := bw.BWParametersDefault
+	params.ManualUpdates = true
+	b := bw.InitBreakwater(params)
+	pod := Object{Kind: "Pod", Namespace: "default", Name: "echo-0", APIVersion: "v1"}
+	h := Handler(b, pod)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/apis/custom.metrics.k8s.io/v1beta2/namespaces/default/pods/echo-0/"+DelaySLORatio, nil))
+	var list metricValueList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode the response: %v", err)
+	}
+	if list.Kind != "MetricValueList" || len(list.Items) != 1 {
+		t.Fatalf("Expected a MetricValueList with the requested metric, got %+v", list)
+	}
+	item := list.Items[0]
+	if item.Metric.Name != DelaySLORatio || item.DescribedObject != pod || item.Value != "0m" {
+		t.Errorf("Unexpected metric value %+v", item)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	list = metricValueList{}
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list.Items) != 2 {
+		t.Errorf("Expected every metric without a metric name, got %d", len(list.Items))
+	}
+}
+
+func TestQuantity(t *testing.T) {
+	if got := quantity(1.2345); got != "1235m" {
+		t.Errorf("Expected 1235m, got %s", got)
+	}
+}
@@ -0,0 +1,43 @@
+// Package bwlogr sends breakwater's log lines to a logr.Logger:
+//
+//	breakwater.SetLogger(bwlogr.New(log, bwlogr.DefaultSampling))
+//
+// Debug lines are written at verbosity 1 and critical ones as errors.
+// Sampling is done by breakwater per logging call site, as the formatted
+// messages differ for every request.
+package bwlogr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+// Writes the first 10 debug lines of each call site every second, then every 100th
+var DefaultSampling = bw.LogSampling{Tick: time.Second, First: 10, Thereafter: 100}
+
+const debugLevel = 1 // verbosity of breakwater's debug lines
+
+type logger struct {
+	log logr.Logger
+}
+
+/*
+Logger writing to l, with debug lines sampled by sampling; a zero sampling
+writes all of them
+*/
+func New(l logr.Logger, sampling bw.LogSampling) bw.Logger {
+	return bw.SampledLogger(logger{log: l.WithName("breakwater")}, sampling)
+}
+
+func (l logger) Debugf(format string, a ...interface{}) {
+	if v := l.log.V(debugLevel); v.Enabled() {
+		v.Info(fmt.Sprintf(format, a...))
+	}
+}
+
+func (l logger) Errorf(format string, a ...interface{}) {
+	l.log.Error(nil, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,39 @@
+package bwlogr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+func TestLinesReachLogr(t *testing.T) {
+	var lines []string
+	log := funcr.New(func(prefix, args string) {
+		lines = append(lines, prefix+" "+args)
+	}, funcr.Options{Verbosity: 1})
+	bw.SetLogger(New(log, DefaultSampling))
+	defer bw.SetLogger(nil)
+
+	params := bw.BWParametersDefault
+	params.SLO = -1
+	params.Verbose = true
+	params.ServerSide = true
+	bw.InitBreakwater(params)
+
+	var errors, debug int
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "breakwater ") {
+			t.Errorf("Expected lines to be named breakwater, got %s", line)
+		}
+		if strings.Contains(line, `"error"`) {
+			errors++
+		} else if strings.Contains(line, `"level"=1`) {
+			debug++
+		}
+	}
+	if errors == 0 || debug == 0 {
+		t.Errorf("Expected the invalid parameters as an error and debug lines at verbosity 1, got %q", lines)
+	}
+}
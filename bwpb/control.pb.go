@@ -0,0 +1,239 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: control.proto
+
+package bwpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DemandUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Id of the client's breakwater instance.
+	ClientId string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	// Number of requests waiting in the client's queue for this server.
+	Demand int64 `protobuf:"varint,2,opt,name=demand,proto3" json:"demand,omitempty"`
+}
+
+func (x *DemandUpdate) Reset() {
+	*x = DemandUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DemandUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DemandUpdate) ProtoMessage() {}
+
+func (x *DemandUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DemandUpdate.ProtoReflect.Descriptor instead.
+func (*DemandUpdate) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DemandUpdate) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *DemandUpdate) GetDemand() int64 {
+	if x != nil {
+		return x.Demand
+	}
+	return 0
+}
+
+type CreditUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// New number of credits issued to the client, 0 if unchanged.
+	// A zero grant when revoke_to is 0 and the client uses zero credits.
+	Credits int64 `protobuf:"varint,1,opt,name=credits,proto3" json:"credits,omitempty"`
+	// Clamp for the client's unspent credits, 0 if none.
+	RevokeTo int64 `protobuf:"varint,2,opt,name=revoke_to,json=revokeTo,proto3" json:"revoke_to,omitempty"`
+}
+
+func (x *CreditUpdate) Reset() {
+	*x = CreditUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_control_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CreditUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreditUpdate) ProtoMessage() {}
+
+func (x *CreditUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreditUpdate.ProtoReflect.Descriptor instead.
+func (*CreditUpdate) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreditUpdate) GetCredits() int64 {
+	if x != nil {
+		return x.Credits
+	}
+	return 0
+}
+
+func (x *CreditUpdate) GetRevokeTo() int64 {
+	if x != nil {
+		return x.RevokeTo
+	}
+	return 0
+}
+
+var File_control_proto protoreflect.FileDescriptor
+
+var file_control_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x63, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x0d, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x22, 0x43,
+	0x0a, 0x0c, 0x44, 0x65, 0x6d, 0x61, 0x6e, 0x64, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x12, 0x1b,
+	0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x64,
+	0x65, 0x6d, 0x61, 0x6e, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x64, 0x65, 0x6d,
+	0x61, 0x6e, 0x64, 0x22, 0x45, 0x0a, 0x0c, 0x43, 0x72, 0x65, 0x64, 0x69, 0x74, 0x55, 0x70, 0x64,
+	0x61, 0x74, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x72, 0x65, 0x64, 0x69, 0x74, 0x73, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x63, 0x72, 0x65, 0x64, 0x69, 0x74, 0x73, 0x12, 0x1b, 0x0a,
+	0x09, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x5f, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x08, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x54, 0x6f, 0x32, 0x5c, 0x0a, 0x11, 0x42, 0x72,
+	0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x12,
+	0x47, 0x0a, 0x07, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12, 0x1b, 0x2e, 0x62, 0x72, 0x65,
+	0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x6d, 0x61, 0x6e,
+	0x64, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x1a, 0x1b, 0x2e, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x77,
+	0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x65, 0x64, 0x69, 0x74, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x28, 0x01, 0x30, 0x01, 0x42, 0x2a, 0x5a, 0x28, 0x67, 0x69, 0x74, 0x68,
+	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c, 0x6f, 0x68, 0x70, 0x61, 0x75, 0x6c, 0x39, 0x2f,
+	0x62, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x2d, 0x67, 0x72, 0x70, 0x63, 0x2f,
+	0x62, 0x77, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_control_proto_rawDescOnce sync.Once
+	file_control_proto_rawDescData = file_control_proto_rawDesc
+)
+
+func file_control_proto_rawDescGZIP() []byte {
+	file_control_proto_rawDescOnce.Do(func() {
+		file_control_proto_rawDescData = protoimpl.X.CompressGZIP(file_control_proto_rawDescData)
+	})
+	return file_control_proto_rawDescData
+}
+
+var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_control_proto_goTypes = []interface{}{
+	(*DemandUpdate)(nil), // 0: breakwater.v1.DemandUpdate
+	(*CreditUpdate)(nil), // 1: breakwater.v1.CreditUpdate
+}
+var file_control_proto_depIdxs = []int32{
+	0, // 0: breakwater.v1.BreakwaterControl.Connect:input_type -> breakwater.v1.DemandUpdate
+	1, // 1: breakwater.v1.BreakwaterControl.Connect:output_type -> breakwater.v1.CreditUpdate
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_control_proto_init() }
+func file_control_proto_init() {
+	if File_control_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_control_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DemandUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_control_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CreditUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_control_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_control_proto_goTypes,
+		DependencyIndexes: file_control_proto_depIdxs,
+		MessageInfos:      file_control_proto_msgTypes,
+	}.Build()
+	File_control_proto = out.File
+	file_control_proto_rawDesc = nil
+	file_control_proto_goTypes = nil
+	file_control_proto_depIdxs = nil
+}
@@ -0,0 +1,137 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: control.proto
+
+package bwpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// BreakwaterControlClient is the client API for BreakwaterControl service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BreakwaterControlClient interface {
+	Connect(ctx context.Context, opts ...grpc.CallOption) (BreakwaterControl_ConnectClient, error)
+}
+
+type breakwaterControlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBreakwaterControlClient(cc grpc.ClientConnInterface) BreakwaterControlClient {
+	return &breakwaterControlClient{cc}
+}
+
+func (c *breakwaterControlClient) Connect(ctx context.Context, opts ...grpc.CallOption) (BreakwaterControl_ConnectClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BreakwaterControl_ServiceDesc.Streams[0], "/breakwater.v1.BreakwaterControl/Connect", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &breakwaterControlConnectClient{stream}
+	return x, nil
+}
+
+type BreakwaterControl_ConnectClient interface {
+	Send(*DemandUpdate) error
+	Recv() (*CreditUpdate, error)
+	grpc.ClientStream
+}
+
+type breakwaterControlConnectClient struct {
+	grpc.ClientStream
+}
+
+func (x *breakwaterControlConnectClient) Send(m *DemandUpdate) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *breakwaterControlConnectClient) Recv() (*CreditUpdate, error) {
+	m := new(CreditUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BreakwaterControlServer is the server API for BreakwaterControl service.
+// All implementations must embed UnimplementedBreakwaterControlServer
+// for forward compatibility
+type BreakwaterControlServer interface {
+	Connect(BreakwaterControl_ConnectServer) error
+	mustEmbedUnimplementedBreakwaterControlServer()
+}
+
+// UnimplementedBreakwaterControlServer must be embedded to have forward compatible implementations.
+type UnimplementedBreakwaterControlServer struct {
+}
+
+func (UnimplementedBreakwaterControlServer) Connect(BreakwaterControl_ConnectServer) error {
+	return status.Errorf(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedBreakwaterControlServer) mustEmbedUnimplementedBreakwaterControlServer() {}
+
+// UnsafeBreakwaterControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BreakwaterControlServer will
+// result in compilation errors.
+type UnsafeBreakwaterControlServer interface {
+	mustEmbedUnimplementedBreakwaterControlServer()
+}
+
+func RegisterBreakwaterControlServer(s grpc.ServiceRegistrar, srv BreakwaterControlServer) {
+	s.RegisterService(&BreakwaterControl_ServiceDesc, srv)
+}
+
+func _BreakwaterControl_Connect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BreakwaterControlServer).Connect(&breakwaterControlConnectServer{stream})
+}
+
+type BreakwaterControl_ConnectServer interface {
+	Send(*CreditUpdate) error
+	Recv() (*DemandUpdate, error)
+	grpc.ServerStream
+}
+
+type breakwaterControlConnectServer struct {
+	grpc.ServerStream
+}
+
+func (x *breakwaterControlConnectServer) Send(m *CreditUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *breakwaterControlConnectServer) Recv() (*DemandUpdate, error) {
+	m := new(DemandUpdate)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BreakwaterControl_ServiceDesc is the grpc.ServiceDesc for BreakwaterControl service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BreakwaterControl_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "breakwater.v1.BreakwaterControl",
+	HandlerType: (*BreakwaterControlServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       _BreakwaterControl_Connect_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "control.proto",
+}
@@ -0,0 +1,4 @@
+// Package bwpb contains the protobuf messages and services used by breakwater.
+package bwpb
+
+//go:generate protoc -I . --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative rejection.proto control.proto metadata.proto
@@ -0,0 +1,271 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.28.1
+// 	protoc        (unknown)
+// source: rejection.proto
+
+package bwpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type BreakwaterRejection_Reason int32
+
+const (
+	BreakwaterRejection_REASON_UNSPECIFIED BreakwaterRejection_Reason = 0
+	// The client's outgoing queue was full.
+	BreakwaterRejection_REASON_CLIENT_QUEUE_FULL BreakwaterRejection_Reason = 1
+	// The request waited longer than the client expiration for a credit.
+	BreakwaterRejection_REASON_CLIENT_EXPIRATION BreakwaterRejection_Reason = 2
+	// The request was dropped from the front of a full client queue.
+	BreakwaterRejection_REASON_CLIENT_DROPPED_FROM_FRONT BreakwaterRejection_Reason = 3
+	// The server's queueing delay was beyond the AQM threshold.
+	BreakwaterRejection_REASON_SERVER_AQM BreakwaterRejection_Reason = 4
+	// The server-side queue was full.
+	BreakwaterRejection_REASON_SERVER_QUEUE_FULL BreakwaterRejection_Reason = 5
+	// The request expired in the server-side queue.
+	BreakwaterRejection_REASON_SERVER_QUEUE_EXPIRATION BreakwaterRejection_Reason = 6
+	// The request's priority was below the server's DAGOR admission level.
+	BreakwaterRejection_REASON_SERVER_ADMISSION_LEVEL BreakwaterRejection_Reason = 7
+	// The request's priority was below the admission level the server last
+	// reported, so the client rejected it without sending.
+	BreakwaterRejection_REASON_CLIENT_ADMISSION_LEVEL BreakwaterRejection_Reason = 8
+)
+
+// Enum value maps for BreakwaterRejection_Reason.
+var (
+	BreakwaterRejection_Reason_name = map[int32]string{
+		0: "REASON_UNSPECIFIED",
+		1: "REASON_CLIENT_QUEUE_FULL",
+		2: "REASON_CLIENT_EXPIRATION",
+		3: "REASON_CLIENT_DROPPED_FROM_FRONT",
+		4: "REASON_SERVER_AQM",
+		5: "REASON_SERVER_QUEUE_FULL",
+		6: "REASON_SERVER_QUEUE_EXPIRATION",
+		7: "REASON_SERVER_ADMISSION_LEVEL",
+		8: "REASON_CLIENT_ADMISSION_LEVEL",
+	}
+	BreakwaterRejection_Reason_value = map[string]int32{
+		"REASON_UNSPECIFIED":               0,
+		"REASON_CLIENT_QUEUE_FULL":         1,
+		"REASON_CLIENT_EXPIRATION":         2,
+		"REASON_CLIENT_DROPPED_FROM_FRONT": 3,
+		"REASON_SERVER_AQM":                4,
+		"REASON_SERVER_QUEUE_FULL":         5,
+		"REASON_SERVER_QUEUE_EXPIRATION":   6,
+		"REASON_SERVER_ADMISSION_LEVEL":    7,
+		"REASON_CLIENT_ADMISSION_LEVEL":    8,
+	}
+)
+
+func (x BreakwaterRejection_Reason) Enum() *BreakwaterRejection_Reason {
+	p := new(BreakwaterRejection_Reason)
+	*p = x
+	return p
+}
+
+func (x BreakwaterRejection_Reason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (BreakwaterRejection_Reason) Descriptor() protoreflect.EnumDescriptor {
+	return file_rejection_proto_enumTypes[0].Descriptor()
+}
+
+func (BreakwaterRejection_Reason) Type() protoreflect.EnumType {
+	return &file_rejection_proto_enumTypes[0]
+}
+
+func (x BreakwaterRejection_Reason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use BreakwaterRejection_Reason.Descriptor instead.
+func (BreakwaterRejection_Reason) EnumDescriptor() ([]byte, []int) {
+	return file_rejection_proto_rawDescGZIP(), []int{0, 0}
+}
+
+// Error detail attached to every request breakwater rejects, so callers can
+// tell overload apart from other RESOURCE_EXHAUSTED errors.
+type BreakwaterRejection struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Reason BreakwaterRejection_Reason `protobuf:"varint,1,opt,name=reason,proto3,enum=breakwater.v1.BreakwaterRejection_Reason" json:"reason,omitempty"`
+	// Id of the breakwater instance that rejected the request.
+	BreakwaterId string `protobuf:"bytes,2,opt,name=breakwater_id,json=breakwaterId,proto3" json:"breakwater_id,omitempty"`
+	// Queueing delay in microseconds when the request was rejected, if known.
+	QueueingDelayUs float64 `protobuf:"fixed64,3,opt,name=queueing_delay_us,json=queueingDelayUs,proto3" json:"queueing_delay_us,omitempty"`
+}
+
+func (x *BreakwaterRejection) Reset() {
+	*x = BreakwaterRejection{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rejection_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BreakwaterRejection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BreakwaterRejection) ProtoMessage() {}
+
+func (x *BreakwaterRejection) ProtoReflect() protoreflect.Message {
+	mi := &file_rejection_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BreakwaterRejection.ProtoReflect.Descriptor instead.
+func (*BreakwaterRejection) Descriptor() ([]byte, []int) {
+	return file_rejection_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *BreakwaterRejection) GetReason() BreakwaterRejection_Reason {
+	if x != nil {
+		return x.Reason
+	}
+	return BreakwaterRejection_REASON_UNSPECIFIED
+}
+
+func (x *BreakwaterRejection) GetBreakwaterId() string {
+	if x != nil {
+		return x.BreakwaterId
+	}
+	return ""
+}
+
+func (x *BreakwaterRejection) GetQueueingDelayUs() float64 {
+	if x != nil {
+		return x.QueueingDelayUs
+	}
+	return 0
+}
+
+var File_rejection_proto protoreflect.FileDescriptor
+
+var file_rejection_proto_rawDesc = []byte{
+	0x0a, 0x0f, 0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x0d, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31,
+	0x22, 0xcd, 0x03, 0x0a, 0x13, 0x42, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x52,
+	0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x41, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73,
+	0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x29, 0x2e, 0x62, 0x72, 0x65, 0x61, 0x6b,
+	0x77, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x42, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61,
+	0x74, 0x65, 0x72, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x52, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x23, 0x0a, 0x0d, 0x62,
+	0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0c, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74, 0x65, 0x72, 0x49, 0x64,
+	0x12, 0x2a, 0x0a, 0x11, 0x71, 0x75, 0x65, 0x75, 0x65, 0x69, 0x6e, 0x67, 0x5f, 0x64, 0x65, 0x6c,
+	0x61, 0x79, 0x5f, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0f, 0x71, 0x75, 0x65,
+	0x75, 0x65, 0x69, 0x6e, 0x67, 0x44, 0x65, 0x6c, 0x61, 0x79, 0x55, 0x73, 0x22, 0xa1, 0x02, 0x0a,
+	0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x12, 0x52, 0x45, 0x41, 0x53, 0x4f,
+	0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
+	0x1c, 0x0a, 0x18, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x43, 0x4c, 0x49, 0x45, 0x4e, 0x54,
+	0x5f, 0x51, 0x55, 0x45, 0x55, 0x45, 0x5f, 0x46, 0x55, 0x4c, 0x4c, 0x10, 0x01, 0x12, 0x1c, 0x0a,
+	0x18, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x43, 0x4c, 0x49, 0x45, 0x4e, 0x54, 0x5f, 0x45,
+	0x58, 0x50, 0x49, 0x52, 0x41, 0x54, 0x49, 0x4f, 0x4e, 0x10, 0x02, 0x12, 0x24, 0x0a, 0x20, 0x52,
+	0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x43, 0x4c, 0x49, 0x45, 0x4e, 0x54, 0x5f, 0x44, 0x52, 0x4f,
+	0x50, 0x50, 0x45, 0x44, 0x5f, 0x46, 0x52, 0x4f, 0x4d, 0x5f, 0x46, 0x52, 0x4f, 0x4e, 0x54, 0x10,
+	0x03, 0x12, 0x15, 0x0a, 0x11, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x53, 0x45, 0x52, 0x56,
+	0x45, 0x52, 0x5f, 0x41, 0x51, 0x4d, 0x10, 0x04, 0x12, 0x1c, 0x0a, 0x18, 0x52, 0x45, 0x41, 0x53,
+	0x4f, 0x4e, 0x5f, 0x53, 0x45, 0x52, 0x56, 0x45, 0x52, 0x5f, 0x51, 0x55, 0x45, 0x55, 0x45, 0x5f,
+	0x46, 0x55, 0x4c, 0x4c, 0x10, 0x05, 0x12, 0x22, 0x0a, 0x1e, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e,
+	0x5f, 0x53, 0x45, 0x52, 0x56, 0x45, 0x52, 0x5f, 0x51, 0x55, 0x45, 0x55, 0x45, 0x5f, 0x45, 0x58,
+	0x50, 0x49, 0x52, 0x41, 0x54, 0x49, 0x4f, 0x4e, 0x10, 0x06, 0x12, 0x21, 0x0a, 0x1d, 0x52, 0x45,
+	0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x53, 0x45, 0x52, 0x56, 0x45, 0x52, 0x5f, 0x41, 0x44, 0x4d, 0x49,
+	0x53, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x4c, 0x45, 0x56, 0x45, 0x4c, 0x10, 0x07, 0x12, 0x21, 0x0a,
+	0x1d, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x43, 0x4c, 0x49, 0x45, 0x4e, 0x54, 0x5f, 0x41,
+	0x44, 0x4d, 0x49, 0x53, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x4c, 0x45, 0x56, 0x45, 0x4c, 0x10, 0x08,
+	0x42, 0x2a, 0x5a, 0x28, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c,
+	0x6f, 0x68, 0x70, 0x61, 0x75, 0x6c, 0x39, 0x2f, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x77, 0x61, 0x74,
+	0x65, 0x72, 0x2d, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x62, 0x77, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_rejection_proto_rawDescOnce sync.Once
+	file_rejection_proto_rawDescData = file_rejection_proto_rawDesc
+)
+
+func file_rejection_proto_rawDescGZIP() []byte {
+	file_rejection_proto_rawDescOnce.Do(func() {
+		file_rejection_proto_rawDescData = protoimpl.X.CompressGZIP(file_rejection_proto_rawDescData)
+	})
+	return file_rejection_proto_rawDescData
+}
+
+var file_rejection_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_rejection_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_rejection_proto_goTypes = []interface{}{
+	(BreakwaterRejection_Reason)(0), // 0: breakwater.v1.BreakwaterRejection.Reason
+	(*BreakwaterRejection)(nil),     // 1: breakwater.v1.BreakwaterRejection
+}
+var file_rejection_proto_depIdxs = []int32{
+	0, // 0: breakwater.v1.BreakwaterRejection.reason:type_name -> breakwater.v1.BreakwaterRejection.Reason
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_rejection_proto_init() }
+func file_rejection_proto_init() {
+	if File_rejection_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rejection_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BreakwaterRejection); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rejection_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_rejection_proto_goTypes,
+		DependencyIndexes: file_rejection_proto_depIdxs,
+		EnumInfos:         file_rejection_proto_enumTypes,
+		MessageInfos:      file_rejection_proto_msgTypes,
+	}.Build()
+	File_rejection_proto = out.File
+	file_rejection_proto_rawDesc = nil
+	file_rejection_proto_goTypes = nil
+	file_rejection_proto_depIdxs = nil
+}
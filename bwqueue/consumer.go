@@ -0,0 +1,199 @@
+// Package bwqueue admits messages consumed from queues such as Kafka and
+// NATS through a Breakwater, so RPC traffic and async ingestion share one
+// overload controller.
+//
+// Every message is admitted with Breakwater.Acquire before it is handled.
+// While the Breakwater sheds, the consumer waits for the retry delay of the
+// rejection, up to its Backoff, and tries again, and pauses fetching through its Pauser so
+// the broker keeps the backlog instead of the process. Fetching resumes
+// once a message is admitted.
+//
+// The adapters match the client libraries by method set, so this package
+// depends on none of them:
+//
+//	// franz-go
+//	consumer.Pauser = bwqueue.PauseTopics(kgoClient, "orders")
+//	// sarama, a ConsumerGroup or a Consumer
+//	consumer.Pauser = bwqueue.PauseAll(consumerGroup)
+//	// NATS, pending messages stay in the subscription while it waits
+//	sub, err := nc.Subscribe("orders", bwqueue.Handler(consumer, handle))
+package bwqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+const DefaultBackoff = 100 * time.Millisecond // longest wait after a rejection by default
+
+/*
+Stops and restarts fetching from the queue
+*/
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+/*
+Consumer admits the messages of one consumer through a Breakwater
+*/
+type Consumer struct {
+	bw   *bw.Breakwater
+	info bw.CallInfo
+	// Paused while the Breakwater sheds, optional
+	Pauser Pauser
+	// Longest wait after a rejection, shorter if the rejection suggests a
+	// shorter retry delay. DefaultBackoff if zero.
+	Backoff time.Duration
+
+	mu     sync.Mutex
+	paused bool
+}
+
+/*
+Creates a consumer whose messages are admitted as info, typically a
+BestEffort class so ingestion is shed before interactive requests
+*/
+func NewConsumer(b *bw.Breakwater, info bw.CallInfo) *Consumer {
+	return &Consumer{bw: b, info: info}
+}
+
+/*
+Waits until a message is admitted, pausing the queue while it is not.
+Returns ctx.Err() if ctx is done first; otherwise release has to be called
+once the message is handled.
+*/
+func (c *Consumer) Admit(ctx context.Context) (bw.ReleaseFunc, error) {
+	for {
+		release, err := c.bw.Acquire(ctx, c.info)
+		if err == nil {
+			c.setPaused(false)
+			return release, nil
+		}
+		c.setPaused(true)
+		wait := c.Backoff
+		if wait <= 0 {
+			wait = DefaultBackoff
+		}
+		if retryDelay, ok := bw.RetryDelayFromError(err); ok && retryDelay > 0 && retryDelay < wait {
+			wait = retryDelay
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+/*
+Admits a message, handles it and releases it, returns the error of
+handle or ctx.Err() if ctx is done before the message is admitted
+*/
+func (c *Consumer) Process(ctx context.Context, handle func(ctx context.Context) error) error {
+	release, err := c.Admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return handle(ctx)
+}
+
+/*
+Wraps a message handler of any message type, such as nats.MsgHandler,
+so every message waits to be admitted before it is handled
+*/
+func Handler[M any](c *Consumer, handle func(msg M)) func(msg M) {
+	return func(msg M) {
+		c.Process(context.Background(), func(context.Context) error {
+			handle(msg)
+			return nil
+		})
+	}
+}
+
+/*
+Returns true while the consumer waits for the Breakwater to admit messages
+*/
+func (c *Consumer) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *Consumer) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused == paused {
+		return
+	}
+	c.paused = paused
+	if c.Pauser == nil {
+		return
+	}
+	if paused {
+		c.Pauser.Pause()
+	} else {
+		c.Pauser.Resume()
+	}
+}
+
+/*
+The topic pausing methods of a franz-go *kgo.Client
+*/
+type TopicPauser interface {
+	PauseFetchTopics(topics ...string) []string
+	ResumeFetchTopics(topics ...string)
+}
+
+type topicPauser struct {
+	client TopicPauser
+	topics []string
+}
+
+/*
+Pauses fetching the given topics of a franz-go client
+*/
+func PauseTopics(client TopicPauser, topics ...string) Pauser {
+	return &topicPauser{client: client, topics: topics}
+}
+
+func (p *topicPauser) Pause() {
+	p.client.PauseFetchTopics(p.topics...)
+}
+
+func (p *topicPauser) Resume() {
+	p.client.ResumeFetchTopics(p.topics...)
+}
+
+/*
+The pausing methods of a sarama ConsumerGroup or Consumer
+*/
+type AllPauser interface {
+	PauseAll()
+	ResumeAll()
+}
+
+type allPauser struct {
+	consumer AllPauser
+}
+
+/*
+Pauses every partition a sarama consumer fetches
+*/
+func PauseAll(consumer AllPauser) Pauser {
+	return allPauser{consumer: consumer}
+}
+
+func (p allPauser) Pause() {
+	p.consumer.PauseAll()
+}
+
+func (p allPauser) Resume() {
+	p.consumer.ResumeAll()
+}
@@ -0,0 +1,108 @@
+package bwqueue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+)
+
+type fakeGroup struct {
+	paused  int32
+	pauses  int32
+	resumes int32
+}
+
+func (g *fakeGroup) PauseAll() {
+	atomic.StoreInt32(&g.paused, 1)
+	atomic.AddInt32(&g.pauses, 1)
+}
+
+func (g *fakeGroup) ResumeAll() {
+	atomic.StoreInt32(&g.paused, 0)
+	atomic.AddInt32(&g.resumes, 1)
+}
+
+// A Breakwater whose delay comes from an application queue, updated on demand
+func queueBreakwater() (*bw.Breakwater, *bw.AppQueue) {
+	params := bw.BWParametersDefault
+	params.ServerSide = true
+	params.DelaySignal = bw.DelaySignalRPC
+	params.ManualUpdates = true
+	b := bw.InitBreakwater(params)
+	return b, b.RegisterAppQueue("ingest", 0)
+}
+
+func TestPausesWhileShedding(t *testing.T) {
+	b, queue := queueBreakwater()
+	group := &fakeGroup{}
+	consumer := NewConsumer(b, bw.CallInfo{Client: "orders", Class: bw.BestEffort})
+	consumer.Pauser = PauseAll(group)
+	consumer.Backoff = time.Millisecond
+
+	queue.Report(0, 100*time.Millisecond)
+	b.UpdateCredits()
+	handled := make(chan struct{})
+	go consumer.Process(context.Background(), func(context.Context) error {
+		close(handled)
+		return nil
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&group.paused) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the consumer to pause while the Breakwater sheds")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-handled:
+		t.Fatalf("Expected the message to wait while the Breakwater sheds")
+	default:
+	}
+
+	queue.Report(0, 0)
+	b.UpdateCredits()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the message to be handled once the overload cleared")
+	}
+	if consumer.Paused() || atomic.LoadInt32(&group.paused) == 1 {
+		t.Errorf("Expected the consumer to resume")
+	}
+	if pauses, resumes := atomic.LoadInt32(&group.pauses), atomic.LoadInt32(&group.resumes); pauses != 1 || resumes != 1 {
+		t.Errorf("Expected one pause and one resume, got %d and %d", pauses, resumes)
+	}
+}
+
+func TestProcessStopsWithContext(t *testing.T) {
+	b, queue := queueBreakwater()
+	consumer := NewConsumer(b, bw.CallInfo{Client: "orders"})
+	queue.Report(0, 100*time.Millisecond)
+	b.UpdateCredits()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := consumer.Process(ctx, func(context.Context) error {
+		t.Errorf("Expected the message not to be handled")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to end the wait, got %v", err)
+	}
+}
+
+func TestHandlerWrapsAnyMessageType(t *testing.T) {
+	b, _ := queueBreakwater()
+	consumer := NewConsumer(b, bw.CallInfo{Client: "orders"})
+	var got string
+	handler := Handler(consumer, func(msg *string) { got = *msg })
+	msg := "order"
+	handler(&msg)
+	if got != "order" {
+		t.Errorf("Expected the wrapped handler to get the message, got %q", got)
+	}
+}
@@ -0,0 +1,228 @@
+// Package bwserviceconfig receives breakwater tunables through the gRPC
+// service config, so whatever delivers service configs to clients, DNS, xDS
+// or a control plane's resolver, can push overload control policy to a
+// fleet.
+//
+// The tunables ride in the "breakwater_config" load balancing policy, using
+// the keys of bwconfig files, and the actual balancing is left to a child
+// policy, pick_first if none is given:
+//
+//	{"loadBalancingConfig": [{"breakwater_config": {
+//		"source": "default",
+//		"slo_us": 500,
+//		"a_factor": 0.001,
+//		"child_policy": [{"round_robin": {}}]
+//	}}]}
+//
+// Every config the ClientConn accepts is applied to the Breakwater of the
+// Source with the same name.
+package bwserviceconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/bwconfig"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const Name = "breakwater_config" // name of the balancer in service configs
+
+var sources sync.Map // *Source by name, for balancers to deliver to
+
+func init() {
+	balancer.Register(builder{})
+}
+
+/*
+Source applies the tunables of service configs naming it to a Breakwater
+*/
+type Source struct {
+	bw      *bw.Breakwater
+	name    string
+	updates chan bw.Tunables // holds the latest config not yet applied
+}
+
+var _ bw.ConfigSource = (*Source)(nil)
+
+/*
+Creates the source for service configs naming it, replacing any earlier
+source of that name. Configs arriving before Run are held, the latest one
+is applied when Run starts.
+*/
+func NewSource(b *bw.Breakwater, name string) *Source {
+	s := &Source{bw: b, name: name, updates: make(chan bw.Tunables, 1)}
+	sources.Store(name, s)
+	return s
+}
+
+/*
+Applies every config delivered until ctx is done
+*/
+func (s *Source) Run(ctx context.Context) error {
+	defer sources.CompareAndDelete(s.name, s)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t := <-s.updates:
+			s.bw.ApplyTunables(t)
+		}
+	}
+}
+
+/*
+Hands a config to Run, replacing one it has not applied yet
+*/
+func (s *Source) deliver(t bw.Tunables) {
+	for {
+		select {
+		case s.updates <- t:
+			return
+		default:
+		}
+		select {
+		case <-s.updates:
+		default:
+		}
+	}
+}
+
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig
+	source      string
+	tunables    bw.Tunables
+	child       string
+	childConfig serviceconfig.LoadBalancingConfig
+}
+
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+/*
+Parses and validates the config, so a service config with bad tunables is
+rejected by gRPC and the previous one stays in effect
+*/
+func (builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(js, &fields); err != nil {
+		return nil, fmt.Errorf("bwserviceconfig: invalid config: %w", err)
+	}
+	cfg := &lbConfig{child: "pick_first"}
+	if raw, ok := fields["source"]; ok {
+		if err := json.Unmarshal(raw, &cfg.source); err != nil {
+			return nil, fmt.Errorf("bwserviceconfig: invalid source: %w", err)
+		}
+		delete(fields, "source")
+	}
+	if raw, ok := fields["child_policy"]; ok {
+		if err := cfg.parseChild(raw); err != nil {
+			return nil, err
+		}
+		delete(fields, "child_policy")
+	}
+	// what is left are the tunables
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("bwserviceconfig: invalid config: %w", err)
+	}
+	if cfg.tunables, err = bwconfig.ParseJSON(rest); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+/*
+Takes the first registered policy of the list, like loadBalancingConfig
+*/
+func (cfg *lbConfig) parseChild(raw json.RawMessage) error {
+	var policies []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &policies); err != nil {
+		return fmt.Errorf("bwserviceconfig: invalid child_policy: %w", err)
+	}
+	for _, policy := range policies {
+		if len(policy) != 1 {
+			return fmt.Errorf("bwserviceconfig: child_policy entries name one policy, got %d", len(policy))
+		}
+		for name, childJSON := range policy {
+			b := balancer.Get(name)
+			if b == nil || name == Name {
+				continue
+			}
+			cfg.child = name
+			if parser, ok := b.(balancer.ConfigParser); ok {
+				childConfig, err := parser.ParseConfig(childJSON)
+				if err != nil {
+					return fmt.Errorf("bwserviceconfig: invalid config for %s: %w", name, err)
+				}
+				cfg.childConfig = childConfig
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("bwserviceconfig: no registered policy in child_policy")
+}
+
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return &configBalancer{cc: cc, opts: opts}
+}
+
+/*
+Delivers each config to its source and passes everything else to the child
+*/
+type configBalancer struct {
+	cc        balancer.ClientConn
+	opts      balancer.BuildOptions
+	childName string
+	child     balancer.Balancer // nil until the first config
+}
+
+func (b *configBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	cfg, ok := s.BalancerConfig.(*lbConfig)
+	if !ok {
+		return fmt.Errorf("bwserviceconfig: unexpected config %T", s.BalancerConfig)
+	}
+	if source, ok := sources.Load(cfg.source); ok {
+		source.(*Source).deliver(cfg.tunables)
+	}
+	if b.child == nil || b.childName != cfg.child {
+		if b.child != nil {
+			b.child.Close()
+		}
+		b.child = balancer.Get(cfg.child).Build(b.cc, b.opts)
+		b.childName = cfg.child
+	}
+	s.BalancerConfig = cfg.childConfig
+	return b.child.UpdateClientConnState(s)
+}
+
+func (b *configBalancer) ResolverError(err error) {
+	if b.child != nil {
+		b.child.ResolverError(err)
+	}
+}
+
+func (b *configBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	if b.child != nil {
+		b.child.UpdateSubConnState(sc, state)
+	}
+}
+
+func (b *configBalancer) ExitIdle() {
+	if exitIdler, ok := b.child.(balancer.ExitIdler); ok {
+		exitIdler.ExitIdle()
+	}
+}
+
+func (b *configBalancer) Close() {
+	if b.child != nil {
+		b.child.Close()
+	}
+}
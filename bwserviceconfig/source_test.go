@@ -0,0 +1,97 @@
+package bwserviceconfig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func serviceConfig(t *testing.T, r *manual.Resolver, slo int64) *resolver.State {
+	js := fmt.Sprintf(`{"loadBalancingConfig": [{"breakwater_config": {
+		"source": "fleet",
+		"slo_us": %d,
+		"child_policy": [{"round_robin": {}}]
+	}}]}`, slo)
+	sc := r.CC.ParseServiceConfig(js)
+	if sc.Err != nil {
+		t.Fatalf("Failed to parse the service config: %v", sc.Err)
+	}
+	return &resolver.State{Addresses: []resolver.Address{{Addr: "bufnet"}}, ServiceConfig: sc}
+}
+
+func waitForSLO(t *testing.T, b *bw.Breakwater, slo int64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for b.Snapshot().SLO != slo {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the service config to set the SLO to %d, got %d", slo, b.Snapshot().SLO)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServiceConfigSetsTunables(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	b := bw.InitBreakwater(bw.BWParametersDefault)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go NewSource(b, "fleet").Run(ctx)
+
+	r := manual.NewBuilderWithScheme("bwserviceconfig")
+	cc, err := grpc.Dial(r.Scheme()+":///fleet",
+		grpc.WithResolvers(r),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(b.UnaryInterceptorClient))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	cc.Connect()
+	r.UpdateState(*serviceConfig(t, r, 500))
+	waitForSLO(t, b, 500)
+
+	// the child policy still routes requests
+	ctx, cancelRequest := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelRequest()
+	if _, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	r.UpdateState(*serviceConfig(t, r, 800))
+	waitForSLO(t, b, 800)
+}
+
+func TestParseConfigRejectsBadTunables(t *testing.T) {
+	for _, js := range []string{
+		`{"slo_us": -1}`,
+		`{"slo_ms": 500}`,
+		`{"child_policy": [{"no_such_policy": {}}]}`,
+	} {
+		if _, err := (builder{}).ParseConfig([]byte(js)); err == nil {
+			t.Errorf("Expected %s to be rejected", js)
+		}
+	}
+	cfg, err := (builder{}).ParseConfig([]byte(`{"source": "fleet", "a_factor": 0.01}`))
+	if err != nil {
+		t.Fatalf("Failed to parse a valid config: %v", err)
+	}
+	if c := cfg.(*lbConfig); c.source != "fleet" || c.tunables.AFactor != 0.01 || c.child != "pick_first" {
+		t.Errorf("Expected source fleet, a_factor 0.01 and pick_first, got %+v", c)
+	}
+}
@@ -0,0 +1,229 @@
+// Package bwxds fetches breakwater parameters from an xDS control plane.
+//
+// Parameters are served as an RTDS (Runtime Discovery Service) resource whose
+// layer is a flat struct, for example
+//
+//	slo_us: 500
+//	a_factor: 0.001
+//	use_client_time_expiration: true
+//
+// Every accepted update is applied to a running Breakwater through its
+// runtime setters, so a fleet can manage admission control centrally.
+package bwxds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corepb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	runtimepb "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const runtimeTypeURL = "type.googleapis.com/envoy.service.runtime.v3.Runtime"
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+/*
+Client subscribes to a single RTDS resource and applies it to a Breakwater
+*/
+type Client struct {
+	bw       *bw.Breakwater
+	rtds     runtimepb.RuntimeDiscoveryServiceClient
+	node     *corepb.Node
+	resource string
+	version  string // last accepted version
+	// Called with every stream or resource error, optional
+	OnError func(err error)
+}
+
+var _ bw.ConfigSource = (*Client)(nil)
+
+/*
+Creates a client for the runtime resource served over cc,
+nodeID identifies this instance to the control plane
+*/
+func NewClient(b *bw.Breakwater, cc grpc.ClientConnInterface, nodeID string, resource string) *Client {
+	return &Client{
+		bw:       b,
+		rtds:     runtimepb.NewRuntimeDiscoveryServiceClient(cc),
+		node:     &corepb.Node{Id: nodeID},
+		resource: resource,
+	}
+}
+
+/*
+Applies every update until ctx is done,
+reconnecting with exponential backoff when the stream breaks
+*/
+func (c *Client) Run(ctx context.Context) error {
+	backoff := minBackoff
+	for {
+		received, err := c.watch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.reportError(fmt.Errorf("bwxds: runtime stream broken: %w", err))
+		if received {
+			backoff = minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+/*
+Runs a single stream, returns whether any response was received
+*/
+func (c *Client) watch(ctx context.Context) (bool, error) {
+	stream, err := c.rtds.StreamRuntime(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := stream.Send(c.request("", "", nil)); err != nil {
+		return false, err
+	}
+
+	received := false
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		received = true
+
+		var errorDetail *status.Status
+		if err := c.apply(resp); err != nil {
+			c.reportError(err)
+			errorDetail = status.New(codes.InvalidArgument, err.Error())
+		} else {
+			c.version = resp.GetVersionInfo()
+		}
+		// ACK with the new version, or NACK with the previously accepted one
+		if err := stream.Send(c.request(c.version, resp.GetNonce(), errorDetail)); err != nil {
+			return received, err
+		}
+	}
+}
+
+func (c *Client) request(version string, nonce string, errorDetail *status.Status) *discoverypb.DiscoveryRequest {
+	req := &discoverypb.DiscoveryRequest{
+		VersionInfo:   version,
+		Node:          c.node,
+		ResourceNames: []string{c.resource},
+		TypeUrl:       runtimeTypeURL,
+		ResponseNonce: nonce,
+	}
+	if errorDetail != nil {
+		req.ErrorDetail = errorDetail.Proto()
+	}
+	return req
+}
+
+func (c *Client) apply(resp *discoverypb.DiscoveryResponse) error {
+	for _, resource := range resp.GetResources() {
+		var runtime runtimepb.Runtime
+		if err := resource.UnmarshalTo(&runtime); err != nil {
+			return fmt.Errorf("bwxds: bad runtime resource: %w", err)
+		}
+		if runtime.GetName() != c.resource {
+			continue
+		}
+		tunables, err := TunablesFromStruct(runtime.GetLayer())
+		if err != nil {
+			return err
+		}
+		c.bw.ApplyTunables(tunables)
+	}
+	return nil
+}
+
+func (c *Client) reportError(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+/*
+Converts a runtime layer into breakwater tunables.
+Unknown keys are ignored so the control plane can serve newer parameters
+to older binaries, but known keys with the wrong type are rejected.
+*/
+func TunablesFromStruct(layer *structpb.Struct) (bw.Tunables, error) {
+	var t bw.Tunables
+	for key, value := range layer.GetFields() {
+		var err error
+		switch key {
+		case "slo_us":
+			t.SLO, err = intField(key, value)
+		case "a_factor":
+			t.AFactor, err = numberField(key, value)
+		case "b_factor":
+			t.BFactor, err = numberField(key, value)
+		case "client_expiration_us":
+			t.ClientExpiration, err = intField(key, value)
+		case "rtt_us":
+			t.RTT_MICROSECOND, err = intField(key, value)
+		case "use_client_time_expiration":
+			t.UseClientTimeExpiration, err = boolField(key, value)
+		case "use_client_queue_length":
+			t.UseClientQueueLength, err = boolField(key, value)
+		case "high_aqm_factor":
+			t.HighAQMFactor, err = numberField(key, value)
+		case "critical_aqm_factor":
+			t.CriticalAQMFactor, err = numberField(key, value)
+		case "high_priority_reserve":
+			var reserve float64
+			if reserve, err = numberField(key, value); err == nil {
+				t.HighPriorityReserve = &reserve
+			}
+		}
+		if err != nil {
+			return bw.Tunables{}, err
+		}
+	}
+	return t, nil
+}
+
+func numberField(key string, value *structpb.Value) (float64, error) {
+	n, ok := value.GetKind().(*structpb.Value_NumberValue)
+	if !ok {
+		return 0, fmt.Errorf("bwxds: %s must be a number", key)
+	}
+	return n.NumberValue, nil
+}
+
+func intField(key string, value *structpb.Value) (int64, error) {
+	n, err := numberField(key, value)
+	if err != nil {
+		return 0, err
+	}
+	if n != float64(int64(n)) {
+		return 0, fmt.Errorf("bwxds: %s must be an integer, got %v", key, n)
+	}
+	return int64(n), nil
+}
+
+func boolField(key string, value *structpb.Value) (*bool, error) {
+	b, ok := value.GetKind().(*structpb.Value_BoolValue)
+	if !ok {
+		return nil, fmt.Errorf("bwxds: %s must be a bool", key)
+	}
+	return &b.BoolValue, nil
+}
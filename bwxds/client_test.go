@@ -0,0 +1,99 @@
+package bwxds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	discoverypb "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	runtimepb "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// fakeRTDS serves a fixed sequence of layers and records the client's replies
+type fakeRTDS struct {
+	runtimepb.UnimplementedRuntimeDiscoveryServiceServer
+	layers  []map[string]interface{}
+	replies chan *discoverypb.DiscoveryRequest
+}
+
+func (f *fakeRTDS) StreamRuntime(stream runtimepb.RuntimeDiscoveryService_StreamRuntimeServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	for i, fields := range f.layers {
+		layer, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		resource, err := anypb.New(&runtimepb.Runtime{Name: "breakwater", Layer: layer})
+		if err != nil {
+			return err
+		}
+		version := string(rune('a' + i))
+		if err := stream.Send(&discoverypb.DiscoveryResponse{
+			VersionInfo: version,
+			Resources:   []*anypb.Any{resource},
+			TypeUrl:     runtimeTypeURL,
+			Nonce:       "nonce-" + version,
+		}); err != nil {
+			return err
+		}
+		reply, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		f.replies <- reply
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestClientAppliesAndAcksRuntimeLayers(t *testing.T) {
+	fake := &fakeRTDS{
+		layers: []map[string]interface{}{
+			{"slo_us": 500, "unknown_key": "ignored"},
+			{"slo_us": "not a number"},
+		},
+		replies: make(chan *discoverypb.DiscoveryRequest, 2),
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	runtimepb.RegisterRuntimeDiscoveryServiceServer(s, fake)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	b := bw.InitBreakwater(bw.BWParametersDefault)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go NewClient(b, conn, "node-1", "breakwater").Run(ctx)
+
+	ack := <-fake.replies
+	if ack.GetVersionInfo() != "a" || ack.GetErrorDetail() != nil {
+		t.Errorf("Expected ACK of version a, got version %q error %v", ack.GetVersionInfo(), ack.GetErrorDetail())
+	}
+	nack := <-fake.replies
+	if nack.GetVersionInfo() != "a" || nack.GetErrorDetail() == nil {
+		t.Errorf("Expected NACK keeping version a, got version %q error %v", nack.GetVersionInfo(), nack.GetErrorDetail())
+	}
+	if nack.GetResponseNonce() != "nonce-b" {
+		t.Errorf("Expected NACK for nonce-b, got %q", nack.GetResponseNonce())
+	}
+	if b.SLO != 500 {
+		t.Errorf("Expected SLO to be 500, got %d", b.SLO)
+	}
+}
@@ -0,0 +1,40 @@
+// Package bwzap sends breakwater's log lines to a zap logger:
+//
+//	breakwater.SetLogger(bwzap.New(zapLogger, bwzap.DefaultSampling))
+//
+// Debug lines are written at zap's debug level and critical ones at its
+// error level. zap's own sampler keys lines by their formatted message,
+// which differs for every request, so sampling is done by breakwater
+// per logging call site instead.
+package bwzap
+
+import (
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"go.uber.org/zap"
+)
+
+// Writes the first 10 debug lines of each call site every second, then every 100th
+var DefaultSampling = bw.LogSampling{Tick: time.Second, First: 10, Thereafter: 100}
+
+type logger struct {
+	sugar *zap.SugaredLogger
+}
+
+/*
+Logger writing to l, with debug lines sampled by sampling; a zero sampling
+writes all of them
+*/
+func New(l *zap.Logger, sampling bw.LogSampling) bw.Logger {
+	// report the caller of breakwater's logging helpers, not the adapter
+	return bw.SampledLogger(logger{sugar: l.WithOptions(zap.AddCallerSkip(3)).Sugar()}, sampling)
+}
+
+func (l logger) Debugf(format string, a ...interface{}) {
+	l.sugar.Debugf(format, a...)
+}
+
+func (l logger) Errorf(format string, a ...interface{}) {
+	l.sugar.Errorf(format, a...)
+}
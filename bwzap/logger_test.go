@@ -0,0 +1,29 @@
+package bwzap
+
+import (
+	"path/filepath"
+	"testing"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAlertsReachZap(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	bw.SetLogger(New(zap.New(core, zap.AddCaller()), DefaultSampling))
+	defer bw.SetLogger(nil)
+
+	params := bw.BWParametersDefault
+	params.SLO = -1
+	bw.InitBreakwater(params)
+
+	entries := logs.FilterLevelExact(zapcore.ErrorLevel).All()
+	if len(entries) == 0 {
+		t.Fatalf("Expected the invalid parameters to be logged as an error")
+	}
+	if file := filepath.Base(entries[0].Caller.File); file != "breakwater.go" {
+		t.Errorf("Expected the caller to be breakwater's, got %s", entries[0].Caller.File)
+	}
+}
@@ -0,0 +1,76 @@
+// Binary bwbench runs open-loop Poisson load against an in-process gRPC
+// server, with and without breakwater, and reports goodput, tail latency
+// and the fraction of requests shed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/bwbench"
+)
+
+var (
+	rate     = flag.Float64("rate", 8000, "mean requests per second")
+	duration = flag.Duration("duration", 5*time.Second, "how long to generate load")
+	clients  = flag.Int("clients", 10, "client connections")
+	workers  = flag.Int("workers", 4, "requests the server handles at once")
+	timeout  = flag.Duration("timeout", 50*time.Millisecond, "deadline of each request")
+	service  = flag.String("service", "exp", "service time distribution: const, exp or bimodal")
+	mean     = flag.Duration("mean", time.Millisecond, "mean service time, the fast mode for bimodal")
+	slow     = flag.Duration("slow", 10*time.Millisecond, "slow mode of the bimodal distribution")
+	pSlow    = flag.Float64("pslow", 0.05, "probability of the slow mode of the bimodal distribution")
+	slo      = flag.Int64("slo", 5000, "breakwater SLO in microseconds")
+	grants   = flag.String("grant-mode", bw.GrantModeLazy, "when breakwater recomputes grants: lazy or rtt")
+	seed     = flag.Int64("seed", 1, "seed of the load generator")
+	onlyBW   = flag.Bool("breakwater-only", false, "skip the run without breakwater")
+)
+
+func main() {
+	flag.Parse()
+	var serviceTime bwbench.ServiceTime
+	switch *service {
+	case "const":
+		serviceTime = bwbench.Constant(*mean)
+	case "exp":
+		serviceTime = bwbench.Exponential(*mean)
+	case "bimodal":
+		serviceTime = bwbench.Bimodal(*mean, *slow, *pSlow)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown service time distribution %q\n", *service)
+		os.Exit(2)
+	}
+	params := bw.BWParametersDefault
+	params.SLO = *slo
+	params.GrantMode = *grants
+
+	runs := []bool{true}
+	if !*onlyBW {
+		runs = append(runs, false)
+	}
+	for _, withBreakwater := range runs {
+		result, err := bwbench.Run(bwbench.Config{
+			Breakwater:  withBreakwater,
+			Params:      params,
+			Clients:     *clients,
+			Workers:     *workers,
+			Rate:        *rate,
+			Duration:    *duration,
+			Timeout:     *timeout,
+			ServiceTime: serviceTime,
+			Seed:        *seed,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "benchmark failed: %v\n", err)
+			os.Exit(1)
+		}
+		name := "without breakwater"
+		if withBreakwater {
+			name = "with breakwater"
+		}
+		fmt.Printf("%-20s %v\n", name, result)
+	}
+}
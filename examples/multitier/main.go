@@ -0,0 +1,217 @@
+// Binary multitier runs a frontend → midtier → backend chain of echo
+// services in one process, connected over bufconn. Every tier admits its
+// requests with the server interceptor of its own Breakwater, and calls the
+// next tier through the client interceptor of the same Breakwater, so each
+// hop exchanges credits and demand independently.
+//
+// Halfway through the run the backend is pushed into overload by injecting
+// queueing delay. The backend sheds, the midtier's client sees the
+// rejections and stops sending, and the frontend's callers get
+// ResourceExhausted errors instead of waiting on a chain that cannot keep up.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var (
+	requests    = flag.Int("requests", 2000, "requests sent to the frontend in each phase")
+	concurrency = flag.Int("concurrency", 16, "requests in flight at once")
+	work        = flag.Duration("work", 100*time.Microsecond, "time each tier spends on a request")
+	overload    = flag.Duration("overload", 50*time.Millisecond, "queueing delay injected into the backend in the second phase")
+	slo         = flag.Int64("slo", 5000, "breakwater SLO of every tier in microseconds")
+	expiration  = flag.Int64("expiration", 20000, "how long a request waits for a credit at each hop in microseconds")
+)
+
+/*
+An echo service that does some work, then calls the next tier, if any,
+through its Breakwater
+*/
+type tier struct {
+	pb.UnimplementedEchoServer
+	name    string
+	bw      *bw.Breakwater
+	work    time.Duration
+	next    pb.EchoClient // nil for the backend
+	handled int64         // requests the tier admitted, accessed atomically
+	server  *grpc.Server
+	conn    *grpc.ClientConn // connection to the next tier, nil for the backend
+}
+
+func (t *tier) UnaryEcho(ctx context.Context, in *pb.EchoRequest) (*pb.EchoResponse, error) {
+	atomic.AddInt64(&t.handled, 1)
+	time.Sleep(t.work)
+	if t.next == nil {
+		return &pb.EchoResponse{Message: t.name}, nil
+	}
+	// the incoming deadline carries over, rejections from the next tier are
+	// passed back unchanged
+	resp, err := t.next.UnaryEcho(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.EchoResponse{Message: t.name + " → " + resp.Message}, nil
+}
+
+func (t *tier) stop() {
+	if t.conn != nil {
+		t.conn.Close()
+	}
+	t.server.Stop()
+}
+
+/*
+The three tiers and the Breakwater of the load generator in front of them
+*/
+type topology struct {
+	tiers    []*tier // frontend, midtier and backend
+	client   *bw.Breakwater
+	frontend pb.EchoClient
+	conn     *grpc.ClientConn
+}
+
+func dial(lis *bufconn.Listener, b *bw.Breakwater) (*grpc.ClientConn, error) {
+	return grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(b.UnaryInterceptorClient))
+}
+
+/*
+Starts the tiers from the backend up, each dialing the one below it
+*/
+func startTopology(params bw.BWParameters, work time.Duration) (*topology, error) {
+	serverParams := params
+	serverParams.ServerSide = true
+	topo := &topology{}
+	var next *bufconn.Listener
+	for _, name := range []string{"backend", "midtier", "frontend"} {
+		t := &tier{name: name, bw: bw.InitBreakwater(serverParams), work: work}
+		if next != nil {
+			conn, err := dial(next, t.bw)
+			if err != nil {
+				topo.stop()
+				return nil, err
+			}
+			t.conn = conn
+			t.next = pb.NewEchoClient(conn)
+		}
+		lis := bufconn.Listen(1 << 20)
+		t.server = grpc.NewServer(grpc.UnaryInterceptor(t.bw.UnaryInterceptor))
+		pb.RegisterEchoServer(t.server, t)
+		go t.server.Serve(lis)
+		topo.tiers = append([]*tier{t}, topo.tiers...)
+		next = lis
+	}
+	topo.client = bw.InitBreakwater(params)
+	conn, err := dial(next, topo.client)
+	if err != nil {
+		topo.stop()
+		return nil, err
+	}
+	topo.conn = conn
+	topo.frontend = pb.NewEchoClient(conn)
+	return topo, nil
+}
+
+func (topo *topology) backend() *tier {
+	return topo.tiers[len(topo.tiers)-1]
+}
+
+func (topo *topology) stop() {
+	if topo.conn != nil {
+		topo.conn.Close()
+	}
+	for _, t := range topo.tiers {
+		t.stop()
+	}
+}
+
+type phaseResult struct {
+	succeeded int64
+	shed      int64 // rejected by breakwater at any tier
+	failed    int64
+	latency   time.Duration // mean latency of succeeded requests
+}
+
+/*
+Sends n requests to the frontend, concurrency at a time
+*/
+func (topo *topology) drive(n int, concurrency int) phaseResult {
+	var (
+		result  phaseResult
+		total   int64
+		wg      sync.WaitGroup
+		pending = make(chan struct{}, n)
+	)
+	for i := 0; i < n; i++ {
+		pending <- struct{}{}
+	}
+	close(pending)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range pending {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				start := time.Now()
+				_, err := topo.frontend.UnaryEcho(ctx, &pb.EchoRequest{Message: "hello"})
+				cancel()
+				switch {
+				case err == nil:
+					atomic.AddInt64(&result.succeeded, 1)
+					atomic.AddInt64(&total, int64(time.Since(start)))
+				case status.Code(err) == codes.ResourceExhausted:
+					atomic.AddInt64(&result.shed, 1)
+				default:
+					atomic.AddInt64(&result.failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if result.succeeded > 0 {
+		result.latency = time.Duration(total / result.succeeded)
+	}
+	return result
+}
+
+func (topo *topology) report(phase string, r phaseResult) {
+	fmt.Printf("%s: %d succeeded (mean %v), %d shed, %d failed\n", phase, r.succeeded, r.latency, r.shed, r.failed)
+	for _, t := range topo.tiers {
+		s := t.bw.Snapshot()
+		fmt.Printf("  %-8s handled %6d  cTotal %5d  cIssued %5d  clients %d\n",
+			t.name, atomic.LoadInt64(&t.handled), s.CTotal, s.CIssued, s.NumClients)
+	}
+}
+
+func main() {
+	flag.Parse()
+	params := bw.BWParametersDefault
+	params.SLO = *slo
+	params.ClientExpiration = *expiration
+	topo, err := startTopology(params, *work)
+	if err != nil {
+		fmt.Printf("failed to start the topology: %v\n", err)
+		return
+	}
+	defer topo.stop()
+
+	topo.report("healthy", topo.drive(*requests, *concurrency))
+	topo.backend().bw.InjectFaults(bw.Faults{ExtraDelay: *overload})
+	topo.report("backend overloaded", topo.drive(*requests, *concurrency))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	pb "google.golang.org/grpc/examples/features/proto/echo"
+)
+
+// Scheduling latency of a busy test machine would make tiers shed, so only
+// injected delay counts
+func testParams() bw.BWParameters {
+	params := bw.BWParametersDefault
+	params.DelaySignal = bw.DelaySignalRPC
+	return params
+}
+
+func TestCreditsPropagateThroughTiers(t *testing.T) {
+	params := testParams()
+	// a burst waits for the next grant instead of expiring after 1ms
+	params.ClientExpiration = 1000000
+	topo, err := startTopology(params, 0)
+	if err != nil {
+		t.Fatalf("Failed to start the topology: %v", err)
+	}
+	t.Cleanup(topo.stop)
+
+	resp, err := topo.frontend.UnaryEcho(context.Background(), &pb.EchoRequest{Message: "hello"})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.Message != "frontend → midtier → backend" {
+		t.Errorf("Expected the request to pass every tier, got %q", resp.Message)
+	}
+
+	result := topo.drive(200, 8)
+	if result.succeeded != 200 {
+		t.Fatalf("Expected every request to succeed, got %+v", result)
+	}
+	for _, tier := range topo.tiers {
+		if handled := atomic.LoadInt64(&tier.handled); handled != 201 {
+			t.Errorf("Expected the %s to handle 201 requests, got %d", tier.name, handled)
+		}
+		// each tier's only client is the tier above it
+		s := tier.bw.Snapshot()
+		if s.NumClients != 1 || s.CIssued < 1 {
+			t.Errorf("Expected the %s to issue credits to one client, got %+v", tier.name, s)
+		}
+	}
+}
+
+func TestBackendOverloadReachesFrontend(t *testing.T) {
+	topo, err := startTopology(testParams(), 0)
+	if err != nil {
+		t.Fatalf("Failed to start the topology: %v", err)
+	}
+	t.Cleanup(topo.stop)
+	topo.drive(50, 4)
+	initial := topo.backend().bw.Snapshot().CTotal
+
+	topo.backend().bw.InjectFaults(bw.Faults{ExtraDelay: 100 * time.Millisecond})
+	var shed int64
+	for i := 0; i < 20 && shed == 0; i++ {
+		// an RTT has to pass before the backend reads the injected delay
+		time.Sleep(10 * time.Millisecond)
+		shed += topo.drive(50, 4).shed
+	}
+	if shed == 0 {
+		t.Fatalf("Expected the backend's shedding to reach the frontend's callers")
+	}
+	if cTotal := topo.backend().bw.Snapshot().CTotal; cTotal >= initial {
+		t.Errorf("Expected the backend's cTotal to shrink from %d, got %d", initial, cTotal)
+	}
+	if mid, back := atomic.LoadInt64(&topo.tiers[1].handled), atomic.LoadInt64(&topo.backend().handled); back >= mid {
+		t.Errorf("Expected requests shed at the backend to be counted at the midtier only, got midtier %d and backend %d", mid, back)
+	}
+}
@@ -1,18 +1,29 @@
 module github.com/lohpaul9/breakwater-grpc
 
-go 1.19
+go 1.20
 
 require (
+	connectrpc.com/connect v1.16.1
+	github.com/envoyproxy/go-control-plane v0.10.3
+	github.com/go-logr/logr v1.2.4
 	github.com/google/uuid v1.3.0
-	google.golang.org/grpc v1.52.3
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.15.2
+	github.com/twitchtv/twirp v8.1.3+incompatible
+	go.uber.org/zap v1.24.0
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1
+	google.golang.org/grpc v1.54.0
 	google.golang.org/grpc/examples v0.0.0-20230201212035-3151e834fa25
+	google.golang.org/protobuf v1.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/golang/protobuf v1.5.2 // indirect
-	golang.org/x/net v0.5.0 // indirect
-	golang.org/x/sys v0.4.0 // indirect
-	golang.org/x/text v0.6.0 // indirect
-	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
+	github.com/cncf/xds/go v0.0.0-20230105202645-06c439db220b // indirect
+	github.com/envoyproxy/protoc-gen-validate v0.9.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,339 @@
+// Package sim runs the breakwater credit controller against a simulated
+// workload, as a discrete-event simulation on a fake clock. No gRPC is
+// involved: requests go straight through Admit and Done, and RTT updates
+// run at fixed points of simulated time, so a run only depends on its
+// Config and algorithm changes can be compared and property-tested
+// reproducibly.
+//
+// Clients queue Poisson arrivals and send while they hold credits, the
+// server handles requests on a fixed number of workers, and the delay
+// signal is the wait of the oldest queued request unless a DelayScript
+// replaces it:
+//
+//	result := sim.Run(sim.Config{Params: params, Rate: 8000, ...})
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/bwbench"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Queueing delay the server reports at a point of the run, overriding the
+simulated worker queue
+*/
+type DelayScript func(elapsed time.Duration) time.Duration
+
+type Config struct {
+	Params         bw.BWParameters     // parameters of the server's Breakwater, Clock and ManualUpdates are set by Run
+	Clients        int                 // clients sending requests
+	Workers        int                 // requests the server handles at once
+	Rate           float64             // mean requests per second across all clients
+	Duration       time.Duration       // simulated time requests arrive for
+	UpdateInterval time.Duration       // simulated time between RTT updates, Params.RTT_MICROSECOND if 0
+	ServiceTime    bwbench.ServiceTime // time each request holds a worker
+	Delay          DelayScript         // scripted delay signal, nil for the wait of the worker queue
+	Seed           int64               // seed of the arrival and service time generators
+	Observe        func(Sample)        // called after every event, may be nil
+}
+
+/*
+Controller state after an event of the run
+*/
+type Sample struct {
+	Elapsed  time.Duration // simulated time since the start of the run
+	Update   bool          // the event was an RTT update, not a request arriving at the server
+	Issued   int64         // credits the event added to cIssued, negative if it took some back
+	Snapshot bw.Snapshot   // controller state after the event
+}
+
+type Result struct {
+	Sent      int64         // requests sent to the server
+	Succeeded int64         // requests the server handled
+	Shed      int64         // requests the server rejected
+	Updates   int64         // RTT updates run
+	Goodput   float64       // handled requests per simulated second
+	ShedRate  float64       // fraction of sent requests shed
+	P50       time.Duration // median latency from arrival at the client to completion
+	P99       time.Duration // 99th percentile latency
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("sent %d, goodput %.0f/s, shed %.1f%%, updates %d, p50 %v, p99 %v",
+		r.Sent, r.Goodput, 100*r.ShedRate, r.Updates, r.P50, r.P99)
+}
+
+/*
+Fake clock of a run, read by the controller through BWParameters.Clock
+*/
+type clock struct {
+	start time.Time
+	now   time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+type eventKind int
+
+const (
+	arrival    eventKind = iota // a request arrives at its client
+	completion                  // the server finishes a request
+	update                      // the controller runs an RTT update
+)
+
+type event struct {
+	at     time.Time
+	seq    int64 // breaks ties in scheduling order, so runs are deterministic
+	kind   eventKind
+	client *client
+	req    *request
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+type request struct {
+	client      *client
+	arrived     time.Time // arrival at the client
+	queued      time.Time // arrival at the server
+	serviceTime time.Duration
+	admission   *bw.Admission
+}
+
+/*
+Client holding the credits granted on its last response, it sends queued
+requests while fewer than its credits are in flight
+*/
+type client struct {
+	id       uuid.UUID
+	credits  int64
+	inFlight int64
+	backlog  []*request
+}
+
+type simulation struct {
+	cfg      Config
+	b        *bw.Breakwater
+	clock    *clock
+	rng      *rand.Rand
+	events   eventQueue
+	seq      int64
+	busy     int        // workers handling a request
+	waiting  []*request // admitted requests waiting for a worker
+	appQueue *bw.AppQueue
+	result   Result
+	latency  []time.Duration
+}
+
+/*
+Runs the simulation described by cfg
+*/
+func Run(cfg Config) Result {
+	if cfg.UpdateInterval <= 0 {
+		cfg.UpdateInterval = time.Duration(cfg.Params.RTT_MICROSECOND) * time.Microsecond
+	}
+	c := &clock{start: time.Unix(0, 0)}
+	c.now = c.start
+	params := cfg.Params
+	params.ServerSide = true
+	params.Clock = c.Now
+	params.ManualUpdates = true
+	// nothing records per-RPC delays, so the app queue is the only signal
+	params.DelaySignal = bw.DelaySignalRPC
+	s := &simulation{
+		cfg:   cfg,
+		b:     bw.InitBreakwater(params),
+		clock: c,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+	}
+	s.appQueue = s.b.RegisterAppQueue("workers", 0)
+
+	clients := make([]*client, cfg.Clients)
+	for i := range clients {
+		id, _ := uuid.NewRandomFromReader(s.rng)
+		clients[i] = &client{id: id, credits: 1}
+	}
+	end := c.start.Add(cfg.Duration)
+	for at := c.start; at.Before(end); {
+		// Poisson arrivals, spread uniformly over the clients
+		at = at.Add(time.Duration(s.rng.ExpFloat64() / cfg.Rate * float64(time.Second)))
+		s.schedule(&event{at: at, kind: arrival, client: clients[s.rng.Intn(len(clients))]})
+	}
+	for at := c.start.Add(cfg.UpdateInterval); at.Before(end); at = at.Add(cfg.UpdateInterval) {
+		s.schedule(&event{at: at, kind: update})
+	}
+
+	for s.events.Len() > 0 {
+		e := heap.Pop(&s.events).(*event)
+		c.now = e.at
+		s.handle(e)
+	}
+
+	r := s.result
+	r.Goodput = float64(r.Succeeded) / cfg.Duration.Seconds()
+	if r.Sent > 0 {
+		r.ShedRate = float64(r.Shed) / float64(r.Sent)
+	}
+	r.P50 = percentile(s.latency, 0.5)
+	r.P99 = percentile(s.latency, 0.99)
+	return r
+}
+
+func (s *simulation) schedule(e *event) {
+	e.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, e)
+}
+
+func (s *simulation) handle(e *event) {
+	switch e.kind {
+	case arrival:
+		e.client.backlog = append(e.client.backlog, &request{
+			client:      e.client,
+			arrived:     s.clock.now,
+			serviceTime: s.cfg.ServiceTime(s.rng),
+		})
+		s.send(e.client)
+	case completion:
+		s.complete(e.req)
+	case update:
+		s.reportDelay()
+		before := s.b.Snapshot().CIssued
+		s.b.UpdateCredits()
+		s.result.Updates++
+		s.observe(true, before)
+	}
+}
+
+/*
+Sends the client's queued requests while it has credits to spend
+*/
+func (s *simulation) send(c *client) {
+	for len(c.backlog) > 0 && c.inFlight < c.credits {
+		req := c.backlog[0]
+		c.backlog = c.backlog[1:]
+		c.inFlight++
+		s.result.Sent++
+		s.admit(req)
+	}
+}
+
+func (s *simulation) admit(req *request) {
+	c := req.client
+	md := metadata.Pairs("id", c.id.String(), "demand", strconv.Itoa(len(c.backlog)+int(c.inFlight)))
+	before := s.b.Snapshot().CIssued
+	s.reportDelay()
+	a, err := s.b.Admit(metadata.NewIncomingContext(context.Background(), md))
+	s.observe(false, before)
+	if err != nil {
+		s.result.Shed++
+		c.inFlight--
+		s.grant(c, a.Header)
+		return
+	}
+	req.admission = a
+	req.queued = s.clock.now
+	s.waiting = append(s.waiting, req)
+	s.dispatch()
+}
+
+/*
+Hands waiting requests to idle workers in FIFO order
+*/
+func (s *simulation) dispatch() {
+	for s.busy < s.cfg.Workers && len(s.waiting) > 0 {
+		req := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		s.busy++
+		s.schedule(&event{at: s.clock.now.Add(req.serviceTime), kind: completion, req: req})
+	}
+}
+
+func (s *simulation) complete(req *request) {
+	s.busy--
+	header, _ := req.admission.Done()
+	s.result.Succeeded++
+	s.latency = append(s.latency, s.clock.now.Sub(req.arrived))
+	c := req.client
+	c.inFlight--
+	s.dispatch()
+	s.grant(c, metadata.Join(req.admission.Header, header))
+}
+
+/*
+Applies the last credits value of a response header, like the client
+interceptor does
+*/
+func (s *simulation) grant(c *client, header metadata.MD) {
+	if values := header["credits"]; len(values) > 0 {
+		if credits, err := strconv.ParseInt(values[len(values)-1], 10, 64); err == nil {
+			c.credits = credits
+		}
+	}
+	s.send(c)
+}
+
+/*
+Reports the delay signal the controller reads, the scripted delay or the
+wait of the oldest request queued for a worker
+*/
+func (s *simulation) reportDelay() {
+	if s.cfg.Delay != nil {
+		s.appQueue.Report(0, s.cfg.Delay(s.clock.now.Sub(s.clock.start)))
+		return
+	}
+	var wait time.Duration
+	if len(s.waiting) > 0 {
+		wait = s.clock.now.Sub(s.waiting[0].queued)
+	}
+	s.appQueue.Report(int64(len(s.waiting)), wait)
+}
+
+func (s *simulation) observe(isUpdate bool, issuedBefore int64) {
+	if s.cfg.Observe == nil {
+		return
+	}
+	snapshot := s.b.Snapshot()
+	s.cfg.Observe(Sample{
+		Elapsed:  s.clock.now.Sub(s.clock.start),
+		Update:   isUpdate,
+		Issued:   snapshot.CIssued - issuedBefore,
+		Snapshot: snapshot,
+	})
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+}
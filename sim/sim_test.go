@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	bw "github.com/lohpaul9/breakwater-grpc/breakwater"
+	"github.com/lohpaul9/breakwater-grpc/bwbench"
+)
+
+func simConfig(rate float64, seed int64) Config {
+	params := bw.BWParametersDefault
+	params.SLO = 5000
+	return Config{
+		Params:      params,
+		Clients:     10,
+		Workers:     4,
+		Rate:        rate,
+		Duration:    time.Second,
+		ServiceTime: bwbench.Exponential(time.Millisecond),
+		Seed:        seed,
+	}
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	first := Run(simConfig(8000, 1))
+	second := Run(simConfig(8000, 1))
+	if first != second {
+		t.Errorf("Expected runs with the same seed to match, got %v and %v", first, second)
+	}
+	if first.Sent == 0 || first.Updates == 0 {
+		t.Errorf("Expected requests and RTT updates, got %v", first)
+	}
+}
+
+// Under limit a grant is capped by the credits left in cTotal; over it a
+// client only gets back up to the minimum grant of one credit
+func TestIssuedWithinCTotal(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		cfg := simConfig(10000, seed)
+		cfg.Observe = func(s Sample) {
+			if s.Snapshot.CTotal < 1 {
+				t.Fatalf("seed %d at %v: cTotal fell to %d", seed, s.Elapsed, s.Snapshot.CTotal)
+			}
+			if !s.Update && s.Issued > 1 && s.Snapshot.CIssued > s.Snapshot.CTotal {
+				t.Fatalf("seed %d at %v: a request issued %d credits taking cIssued to %d over cTotal %d",
+					seed, s.Elapsed, s.Issued, s.Snapshot.CIssued, s.Snapshot.CTotal)
+			}
+		}
+		if result := Run(cfg); result.Shed == 0 {
+			t.Errorf("seed %d: expected 2.5x the capacity to overload the server, got %v", seed, result)
+		}
+	}
+}
+
+func TestScriptedDelaySpike(t *testing.T) {
+	cfg := simConfig(2000, 1)
+	cfg.Delay = func(elapsed time.Duration) time.Duration {
+		if elapsed >= 400*time.Millisecond && elapsed < 500*time.Millisecond {
+			return 50 * time.Millisecond
+		}
+		return 0
+	}
+	var before, during, after int64
+	cfg.Observe = func(s Sample) {
+		if !s.Update {
+			return
+		}
+		switch {
+		case s.Elapsed < 400*time.Millisecond:
+			before = s.Snapshot.CTotal
+		case s.Elapsed < 500*time.Millisecond:
+			during = s.Snapshot.CTotal
+		default:
+			after = s.Snapshot.CTotal
+		}
+	}
+	Run(cfg)
+	if during >= before {
+		t.Errorf("Expected cTotal to drop during the spike, got %d before and %d during", before, during)
+	}
+	if after <= during {
+		t.Errorf("Expected cTotal to recover after the spike, got %d during and %d after", during, after)
+	}
+}